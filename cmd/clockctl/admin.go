@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// adminCredential is a credential as returned by the admin API.
+type adminCredential struct {
+	ID          string     `json:"id"`
+	Source      string     `json:"source"`
+	Devices     []string   `json:"devices"`
+	Roles       []string   `json:"roles"`
+	Description string     `json:"description"`
+	Owner       string     `json:"owner"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	Status      string     `json:"status"`
+	Token       string     `json:"token"`
+}
+
+// timeFields maps the time flags of create and update onto request fields.
+var timeFields = map[string]string{"not-before": "notBefore", "expires": "expiresAt"}
+
+// runAdmin handles "clockctl admin credentials <list|create|update|revoke>".
+func runAdmin(client *apiClient, args []string) {
+	if len(args) == 0 || args[0] != "credentials" {
+		usageAndExit("unknown admin command")
+	}
+	err := adminCredentials(client, args[1:], os.Stdout, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func adminCredentials(client *apiClient, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("expected list, create, update or revoke")
+	}
+	if client.versionPrefix() == "" {
+		return errors.New("the admin API requires a versioned server API; set CLOCKCTL_API_VERSION")
+	}
+	action, args := args[0], args[1:]
+	fs := flag.NewFlagSet("admin credentials "+action, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	id := fs.String("id", "", "credential id")
+	switch action {
+	case "list":
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		return listCredentials(client, stdout)
+	case "create", "update":
+		scopes := fs.String("scopes", "", "comma-separated device scopes")
+		roles := fs.String("roles", "", "comma-separated roles (defined in API_ROLES)")
+		description := fs.String("description", "", "what the credential is for")
+		owner := fs.String("owner", "", "who is responsible for the credential")
+		fs.String("not-before", "", "RFC 3339 time before which the token is not accepted")
+		fs.String("expires", "", "RFC 3339 time from which the token is no longer accepted")
+		disabled := false
+		if action == "update" {
+			fs.BoolVar(&disabled, "disabled", false, "disable (true) or re-enable (false) the credential")
+		}
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if strings.TrimSpace(*id) == "" {
+			return errors.New("-id is required")
+		}
+		payload := map[string]any{}
+		var parseErr error
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "scopes":
+				payload["devices"] = splitList(*scopes)
+			case "roles":
+				payload["roles"] = splitList(*roles)
+			case "description":
+				payload["description"] = *description
+			case "owner":
+				payload["owner"] = *owner
+			case "not-before", "expires":
+				at, err := time.Parse(time.RFC3339, f.Value.String())
+				if err != nil {
+					parseErr = fmt.Errorf("-%s must be an RFC 3339 time", f.Name)
+				}
+				payload[timeFields[f.Name]] = at
+			case "disabled":
+				payload["disabled"] = disabled
+			}
+		})
+		if parseErr != nil {
+			return parseErr
+		}
+		if action == "update" {
+			return updateCredential(client, *id, payload, stdout)
+		}
+		if _, ok := payload["devices"]; !ok {
+			return errors.New("-scopes is required")
+		}
+		payload["id"] = strings.TrimSpace(*id)
+		return createCredential(client, payload, stdout, stderr)
+	case "revoke":
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if strings.TrimSpace(*id) == "" {
+			return errors.New("-id is required")
+		}
+		if err := client.send(http.MethodDelete, "/admin/credentials/"+url.PathEscape(*id), nil); err != nil {
+			return fmt.Errorf("revoke credential: %w", err)
+		}
+		fmt.Fprintf(stdout, "credential %s revoked\n", *id)
+		return nil
+	default:
+		return fmt.Errorf("unknown admin credentials command %q: expected list, create, update or revoke", action)
+	}
+}
+
+func listCredentials(client *apiClient, stdout io.Writer) error {
+	body, status, err := client.do(http.MethodGet, "/admin/credentials", nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return responseError(status, body)
+	}
+	var resp struct {
+		Credentials []adminCredential `json:"credentials"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("decode credentials: %w", err)
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSOURCE\tSTATUS\tDEVICES\tROLES\tEXPIRES\tOWNER")
+	for _, cred := range resp.Credentials {
+		expires := "-"
+		if cred.ExpiresAt != nil {
+			expires = cred.ExpiresAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", cred.ID, cred.Source, cred.Status,
+			orDash(strings.Join(cred.Devices, ",")), orDash(strings.Join(cred.Roles, ",")), expires, orDash(cred.Owner))
+	}
+	return tw.Flush()
+}
+
+// createCredential prints the issued token alone on stdout, so it can be
+// captured by a script; it cannot be retrieved again.
+func createCredential(client *apiClient, payload map[string]any, stdout, stderr io.Writer) error {
+	body, status, err := client.do(http.MethodPost, "/admin/credentials", payload)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusCreated {
+		return responseError(status, body)
+	}
+	var cred adminCredential
+	if err := json.Unmarshal(body, &cred); err != nil || cred.Token == "" {
+		return errors.New("the server response did not contain a token")
+	}
+	fmt.Fprintf(stderr, "credential %s created; store the token now, it is not shown again\n", cred.ID)
+	fmt.Fprintln(stdout, cred.Token)
+	return nil
+}
+
+func updateCredential(client *apiClient, id string, payload map[string]any, stdout io.Writer) error {
+	if len(payload) == 0 {
+		return errors.New("nothing to update")
+	}
+	if err := client.send(http.MethodPatch, "/admin/credentials/"+url.PathEscape(id), payload); err != nil {
+		return fmt.Errorf("update credential: %w", err)
+	}
+	fmt.Fprintf(stdout, "credential %s updated\n", id)
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(raw string) []string {
+	out := []string{}
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -27,14 +28,16 @@ func (r bddRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 type cliWorld struct {
 	envKeys []string
 
-	baseURL   string
-	token     string
-	timeout   time.Duration
-	allowHTTP bool
+	baseURL    string
+	token      string
+	timeout    time.Duration
+	allowHTTP  bool
+	apiVersion string
 
 	responseStatus int
 	responseBody   string
 	transportErr   error
+	versionsBody   string
 
 	lastMethod string
 	lastPath   string
@@ -51,6 +54,7 @@ func (w *cliWorld) reset() {
 		"CLOCK_SERVER_TOKEN",
 		"CLOCKCTL_TIMEOUT_MS",
 		"CLOCKCTL_ALLOW_INSECURE_HTTP",
+		"CLOCKCTL_API_VERSION",
 	}
 	w.clearEnv()
 
@@ -58,10 +62,12 @@ func (w *cliWorld) reset() {
 	w.token = ""
 	w.timeout = 2 * time.Second
 	w.allowHTTP = false
+	w.apiVersion = ""
 
 	w.responseStatus = http.StatusAccepted
 	w.responseBody = `{"result":"ok"}`
 	w.transportErr = nil
+	w.versionsBody = ""
 
 	w.lastMethod = ""
 	w.lastPath = ""
@@ -90,7 +96,7 @@ func (w *cliWorld) serverRespondsWithStatusAndBody(status int, body string) {
 }
 
 func (w *cliWorld) transportFailsWith(message string) {
-	w.transportErr = fmt.Errorf(message)
+	w.transportErr = errors.New(message)
 }
 
 func (w *cliWorld) allowInsecureHTTPTransport() {
@@ -136,6 +142,16 @@ func (w *cliWorld) callSendWithPayload(method, path string, payload string) erro
 	return nil
 }
 
+func (w *cliWorld) callBatchFile(mode, file string) error {
+	client := w.newClient()
+	payload, err := buildBatchPayload([]byte(file), mode)
+	if err != nil {
+		return fmt.Errorf("build batch payload: %w", err)
+	}
+	_, w.sendErr = client.sendBatch(payload)
+	return nil
+}
+
 func (w *cliWorld) setEnv(key, value string) error {
 	if err := os.Setenv(key, value); err != nil {
 		return fmt.Errorf("set env %s: %w", key, err)
@@ -152,6 +168,7 @@ func (w *cliWorld) createClientFromEnvironment() {
 	w.baseURL = client.baseURL
 	w.token = client.token
 	w.timeout = client.client.Timeout
+	w.apiVersion = client.apiVersion
 }
 
 func (w *cliWorld) sendShouldSucceed() error {
@@ -228,6 +245,13 @@ func (w *cliWorld) clientTokenShouldBe(expected string) error {
 	return nil
 }
 
+func (w *cliWorld) clientAPIVersionShouldBe(expected string) error {
+	if w.apiVersion != expected {
+		return fmt.Errorf("expected API version %q, got %q", expected, w.apiVersion)
+	}
+	return nil
+}
+
 func (w *cliWorld) newClient() *apiClient {
 	baseURL := w.baseURL
 	token := w.token
@@ -235,14 +259,18 @@ func (w *cliWorld) newClient() *apiClient {
 		_ = os.Setenv("CLOCKCTL_ALLOW_INSECURE_HTTP", "true")
 	}
 	return &apiClient{
-		baseURL: baseURL,
-		token:   token,
+		baseURL:    baseURL,
+		token:      token,
+		apiVersion: w.apiVersion,
 		client: &http.Client{
 			Timeout: w.timeout,
 			Transport: bddRoundTripper{handler: func(req *http.Request) (*http.Response, error) {
 				if w.transportErr != nil {
 					return nil, w.transportErr
 				}
+				if req.Method == http.MethodGet && req.URL.Path == "/versions" {
+					return w.versionsResponse(), nil
+				}
 				w.lastMethod = req.Method
 				w.lastPath = req.URL.Path
 				w.lastAuth = req.Header.Get("Authorization")
@@ -269,6 +297,19 @@ func (w *cliWorld) newClient() *apiClient {
 	}
 }
 
+// versionsResponse answers version discovery like a server without it
+// unless the scenario advertises versions.
+func (w *cliWorld) versionsResponse() *http.Response {
+	if w.versionsBody == "" {
+		return &http.Response{StatusCode: http.StatusNotFound, Header: make(http.Header), Body: io.NopCloser(strings.NewReader("404 page not found"))}
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: io.NopCloser(strings.NewReader(w.versionsBody))}
+}
+
+func (w *cliWorld) serverAdvertisesVersions(body *godog.DocString) {
+	w.versionsBody = body.Content
+}
+
 func (w *cliWorld) parseTimeoutForKey(key string, fallbackMS int) {
 	w.timeout = parseTimeout(key, time.Duration(fallbackMS)*time.Millisecond)
 }
@@ -332,10 +373,12 @@ func InitializeScenario(ctx *godog.ScenarioContext) {
 	ctx.Step(`^the bearer token is "([^"]*)"$`, world.bearerTokenIs)
 	ctx.Step(`^the server responds with status (\d+) and body:$`, world.serverRespondsWithStatusAndBody)
 	ctx.Step(`^the transport fails with "([^"]*)"$`, world.transportFailsWith)
+	ctx.Step(`^the server advertises API versions:$`, world.serverAdvertisesVersions)
 	ctx.Step(`^insecure HTTP transport is allowed$`, world.allowInsecureHTTPTransport)
 	ctx.Step(`^I send a message command for device "([^"]*)" message "([^"]*)" and duration (\d+)$`, world.callMessageCommand)
 	ctx.Step(`^I send an alarm command for device "([^"]*)" time "([^"]*)" label "([^"]*)"$`, world.callAlarmCommand)
 	ctx.Step(`^I send a brightness command for device "([^"]*)" level (\d+)$`, world.callBrightnessCommand)
+	ctx.Step(`^I send a batch in mode "([^"]*)" from file:$`, world.callBatchFile)
 	ctx.Step(`^I call send with method "([^"]*)" path "([^"]*)" payload:$`, world.callSendWithPayload)
 	ctx.Step(`^I set env "([^"]*)" to "([^"]*)"$`, world.setEnv)
 	ctx.Step(`^I resolve the server base URL$`, world.resolveBaseURLFromEnvironment)
@@ -349,6 +392,7 @@ func InitializeScenario(ctx *godog.ScenarioContext) {
 	ctx.Step(`^the resolved base URL should be "([^"]*)"$`, world.resolvedBaseURLShouldBe)
 	ctx.Step(`^the client timeout should be (\d+) milliseconds$`, world.clientTimeoutShouldBeMilliseconds)
 	ctx.Step(`^the client token should be "([^"]*)"$`, world.clientTokenShouldBe)
+	ctx.Step(`^the client API version should be "([^"]*)"$`, world.clientAPIVersionShouldBe)
 	ctx.Step(`^I set CLOCKCTL_TIMEOUT_MS to "([^"]*)"$`, world.setTimeoutEnvValue)
 	ctx.Step(`^I parse timeout using fallback (\d+) milliseconds$`, world.parseTimeoutUsingEnvFallback)
 	ctx.Step(`^the parsed timeout should be (\d+) milliseconds$`, world.timeoutShouldMatchFallback)
@@ -34,13 +34,68 @@ Feature: clockctl command payloads
     Given the server base URL is "http://localhost:8080"
     And the server responds with status 502 and body:
       """
-      {"error":"bad gateway"}
+      {"type":"urn:clock-server:problem:dispatch_failed","title":"Bad Gateway","status":502,"code":"dispatch_failed","detail":"command dispatch failed"}
       """
     When I send a message command for device "clock-4" message "hello" and duration 10
-    Then the send call should fail containing "status=502"
+    Then the send call should fail containing "status=502 code=dispatch_failed: command dispatch failed"
 
   Scenario: Transport errors are propagated
     Given the server base URL is "http://localhost:8080"
     And the transport fails with "dial tcp timeout"
     When I send a message command for device "clock-5" message "hello" and duration 10
     Then the send call should fail containing "call server"
+
+  Scenario: Batch command posts all commands in one request
+    Given the server base URL is "http://localhost:8080"
+    When I send a batch in mode "best-effort" from file:
+      """
+      [{"type":"set_brightness","deviceId":"room-1","level":40},{"type":"display_message","deviceId":"room-1","message":"welcome","durationSeconds":30}]
+      """
+    Then the send call should succeed
+    And the request method should be "POST"
+    And the request path should be "/commands/batch"
+    And the request JSON field "mode" should equal "best-effort"
+
+  Scenario: Rejected batch returns status error
+    Given the server base URL is "http://localhost:8080"
+    And the server responds with status 400 and body:
+      """
+      {"type":"urn:clock-server:problem:batch_validation_failed","title":"Bad Request","status":400,"code":"batch_validation_failed","detail":"batch validation failed","mode":"atomic-validate","results":[{"index":0,"status":"invalid","code":"validation_failed","error":"brightness level must be between 0 and 100"}]}
+      """
+    When I send a batch in mode "" from file:
+      """
+      {"commands":[{"type":"set_brightness","deviceId":"room-1","level":400}]}
+      """
+    Then the send call should fail containing "status=400 code=batch_validation_failed"
+
+  Scenario: API version is discovered from the server
+    Given the server base URL is "http://localhost:8080"
+    And the server advertises API versions:
+      """
+      {"preferred":"v1","versions":[{"version":"v1","path":"/v1","status":"current"},{"version":"legacy","path":"/","status":"deprecated"}]}
+      """
+    When I call send with method "POST" path "/commands/set_brightness" payload:
+      """
+      {"deviceId":"clock-3","level":77}
+      """
+    Then the send call should succeed
+    And the request path should be "/v1/commands/set_brightness"
+
+  Scenario: Newest common API version is used when the preferred one is unknown
+    Given the server base URL is "http://localhost:8080"
+    And the server advertises API versions:
+      """
+      {"preferred":"v9","versions":[{"version":"v1","path":"/v1","status":"current"},{"version":"v9","path":"/v9","status":"current"}]}
+      """
+    When I call send with method "POST" path "/commands/display_message" payload:
+      """
+      {"deviceId":"clock-1","message":"hello","durationSeconds":15}
+      """
+    Then the send call should succeed
+    And the request path should be "/v1/commands/display_message"
+
+  Scenario: API version can be pinned
+    Given the server base URL is "http://localhost:8080"
+    And I set env "CLOCKCTL_API_VERSION" to "legacy"
+    And I create an API client from environment
+    Then the client API version should be "legacy"
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -14,147 +15,403 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/paul/clock-server/internal/commands"
 )
 
 const (
 	defaultServerBaseURL = "http://localhost:8080"
 	defaultTimeout       = 5 * time.Second
+	maxResponseBytes     = 1 << 20
 )
 
+// supportedAPIVersions are the server API versions this client speaks,
+// oldest first.
+var supportedAPIVersions = []string{"v1"}
+
 type apiClient struct {
 	baseURL string
 	token   string
 	client  *http.Client
+	// apiVersion is "", a supported version, or "legacy". Empty means it is
+	// discovered from the server on first use.
+	apiVersion string
+	apiPrefix  string
 }
 
 func main() {
 	if len(os.Args) < 2 {
 		usageAndExit("missing command")
 	}
+	if os.Args[1] == "token" {
+		runToken(os.Args[2:])
+		return
+	}
+	if os.Args[1] == "audit" {
+		runAudit(os.Args[2:])
+		return
+	}
 
 	client := newAPIClientFromEnv()
 
 	switch os.Args[1] {
-	case "alarm":
-		runAlarm(client, os.Args[2:])
-	case "message":
-		runMessage(client, os.Args[2:])
-	case "brightness":
-		runBrightness(client, os.Args[2:])
-	default:
+	case "batch":
+		runBatch(client, os.Args[2:])
+		return
+	case "admin":
+		runAdmin(client, os.Args[2:])
+		return
+	}
+	def, ok := lookupSubcommand(os.Args[1])
+	if !ok {
 		usageAndExit("unknown command")
 	}
+	runCommand(client, def, os.Args[2:])
 }
 
 func newAPIClientFromEnv() *apiClient {
 	baseURL := resolveServerBaseURL()
 	timeout := parseTimeout("CLOCKCTL_TIMEOUT_MS", defaultTimeout)
 	return &apiClient{
-		baseURL: baseURL,
-		token:   strings.TrimSpace(os.Getenv("CLOCK_SERVER_TOKEN")),
-		client:  &http.Client{Timeout: timeout},
+		baseURL:    baseURL,
+		token:      strings.TrimSpace(os.Getenv("CLOCK_SERVER_TOKEN")),
+		client:     &http.Client{Timeout: timeout},
+		apiVersion: strings.TrimSpace(os.Getenv("CLOCKCTL_API_VERSION")),
+	}
+}
+
+// lookupSubcommand finds the registered command type for a subcommand name,
+// accepting either its CLI name or its command type.
+func lookupSubcommand(name string) (commands.Definition, bool) {
+	for _, def := range commands.All() {
+		if def.CLIName == name || def.Type == name {
+			return def, true
+		}
+	}
+	return commands.Definition{}, false
+}
+
+// runCommand parses the flags generated from the command definition and
+// posts the command to /commands/{type}.
+func runCommand(client *apiClient, def commands.Definition, args []string) {
+	payload, err := parseCommandFlags(def, args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
+	if err := client.send(http.MethodPost, "/commands/"+def.Type, payload); err != nil {
+		log.Fatalf("dispatch %s command via server: %v", subcommandName(def), err)
+	}
+	fmt.Printf("%s command dispatched\n", subcommandName(def))
 }
 
-func runAlarm(client *apiClient, args []string) {
-	fs := flag.NewFlagSet("alarm", flag.ExitOnError)
+// parseCommandFlags builds the request payload from a --device flag plus one
+// flag per command field.
+func parseCommandFlags(def commands.Definition, args []string) (map[string]any, error) {
+	fs := flag.NewFlagSet(subcommandName(def), flag.ContinueOnError)
 	deviceID := fs.String("device", "", "clock device id")
-	alarmTime := fs.String("time", "", "alarm time in RFC3339")
-	label := fs.String("label", "", "alarm label")
-	_ = fs.Parse(args)
+	values := make(map[string]any, len(def.Fields))
+	for _, field := range def.Fields {
+		switch field.Kind {
+		case commands.KindInt:
+			fallback, _ := strconv.Atoi(field.Default)
+			values[field.Name] = fs.Int(field.Flag, fallback, field.Usage)
+		default:
+			values[field.Name] = fs.String(field.Flag, field.Default, field.Usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
 
 	if strings.TrimSpace(*deviceID) == "" {
-		log.Fatal("device is required")
-	}
-	if _, err := time.Parse(time.RFC3339, *alarmTime); err != nil {
-		log.Fatalf("time must be RFC3339: %v", err)
+		return nil, errors.New("device is required")
+	}
+	payload := map[string]any{"deviceId": *deviceID}
+	for _, field := range def.Fields {
+		switch value := values[field.Name].(type) {
+		case *int:
+			payload[field.Name] = *value
+		case *string:
+			if field.Required && strings.TrimSpace(*value) == "" {
+				return nil, fmt.Errorf("%s is required", field.Flag)
+			}
+			if field.Kind == commands.KindTime {
+				if _, err := time.Parse(time.RFC3339, *value); err != nil {
+					return nil, fmt.Errorf("%s must be RFC3339: %w", field.Flag, err)
+				}
+			}
+			payload[field.Name] = *value
+		}
 	}
+	return payload, nil
+}
 
-	payload := map[string]any{
-		"deviceId":  *deviceID,
-		"alarmTime": *alarmTime,
-		"label":     *label,
+func subcommandName(def commands.Definition) string {
+	if def.CLIName != "" {
+		return def.CLIName
 	}
-	if err := client.send(http.MethodPost, "/commands/alarms", payload); err != nil {
-		log.Fatalf("dispatch alarm command via server: %v", err)
+	return def.Type
+}
+
+// usageLine renders the synopsis of a generated subcommand.
+func usageLine(def commands.Definition) string {
+	parts := []string{"clockctl", subcommandName(def), "--device <id>"}
+	for _, field := range def.Fields {
+		placeholder := field.Placeholder
+		if placeholder == "" {
+			switch field.Kind {
+			case commands.KindTime:
+				placeholder = "RFC3339"
+			case commands.KindInt:
+				placeholder = "n"
+			default:
+				placeholder = "text"
+			}
+		}
+		flagUsage := fmt.Sprintf("--%s <%s>", field.Flag, placeholder)
+		if !field.Required {
+			flagUsage = "[" + flagUsage + "]"
+		}
+		parts = append(parts, flagUsage)
 	}
-	fmt.Println("alarm command dispatched")
+	return strings.Join(parts, " ")
 }
 
-func runMessage(client *apiClient, args []string) {
-	fs := flag.NewFlagSet("message", flag.ExitOnError)
-	deviceID := fs.String("device", "", "clock device id")
-	message := fs.String("message", "", "message text")
-	duration := fs.Int("duration", 10, "duration in seconds")
+func runBatch(client *apiClient, args []string) {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	file := fs.String("f", "", "batch file (JSON), - for stdin")
+	mode := fs.String("mode", "", "atomic-validate or best-effort (overrides the file)")
 	_ = fs.Parse(args)
 
-	if strings.TrimSpace(*deviceID) == "" {
-		log.Fatal("device is required")
+	if strings.TrimSpace(*file) == "" {
+		log.Fatal("-f is required")
 	}
-	if strings.TrimSpace(*message) == "" {
-		log.Fatal("message is required")
+	var raw []byte
+	var err error
+	if *file == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		log.Fatalf("read batch file: %v", err)
+	}
+	payload, err := buildBatchPayload(raw, *mode)
+	if err != nil {
+		log.Fatalf("parse batch file: %v", err)
 	}
 
-	payload := map[string]any{
-		"deviceId":        *deviceID,
-		"message":         *message,
-		"durationSeconds": *duration,
+	results, err := client.sendBatch(payload)
+	printBatchResults(os.Stdout, results)
+	if err != nil {
+		log.Fatalf("dispatch batch via server: %v", err)
 	}
-	if err := client.send(http.MethodPost, "/commands/messages", payload); err != nil {
-		log.Fatalf("dispatch message command via server: %v", err)
+	for _, result := range results {
+		if result.Status != "accepted" {
+			os.Exit(1)
+		}
 	}
-	fmt.Println("display-message command dispatched")
 }
 
-func runBrightness(client *apiClient, args []string) {
-	fs := flag.NewFlagSet("brightness", flag.ExitOnError)
-	deviceID := fs.String("device", "", "clock device id")
-	level := fs.Int("level", 50, "brightness 0..100")
-	_ = fs.Parse(args)
+type batchItemResult struct {
+	Index    int    `json:"index"`
+	Type     string `json:"type"`
+	DeviceID string `json:"deviceId"`
+	Status   string `json:"status"`
+	Error    string `json:"error"`
+}
 
-	if strings.TrimSpace(*deviceID) == "" {
-		log.Fatal("device is required")
+// buildBatchPayload accepts either a full batch request object or a bare
+// array of commands. A non-empty mode overrides the mode in the file.
+func buildBatchPayload(raw []byte, mode string) (map[string]any, error) {
+	raw = bytes.TrimSpace(raw)
+	payload := map[string]any{}
+	if len(raw) > 0 && raw[0] == '[' {
+		var commands []any
+		if err := json.Unmarshal(raw, &commands); err != nil {
+			return nil, err
+		}
+		payload["commands"] = commands
+	} else if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, err
+	}
+	commands, ok := payload["commands"].([]any)
+	if !ok || len(commands) == 0 {
+		return nil, fmt.Errorf("batch must contain at least one command")
+	}
+	if strings.TrimSpace(mode) != "" {
+		payload["mode"] = strings.TrimSpace(mode)
 	}
+	return payload, nil
+}
 
-	payload := map[string]any{
-		"deviceId": *deviceID,
-		"level":    *level,
+// sendBatch posts a batch and returns the per-item results. Results are also
+// returned when the server rejects the batch with a per-item report.
+func (c *apiClient) sendBatch(payload map[string]any) ([]batchItemResult, error) {
+	body, status, err := c.do(http.MethodPost, "/commands/batch", payload)
+	if err != nil {
+		return nil, err
 	}
-	if err := client.send(http.MethodPut, "/commands/brightness", payload); err != nil {
-		log.Fatalf("dispatch brightness command via server: %v", err)
+	var resp struct {
+		Results []batchItemResult `json:"results"`
+	}
+	_ = json.Unmarshal(body, &resp)
+	if status < 200 || status >= 300 {
+		return resp.Results, responseError(status, body)
+	}
+	return resp.Results, nil
+}
+
+func printBatchResults(w io.Writer, results []batchItemResult) {
+	for _, result := range results {
+		line := fmt.Sprintf("#%d %s %s: %s", result.Index, result.Type, result.DeviceID, result.Status)
+		if result.Error != "" {
+			line += " (" + result.Error + ")"
+		}
+		fmt.Fprintln(w, line)
 	}
-	fmt.Println("brightness command dispatched")
+}
+
+// responseError describes a failed response by its problem code and detail
+// when the server returned problem details, and by the raw body otherwise.
+func responseError(status int, body []byte) error {
+	var problem struct {
+		Code   string `json:"code"`
+		Detail string `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &problem); err == nil && problem.Code != "" {
+		if problem.Detail == "" {
+			return fmt.Errorf("server returned status=%d code=%s", status, problem.Code)
+		}
+		return fmt.Errorf("server returned status=%d code=%s: %s", status, problem.Code, problem.Detail)
+	}
+	return fmt.Errorf("server returned status=%d body=%s", status, strings.TrimSpace(string(truncate(body, 1024))))
+}
+
+func truncate(b []byte, n int) []byte {
+	if len(b) > n {
+		return b[:n]
+	}
+	return b
 }
 
 func (c *apiClient) send(method, path string, payload map[string]any) error {
-	body, err := json.Marshal(payload)
+	body, status, err := c.do(method, path, payload)
 	if err != nil {
-		return fmt.Errorf("marshal request: %w", err)
+		return err
+	}
+	if status < 200 || status >= 300 {
+		return responseError(status, body)
+	}
+	return nil
+}
+
+// do sends payload as JSON and returns the response status and body. A nil
+// payload sends no body.
+func (c *apiClient) do(method, path string, payload map[string]any) ([]byte, int, error) {
+	var body io.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, 0, fmt.Errorf("marshal request: %w", err)
+		}
+		body = bytes.NewReader(raw)
 	}
 
-	req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+	req, err := http.NewRequest(method, c.baseURL+c.versionPrefix()+path, body)
 	if err != nil {
-		return fmt.Errorf("build request: %w", err)
+		return nil, 0, fmt.Errorf("build request: %w", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
-	req.Header.Set("Content-Type", "application/json")
 	if c.token != "" {
 		if err := ensureSafeTokenTransport(c.baseURL); err != nil {
-			return err
+			return nil, 0, err
 		}
 		req.Header.Set("Authorization", "Bearer "+c.token)
 	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("call server: %w", err)
+		return nil, 0, fmt.Errorf("call server: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-		return fmt.Errorf("server returned status=%d body=%s", resp.StatusCode, strings.TrimSpace(string(message)))
+	message, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("read response: %w", err)
 	}
-	return nil
+	return message, resp.StatusCode, nil
+}
+
+// versionPrefix returns the path prefix of the API version to use, resolving
+// it on first use. Unknown versions and servers without discovery get the
+// unversioned legacy routes.
+func (c *apiClient) versionPrefix() string {
+	if c.apiVersion == "" {
+		c.apiVersion = c.discoverAPIVersion()
+	}
+	if c.apiVersion == "legacy" {
+		return ""
+	}
+	if c.apiPrefix == "" {
+		c.apiPrefix = "/" + c.apiVersion
+	}
+	return c.apiPrefix
+}
+
+// discoverAPIVersion asks GET /versions for the server's versions and picks
+// the server's preferred version if supported, else the newest common one.
+func (c *apiClient) discoverAPIVersion() string {
+	resp, err := c.client.Get(c.baseURL + "/versions")
+	if err != nil {
+		return "legacy"
+	}
+	defer resp.Body.Close()
+	var discovery struct {
+		Preferred string `json:"preferred"`
+		Versions  []struct {
+			Version string `json:"version"`
+			Path    string `json:"path"`
+		} `json:"versions"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&discovery) != nil {
+		return "legacy"
+	}
+	served := map[string]string{}
+	for _, version := range discovery.Versions {
+		served[version.Version] = version.Path
+	}
+	pick := func(version string) bool {
+		path, ok := served[version]
+		if !ok || !isSupportedAPIVersion(version) {
+			return false
+		}
+		c.apiPrefix = strings.TrimSuffix(path, "/")
+		return true
+	}
+	if pick(discovery.Preferred) {
+		return discovery.Preferred
+	}
+	for i := len(supportedAPIVersions) - 1; i >= 0; i-- {
+		if pick(supportedAPIVersions[i]) {
+			return supportedAPIVersions[i]
+		}
+	}
+	return "legacy"
+}
+
+func isSupportedAPIVersion(version string) bool {
+	for _, supported := range supportedAPIVersions {
+		if supported == version {
+			return true
+		}
+	}
+	return false
 }
 
 func getEnv(key, fallback string) string {
@@ -252,14 +509,23 @@ func isLocalHost(host string) bool {
 func usageAndExit(msg string) {
 	fmt.Fprintf(os.Stderr, "%s\n\n", msg)
 	fmt.Fprintln(os.Stderr, "usage:")
-	fmt.Fprintln(os.Stderr, "  clockctl alarm --device <id> --time <RFC3339> [--label <text>]")
-	fmt.Fprintln(os.Stderr, "  clockctl message --device <id> --message <text> [--duration <seconds>]")
-	fmt.Fprintln(os.Stderr, "  clockctl brightness --device <id> --level <0-100>")
+	for _, def := range commands.All() {
+		fmt.Fprintf(os.Stderr, "  %s\n", usageLine(def))
+	}
+	fmt.Fprintln(os.Stderr, "  clockctl batch -f <file.json|-> [--mode atomic-validate|best-effort]")
+	fmt.Fprintln(os.Stderr, "  clockctl admin credentials list")
+	fmt.Fprintln(os.Stderr, "  clockctl admin credentials create --id <id> --scopes <scopes> [--roles <roles>] [--description <text>] [--owner <owner>] [--not-before <RFC3339>] [--expires <RFC3339>]")
+	fmt.Fprintln(os.Stderr, "  clockctl admin credentials update --id <id> [--scopes <scopes>] [--roles <roles>] [--description <text>] [--owner <owner>] [--not-before <RFC3339>] [--expires <RFC3339>] [--disabled=true|false]")
+	fmt.Fprintln(os.Stderr, "  clockctl admin credentials revoke --id <id>")
+	fmt.Fprintln(os.Stderr, "  clockctl audit verify [--key-env AUDIT_LOG_KEY] <audit.log>")
+	fmt.Fprintln(os.Stderr, "  clockctl token hash [--algorithm argon2id|sha256] [--generate] [--id <id> [--scopes <scopes>] [--roles <roles>]] < token")
 	fmt.Fprintln(os.Stderr)
 	fmt.Fprintln(os.Stderr, "environment:")
 	fmt.Fprintln(os.Stderr, "  CLOCK_SERVER_BASE_URL (default http://localhost:8080)")
 	fmt.Fprintln(os.Stderr, "  CLOCK_SERVER_HOST (docker-style host, e.g. tcp://clock-server:8080)")
 	fmt.Fprintln(os.Stderr, "  CLOCK_SERVER_TOKEN (optional bearer token)")
 	fmt.Fprintln(os.Stderr, "  CLOCKCTL_TIMEOUT_MS (default 5000)")
+	fmt.Fprintln(os.Stderr, "  API_AUTH_TOKEN_PEPPER (token hash --algorithm sha256)")
+	fmt.Fprintln(os.Stderr, "  AUDIT_LOG_KEY (audit verify of HMAC-chained logs)")
 	os.Exit(2)
 }
@@ -5,9 +5,15 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/paul/clock-server/internal/adapters/auditlog"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
+	"github.com/paul/clock-server/internal/security"
 )
 
 func TestGetEnv(t *testing.T) {
@@ -52,7 +58,8 @@ func TestParseTimeout(t *testing.T) {
 
 func TestAPIClientSendSuccess(t *testing.T) {
 	client := &apiClient{
-		baseURL: "http://clock-server.local",
+		baseURL:    "http://clock-server.local",
+		apiVersion: "legacy",
 		client: &http.Client{
 			Timeout: 2 * time.Second,
 			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
@@ -77,7 +84,8 @@ func TestAPIClientSendSuccess(t *testing.T) {
 
 func TestAPIClientSendFailureStatus(t *testing.T) {
 	client := &apiClient{
-		baseURL: "http://clock-server.local",
+		baseURL:    "http://clock-server.local",
+		apiVersion: "legacy",
 		client: &http.Client{
 			Timeout: 2 * time.Second,
 			Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
@@ -98,6 +106,17 @@ func TestAPIClientSendFailureStatus(t *testing.T) {
 	}
 }
 
+func TestResponseErrorPrefersProblemDetails(t *testing.T) {
+	err := responseError(http.StatusBadRequest, []byte(`{"type":"urn:clock-server:problem:validation_failed","code":"validation_failed","detail":"brightness level must be between 0 and 100"}`))
+	if err.Error() != "server returned status=400 code=validation_failed: brightness level must be between 0 and 100" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = responseError(http.StatusBadGateway, []byte("upstream gone"))
+	if err.Error() != "server returned status=502 body=upstream gone" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func TestResolveServerBaseURL(t *testing.T) {
 	t.Setenv("CLOCK_SERVER_BASE_URL", "")
 	t.Setenv("CLOCK_SERVER_HOST", "")
@@ -138,9 +157,10 @@ func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
 
 func TestAPIClientRejectsInsecureTokenTransport(t *testing.T) {
 	client := &apiClient{
-		baseURL: "http://clock-server.local",
-		token:   "secret",
-		client:  &http.Client{Timeout: 2 * time.Second},
+		baseURL:    "http://clock-server.local",
+		apiVersion: "legacy",
+		token:      "secret",
+		client:     &http.Client{Timeout: 2 * time.Second},
 	}
 	err := client.send(http.MethodPost, "/commands/messages", map[string]any{"deviceId": "clock-1"})
 	if err == nil {
@@ -150,3 +170,259 @@ func TestAPIClientRejectsInsecureTokenTransport(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestBuildBatchPayload(t *testing.T) {
+	payload, err := buildBatchPayload([]byte(`[{"type":"set_brightness","deviceId":"clock-1","level":10}]`), "best-effort")
+	if err != nil {
+		t.Fatalf("build payload: %v", err)
+	}
+	if payload["mode"] != "best-effort" {
+		t.Fatalf("expected mode override, got %v", payload["mode"])
+	}
+	if commands, ok := payload["commands"].([]any); !ok || len(commands) != 1 {
+		t.Fatalf("expected one command, got %v", payload["commands"])
+	}
+
+	payload, err = buildBatchPayload([]byte(`{"mode":"atomic-validate","commands":[{"type":"set_brightness"}]}`), "")
+	if err != nil {
+		t.Fatalf("build payload: %v", err)
+	}
+	if payload["mode"] != "atomic-validate" {
+		t.Fatalf("expected file mode to be kept, got %v", payload["mode"])
+	}
+
+	if _, err := buildBatchPayload([]byte(`{"commands":[]}`), ""); err == nil {
+		t.Fatal("expected error for empty batch")
+	}
+}
+
+func TestAPIClientSendBatchReturnsResults(t *testing.T) {
+	client := &apiClient{
+		baseURL:    "http://clock-server.local",
+		apiVersion: "legacy",
+		client: &http.Client{
+			Timeout: 2 * time.Second,
+			Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				if r.URL.Path != "/commands/batch" {
+					t.Fatalf("expected batch path, got %s", r.URL.Path)
+				}
+				return &http.Response{
+					StatusCode: http.StatusMultiStatus,
+					Header:     make(http.Header),
+					Body: io.NopCloser(bytes.NewBufferString(`{"mode":"best-effort","results":[` +
+						`{"index":0,"type":"set_brightness","deviceId":"clock-1","status":"accepted"},` +
+						`{"index":1,"type":"set_brightness","deviceId":"clock-2","status":"forbidden","error":"forbidden for target device"}]}`)),
+				}, nil
+			}),
+		},
+	}
+	results, err := client.sendBatch(map[string]any{"commands": []any{}})
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if len(results) != 2 || results[1].Status != "forbidden" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	var out bytes.Buffer
+	printBatchResults(&out, results)
+	if !strings.Contains(out.String(), "#1 set_brightness clock-2: forbidden (forbidden for target device)") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestParseCommandFlagsBuildsPayloadFromRegistry(t *testing.T) {
+	def, ok := lookupSubcommand("alarm")
+	if !ok {
+		t.Fatal("expected alarm subcommand")
+	}
+	payload, err := parseCommandFlags(def, []string{"--device", "clock-1", "--time", "2099-01-01T07:00:00Z", "--label", "wake"})
+	if err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	if payload["deviceId"] != "clock-1" || payload["alarmTime"] != "2099-01-01T07:00:00Z" || payload["label"] != "wake" {
+		t.Fatalf("unexpected payload: %v", payload)
+	}
+
+	def, _ = lookupSubcommand("set_brightness")
+	payload, err = parseCommandFlags(def, []string{"--device", "clock-1"})
+	if err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	if payload["level"] != 50 {
+		t.Fatalf("expected default level 50, got %v", payload["level"])
+	}
+}
+
+func TestParseCommandFlagsValidatesInput(t *testing.T) {
+	alarm, _ := lookupSubcommand("alarm")
+	message, _ := lookupSubcommand("message")
+
+	cases := []struct {
+		name string
+		def  commands.Definition
+		args []string
+		want string
+	}{
+		{"missing device", message, []string{"--message", "hi"}, "device is required"},
+		{"missing required field", message, []string{"--device", "clock-1"}, "message is required"},
+		{"invalid time", alarm, []string{"--device", "clock-1", "--time", "tomorrow"}, "time must be RFC3339"},
+	}
+	for _, tc := range cases {
+		_, err := parseCommandFlags(tc.def, tc.args)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected %q, got %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestUsageLineFromDefinition(t *testing.T) {
+	def, _ := lookupSubcommand("message")
+	if got := usageLine(def); got != "clockctl message --device <id> --message <text> [--duration <seconds>]" {
+		t.Fatalf("unexpected usage line: %q", got)
+	}
+}
+
+func TestHashTokenPrintsVerifiableEntry(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	err := hashToken([]string{"-algorithm", "sha256", "-id", "ops", "-scopes", "clock-*", "-roles", "messenger"}, strings.NewReader("s3cr3t\n"), &stdout, &stderr, "pepper")
+	if err != nil {
+		t.Fatalf("hash token: %v", err)
+	}
+	creds, err := security.ParseCredentials(strings.TrimSpace(stdout.String()))
+	if err != nil || len(creds) != 1 || creds[0].ID != "ops" || len(creds[0].Roles) != 1 || creds[0].Roles[0] != "messenger" {
+		t.Fatalf("unexpected entry %q (%v)", stdout.String(), err)
+	}
+	if cred, ok := security.NewCredentialIndex(creds, []byte("pepper")).Lookup("s3cr3t"); !ok || !cred.Allows("clock-1") {
+		t.Fatalf("expected the entry to authenticate s3cr3t, got %+v %v", cred, ok)
+	}
+	if stderr.Len() != 0 {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestHashTokenGeneratesToken(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if err := hashToken([]string{"-generate"}, strings.NewReader(""), &stdout, &stderr, ""); err != nil {
+		t.Fatalf("hash token: %v", err)
+	}
+	token := strings.TrimPrefix(strings.TrimSpace(stderr.String()), "token: ")
+	hash := strings.TrimSpace(stdout.String())
+	if len(token) < 40 || !strings.HasPrefix(hash, "$argon2id$") {
+		t.Fatalf("unexpected token %q hash %q", token, hash)
+	}
+	if _, ok := security.NewCredentialIndex([]security.Credential{{ID: "gen", Token: hash, Devices: []string{"*"}}}, nil).Lookup(token); !ok {
+		t.Fatal("expected the generated token to match its hash")
+	}
+}
+
+func TestHashTokenRejectsInvalidInput(t *testing.T) {
+	cases := []struct {
+		args   []string
+		stdin  string
+		pepper string
+	}{
+		{stdin: ""},
+		{stdin: "a|b"},
+		{args: []string{"-algorithm", "sha256"}, stdin: "token"},
+		{args: []string{"-algorithm", "md5"}, stdin: "token", pepper: "pepper"},
+	}
+	for _, tc := range cases {
+		if err := hashToken(tc.args, strings.NewReader(tc.stdin), io.Discard, io.Discard, tc.pepper); err == nil {
+			t.Fatalf("expected error for %v %q", tc.args, tc.stdin)
+		}
+	}
+}
+
+func TestAdminCredentialsCommands(t *testing.T) {
+	var requests []string
+	client := &apiClient{
+		baseURL:    "http://localhost:8080",
+		apiVersion: "v1",
+		client: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			body := ""
+			if r.Body != nil {
+				raw, _ := io.ReadAll(r.Body)
+				body = string(raw)
+			}
+			requests = append(requests, r.Method+" "+r.URL.EscapedPath()+" "+body)
+			status, response := http.StatusOK, `{}`
+			switch r.Method {
+			case http.MethodPost:
+				status, response = http.StatusCreated, `{"id":"kiosk","source":"store","token":"issued-token"}`
+			case http.MethodGet:
+				response = `{"credentials":[{"id":"kiosk","source":"store","status":"active","devices":["lobby-*"],"roles":[],"expiresAt":"2027-01-01T00:00:00Z"}]}`
+			case http.MethodDelete:
+				status = http.StatusNoContent
+			}
+			return &http.Response{StatusCode: status, Header: make(http.Header), Body: io.NopCloser(strings.NewReader(response))}, nil
+		})},
+	}
+	run := func(args ...string) (string, string) {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		if err := adminCredentials(client, args, &stdout, &stderr); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return stdout.String(), stderr.String()
+	}
+
+	stdout, stderr := run("create", "-id", "kiosk", "-scopes", "lobby-*", "-expires", "2027-01-01T00:00:00Z")
+	if stdout != "issued-token\n" || !strings.Contains(stderr, "not shown again") {
+		t.Fatalf("expected only the token on stdout, got %q / %q", stdout, stderr)
+	}
+	run("update", "-id", "kiosk", "-scopes", "office-1", "-disabled")
+	stdout, _ = run("list")
+	if !strings.Contains(stdout, "kiosk") || !strings.Contains(stdout, "2027-01-01T00:00:00Z") {
+		t.Fatalf("unexpected list output %q", stdout)
+	}
+	run("revoke", "-id", "kiosk")
+
+	want := []string{
+		`POST /v1/admin/credentials {"devices":["lobby-*"],"expiresAt":"2027-01-01T00:00:00Z","id":"kiosk"}`,
+		`PATCH /v1/admin/credentials/kiosk {"devices":["office-1"],"disabled":true}`,
+		`GET /v1/admin/credentials `,
+		`DELETE /v1/admin/credentials/kiosk `,
+	}
+	if strings.Join(requests, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected requests:\n%s", strings.Join(requests, "\n"))
+	}
+
+	for _, args := range [][]string{{"create", "-id", "kiosk"}, {"update", "-id", "kiosk"}, {"create", "-id", "x", "-scopes", "*", "-expires", "soon"}, {"rotate"}} {
+		if err := adminCredentials(client, args, io.Discard, io.Discard); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+	if err := adminCredentials(&apiClient{apiVersion: "legacy"}, []string{"list"}, io.Discard, io.Discard); err == nil {
+		t.Error("expected the admin API to require a versioned server")
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := auditlog.Open(auditlog.Config{Path: path, MaxBytes: 1 << 20, Key: []byte("audit-key")})
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := l.Append(application.AuditRecord{Action: application.AuditActionCommand, Principal: "ops", Result: "accepted"}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	_ = l.Close()
+	env := map[string]string{"AUDIT_LOG_KEY": "audit-key"}
+
+	var stdout bytes.Buffer
+	if err := verifyAuditLog([]string{path}, &stdout, io.Discard, func(k string) string { return env[k] }); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "entries: 2 (seq 1-2)") || !strings.Contains(stdout.String(), "intact from the first entry") {
+		t.Fatalf("unexpected summary %q", stdout.String())
+	}
+	if err := verifyAuditLog([]string{"-key-env", "", path}, io.Discard, io.Discard, func(k string) string { return env[k] }); err == nil {
+		t.Fatal("expected verification without the key to fail")
+	}
+	if err := verifyAuditLog([]string{filepath.Join(t.TempDir(), "missing.log")}, io.Discard, io.Discard, os.Getenv); err == nil {
+		t.Fatal("expected a missing log to be rejected")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/paul/clock-server/internal/security"
+)
+
+// runToken handles "clockctl token hash", which prints an
+// API_AUTH_CREDENTIALS token hash so the server never stores the token.
+func runToken(args []string) {
+	if len(args) == 0 || args[0] != "hash" {
+		usageAndExit("unknown token command")
+	}
+	err := hashToken(args[1:], os.Stdin, os.Stdout, os.Stderr, os.Getenv("API_AUTH_TOKEN_PEPPER"))
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// hashToken reads the token from the first line of stdin, or generates one
+// with -generate, and writes the hash (or, with -id, a full credential
+// entry) to stdout. A generated token is written to stderr, as it is the
+// only copy.
+func hashToken(args []string, stdin io.Reader, stdout, stderr io.Writer, pepper string) error {
+	fs := flag.NewFlagSet("token hash", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	algorithm := fs.String("algorithm", "argon2id", "argon2id, or sha256 (HMAC with API_AUTH_TOKEN_PEPPER) for generated tokens")
+	generate := fs.Bool("generate", false, "generate a random token instead of reading one from stdin")
+	id := fs.String("id", "", "credential id; prints a full id|hash|scopes entry")
+	scopes := fs.String("scopes", "*", "comma-separated device scopes for -id")
+	roles := fs.String("roles", "", "comma-separated roles (defined in API_ROLES) for -id")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var token string
+	if *generate {
+		var err error
+		if token, err = security.GenerateToken(); err != nil {
+			return err
+		}
+	} else {
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read token: %w", err)
+		}
+		token = strings.TrimSpace(line)
+	}
+	if token == "" {
+		return errors.New("token is required on stdin (or use -generate)")
+	}
+	if strings.ContainsAny(token, "|;") {
+		return errors.New("token must not contain | or ;")
+	}
+
+	var hash string
+	var err error
+	switch *algorithm {
+	case "argon2id":
+		hash, err = security.HashTokenArgon2id(token)
+	case "sha256":
+		hash, err = security.HashTokenSHA256(token, []byte(pepper))
+		if err != nil {
+			err = errors.New("API_AUTH_TOKEN_PEPPER must be set for -algorithm sha256")
+		}
+	default:
+		err = fmt.Errorf("unknown algorithm %q: expected argon2id or sha256", *algorithm)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *generate {
+		fmt.Fprintf(stderr, "token: %s\n", token)
+	}
+	if strings.TrimSpace(*id) != "" {
+		entry := fmt.Sprintf("%s|%s|%s", strings.TrimSpace(*id), hash, *scopes)
+		if strings.TrimSpace(*roles) != "" {
+			entry += "|" + strings.TrimSpace(*roles)
+		}
+		fmt.Fprintln(stdout, entry)
+		return nil
+	}
+	fmt.Fprintln(stdout, hash)
+	return nil
+}
@@ -2,17 +2,31 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/paul/clock-server/internal/adapters/auditlog"
+	"github.com/paul/clock-server/internal/adapters/events"
+	"github.com/paul/clock-server/internal/adapters/idempotency"
+	"github.com/paul/clock-server/internal/adapters/redis"
 	"github.com/paul/clock-server/internal/api"
 	"github.com/paul/clock-server/internal/application"
 	"github.com/paul/clock-server/internal/bootstrap"
 	"github.com/paul/clock-server/internal/config"
+	"github.com/paul/clock-server/internal/security"
 )
 
 func main() {
@@ -21,13 +35,21 @@ func main() {
 		log.Fatalf("load config: %v", err)
 	}
 
-	sender, checkers, cleanup, err := bootstrap.BuildCompositeSender(cfg)
+	devices, err := bootstrap.BuildDeviceChannel(cfg)
+	if err != nil {
+		log.Fatalf("build device channel: %v", err)
+	}
+	sender, checkers, cleanup, err := bootstrap.BuildCompositeSender(cfg, devices)
 	if err != nil {
 		log.Fatalf("build senders: %v", err)
 	}
 	defer cleanup()
 
-	dispatcher := application.NewCommandDispatcher(sender)
+	broker := events.NewBroker(cfg.EventsBufferSize)
+	if devices != nil {
+		devices.WithEvents(broker)
+	}
+	dispatcher := application.NewCommandDispatcher(sender).WithEvents(broker)
 	handler := api.NewHandler(
 		dispatcher,
 		cfg.AuthCredentials,
@@ -37,7 +59,78 @@ func main() {
 		cfg.MaxBodyBytes,
 		cfg.AuthFailLimitPerMin,
 		checkers...,
-	)
+	).WithIdempotency(idempotency.NewMemoryStore(cfg.IdempotencyTTL, cfg.IdempotencyMaxKeys))
+	if cfg.JWT.Enabled() {
+		verifier, err := security.NewJWTVerifier(cfg.JWT)
+		if err != nil {
+			log.Fatalf("configure JWT authentication: %v", err)
+		}
+		handler.WithJWTVerifier(verifier)
+		log.Printf("JWT authentication enabled for issuer %s", cfg.JWT.Issuer)
+	}
+	tlsConfig, err := clientTLSConfig(cfg)
+	if err != nil {
+		log.Fatalf("configure client certificates: %v", err)
+	}
+	if tlsConfig != nil {
+		handler.WithClientCertPrincipals(cfg.ClientCertPrincipals)
+		log.Printf("client certificate verification %s with %d mapped principals", cfg.TLSClientAuth, len(cfg.ClientCertPrincipals))
+	}
+	handler.WithTokenPepper(cfg.AuthTokenPepper).WithPolicy(cfg.Roles).WithLegacySunset(cfg.LegacyAPISunset).WithEvents(broker)
+	handler.WithRateLimits(cfg.PrincipalRateLimits, cfg.DeviceRateLimits, cfg.DailyQuotas)
+	if cfg.RateLimitBackend == "redis" {
+		limiter, err := redis.NewLimiter(cfg.Redis)
+		if err != nil {
+			log.Fatalf("configure redis rate limiter: %v", err)
+		}
+		defer limiter.Close()
+		checkCtx, cancel := context.WithTimeout(context.Background(), cfg.Redis.Timeout)
+		err = limiter.Check(checkCtx)
+		cancel()
+		if err != nil {
+			log.Fatalf("connect redis rate limiter: %v", err)
+		}
+		handler.WithRateLimiter(limiter)
+		log.Printf("rate limits shared through redis with key prefix %q", cfg.Redis.KeyPrefix)
+	}
+	if cfg.AuditLog.Path != "" {
+		auditLog, err := auditlog.Open(cfg.AuditLog)
+		if err != nil {
+			log.Fatalf("open audit log: %v", err)
+		}
+		defer auditLog.Close()
+		handler.WithAuditLog(auditLog)
+		chain := "SHA-256"
+		if len(cfg.AuditLog.Key) > 0 {
+			chain = "HMAC-SHA256"
+		}
+		log.Printf("audit log %s, chained with %s", cfg.AuditLog.Path, chain)
+	}
+	if cfg.AdminCredential != nil {
+		handler.WithAdminCredential(*cfg.AdminCredential)
+	}
+	if cfg.CredentialStoreFile != "" {
+		store, err := security.OpenCredentialStore(cfg.CredentialStoreFile, cfg.AuthTokenPepper)
+		if err != nil {
+			log.Fatalf("open credential store: %v", err)
+		}
+		if err := cfg.CheckCredentials(store.Credentials()); err != nil {
+			log.Fatalf("credential store %s: %v", cfg.CredentialStoreFile, err)
+		}
+		handler.WithCredentialStore(store)
+		log.Printf("credential store %s holds %d credentials", cfg.CredentialStoreFile, len(store.Credentials()))
+	}
+	if devices != nil {
+		handler.WithDeviceChannel(devices)
+	}
+	if router, ok := sender.(application.CommandRouter); ok {
+		handler.WithRouter(router)
+	}
+	for _, checker := range checkers {
+		if provider, ok := checker.(application.MetricsProvider); ok {
+			handler.WithMetrics(provider)
+		}
+	}
 
 	server := &http.Server{
 		Addr:              cfg.ServerAddr,
@@ -46,15 +139,192 @@ func main() {
 		ReadHeaderTimeout: cfg.ServerHeaderTimeout,
 		WriteTimeout:      cfg.ServerWriteTimeout,
 		IdleTimeout:       cfg.ServerIdleTimeout,
+		TLSConfig:         tlsConfig,
+	}
+
+	servers := []httpServer{server}
+	if cfg.GRPCAddr != "" {
+		grpcServer, err := newGRPCServer(cfg, handler, tlsConfig)
+		if err != nil {
+			log.Fatalf("configure gRPC server: %v", err)
+		}
+		servers = append(servers, grpcServer)
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
+	if cfg.AuthCredentialsFile != "" {
+		go watchCredentials(ctx, cfg, handler)
+		log.Printf("reloading credentials from %s on change and on SIGHUP", cfg.AuthCredentialsFile)
+	}
+	go watchCredentialExpiry(ctx, cfg.CredentialExpiryWarning, handler)
 
 	log.Printf("clock command dispatcher listening on %s", cfg.ServerAddr)
-	if err := runServer(ctx, server, cfg.ServerShutdownPeriod, cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
-		log.Fatalf("server error: %v", err)
+	if cfg.GRPCAddr != "" {
+		log.Printf("gRPC API listening on %s", cfg.GRPCAddr)
+	}
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv httpServer) {
+			errs <- runServer(ctx, srv, cfg.ServerShutdownPeriod, cfg.TLSCertFile, cfg.TLSKeyFile)
+		}(srv)
+	}
+	// The first server to fail stops the others.
+	var serveErr error
+	for range servers {
+		if err := <-errs; err != nil && serveErr == nil {
+			serveErr = err
+			stop()
+		}
+	}
+	if serveErr != nil {
+		log.Fatalf("server error: %v", serveErr)
+	}
+}
+
+// grpcServer runs the gRPC API on its own listener behind the httpServer
+// interface of runServer. Without a certificate it serves cleartext HTTP/2,
+// for use behind a TLS-terminating proxy or within the cluster.
+type grpcServer struct {
+	addr   string
+	server *grpc.Server
+}
+
+// newGRPCServer builds the gRPC server. grpc-go takes its TLS credentials
+// when the server is created, so the certificate files are loaded here and
+// combined with tlsConfig, which verifies client certificates.
+func newGRPCServer(cfg config.Config, handler *api.Handler, tlsConfig *tls.Config) (*grpcServer, error) {
+	opts := []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{MaxConnectionIdle: cfg.ServerIdleTimeout}),
+	}
+	if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS certificate: %w", err)
+		}
+		serverTLS := &tls.Config{MinVersion: tls.VersionTLS12}
+		if tlsConfig != nil {
+			serverTLS = tlsConfig.Clone()
+		}
+		serverTLS.Certificates = []tls.Certificate{cert}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(serverTLS)))
+	}
+	return &grpcServer{addr: cfg.GRPCAddr, server: handler.GRPCServer(opts...)}, nil
+}
+
+func (s *grpcServer) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if err := s.server.Serve(ln); err != nil {
+		if errors.Is(err, grpc.ErrServerStopped) {
+			return http.ErrServerClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// ListenAndServeTLS serves with the credentials newGRPCServer loaded.
+func (s *grpcServer) ListenAndServeTLS(_, _ string) error {
+	return s.ListenAndServe()
+}
+
+// Shutdown stops accepting calls and waits for running ones until ctx is
+// done, then closes the remaining connections.
+func (s *grpcServer) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+// watchCredentials reloads the credentials file into handler when it changes
+// or the process receives SIGHUP, until ctx is done. A file whose
+// credentials fail validation keeps the current set. Every attempt is
+// written to the handler's audit log.
+func watchCredentials(ctx context.Context, cfg config.Config, handler *api.Handler) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	watcher := security.NewCredentialFileWatcher(cfg.AuthCredentialsFile, cfg.CredentialsReloadInterval, cfg.AuthCredentialsDigest,
+		func(creds []security.Credential) error {
+			if err := cfg.CheckCredentials(creds); err != nil {
+				return err
+			}
+			handler.ReplaceCredentials(creds)
+			warnExpiringCredentials(creds, time.Now(), cfg.CredentialExpiryWarning)
+			return nil
+		}).WithReporter(handler.AuditCredentialsReload)
+	watcher.Run(ctx, hup)
+}
+
+// credentialExpiryCheckInterval is how often expiring credentials are
+// reported while the server runs.
+const credentialExpiryCheckInterval = 24 * time.Hour
+
+// watchCredentialExpiry reports credentials that expire within window at
+// startup and then daily, until ctx is done.
+func watchCredentialExpiry(ctx context.Context, window time.Duration, handler *api.Handler) {
+	warnExpiringCredentials(handler.Credentials(), time.Now(), window)
+	ticker := time.NewTicker(credentialExpiryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			warnExpiringCredentials(handler.Credentials(), now, window)
+		}
+	}
+}
+
+// warnExpiringCredentials logs a warning for each credential that has
+// expired or expires within window.
+func warnExpiringCredentials(creds []security.Credential, now time.Time, window time.Duration) {
+	for _, cred := range security.ExpiringCredentials(creds, now, window) {
+		if !now.Before(cred.ExpiresAt) {
+			log.Printf("WARNING: credential %s expired at %s", cred.ID, cred.ExpiresAt.Format(time.RFC3339))
+			continue
+		}
+		log.Printf("WARNING: credential %s expires at %s (in %s)", cred.ID, cred.ExpiresAt.Format(time.RFC3339), cred.ExpiresAt.Sub(now).Round(time.Minute))
+	}
+}
+
+// clientTLSConfig returns the server TLS configuration that verifies client
+// certificates against TLS_CLIENT_CA_FILE, or nil when client certificates
+// are not configured.
+func clientTLSConfig(cfg config.Config) (*tls.Config, error) {
+	if cfg.TLSClientCAFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(cfg.TLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read TLS_CLIENT_CA_FILE: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("TLS_CLIENT_CA_FILE contains no PEM certificates")
+	}
+	clientAuth := tls.VerifyClientCertIfGiven
+	if cfg.TLSClientAuth == "require" {
+		clientAuth = tls.RequireAndVerifyClientCert
 	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientCAs:  pool,
+		ClientAuth: clientAuth,
+	}, nil
 }
 
 type httpServer interface {
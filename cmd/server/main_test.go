@@ -2,11 +2,32 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"errors"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/paul/clock-server/internal/api"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/config"
 )
 
 type fakeHTTPServer struct {
@@ -99,3 +120,103 @@ func TestRunServerUsesTLSWhenConfigured(t *testing.T) {
 		t.Fatalf("unexpected TLS files: cert=%q key=%q", usedCert, usedKey)
 	}
 }
+
+func TestGRPCServerServesCleartextAndShutsDown(t *testing.T) {
+	handler := api.NewHandler(application.NewCommandDispatcher(nil), nil, false, false, false, 1024, 10)
+	server, err := newGRPCServer(config.Config{GRPCAddr: "127.0.0.1:0"}, handler, nil)
+	if err != nil {
+		t.Fatalf("new gRPC server: %v", err)
+	}
+	ln, err := net.Listen("tcp", server.addr)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.server.Serve(ln) }()
+
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected SERVING over cleartext HTTP/2, got %v %v", resp.GetStatus(), err)
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("expected serving to end cleanly, got %v", err)
+	}
+}
+
+func TestClientTLSConfigVerifiesClientCertificates(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test client ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create ca: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	clientDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "clock-agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create client cert: %v", err)
+	}
+	caFile := filepath.Join(t.TempDir(), "client-ca.crt")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0o600); err != nil {
+		t.Fatalf("write ca: %v", err)
+	}
+
+	if cfg, err := clientTLSConfig(config.Config{}); cfg != nil || err != nil {
+		t.Fatalf("expected no client TLS config by default, got %v %v", cfg, err)
+	}
+	if _, err := clientTLSConfig(config.Config{TLSClientCAFile: filepath.Join(t.TempDir(), "missing.crt")}); err == nil {
+		t.Fatal("expected a missing CA file to fail")
+	}
+	tlsConfig, err := clientTLSConfig(config.Config{TLSClientCAFile: caFile, TLSClientAuth: "require"})
+	if err != nil {
+		t.Fatalf("client tls config: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.VerifiedChains[0][0].Subject.CommonName)
+	}))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := srv.Client()
+	if resp, err := client.Get(srv.URL); err == nil {
+		_ = resp.Body.Close()
+		t.Fatal("expected a client without a certificate to be rejected")
+	}
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{{
+		Certificate: [][]byte{clientDER},
+		PrivateKey:  clientKey,
+	}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request with client certificate: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "clock-agent" {
+		t.Fatalf("expected the verified client certificate, got %q", body)
+	}
+}
@@ -1,10 +1,15 @@
 module github.com/paul/clock-server
 
-go 1.22
+go 1.24.0
 
 toolchain go1.24.2
 
-require github.com/cucumber/godog v0.15.1
+require (
+	github.com/cucumber/godog v0.15.1
+	golang.org/x/crypto v0.48.0
+	google.golang.org/grpc v1.80.0
+	google.golang.org/protobuf v1.36.11
+)
 
 require (
 	github.com/cucumber/gherkin/go/v26 v26.2.0 // indirect
@@ -14,4 +19,8 @@ require (
 	github.com/hashicorp/go-memdb v1.3.4 // indirect
 	github.com/hashicorp/golang-lru v0.5.4 // indirect
 	github.com/spf13/pflag v1.0.7 // indirect
+	golang.org/x/net v0.49.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
+	golang.org/x/text v0.34.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260120221211-b8f7ae30c516 // indirect
 )
@@ -0,0 +1,300 @@
+package amqp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// protocolHeader opens an AMQP 0-9-1 connection.
+var protocolHeader = []byte{'A', 'M', 'Q', 'P', 0, 0, 9, 1}
+
+// Frame types.
+const (
+	frameMethod    byte = 1
+	frameHeader    byte = 2
+	frameBody      byte = 3
+	frameHeartbeat byte = 8
+	frameEnd       byte = 0xCE
+)
+
+// Class and method IDs of the subset used by the sender.
+const (
+	classConnection uint16 = 10
+	classChannel    uint16 = 20
+	classBasic      uint16 = 60
+	classConfirm    uint16 = 85
+
+	methodConnectionStart   uint16 = 10
+	methodConnectionStartOk uint16 = 11
+	methodConnectionTune    uint16 = 30
+	methodConnectionTuneOk  uint16 = 31
+	methodConnectionOpen    uint16 = 40
+	methodConnectionOpenOk  uint16 = 41
+	methodConnectionClose   uint16 = 50
+	methodConnectionCloseOk uint16 = 51
+
+	methodChannelOpen    uint16 = 10
+	methodChannelOpenOk  uint16 = 11
+	methodChannelClose   uint16 = 40
+	methodChannelCloseOk uint16 = 41
+
+	methodBasicPublish uint16 = 40
+	methodBasicReturn  uint16 = 50
+	methodBasicAck     uint16 = 80
+	methodBasicNack    uint16 = 120
+
+	methodConfirmSelect   uint16 = 10
+	methodConfirmSelectOk uint16 = 11
+)
+
+// Basic property flags, in wire order.
+const (
+	propContentType  uint16 = 1 << 15
+	propDeliveryMode uint16 = 1 << 12
+	propMessageID    uint16 = 1 << 7
+	propType         uint16 = 1 << 5
+)
+
+const (
+	// defaultFrameMax is proposed when the broker sets no limit.
+	defaultFrameMax = 128 * 1024
+	// maxFrameSize bounds frames read from the broker.
+	maxFrameSize = 4 * 1024 * 1024
+	// frameOverhead is the frame header plus frame-end octet.
+	frameOverhead = 8
+)
+
+type frame struct {
+	typ     byte
+	channel uint16
+	payload []byte
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return frame{}, fmt.Errorf("read amqp frame: %w", err)
+	}
+	size := binary.BigEndian.Uint32(header[3:7])
+	if size > maxFrameSize {
+		return frame{}, fmt.Errorf("amqp frame too large: %d bytes", size)
+	}
+	payload := make([]byte, size+1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return frame{}, fmt.Errorf("read amqp frame: %w", err)
+	}
+	if payload[size] != frameEnd {
+		return frame{}, errors.New("invalid amqp frame end")
+	}
+	return frame{typ: header[0], channel: binary.BigEndian.Uint16(header[1:3]), payload: payload[:size]}, nil
+}
+
+func encodeFrame(typ byte, channel uint16, payload []byte) []byte {
+	out := make([]byte, 7, len(payload)+frameOverhead)
+	out[0] = typ
+	binary.BigEndian.PutUint16(out[1:3], channel)
+	binary.BigEndian.PutUint32(out[3:7], uint32(len(payload)))
+	out = append(out, payload...)
+	return append(out, frameEnd)
+}
+
+// methodID returns the class and method of a method frame.
+func (f frame) methodID() (uint16, uint16, error) {
+	if f.typ != frameMethod || len(f.payload) < 4 {
+		return 0, 0, fmt.Errorf("expected amqp method frame, got type %d", f.typ)
+	}
+	return binary.BigEndian.Uint16(f.payload[0:2]), binary.BigEndian.Uint16(f.payload[2:4]), nil
+}
+
+// args returns a reader over the method arguments.
+func (f frame) args() *argReader {
+	return &argReader{buf: bytes.NewReader(f.payload[4:])}
+}
+
+// argWriter encodes method arguments.
+type argWriter struct {
+	buf bytes.Buffer
+}
+
+func newMethod(class, method uint16) *argWriter {
+	w := &argWriter{}
+	w.short(class)
+	w.short(method)
+	return w
+}
+
+func (w *argWriter) octet(v byte) { w.buf.WriteByte(v) }
+
+func (w *argWriter) short(v uint16) {
+	_ = binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *argWriter) long(v uint32) {
+	_ = binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *argWriter) longlong(v uint64) {
+	_ = binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *argWriter) shortstr(s string) error {
+	if len(s) > 255 {
+		return fmt.Errorf("amqp short string too long: %d bytes", len(s))
+	}
+	w.buf.WriteByte(byte(len(s)))
+	w.buf.WriteString(s)
+	return nil
+}
+
+func (w *argWriter) longstr(s string) {
+	w.long(uint32(len(s)))
+	w.buf.WriteString(s)
+}
+
+// emptyTable writes a field table with no entries.
+func (w *argWriter) emptyTable() { w.long(0) }
+
+func (w *argWriter) bytes() []byte { return w.buf.Bytes() }
+
+// argReader decodes method arguments.
+type argReader struct {
+	buf *bytes.Reader
+	err error
+}
+
+func (r *argReader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	out := make([]byte, n)
+	if _, err := io.ReadFull(r.buf, out); err != nil {
+		r.err = errors.New("truncated amqp method arguments")
+		return nil
+	}
+	return out
+}
+
+func (r *argReader) octet() byte {
+	if b := r.read(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *argReader) short() uint16 {
+	if b := r.read(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *argReader) long() uint32 {
+	if b := r.read(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *argReader) longlong() uint64 {
+	if b := r.read(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *argReader) shortstr() string {
+	return string(r.read(int(r.octet())))
+}
+
+func (r *argReader) longstr() string {
+	size := r.long()
+	if r.err == nil && int64(size) > int64(r.buf.Len()) {
+		r.err = errors.New("truncated amqp method arguments")
+		return ""
+	}
+	return string(r.read(int(size)))
+}
+
+// skipTable discards a field table; the sender needs none of their values.
+func (r *argReader) skipTable() {
+	_ = r.longstr()
+}
+
+// publishFrames encodes basic.publish with its content header and body
+// frames. Body frames are split to fit frameMax.
+func publishFrames(channel uint16, exchange, routingKey string, props properties, body []byte, frameMax uint32) ([]byte, error) {
+	method := newMethod(classBasic, methodBasicPublish)
+	method.short(0) // reserved
+	if err := method.shortstr(exchange); err != nil {
+		return nil, err
+	}
+	if err := method.shortstr(routingKey); err != nil {
+		return nil, err
+	}
+	method.octet(0x01) // mandatory: unroutable messages are returned
+
+	header := &argWriter{}
+	header.short(classBasic)
+	header.short(0) // weight
+	header.longlong(uint64(len(body)))
+	if err := props.encode(header); err != nil {
+		return nil, err
+	}
+
+	out := encodeFrame(frameMethod, channel, method.bytes())
+	out = append(out, encodeFrame(frameHeader, channel, header.bytes())...)
+	chunk := int(frameMax) - frameOverhead
+	for len(body) > 0 {
+		n := min(chunk, len(body))
+		out = append(out, encodeFrame(frameBody, channel, body[:n])...)
+		body = body[n:]
+	}
+	return out, nil
+}
+
+// properties are the basic content properties set on published commands.
+type properties struct {
+	ContentType  string
+	DeliveryMode byte
+	MessageID    string
+	Type         string
+}
+
+func (p properties) encode(w *argWriter) error {
+	var flags uint16
+	if p.ContentType != "" {
+		flags |= propContentType
+	}
+	if p.DeliveryMode != 0 {
+		flags |= propDeliveryMode
+	}
+	if p.MessageID != "" {
+		flags |= propMessageID
+	}
+	if p.Type != "" {
+		flags |= propType
+	}
+	w.short(flags)
+	if p.ContentType != "" {
+		if err := w.shortstr(p.ContentType); err != nil {
+			return err
+		}
+	}
+	if p.DeliveryMode != 0 {
+		w.octet(p.DeliveryMode)
+	}
+	if p.MessageID != "" {
+		if err := w.shortstr(p.MessageID); err != nil {
+			return err
+		}
+	}
+	if p.Type != "" {
+		if err := w.shortstr(p.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
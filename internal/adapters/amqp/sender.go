@@ -0,0 +1,704 @@
+// Package amqp publishes commands to an AMQP 0-9-1 broker such as RabbitMQ.
+// It implements the small protocol subset needed for confirmed publishing
+// on a single channel, without external dependencies.
+package amqp
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paul/clock-server/internal/adapters/retry"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+const (
+	defaultConnectTimeout = 10 * time.Second
+	defaultConfirmTimeout = 5 * time.Second
+	defaultHeartbeat      = 30 * time.Second
+	maxHeartbeat          = 65535 * time.Second
+	defaultExchange       = "clocks.commands"
+	defaultAttempts       = 3
+
+	// publishChannel is the only channel the sender opens.
+	publishChannel uint16 = 1
+	// deliveryPersistent asks the broker to store messages on disk.
+	deliveryPersistent byte = 2
+)
+
+// Config defines AMQP adapter settings.
+type Config struct {
+	// URL is amqps://host[:port][/vhost] or, when AllowInsecureTransport is
+	// set, amqp://. Credentials in the URL are used unless Username is set.
+	URL      string
+	Username string
+	Password string
+	// Exchange receives every command. It must exist; the sender does not
+	// declare topology.
+	Exchange string
+	// RoutingKeyPrefix is prepended to "<deviceId>.<commandType>".
+	RoutingKeyPrefix string
+	ConfirmTimeout   time.Duration
+	// Heartbeat is proposed to the broker; the lower of it and the
+	// broker's proposal is used. A zero Heartbeat proposes 30 seconds.
+	Heartbeat              time.Duration
+	TLSInsecureSkipVerify  bool
+	AllowInsecureTLS       bool
+	AllowInsecureTransport bool
+	// Retry controls publish retries, each reconnecting if the connection
+	// was lost. A zero MaxAttempts makes three attempts.
+	Retry retry.Policy
+}
+
+// Sender publishes smart clock commands to an AMQP exchange with publisher
+// confirms, over a persistent connection that is re-established on failure.
+type Sender struct {
+	cfg        Config
+	address    string
+	tlsEnabled bool
+	serverName string
+	vhost      string
+	username   string
+	password   string
+
+	// slot serialises publishes; waiting for it honours the caller's
+	// context.
+	slot chan struct{}
+
+	mu          sync.Mutex
+	link        *link
+	closed      bool
+	connTimeout time.Duration
+}
+
+// link is one broker connection. A reader goroutine owns every read, so
+// broker heartbeats are consumed and a silent broker is noticed even while
+// no publish is waiting for a confirm.
+type link struct {
+	conn      net.Conn
+	frameMax  uint32
+	heartbeat time.Duration
+
+	// frames carries method and content frames to the waiting publish.
+	frames chan frame
+	// done is closed when the reader stops; err then says why.
+	done      chan struct{}
+	err       error
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	writeMu sync.Mutex
+	// deliveryTag is guarded by the sender's slot.
+	deliveryTag uint64
+}
+
+// NewSender creates and connects an AMQP sender.
+func NewSender(cfg Config) (*Sender, error) {
+	if strings.TrimSpace(cfg.URL) == "" {
+		return nil, errors.New("amqp url is required")
+	}
+	if strings.TrimSpace(cfg.Exchange) == "" {
+		cfg.Exchange = defaultExchange
+	}
+	if cfg.ConfirmTimeout <= 0 {
+		cfg.ConfirmTimeout = defaultConfirmTimeout
+	}
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = defaultHeartbeat
+	}
+	// The protocol negotiates whole seconds.
+	cfg.Heartbeat = min(max(cfg.Heartbeat.Truncate(time.Second), time.Second), maxHeartbeat)
+	if cfg.TLSInsecureSkipVerify && !cfg.AllowInsecureTLS {
+		return nil, errors.New("AMQP_TLS_INSECURE_SKIP_VERIFY requires ALLOW_INSECURE_TLS_VERIFY=true")
+	}
+	parsed, err := parseBrokerURL(cfg.URL, cfg.AllowInsecureTransport)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sender{
+		cfg:         cfg,
+		address:     net.JoinHostPort(parsed.host, parsed.port),
+		tlsEnabled:  parsed.tls,
+		serverName:  parsed.host,
+		vhost:       parsed.vhost,
+		username:    parsed.username,
+		password:    parsed.password,
+		slot:        make(chan struct{}, 1),
+		connTimeout: defaultConnectTimeout,
+	}
+	if cfg.Username != "" {
+		s.username, s.password = cfg.Username, cfg.Password
+	}
+	l, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	s.link = l
+	return s, nil
+}
+
+// Send publishes the command and waits for the broker to confirm it.
+func (s *Sender) Send(ctx context.Context, cmd domain.ClockCommand) error {
+	if cmd == nil {
+		return errors.New("command is required")
+	}
+	payload, err := commands.DevicePayload(cmd)
+	if err != nil {
+		return err
+	}
+	key := application.IdempotencyKeyFromContext(ctx)
+	if key != "" {
+		payload["idempotencyKey"] = key
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal amqp payload: %w", err)
+	}
+	routingKey := buildRoutingKey(s.cfg.RoutingKeyPrefix, cmd)
+	props := properties{
+		ContentType:  "application/json",
+		DeliveryMode: deliveryPersistent,
+		MessageID:    key,
+		Type:         cmd.CommandType(),
+	}
+
+	policy := s.cfg.Retry
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = defaultAttempts
+	}
+	attempts, err := policy.Do(ctx, retry.Allowed(ctx, cmd), func(ctx context.Context) error {
+		return s.attempt(ctx, routingKey, props, body)
+	})
+	if err != nil {
+		if attempts <= 1 {
+			return err
+		}
+		return fmt.Errorf("amqp publish failed after %d attempts: %w", attempts, err)
+	}
+	return nil
+}
+
+// attempt publishes once, reconnecting first when the connection was
+// dropped. Connection failures are always retryable because nothing was
+// published; an unroutable command is not, since no queue is bound for it.
+func (s *Sender) attempt(ctx context.Context, routingKey string, props properties, body []byte) error {
+	select {
+	case s.slot <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s.slot }()
+
+	l, err := s.current()
+	if err != nil {
+		return retry.RetryableBeforeSend(err)
+	}
+	err = s.publish(ctx, l, routingKey, props, body)
+	var unroutable *unroutableError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &unroutable):
+		return err
+	default:
+		s.drop(l)
+		return retry.Retryable(err, 0)
+	}
+}
+
+// current returns the live connection, replacing one that was dropped or
+// whose reader stopped.
+func (s *Sender) current() (*link, error) {
+	s.mu.Lock()
+	l, closed := s.link, s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil, errors.New("amqp sender is closed")
+	}
+	if l != nil {
+		if l.alive() {
+			return l, nil
+		}
+		s.drop(l)
+	}
+
+	l, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		l.close()
+		return nil, errors.New("amqp sender is closed")
+	}
+	s.link = l
+	return l, nil
+}
+
+// drop closes l and forgets it if it is still the current connection.
+func (s *Sender) drop(l *link) {
+	s.mu.Lock()
+	if s.link == l {
+		s.link = nil
+	}
+	s.mu.Unlock()
+	l.close()
+}
+
+// Check verifies adapter readiness.
+func (s *Sender) Check(_ context.Context) error {
+	s.mu.Lock()
+	l := s.link
+	s.mu.Unlock()
+	if l == nil {
+		return errors.New("amqp not connected")
+	}
+	if !l.alive() {
+		return fmt.Errorf("amqp connection lost: %w", l.err)
+	}
+	return nil
+}
+
+// Close disconnects from the broker. A publish waiting for a confirm
+// fails immediately.
+func (s *Sender) Close() {
+	s.mu.Lock()
+	l := s.link
+	s.link = nil
+	s.closed = true
+	s.mu.Unlock()
+	if l != nil {
+		l.close()
+	}
+}
+
+// connect dials the broker, opens the connection and a publishing channel,
+// and puts the channel into confirm mode.
+func (s *Sender) connect() (*link, error) {
+	dialer := net.Dialer{Timeout: s.connTimeout}
+	var conn net.Conn
+	var err error
+	if s.tlsEnabled {
+		conn, err = tls.DialWithDialer(&dialer, "tcp", s.address, &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ServerName:         s.serverName,
+			InsecureSkipVerify: s.cfg.TLSInsecureSkipVerify,
+		})
+	} else {
+		conn, err = dialer.Dial("tcp", s.address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dial amqp broker: %w", err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(s.connTimeout)); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("set connect deadline: %w", err)
+	}
+	frameMax, heartbeat, err := s.handshake(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("clear deadline: %w", err)
+	}
+	return newLink(conn, frameMax, heartbeat), nil
+}
+
+func (s *Sender) handshake(conn net.Conn) (uint32, time.Duration, error) {
+	if _, err := conn.Write(protocolHeader); err != nil {
+		return 0, 0, fmt.Errorf("write amqp protocol header: %w", err)
+	}
+
+	start, err := expectMethod(conn, 0, classConnection, methodConnectionStart)
+	if err != nil {
+		return 0, 0, err
+	}
+	args := start.args()
+	args.octet() // version-major
+	args.octet() // version-minor
+	args.skipTable()
+	mechanisms := args.longstr()
+	if args.err != nil {
+		return 0, 0, args.err
+	}
+	if !containsWord(mechanisms, "PLAIN") {
+		return 0, 0, fmt.Errorf("amqp broker does not offer PLAIN authentication (offers %q)", mechanisms)
+	}
+
+	startOk := newMethod(classConnection, methodConnectionStartOk)
+	startOk.emptyTable() // client-properties
+	_ = startOk.shortstr("PLAIN")
+	startOk.longstr("\x00" + s.username + "\x00" + s.password)
+	_ = startOk.shortstr("en_US")
+	if err := writeMethod(conn, 0, startOk); err != nil {
+		return 0, 0, err
+	}
+
+	// The broker closes the connection instead of tuning when the
+	// credentials are rejected.
+	tune, err := expectMethod(conn, 0, classConnection, methodConnectionTune)
+	if err != nil {
+		return 0, 0, fmt.Errorf("amqp authentication: %w", err)
+	}
+	args = tune.args()
+	channelMax := args.short()
+	frameMax := args.long()
+	heartbeat := negotiateHeartbeat(s.cfg.Heartbeat, args.short())
+	if args.err != nil {
+		return 0, 0, args.err
+	}
+	if frameMax == 0 || frameMax > defaultFrameMax {
+		frameMax = defaultFrameMax
+	}
+
+	tuneOk := newMethod(classConnection, methodConnectionTuneOk)
+	tuneOk.short(channelMax)
+	tuneOk.long(frameMax)
+	tuneOk.short(uint16(heartbeat / time.Second))
+	if err := writeMethod(conn, 0, tuneOk); err != nil {
+		return 0, 0, err
+	}
+
+	open := newMethod(classConnection, methodConnectionOpen)
+	if err := open.shortstr(s.vhost); err != nil {
+		return 0, 0, err
+	}
+	_ = open.shortstr("") // reserved
+	open.octet(0)         // reserved
+	if err := writeMethod(conn, 0, open); err != nil {
+		return 0, 0, err
+	}
+	if _, err := expectMethod(conn, 0, classConnection, methodConnectionOpenOk); err != nil {
+		return 0, 0, fmt.Errorf("open amqp vhost %q: %w", s.vhost, err)
+	}
+
+	channelOpen := newMethod(classChannel, methodChannelOpen)
+	_ = channelOpen.shortstr("") // reserved
+	if err := writeMethod(conn, publishChannel, channelOpen); err != nil {
+		return 0, 0, err
+	}
+	if _, err := expectMethod(conn, publishChannel, classChannel, methodChannelOpenOk); err != nil {
+		return 0, 0, err
+	}
+
+	confirmSelect := newMethod(classConfirm, methodConfirmSelect)
+	confirmSelect.octet(0) // nowait
+	if err := writeMethod(conn, publishChannel, confirmSelect); err != nil {
+		return 0, 0, err
+	}
+	if _, err := expectMethod(conn, publishChannel, classConfirm, methodConfirmSelectOk); err != nil {
+		return 0, 0, fmt.Errorf("enable amqp publisher confirms: %w", err)
+	}
+	return frameMax, heartbeat, nil
+}
+
+// negotiateHeartbeat picks the lower of the client's and the broker's
+// proposal, or the client's when the broker proposes none.
+func negotiateHeartbeat(client time.Duration, brokerSeconds uint16) time.Duration {
+	broker := time.Duration(brokerSeconds) * time.Second
+	if broker == 0 || client < broker {
+		return client
+	}
+	return broker
+}
+
+// publish sends one message and waits for its confirm, for at most
+// ConfirmTimeout and never past the caller's context.
+func (s *Sender) publish(ctx context.Context, l *link, routingKey string, props properties, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ConfirmTimeout)
+	defer cancel()
+
+	frames, err := publishFrames(publishChannel, s.cfg.Exchange, routingKey, props, body, l.frameMax)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := l.write(frames, deadline); err != nil {
+		return fmt.Errorf("write amqp publish: %w", err)
+	}
+	l.deliveryTag++
+	return l.awaitConfirm(ctx, l.deliveryTag)
+}
+
+// awaitConfirm waits until the broker acks or nacks tag. A mandatory
+// message that matched no queue is returned before its ack.
+func (l *link) awaitConfirm(ctx context.Context, tag uint64) error {
+	var returned error
+	for {
+		var f frame
+		select {
+		case f = <-l.frames:
+		case <-l.done:
+			// Frames read before the reader stopped still count.
+			select {
+			case f = <-l.frames:
+			default:
+				return l.err
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("wait for amqp confirm: %w", ctx.Err())
+		}
+		if f.typ != frameMethod {
+			// The content of a returned message.
+			continue
+		}
+		class, method, err := f.methodID()
+		if err != nil {
+			return err
+		}
+		args := f.args()
+		switch {
+		case class == classBasic && (method == methodBasicAck || method == methodBasicNack):
+			confirmed := args.longlong()
+			multiple := args.octet()&0x01 != 0
+			if args.err != nil {
+				return args.err
+			}
+			if confirmed != tag && !(multiple && confirmed > tag) {
+				continue
+			}
+			if method == methodBasicNack {
+				return errors.New("amqp broker rejected the message")
+			}
+			return returned
+		case class == classBasic && method == methodBasicReturn:
+			code := args.short()
+			text := args.shortstr()
+			args.shortstr() // exchange
+			returned = &unroutableError{routingKey: args.shortstr(), code: code, text: text}
+		case class == classChannel && method == methodChannelClose:
+			_ = l.writeMethod(publishChannel, newMethod(classChannel, methodChannelCloseOk))
+			return closeError("channel", args)
+		}
+	}
+}
+
+func newLink(conn net.Conn, frameMax uint32, heartbeat time.Duration) *link {
+	l := &link{
+		conn:      conn,
+		frameMax:  frameMax,
+		heartbeat: heartbeat,
+		frames:    make(chan frame, 16),
+		done:      make(chan struct{}),
+		closing:   make(chan struct{}),
+	}
+	go l.readLoop()
+	go l.heartbeatLoop()
+	return l
+}
+
+// alive reports whether the reader is still running.
+func (l *link) alive() bool {
+	select {
+	case <-l.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (l *link) close() {
+	l.closeOnce.Do(func() {
+		close(l.closing)
+		_ = l.conn.Close()
+	})
+}
+
+// readLoop reads every frame from the broker. It drops heartbeats, answers
+// a connection close and hands the other frames to the waiting publish. A
+// broker that sends nothing for two heartbeat intervals is considered gone.
+func (l *link) readLoop() {
+	defer close(l.done)
+	for {
+		_ = l.conn.SetReadDeadline(time.Now().Add(2 * l.heartbeat))
+		f, err := readFrame(l.conn)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				err = fmt.Errorf("amqp broker missed heartbeats for %s", 2*l.heartbeat)
+			}
+			l.err = err
+			_ = l.conn.Close()
+			return
+		}
+		if f.typ == frameHeartbeat {
+			continue
+		}
+		if f.typ == frameMethod {
+			if class, method, err := f.methodID(); err == nil && class == classConnection && method == methodConnectionClose {
+				_ = l.writeMethod(0, newMethod(classConnection, methodConnectionCloseOk))
+				l.err = closeError("connection", f.args())
+				_ = l.conn.Close()
+				return
+			}
+		}
+		select {
+		case l.frames <- f:
+		case <-l.closing:
+			l.err = errors.New("amqp connection closed")
+			return
+		}
+	}
+}
+
+// heartbeatLoop sends a heartbeat every half interval so the broker keeps
+// an idle connection open.
+func (l *link) heartbeatLoop() {
+	ticker := time.NewTicker(l.heartbeat / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.write(encodeFrame(frameHeartbeat, 0, nil), time.Now().Add(l.heartbeat)); err != nil {
+				return
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// write sends b in one piece; frames of a publish must not interleave with
+// a heartbeat.
+func (l *link) write(b []byte, deadline time.Time) error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	if err := l.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	_, err := l.conn.Write(b)
+	return err
+}
+
+func (l *link) writeMethod(channel uint16, method *argWriter) error {
+	if err := l.write(encodeFrame(frameMethod, channel, method.bytes()), time.Now().Add(l.heartbeat)); err != nil {
+		return fmt.Errorf("write amqp method: %w", err)
+	}
+	return nil
+}
+
+// unroutableError reports a command no queue is bound for.
+type unroutableError struct {
+	routingKey string
+	code       uint16
+	text       string
+}
+
+func (e *unroutableError) Error() string {
+	return fmt.Sprintf("amqp message with routing key %q was not routed to any queue: %d %s", e.routingKey, e.code, e.text)
+}
+
+func closeError(scope string, args *argReader) error {
+	code := args.short()
+	text := args.shortstr()
+	return fmt.Errorf("amqp broker closed the %s: %d %s", scope, code, text)
+}
+
+func writeMethod(conn net.Conn, channel uint16, method *argWriter) error {
+	if _, err := conn.Write(encodeFrame(frameMethod, channel, method.bytes())); err != nil {
+		return fmt.Errorf("write amqp method: %w", err)
+	}
+	return nil
+}
+
+// expectMethod reads the next method frame and fails unless it is
+// class.method on channel. A close from the broker is reported with its
+// reply text.
+func expectMethod(conn net.Conn, channel, class, method uint16) (frame, error) {
+	for {
+		f, err := readFrame(conn)
+		if err != nil {
+			return frame{}, err
+		}
+		if f.typ == frameHeartbeat {
+			continue
+		}
+		gotClass, gotMethod, err := f.methodID()
+		if err != nil {
+			return frame{}, err
+		}
+		switch {
+		case gotClass == class && gotMethod == method && f.channel == channel:
+			return f, nil
+		case gotClass == classConnection && gotMethod == methodConnectionClose:
+			return frame{}, closeError("connection", f.args())
+		case gotClass == classChannel && gotMethod == methodChannelClose:
+			return frame{}, closeError("channel", f.args())
+		default:
+			return frame{}, fmt.Errorf("unexpected amqp method %d.%d, want %d.%d", gotClass, gotMethod, class, method)
+		}
+	}
+}
+
+type brokerURL struct {
+	host, port         string
+	tls                bool
+	vhost              string
+	username, password string
+}
+
+func parseBrokerURL(raw string, allowInsecureTransport bool) (brokerURL, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return brokerURL{}, fmt.Errorf("invalid amqp url: %w", err)
+	}
+	var out brokerURL
+	switch strings.ToLower(parsed.Scheme) {
+	case "amqp":
+		if !allowInsecureTransport {
+			return brokerURL{}, errors.New("insecure amqp:// is disabled; use amqps:// or set ALLOW_INSECURE_AMQP=true")
+		}
+		out.port = "5672"
+	case "amqps":
+		out.tls = true
+		out.port = "5671"
+	default:
+		return brokerURL{}, fmt.Errorf("unsupported amqp scheme %q", parsed.Scheme)
+	}
+	out.host = parsed.Hostname()
+	if out.host == "" {
+		return brokerURL{}, errors.New("amqp broker host is required")
+	}
+	if port := parsed.Port(); port != "" {
+		out.port = port
+	}
+	// The vhost is the path without its leading slash; "/" when empty.
+	out.vhost = "/"
+	if path := strings.TrimPrefix(parsed.Path, "/"); path != "" {
+		out.vhost = path
+	}
+	if parsed.User != nil {
+		out.username = parsed.User.Username()
+		out.password, _ = parsed.User.Password()
+	}
+	return out, nil
+}
+
+func buildRoutingKey(prefix string, cmd domain.ClockCommand) string {
+	key := cmd.TargetDeviceID() + "." + cmd.CommandType()
+	if cleanPrefix := strings.Trim(strings.TrimSpace(prefix), "."); cleanPrefix != "" {
+		return cleanPrefix + "." + key
+	}
+	return key
+}
+
+func containsWord(list, word string) bool {
+	for _, field := range strings.Fields(list) {
+		if field == word {
+			return true
+		}
+	}
+	return false
+}
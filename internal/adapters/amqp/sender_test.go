@@ -0,0 +1,632 @@
+package amqp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paul/clock-server/internal/adapters/retry"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+// ---------------------------------------------------------------------------
+// Helpers: mock AMQP broker
+// ---------------------------------------------------------------------------
+
+// brokerBehavior controls how a mockBroker answers publishes.
+type brokerBehavior int
+
+const (
+	behaviorAck          brokerBehavior = iota // confirm every publish
+	behaviorNack                               // nack every publish
+	behaviorReturn                             // return every publish as unroutable, then ack it
+	behaviorDropFirst                          // close the first connection instead of confirming
+	behaviorRejectLogin                        // close the connection with 403 instead of tuning
+	behaviorCloseChannel                       // close the channel with 404 instead of confirming
+	behaviorSilent                             // never confirm
+)
+
+type publishRecord struct {
+	exchange   string
+	routingKey string
+	props      []byte
+	body       []byte
+}
+
+// mockBroker is an in-process TCP listener speaking the server side of the
+// AMQP subset the sender uses.
+type mockBroker struct {
+	t         *testing.T
+	ln        net.Listener
+	behavior  brokerBehavior
+	frameMax  uint32
+	heartbeat uint16
+
+	mu          sync.Mutex
+	conns       []net.Conn
+	connections int
+	logins      []string
+	vhosts      []string
+	published   []publishRecord
+	tuned       []uint16
+	heartbeats  int
+}
+
+func newMockBroker(t *testing.T, behavior brokerBehavior) *mockBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	return startMockBroker(t, ln, behavior)
+}
+
+func startMockBroker(t *testing.T, ln net.Listener, behavior brokerBehavior) *mockBroker {
+	mb := &mockBroker{t: t, ln: ln, behavior: behavior, frameMax: 4096, heartbeat: 60}
+	go mb.acceptLoop()
+	t.Cleanup(mb.close)
+	return mb
+}
+
+func (mb *mockBroker) url() string {
+	return "amqp://clock:secret@" + mb.ln.Addr().String() + "/building"
+}
+
+func (mb *mockBroker) close() {
+	_ = mb.ln.Close()
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for _, c := range mb.conns {
+		_ = c.Close()
+	}
+}
+
+func (mb *mockBroker) records() []publishRecord {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return append([]publishRecord(nil), mb.published...)
+}
+
+// session returns the login response and vhost of connection i.
+func (mb *mockBroker) session(i int) (login, vhost string) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.logins[i], mb.vhosts[i]
+}
+
+func (mb *mockBroker) acceptLoop() {
+	for {
+		conn, err := mb.ln.Accept()
+		if err != nil {
+			return
+		}
+		mb.mu.Lock()
+		mb.conns = append(mb.conns, conn)
+		mb.connections++
+		n := mb.connections
+		mb.mu.Unlock()
+		go mb.handleConn(conn, n)
+	}
+}
+
+func (mb *mockBroker) handleConn(conn net.Conn, n int) {
+	defer conn.Close()
+
+	header := make([]byte, len(protocolHeader))
+	if _, err := io.ReadFull(conn, header); err != nil || !bytes.Equal(header, protocolHeader) {
+		return
+	}
+	start := newMethod(classConnection, methodConnectionStart)
+	start.octet(0)
+	start.octet(9)
+	start.emptyTable()
+	start.longstr("AMQPLAIN PLAIN")
+	start.longstr("en_US")
+	_ = writeMethod(conn, 0, start)
+
+	startOk, ok := mb.expect(conn, classConnection, methodConnectionStartOk)
+	if !ok {
+		return
+	}
+	args := startOk.args()
+	args.skipTable()
+	args.shortstr()
+	response := args.longstr()
+	mb.mu.Lock()
+	mb.logins = append(mb.logins, response)
+	heartbeat := mb.heartbeat
+	mb.mu.Unlock()
+
+	if mb.behavior == behaviorRejectLogin {
+		mb.sendClose(conn, 0, classConnection, 403, "ACCESS_REFUSED")
+		return
+	}
+
+	tune := newMethod(classConnection, methodConnectionTune)
+	tune.short(2047)
+	tune.long(mb.frameMax)
+	tune.short(heartbeat)
+	_ = writeMethod(conn, 0, tune)
+	tuneOk, ok := mb.expect(conn, classConnection, methodConnectionTuneOk)
+	if !ok {
+		return
+	}
+	args = tuneOk.args()
+	args.short()
+	args.long()
+	mb.mu.Lock()
+	mb.tuned = append(mb.tuned, args.short())
+	mb.mu.Unlock()
+	open, ok := mb.expect(conn, classConnection, methodConnectionOpen)
+	if !ok {
+		return
+	}
+	mb.mu.Lock()
+	mb.vhosts = append(mb.vhosts, open.args().shortstr())
+	mb.mu.Unlock()
+	_ = writeMethod(conn, 0, newMethod(classConnection, methodConnectionOpenOk))
+
+	if _, ok := mb.expect(conn, classChannel, methodChannelOpen); !ok {
+		return
+	}
+	channelOpenOk := newMethod(classChannel, methodChannelOpenOk)
+	channelOpenOk.longstr("")
+	_ = writeMethod(conn, publishChannel, channelOpenOk)
+	if _, ok := mb.expect(conn, classConfirm, methodConfirmSelect); !ok {
+		return
+	}
+	_ = writeMethod(conn, publishChannel, newMethod(classConfirm, methodConfirmSelectOk))
+
+	var tag uint64
+	for {
+		record, ok := mb.readPublish(conn)
+		if !ok {
+			return
+		}
+		tag++
+		mb.mu.Lock()
+		mb.published = append(mb.published, record)
+		mb.mu.Unlock()
+
+		switch {
+		case mb.behavior == behaviorDropFirst && n == 1:
+			return
+		case mb.behavior == behaviorSilent:
+			continue
+		case mb.behavior == behaviorCloseChannel:
+			mb.sendClose(conn, publishChannel, classChannel, 404, "NOT_FOUND - no exchange")
+			continue
+		case mb.behavior == behaviorReturn:
+			ret := newMethod(classBasic, methodBasicReturn)
+			ret.short(312)
+			_ = ret.shortstr("NO_ROUTE")
+			_ = ret.shortstr(record.exchange)
+			_ = ret.shortstr(record.routingKey)
+			_ = writeMethod(conn, publishChannel, ret)
+			_, _ = conn.Write(encodeFrame(frameHeader, publishChannel, record.props))
+			_, _ = conn.Write(encodeFrame(frameBody, publishChannel, record.body))
+		}
+
+		confirm := newMethod(classBasic, methodBasicAck)
+		if mb.behavior == behaviorNack {
+			confirm = newMethod(classBasic, methodBasicNack)
+		}
+		confirm.longlong(tag)
+		confirm.octet(0)
+		_, _ = conn.Write(encodeFrame(frameHeartbeat, 0, nil))
+		_ = writeMethod(conn, publishChannel, confirm)
+	}
+}
+
+func (mb *mockBroker) expect(conn net.Conn, class, method uint16) (frame, bool) {
+	f, err := readFrame(conn)
+	if err != nil {
+		return frame{}, false
+	}
+	gotClass, gotMethod, err := f.methodID()
+	if err != nil || gotClass != class || gotMethod != method {
+		mb.t.Errorf("broker expected %d.%d, got %d.%d (%v)", class, method, gotClass, gotMethod, err)
+		return frame{}, false
+	}
+	return f, true
+}
+
+func (mb *mockBroker) sendClose(conn net.Conn, channel, class uint16, code uint16, text string) {
+	method := methodConnectionClose
+	if class == classChannel {
+		method = methodChannelClose
+	}
+	closeMethod := newMethod(class, method)
+	closeMethod.short(code)
+	_ = closeMethod.shortstr(text)
+	closeMethod.short(0)
+	closeMethod.short(0)
+	_ = writeMethod(conn, channel, closeMethod)
+	_, _ = readFrame(conn) // close-ok
+}
+
+// readPublish reads basic.publish, its content header and the body frames.
+func (mb *mockBroker) readPublish(conn net.Conn) (publishRecord, bool) {
+	for {
+		f, err := readFrame(conn)
+		if err != nil {
+			return publishRecord{}, false
+		}
+		if f.typ == frameHeartbeat {
+			mb.mu.Lock()
+			mb.heartbeats++
+			mb.mu.Unlock()
+			continue
+		}
+		class, method, err := f.methodID()
+		if err != nil {
+			mb.t.Errorf("broker expected a method frame: %v", err)
+			return publishRecord{}, false
+		}
+		if class == classChannel && method == methodChannelCloseOk {
+			continue
+		}
+		if class != classBasic || method != methodBasicPublish {
+			mb.t.Errorf("broker expected basic.publish, got %d.%d", class, method)
+			return publishRecord{}, false
+		}
+		args := f.args()
+		args.short()
+		record := publishRecord{exchange: args.shortstr(), routingKey: args.shortstr()}
+		if args.octet()&0x01 == 0 {
+			mb.t.Error("expected mandatory publish")
+		}
+
+		header, err := readFrame(conn)
+		if err != nil || header.typ != frameHeader {
+			mb.t.Errorf("broker expected a content header: %v", err)
+			return publishRecord{}, false
+		}
+		record.props = header.payload
+		size := binary.BigEndian.Uint64(header.payload[4:12])
+		for uint64(len(record.body)) < size {
+			body, err := readFrame(conn)
+			if err != nil || body.typ != frameBody {
+				mb.t.Errorf("broker expected a body frame: %v", err)
+				return publishRecord{}, false
+			}
+			if len(body.payload) > int(mb.frameMax)-frameOverhead {
+				mb.t.Errorf("body frame of %d bytes exceeds frame max %d", len(body.payload), mb.frameMax)
+			}
+			record.body = append(record.body, body.payload...)
+		}
+		return record, true
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 5s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newSenderWithBroker(t *testing.T, mb *mockBroker, overrides func(*Config)) (*Sender, error) {
+	t.Helper()
+	cfg := Config{
+		URL:                    mb.url(),
+		Exchange:               "clocks",
+		AllowInsecureTransport: true,
+		ConfirmTimeout:         time.Second,
+		Retry:                  retry.Policy{BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+	if overrides != nil {
+		overrides(&cfg)
+	}
+	s, err := NewSender(cfg)
+	if err == nil {
+		t.Cleanup(s.Close)
+	}
+	return s, err
+}
+
+// ---------------------------------------------------------------------------
+// Publishing
+// ---------------------------------------------------------------------------
+
+func TestSendPublishesConfirmedMessage(t *testing.T) {
+	mb := newMockBroker(t, behaviorAck)
+	s, err := newSenderWithBroker(t, mb, func(cfg *Config) { cfg.RoutingKeyPrefix = "building-a" })
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+
+	ctx := application.WithIdempotencyKey(context.Background(), "key-1")
+	if err := s.Send(ctx, domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 40}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	records := mb.records()
+	if len(records) != 1 {
+		t.Fatalf("expected one publish, got %d", len(records))
+	}
+	record := records[0]
+	if record.exchange != "clocks" || record.routingKey != "building-a.clock-1.set_brightness" {
+		t.Fatalf("unexpected destination %q %q", record.exchange, record.routingKey)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(record.body, &payload); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if payload["deviceId"] != "clock-1" || payload["type"] != "set_brightness" || payload["level"] != float64(40) || payload["idempotencyKey"] != "key-1" {
+		t.Fatalf("unexpected payload %v", payload)
+	}
+	props := string(record.props)
+	if !strings.Contains(props, "application/json") || !strings.Contains(props, "key-1") || !strings.Contains(props, "set_brightness") {
+		t.Fatalf("expected content type, message id and type properties, got %q", props)
+	}
+	if login, vhost := mb.session(0); login != "\x00clock\x00secret" || vhost != "building" {
+		t.Fatalf("unexpected login %q vhost %q", login, vhost)
+	}
+}
+
+func TestSendSplitsLargeBodies(t *testing.T) {
+	mb := newMockBroker(t, behaviorAck)
+	s, err := newSenderWithBroker(t, mb, nil)
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	message := strings.Repeat("x", 10000)
+	if err := s.Send(context.Background(), domain.DisplayMessageCommand{DeviceID: "clock-1", Message: message, DurationSeconds: 5}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if records := mb.records(); len(records) != 1 || !strings.Contains(string(records[0].body), message) {
+		t.Fatal("expected the full body to reach the broker")
+	}
+}
+
+func TestSendFailsOnNack(t *testing.T) {
+	mb := newMockBroker(t, behaviorNack)
+	s, err := newSenderWithBroker(t, mb, func(cfg *Config) { cfg.Retry.MaxAttempts = 2 })
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	err = s.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 1})
+	if err == nil || !strings.Contains(err.Error(), "rejected") || !strings.Contains(err.Error(), "after 2 attempts") {
+		t.Fatalf("expected nack after retries, got %v", err)
+	}
+}
+
+func TestSendDoesNotRetryUnroutableMessages(t *testing.T) {
+	mb := newMockBroker(t, behaviorReturn)
+	s, err := newSenderWithBroker(t, mb, nil)
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	err = s.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 1})
+	if err == nil || !strings.Contains(err.Error(), "not routed") || !strings.Contains(err.Error(), "clock-1.set_brightness") {
+		t.Fatalf("expected unroutable error, got %v", err)
+	}
+	if len(mb.records()) != 1 {
+		t.Fatalf("expected a single attempt, got %d", len(mb.records()))
+	}
+	if err := s.Check(context.Background()); err != nil {
+		t.Fatalf("connection should stay open after a return: %v", err)
+	}
+}
+
+func TestSendReconnectsAfterConnectionLoss(t *testing.T) {
+	mb := newMockBroker(t, behaviorDropFirst)
+	s, err := newSenderWithBroker(t, mb, nil)
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	if err := s.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 1}); err != nil {
+		t.Fatalf("expected send to succeed after reconnect, got %v", err)
+	}
+	mb.mu.Lock()
+	connections := mb.connections
+	mb.mu.Unlock()
+	if connections != 2 || len(mb.records()) != 2 {
+		t.Fatalf("expected a reconnect and a second publish, got %d connections %d publishes", connections, len(mb.records()))
+	}
+}
+
+func TestSendReportsChannelClose(t *testing.T) {
+	mb := newMockBroker(t, behaviorCloseChannel)
+	s, err := newSenderWithBroker(t, mb, func(cfg *Config) { cfg.Retry.MaxAttempts = 1 })
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	err = s.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 1})
+	if err == nil || !strings.Contains(err.Error(), "404 NOT_FOUND") {
+		t.Fatalf("expected channel close reason, got %v", err)
+	}
+	if s.Check(context.Background()) == nil {
+		t.Fatal("expected sender to be disconnected after a channel close")
+	}
+}
+
+func TestSendStopsAtTheContextDeadline(t *testing.T) {
+	mb := newMockBroker(t, behaviorSilent)
+	s, err := newSenderWithBroker(t, mb, func(cfg *Config) { cfg.ConfirmTimeout = time.Minute })
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+
+	// The first publish holds the connection while it waits for a confirm
+	// that never comes; the second must not wait past its own deadline.
+	firstDone := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		firstDone <- s.Send(ctx, domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 1})
+	}()
+	waitFor(t, func() bool { return len(mb.records()) == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err = s.Send(ctx, domain.SetBrightnessCommand{DeviceID: "clock-2", Level: 1})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the queued send to hit its deadline, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 400*time.Millisecond {
+		t.Fatalf("queued send waited %s, past its 50ms deadline", elapsed)
+	}
+
+	if err := <-firstDone; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the unconfirmed send to stop at its deadline, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("unconfirmed send took %s despite a 500ms deadline", elapsed)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Connection
+// ---------------------------------------------------------------------------
+
+func TestNewSenderReportsRejectedLogin(t *testing.T) {
+	mb := newMockBroker(t, behaviorRejectLogin)
+	_, err := newSenderWithBroker(t, mb, nil)
+	if err == nil || !strings.Contains(err.Error(), "403 ACCESS_REFUSED") {
+		t.Fatalf("expected access refused, got %v", err)
+	}
+}
+
+func TestSenderNegotiatesHeartbeats(t *testing.T) {
+	mb := newMockBroker(t, behaviorAck)
+	mb.mu.Lock()
+	mb.heartbeat = 1
+	mb.mu.Unlock()
+	s, err := newSenderWithBroker(t, mb, nil)
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	mb.mu.Lock()
+	tuned := mb.tuned[0]
+	mb.mu.Unlock()
+	if tuned != 1 {
+		t.Fatalf("expected the broker's shorter heartbeat to be accepted, got %d", tuned)
+	}
+
+	// The sender keeps the connection alive, but the mock broker never
+	// sends heartbeats, so it is dropped after two missed intervals.
+	waitFor(t, func() bool {
+		mb.mu.Lock()
+		defer mb.mu.Unlock()
+		return mb.heartbeats > 0
+	})
+	waitFor(t, func() bool {
+		err := s.Check(context.Background())
+		return err != nil && strings.Contains(err.Error(), "missed heartbeats")
+	})
+
+	if err := s.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 1}); err != nil {
+		t.Fatalf("expected send to reconnect, got %v", err)
+	}
+}
+
+func TestNegotiateHeartbeat(t *testing.T) {
+	if got := negotiateHeartbeat(30*time.Second, 60); got != 30*time.Second {
+		t.Fatalf("expected the client's shorter heartbeat, got %s", got)
+	}
+	if got := negotiateHeartbeat(30*time.Second, 10); got != 10*time.Second {
+		t.Fatalf("expected the broker's shorter heartbeat, got %s", got)
+	}
+	if got := negotiateHeartbeat(30*time.Second, 0); got != 30*time.Second {
+		t.Fatalf("expected the client's heartbeat when the broker proposes none, got %s", got)
+	}
+}
+
+func TestNewSenderPrefersConfiguredCredentials(t *testing.T) {
+	mb := newMockBroker(t, behaviorAck)
+	if _, err := newSenderWithBroker(t, mb, func(cfg *Config) {
+		cfg.Username, cfg.Password = "svc", "from-secret"
+	}); err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	if login, _ := mb.session(0); login != "\x00svc\x00from-secret" {
+		t.Fatalf("unexpected login %q", login)
+	}
+}
+
+func TestNewSenderConnectsOverTLS(t *testing.T) {
+	// Borrow the self-signed certificate of an httptest TLS server.
+	srv := httptest.NewTLSServer(nil)
+	t.Cleanup(srv.Close)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	mb := startMockBroker(t, tls.NewListener(ln, srv.TLS), behaviorAck)
+
+	cfg := func(c *Config) {
+		c.URL = "amqps://clock:secret@" + ln.Addr().String()
+		c.AllowInsecureTransport = false
+		c.TLSInsecureSkipVerify = true
+		c.AllowInsecureTLS = true
+	}
+	s, err := newSenderWithBroker(t, mb, cfg)
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	if err := s.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 1}); err != nil {
+		t.Fatalf("send over tls: %v", err)
+	}
+	if _, vhost := mb.session(0); vhost != "/" {
+		t.Fatalf("expected default vhost, got %q", vhost)
+	}
+}
+
+func TestNewSenderValidatesConfig(t *testing.T) {
+	cases := map[string]Config{
+		"no url":             {},
+		"insecure amqp":      {URL: "amqp://localhost"},
+		"unsupported":        {URL: "http://localhost"},
+		"no host":            {URL: "amqps://"},
+		"unguarded skip tls": {URL: "amqps://localhost", TLSInsecureSkipVerify: true},
+	}
+	for name, cfg := range cases {
+		if _, err := NewSender(cfg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseBrokerURLDefaults(t *testing.T) {
+	parsed, err := parseBrokerURL("amqps://rabbit.local", false)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if parsed.port != "5671" || !parsed.tls || parsed.vhost != "/" {
+		t.Fatalf("unexpected defaults %+v", parsed)
+	}
+	parsed, err = parseBrokerURL("amqp://rabbit.local/%2Fclocks", true)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if parsed.port != "5672" || parsed.tls || parsed.vhost != "/clocks" {
+		t.Fatalf("unexpected insecure defaults %+v", parsed)
+	}
+}
+
+func TestBuildRoutingKey(t *testing.T) {
+	cmd := domain.SetAlarmCommand{DeviceID: "clock-7"}
+	if got := buildRoutingKey("", cmd); got != "clock-7.set_alarm" {
+		t.Fatalf("unexpected key %q", got)
+	}
+	if got := buildRoutingKey(" .site-1. ", cmd); got != "site-1.clock-7.set_alarm" {
+		t.Fatalf("unexpected prefixed key %q", got)
+	}
+}
@@ -0,0 +1,141 @@
+package auditlog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/paul/clock-server/internal/application"
+)
+
+// Each line of a log file is the JSON of an AuditEntry whose last member is
+// "hash". The hash is computed over the line without that member, so a
+// verifier checks the exact bytes that were written rather than a
+// re-encoding of them.
+const (
+	hashLen    = sha256.Size * 2
+	hashSuffix = len(`,"hash":""}`) + hashLen
+)
+
+// entryBody is an AuditEntry without its hash, in the order it is written.
+type entryBody struct {
+	Seq      uint64 `json:"seq"`
+	PrevHash string `json:"prevHash"`
+	application.AuditRecord
+}
+
+// encodeEntry returns the line for record at seq after prevHash, without
+// the trailing newline, and its hash.
+func encodeEntry(record application.AuditRecord, seq uint64, prevHash string, key []byte) ([]byte, string, error) {
+	body, err := json.Marshal(entryBody{Seq: seq, PrevHash: prevHash, AuditRecord: record})
+	if err != nil {
+		return nil, "", err
+	}
+	hash := chainHash(body, key)
+	line := append(body[:len(body)-1], `,"hash":"`+hash+`"}`...)
+	return line, hash, nil
+}
+
+// chainHash is the hex SHA-256 of body, or its HMAC-SHA256 when a key is
+// set, so that entries cannot be rewritten without the key.
+func chainHash(body, key []byte) string {
+	if len(key) == 0 {
+		sum := sha256.Sum256(body)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// decodeEntry parses line and checks that its hash matches its content.
+func decodeEntry(line, key []byte) (application.AuditEntry, error) {
+	var entry application.AuditEntry
+	if len(line) <= hashSuffix || !bytes.HasPrefix(line[len(line)-hashSuffix:], []byte(`,"hash":"`)) {
+		return entry, errors.New("entry does not end with its hash")
+	}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return entry, fmt.Errorf("invalid entry: %w", err)
+	}
+	body := append(bytes.Clone(line[:len(line)-hashSuffix]), '}')
+	if want := chainHash(body, key); !hmac.Equal([]byte(want), []byte(entry.Hash)) {
+		return entry, errors.New("hash does not match the entry; it was modified or the key is wrong")
+	}
+	return entry, nil
+}
+
+// VerifyResult describes a verified chain. Anchored is set when it starts at
+// the first entry ever written; otherwise older files were rotated away and
+// the chain is verified from FirstSeq.
+type VerifyResult struct {
+	Entries  int
+	FirstSeq uint64
+	LastSeq  uint64
+	LastHash string
+	Anchored bool
+}
+
+// Verify checks the hash chain across paths, oldest first, as returned by
+// Files. The error names the file and line of the first broken link.
+func Verify(paths []string, key []byte) (VerifyResult, error) {
+	var result VerifyResult
+	for _, path := range paths {
+		err := readEntries(path, key, func(entry application.AuditEntry) error {
+			if result.Entries == 0 {
+				result.FirstSeq, result.Anchored = entry.Seq, entry.Seq == 1 && entry.PrevHash == ""
+			} else if entry.Seq != result.LastSeq+1 || entry.PrevHash != result.LastHash {
+				return fmt.Errorf("entry %d does not follow entry %d; entries were removed, reordered or inserted", entry.Seq, result.LastSeq)
+			}
+			result.Entries++
+			result.LastSeq, result.LastHash = entry.Seq, entry.Hash
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// readEntries calls fn with each entry of path in order. Errors name the
+// file and line.
+func readEntries(path string, key []byte, fn func(application.AuditEntry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return scanEntries(f, path, key, fn)
+}
+
+// scanEntries calls fn with each entry read from r, the content of the file
+// name, in order.
+func scanEntries(src io.Reader, name string, key []byte, fn func(application.AuditEntry) error) error {
+	r := bufio.NewReader(src)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				return fmt.Errorf("%s:%d: incomplete entry at the end of the file", name, n)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		entry, err := decodeEntry(bytes.TrimSuffix(line, []byte("\n")), key)
+		if err == nil {
+			err = fn(entry)
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+	}
+}
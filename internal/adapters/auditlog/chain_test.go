@@ -0,0 +1,72 @@
+package auditlog
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// writeChain appends n entries and returns the log's path and lines.
+func writeChain(t *testing.T, n int, key []byte) (string, [][]byte) {
+	t.Helper()
+	l, _ := openTestLog(t, Config{Key: key})
+	for i := 0; i < n; i++ {
+		if err := l.Append(commandRecord("ops", "clock-1", "accepted")); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	raw, err := os.ReadFile(l.cfg.Path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return l.cfg.Path, bytes.SplitAfter(raw, []byte("\n"))[:n]
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	cases := []struct {
+		name   string
+		tamper func([][]byte) [][]byte
+		want   string
+	}{
+		{"edited entry", func(lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte(`"result":"accepted"`), []byte(`"result":"failed"`), 1)
+			return lines
+		}, "audit.log:2: hash does not match"},
+		{"removed entry", func(lines [][]byte) [][]byte {
+			return append(lines[:1], lines[2:]...)
+		}, "audit.log:2: entry 3 does not follow entry 1"},
+		{"reordered entries", func(lines [][]byte) [][]byte {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, "audit.log:2: entry 3 does not follow entry 1"},
+		{"truncated entry", func(lines [][]byte) [][]byte {
+			lines[2] = lines[2][:20]
+			return lines
+		}, "audit.log:3: incomplete entry"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path, lines := writeChain(t, 3, nil)
+			if result, err := Verify([]string{path}, nil); err != nil || result.Entries != 3 {
+				t.Fatalf("expected the untouched log to verify, got %+v (%v)", result, err)
+			}
+			if err := os.WriteFile(path, bytes.Join(tc.tamper(lines), nil), 0o600); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if _, err := Verify([]string{path}, nil); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestVerifyWithKey(t *testing.T) {
+	path, _ := writeChain(t, 2, []byte("audit-key"))
+	if _, err := Verify([]string{path}, []byte("audit-key")); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if _, err := Verify([]string{path}, nil); err == nil {
+		t.Fatal("expected a keyed chain not to verify without the key")
+	}
+}
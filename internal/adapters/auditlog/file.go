@@ -0,0 +1,286 @@
+// Package auditlog writes audit records as hash-chained JSON lines to a local
+// file that is rotated by size. Each entry carries the hash of the entry
+// before it, so removing, reordering or editing entries breaks the chain,
+// which Verify reports.
+package auditlog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+)
+
+const (
+	// rotatedLayout is the UTC timestamp suffix of rotated files, which
+	// sorts in rotation order.
+	rotatedLayout = "20060102T150405.000000000Z"
+
+	defaultQueryLimit = 100
+)
+
+// Config defines the audit log file.
+type Config struct {
+	// Path is the active file. Rotated files are kept next to it as
+	// Path.<timestamp>.
+	Path string
+	// MaxBytes rotates the file before an entry would make it larger.
+	MaxBytes int64
+	// MaxFiles is the number of rotated files kept; zero keeps all.
+	MaxFiles int
+	// Key, when set, chains entries with HMAC-SHA256 instead of SHA-256.
+	Key        []byte
+	Redactions Redactions
+}
+
+// FileLog is an application.AuditLog backed by local files.
+type FileLog struct {
+	cfg   Config
+	now   func() time.Time
+	write func(f *os.File, b []byte) (int, error)
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	seq  uint64
+	last string
+}
+
+var _ application.AuditLog = (*FileLog)(nil)
+
+// Open opens the log at cfg.Path and continues the chain of its newest
+// entry. It fails when that entry is incomplete or was not written with
+// cfg.Key.
+func Open(cfg Config) (*FileLog, error) {
+	if strings.TrimSpace(cfg.Path) == "" {
+		return nil, errors.New("audit log path is required")
+	}
+	if cfg.MaxBytes <= 0 {
+		return nil, errors.New("audit log max bytes must be positive")
+	}
+	l := &FileLog{cfg: cfg, now: time.Now, write: (*os.File).Write}
+	paths, err := Files(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(paths) - 1; i >= 0 && l.seq == 0; i-- {
+		err := readEntries(paths[i], cfg.Key, func(entry application.AuditEntry) error {
+			l.seq, l.last = entry.Seq, entry.Hash
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("resume audit log: %w", err)
+		}
+	}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Files returns the rotated files of the log at path, oldest first, followed
+// by path itself if it exists.
+func Files(path string) ([]string, error) {
+	matches, err := filepath.Glob(globEscape(path) + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, match := range matches {
+		if _, err := time.Parse(rotatedLayout, strings.TrimPrefix(match, path+".")); err == nil {
+			files = append(files, match)
+		}
+	}
+	sort.Strings(files)
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Append writes record as the next entry, after redacting its parameters.
+// A failed write is truncated away so the file keeps ending with a complete
+// entry; when that fails too, the log is closed rather than chaining later
+// entries onto a partial line.
+func (l *FileLog) Append(record application.AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return errors.New("audit log is closed")
+	}
+	record.Parameters = l.cfg.Redactions.Apply(record.CommandType, record.Parameters)
+	line, hash, err := encodeEntry(record, l.seq+1, l.last, l.cfg.Key)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if l.size > 0 && l.size+int64(len(line)) > l.cfg.MaxBytes {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("rotate audit log: %w", err)
+		}
+	}
+	if n, err := l.write(l.file, line); err != nil {
+		if n > 0 {
+			if terr := l.file.Truncate(l.size); terr != nil {
+				_ = l.file.Close()
+				l.file = nil
+				return errors.Join(err, fmt.Errorf("truncate audit log, closing it: %w", terr))
+			}
+		}
+		return err
+	}
+	l.size += int64(len(line))
+	l.seq, l.last = l.seq+1, hash
+	return nil
+}
+
+// Query reads the entries selected by q, newest first. Entries are not
+// verified against the chain; use Verify for that. The files are opened and
+// the length of the active one pinned under the lock, and read after it is
+// released, so a query does not hold up Append; an open file stays readable
+// when a rotation renames or removes it meanwhile.
+func (l *FileLog) Query(q application.AuditQuery) ([]application.AuditEntry, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultQueryLimit
+	}
+	files, err := l.snapshot()
+	for _, f := range files {
+		defer f.file.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []application.AuditEntry{}
+	for i := len(files) - 1; i >= 0 && len(entries) < limit; i-- {
+		var matched []application.AuditEntry
+		err := scanEntries(io.NewSectionReader(files[i].file, 0, files[i].size), files[i].file.Name(), l.cfg.Key, func(entry application.AuditEntry) error {
+			if q.Matches(entry) {
+				matched = append(matched, entry)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for j := len(matched) - 1; j >= 0 && len(entries) < limit; j-- {
+			entries = append(entries, matched[j])
+		}
+	}
+	return entries, nil
+}
+
+// snapshotFile is an open log file and the length of it to read.
+type snapshotFile struct {
+	file *os.File
+	size int64
+}
+
+// snapshot opens the files of the log, oldest first. The active file is read
+// up to the end of its last complete entry.
+func (l *FileLog) snapshot() ([]snapshotFile, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	paths, err := Files(l.cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]snapshotFile, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return files, err
+		}
+		size := l.size
+		if path != l.cfg.Path || l.file == nil {
+			info, err := f.Stat()
+			if err != nil {
+				_ = f.Close()
+				return files, err
+			}
+			size = info.Size()
+		}
+		files = append(files, snapshotFile{file: f, size: size})
+	}
+	return files, nil
+}
+
+// Close closes the active file.
+func (l *FileLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *FileLog) openFile() error {
+	f, err := os.OpenFile(l.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+// rotate renames the active file with a timestamp suffix, opens a new one
+// and removes the oldest rotated files beyond MaxFiles. Must be called with
+// l.mu held.
+func (l *FileLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+	rotated := l.cfg.Path + "." + l.now().UTC().Format(rotatedLayout)
+	if err := os.Rename(l.cfg.Path, rotated); err != nil {
+		return err
+	}
+	if err := l.openFile(); err != nil {
+		return err
+	}
+	if l.cfg.MaxFiles <= 0 {
+		return nil
+	}
+	paths, err := Files(l.cfg.Path)
+	if err != nil {
+		return err
+	}
+	rotatedFiles := paths[:len(paths)-1]
+	for len(rotatedFiles) > l.cfg.MaxFiles {
+		if err := os.Remove(rotatedFiles[0]); err != nil {
+			return err
+		}
+		rotatedFiles = rotatedFiles[1:]
+	}
+	return nil
+}
+
+// globEscape escapes the glob metacharacters of a literal path.
+func globEscape(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
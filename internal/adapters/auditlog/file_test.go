@@ -0,0 +1,215 @@
+package auditlog
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+)
+
+func openTestLog(t *testing.T, cfg Config) (*FileLog, *time.Time) {
+	t.Helper()
+	if cfg.Path == "" {
+		cfg.Path = filepath.Join(t.TempDir(), "audit.log")
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = 1 << 20
+	}
+	l, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func commandRecord(principal, deviceID, result string) application.AuditRecord {
+	return application.AuditRecord{
+		Time:        time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+		Action:      application.AuditActionCommand,
+		Principal:   principal,
+		RemoteIP:    "10.0.0.1",
+		RequestID:   "req-1",
+		Method:      "POST",
+		Path:        "/v1/commands/display_message",
+		DeviceID:    deviceID,
+		CommandType: "display_message",
+		Parameters:  map[string]any{"message": "door code 1234", "durationSeconds": 5},
+		Result:      result,
+		Deliveries:  []application.AuditDelivery{{Sender: "mqtt", Status: application.DeliveryDelivered, DurationMS: 12}},
+	}
+}
+
+func TestAppendRedactsAndResumesChain(t *testing.T) {
+	redactions, err := ParseRedactions("display_message.message")
+	if err != nil {
+		t.Fatalf("ParseRedactions: %v", err)
+	}
+	l, _ := openTestLog(t, Config{Redactions: redactions})
+	params := map[string]any{"message": "door code 1234", "durationSeconds": 5}
+	record := commandRecord("ops", "clock-1", "accepted")
+	record.Parameters = params
+	for i := 0; i < 2; i++ {
+		if err := l.Append(record); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if params["message"] != "door code 1234" {
+		t.Fatal("expected the caller's parameters to be left unchanged")
+	}
+	raw, _ := os.ReadFile(l.cfg.Path)
+	if strings.Contains(string(raw), "1234") || !strings.Contains(string(raw), `"message":"[redacted]"`) {
+		t.Fatalf("expected the message to be redacted, got %s", raw)
+	}
+	_ = l.Close()
+
+	// A reopened log continues the chain.
+	reopened, _ := openTestLog(t, Config{Path: l.cfg.Path, Redactions: redactions})
+	if err := reopened.Append(record); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	result, err := Verify([]string{l.cfg.Path}, nil)
+	if err != nil || result.Entries != 3 || result.LastSeq != 3 || !result.Anchored {
+		t.Fatalf("unexpected verification %+v (%v)", result, err)
+	}
+}
+
+func TestOpenRejectsWrongKey(t *testing.T) {
+	l, _ := openTestLog(t, Config{Key: []byte("k1")})
+	if err := l.Append(commandRecord("ops", "clock-1", "accepted")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	_ = l.Close()
+	if _, err := Open(Config{Path: l.cfg.Path, MaxBytes: 1 << 20, Key: []byte("k2")}); err == nil {
+		t.Fatal("expected a log chained with another key to be rejected")
+	}
+}
+
+func TestRotationKeepsChainAndMaxFiles(t *testing.T) {
+	l, now := openTestLog(t, Config{MaxBytes: 900, MaxFiles: 2})
+	for i := 0; i < 8; i++ {
+		*now = now.Add(time.Second)
+		if err := l.Append(commandRecord("ops", "clock-1", "accepted")); err != nil {
+			t.Fatalf("Append %d: %v", i, err)
+		}
+	}
+	paths, err := Files(l.cfg.Path)
+	if err != nil || len(paths) != 3 {
+		t.Fatalf("expected two rotated files and the active one, got %v (%v)", paths, err)
+	}
+	result, err := Verify(paths, nil)
+	if err != nil || result.LastSeq != 8 || result.Anchored || result.FirstSeq == 1 {
+		t.Fatalf("expected the remaining files to verify from a later entry, got %+v (%v)", result, err)
+	}
+}
+
+func TestQueryFiltersNewestFirstAcrossFiles(t *testing.T) {
+	l, now := openTestLog(t, Config{MaxBytes: 900})
+	for i, device := range []string{"clock-1", "clock-2", "clock-1", "clock-1"} {
+		*now = now.Add(time.Second)
+		record := commandRecord("ops", device, "accepted")
+		record.Time = record.Time.Add(time.Duration(i) * time.Minute)
+		if err := l.Append(record); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if paths, _ := Files(l.cfg.Path); len(paths) < 2 {
+		t.Fatalf("expected the log to have rotated, got %v", paths)
+	}
+
+	entries, err := l.Query(application.AuditQuery{DeviceID: "clock-1", Limit: 2})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Seq != 4 || entries[1].Seq != 3 {
+		t.Fatalf("expected entries 4 and 3, got %+v", entries)
+	}
+	entries, _ = l.Query(application.AuditQuery{Since: time.Date(2026, 10, 18, 12, 1, 0, 0, time.UTC), Until: time.Date(2026, 10, 18, 12, 3, 0, 0, time.UTC)})
+	if len(entries) != 2 || entries[0].Seq != 3 || entries[1].Seq != 2 {
+		t.Fatalf("expected entries 3 and 2 in the time range, got %+v", entries)
+	}
+}
+
+func TestParseRedactions(t *testing.T) {
+	r, err := ParseRedactions("display_message.message, *.label")
+	if err != nil {
+		t.Fatalf("ParseRedactions: %v", err)
+	}
+	got := r.Apply("set_alarm", map[string]any{"label": "wake", "alarmTime": "x"})
+	if got["label"] != Redacted || got["alarmTime"] != "x" {
+		t.Fatalf("unexpected redaction %v", got)
+	}
+	for _, raw := range []string{"message", "reboot.message", "display_message.colour"} {
+		if _, err := ParseRedactions(raw); err == nil {
+			t.Fatalf("expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestQueryRunsAlongsideAppends(t *testing.T) {
+	l, _ := openTestLog(t, Config{MaxBytes: 4000, MaxFiles: 3})
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 200; i++ {
+			if err := l.Append(commandRecord("ops", "clock-1", "accepted")); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			entries, err := l.Query(application.AuditQuery{Limit: 1})
+			if err != nil || len(entries) != 1 || entries[0].Seq != 200 {
+				t.Fatalf("expected the newest entry 200, got %+v (%v)", entries, err)
+			}
+			return
+		default:
+		}
+		if _, err := l.Query(application.AuditQuery{Limit: 1000}); err != nil {
+			t.Fatalf("Query during appends: %v", err)
+		}
+	}
+}
+
+func TestAppendTruncatesAShortWrite(t *testing.T) {
+	l, _ := openTestLog(t, Config{})
+	if err := l.Append(commandRecord("ops", "clock-1", "accepted")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	l.write = func(f *os.File, b []byte) (int, error) {
+		n, _ := f.Write(b[:len(b)/2])
+		return n, io.ErrShortWrite
+	}
+	if err := l.Append(commandRecord("ops", "clock-2", "accepted")); !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("expected the short write to be reported, got %v", err)
+	}
+	l.write = (*os.File).Write
+	if err := l.Append(commandRecord("ops", "clock-3", "accepted")); err != nil {
+		t.Fatalf("Append after a short write: %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err := Verify([]string{l.cfg.Path}, nil); err != nil || result.Entries != 2 || result.LastSeq != 2 {
+		t.Fatalf("expected two chained entries, got %+v (%v)", result, err)
+	}
+	reopened, err := Open(l.cfg)
+	if err != nil {
+		t.Fatalf("expected the log to reopen after a short write: %v", err)
+	}
+	_ = reopened.Close()
+}
@@ -0,0 +1,61 @@
+package auditlog
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/paul/clock-server/internal/commands"
+)
+
+// Redacted replaces the value of a redacted command parameter.
+const Redacted = "[redacted]"
+
+// Redactions name the command parameters that are not written to the audit
+// log, per command type; the "*" entry applies to every type.
+type Redactions map[string][]string
+
+// ParseRedactions parses comma-separated type.field entries, e.g.
+// "display_message.message,*.label". The type must be "*" or a registered
+// command type with that field.
+func ParseRedactions(raw string) (Redactions, error) {
+	redactions := Redactions{}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		commandType, field, ok := strings.Cut(entry, ".")
+		if !ok || commandType == "" || field == "" {
+			return nil, fmt.Errorf("invalid redaction %q: expected type.field", entry)
+		}
+		if commandType != "*" {
+			def, ok := commands.Lookup(commandType)
+			if !ok {
+				return nil, fmt.Errorf("redaction %q names an unknown command type", entry)
+			}
+			if !slices.ContainsFunc(def.Fields, func(f commands.Field) bool { return f.Name == field }) {
+				return nil, fmt.Errorf("redaction %q names an unknown field of %s", entry, commandType)
+			}
+		}
+		redactions[commandType] = append(redactions[commandType], field)
+	}
+	return redactions, nil
+}
+
+// Apply returns params with the redacted fields of commandType replaced. The
+// original map is not modified.
+func (r Redactions) Apply(commandType string, params map[string]any) map[string]any {
+	fields := append(slices.Clone(r["*"]), r[commandType]...)
+	if len(fields) == 0 || len(params) == 0 {
+		return params
+	}
+	out := make(map[string]any, len(params))
+	for name, value := range params {
+		if slices.Contains(fields, name) {
+			value = Redacted
+		}
+		out[name] = value
+	}
+	return out
+}
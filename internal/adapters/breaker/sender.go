@@ -0,0 +1,302 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+// ErrOpen is returned without calling the wrapped sender while the circuit is open.
+var ErrOpen = errors.New("circuit breaker open")
+
+// State is the circuit breaker state.
+type State int
+
+const (
+	// StateClosed lets every call through and records outcomes.
+	StateClosed State = iota
+	// StateOpen rejects calls until the cool-down has elapsed.
+	StateOpen
+	// StateHalfOpen lets a limited number of trial calls through.
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Config defines circuit breaker thresholds.
+type Config struct {
+	// FailureRateThreshold opens the circuit when the share of failed (or slow)
+	// calls in the window reaches this value (0 < rate <= 1).
+	FailureRateThreshold float64
+	// SlowCallThreshold counts successful calls slower than this as failures.
+	// Zero disables latency tracking.
+	SlowCallThreshold time.Duration
+	// WindowSize is the number of most recent calls considered.
+	WindowSize int
+	// MinCalls is the number of calls required before the rate is evaluated.
+	MinCalls int
+	// CoolDown is how long the circuit stays open before allowing a trial call.
+	CoolDown time.Duration
+	// HalfOpenMaxCalls is the number of concurrent trial calls when half-open.
+	HalfOpenMaxCalls int
+}
+
+// Sender wraps a ClockCommandSender with a circuit breaker.
+type Sender struct {
+	name  string
+	inner application.ClockCommandSender
+	cfg   Config
+	now   func() time.Time
+
+	mu              sync.Mutex
+	state           State
+	outcomes        []bool // true means failure
+	next            int
+	filled          int
+	openedAt        time.Time
+	halfOpenCalls   int
+	successes       uint64
+	failures        uint64
+	slowCalls       uint64
+	rejections      uint64
+	ignored         uint64
+	stateTransition uint64
+}
+
+// NewSender wraps inner with a circuit breaker named after the sender it protects.
+func NewSender(name string, inner application.ClockCommandSender, cfg Config) (*Sender, error) {
+	if inner == nil {
+		return nil, errors.New("circuit breaker requires a sender")
+	}
+	if cfg.FailureRateThreshold == 0 {
+		cfg.FailureRateThreshold = 0.5
+	}
+	if cfg.FailureRateThreshold < 0 || cfg.FailureRateThreshold > 1 {
+		return nil, fmt.Errorf("failure rate threshold %.2f must be between 0 and 1", cfg.FailureRateThreshold)
+	}
+	if cfg.WindowSize <= 0 {
+		cfg.WindowSize = 20
+	}
+	if cfg.MinCalls <= 0 {
+		cfg.MinCalls = 5
+	}
+	if cfg.MinCalls > cfg.WindowSize {
+		cfg.MinCalls = cfg.WindowSize
+	}
+	if cfg.CoolDown <= 0 {
+		cfg.CoolDown = 30 * time.Second
+	}
+	if cfg.HalfOpenMaxCalls <= 0 {
+		cfg.HalfOpenMaxCalls = 1
+	}
+	return &Sender{
+		name:     name,
+		inner:    inner,
+		cfg:      cfg,
+		now:      time.Now,
+		outcomes: make([]bool, cfg.WindowSize),
+	}, nil
+}
+
+// Send forwards the command unless the circuit is open.
+func (s *Sender) Send(ctx context.Context, cmd domain.ClockCommand) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	started := s.now()
+	err := s.inner.Send(ctx, cmd)
+	if !counts(err) {
+		s.release()
+		return err
+	}
+	s.record(err, s.now().Sub(started))
+	return err
+}
+
+// counts reports whether the outcome of a call says anything about the
+// health of the wrapped sender. Cancelled requests, invalid commands and
+// failures confined to one device stay out of the failure window.
+func counts(err error) bool {
+	var validationErr domain.ValidationError
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, application.ErrValidation),
+		errors.As(err, &validationErr),
+		application.IsDeviceError(err):
+		return false
+	}
+	return true
+}
+
+// Check reports not-ready while the circuit is open and otherwise delegates
+// to the wrapped sender's readiness check when it has one.
+func (s *Sender) Check(ctx context.Context) error {
+	if state := s.State(); state == StateOpen {
+		return fmt.Errorf("%s: %w", s.name, ErrOpen)
+	}
+	if checker, ok := s.inner.(application.ReadinessChecker); ok {
+		return checker.Check(ctx)
+	}
+	return nil
+}
+
+// ReadinessDetails forwards the details of the wrapped sender, if any.
+func (s *Sender) ReadinessDetails() map[string]any {
+	if reporter, ok := s.inner.(application.ReadinessReporter); ok {
+		return reporter.ReadinessDetails()
+	}
+	return nil
+}
+
+// Close closes the wrapped sender when it holds resources.
+func (s *Sender) Close() {
+	if closer, ok := s.inner.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// State returns the current state, moving an expired open circuit to half-open.
+func (s *Sender) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+	return s.state
+}
+
+// Metrics reports breaker state and call counters.
+func (s *Sender) Metrics() []application.Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+	labels := map[string]string{"sender": s.name}
+	return []application.Metric{
+		{Name: "clock_sender_circuit_state", Help: "Circuit breaker state (0=closed, 1=open, 2=half-open).", Type: "gauge", Labels: labels, Value: float64(s.state)},
+		{Name: "clock_sender_calls_succeeded_total", Help: "Calls that completed successfully.", Type: "counter", Labels: labels, Value: float64(s.successes)},
+		{Name: "clock_sender_calls_failed_total", Help: "Calls that returned an error.", Type: "counter", Labels: labels, Value: float64(s.failures)},
+		{Name: "clock_sender_calls_slow_total", Help: "Calls slower than the slow-call threshold.", Type: "counter", Labels: labels, Value: float64(s.slowCalls)},
+		{Name: "clock_sender_calls_ignored_total", Help: "Calls whose error was not counted: cancelled, invalid or confined to one device.", Type: "counter", Labels: labels, Value: float64(s.ignored)},
+		{Name: "clock_sender_calls_rejected_total", Help: "Calls rejected while the circuit was open.", Type: "counter", Labels: labels, Value: float64(s.rejections)},
+		{Name: "clock_sender_circuit_transitions_total", Help: "Circuit breaker state transitions.", Type: "counter", Labels: labels, Value: float64(s.stateTransition)},
+	}
+}
+
+func (s *Sender) acquire() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+	switch s.state {
+	case StateOpen:
+		s.rejections++
+		return fmt.Errorf("%s: %w", s.name, ErrOpen)
+	case StateHalfOpen:
+		if s.halfOpenCalls >= s.cfg.HalfOpenMaxCalls {
+			s.rejections++
+			return fmt.Errorf("%s: %w", s.name, ErrOpen)
+		}
+		s.halfOpenCalls++
+	}
+	return nil
+}
+
+// release frees the trial slot of a call whose outcome is not recorded.
+func (s *Sender) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == StateHalfOpen && s.halfOpenCalls > 0 {
+		s.halfOpenCalls--
+	}
+	s.ignored++
+}
+
+func (s *Sender) record(err error, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slow := s.cfg.SlowCallThreshold > 0 && elapsed > s.cfg.SlowCallThreshold
+	failed := err != nil || slow
+	switch {
+	case err != nil:
+		s.failures++
+	default:
+		s.successes++
+	}
+	if slow {
+		s.slowCalls++
+	}
+
+	if s.state == StateHalfOpen {
+		if s.halfOpenCalls > 0 {
+			s.halfOpenCalls--
+		}
+		if failed {
+			s.transitionLocked(StateOpen)
+		} else {
+			s.transitionLocked(StateClosed)
+		}
+		return
+	}
+	if s.state != StateClosed {
+		return
+	}
+
+	s.outcomes[s.next] = failed
+	s.next = (s.next + 1) % len(s.outcomes)
+	if s.filled < len(s.outcomes) {
+		s.filled++
+	}
+	if s.filled < s.cfg.MinCalls {
+		return
+	}
+	failedCalls := 0
+	for i := 0; i < s.filled; i++ {
+		if s.outcomes[i] {
+			failedCalls++
+		}
+	}
+	if float64(failedCalls)/float64(s.filled) >= s.cfg.FailureRateThreshold {
+		s.transitionLocked(StateOpen)
+	}
+}
+
+// refreshLocked moves an open circuit to half-open once the cool-down elapsed.
+// Must be called with s.mu held.
+func (s *Sender) refreshLocked() {
+	if s.state == StateOpen && s.now().Sub(s.openedAt) >= s.cfg.CoolDown {
+		s.transitionLocked(StateHalfOpen)
+	}
+}
+
+// transitionLocked switches state and resets the per-state bookkeeping.
+// Must be called with s.mu held.
+func (s *Sender) transitionLocked(state State) {
+	if s.state == state {
+		return
+	}
+	s.state = state
+	s.stateTransition++
+	switch state {
+	case StateOpen:
+		s.openedAt = s.now()
+	case StateHalfOpen:
+		s.halfOpenCalls = 0
+	case StateClosed:
+		s.next = 0
+		s.filled = 0
+	}
+}
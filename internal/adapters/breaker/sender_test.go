@@ -0,0 +1,221 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+type stubSender struct {
+	err      error
+	delay    time.Duration
+	calls    int
+	checkErr error
+	closed   bool
+}
+
+func (s *stubSender) Send(_ context.Context, _ domain.ClockCommand) error {
+	s.calls++
+	if s.delay > 0 {
+		time.Sleep(s.delay)
+	}
+	return s.err
+}
+
+func (s *stubSender) Check(_ context.Context) error {
+	return s.checkErr
+}
+
+func (s *stubSender) Close() {
+	s.closed = true
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestBreaker(t *testing.T, inner *stubSender, cfg Config) (*Sender, *fakeClock) {
+	t.Helper()
+	sut, err := NewSender("rest", inner, cfg)
+	if err != nil {
+		t.Fatalf("new breaker: %v", err)
+	}
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	sut.now = clock.Now
+	return sut, clock
+}
+
+var testCmd = domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 10}
+
+func TestBreakerOpensAfterFailureRateThreshold(t *testing.T) {
+	inner := &stubSender{err: errors.New("downstream unavailable")}
+	sut, _ := newTestBreaker(t, inner, Config{FailureRateThreshold: 0.5, WindowSize: 4, MinCalls: 4, CoolDown: time.Minute})
+
+	for i := 0; i < 4; i++ {
+		_ = sut.Send(context.Background(), testCmd)
+	}
+	if sut.State() != StateOpen {
+		t.Fatalf("expected open circuit, got %s", sut.State())
+	}
+
+	err := sut.Send(context.Background(), testCmd)
+	if !errors.Is(err, ErrOpen) {
+		t.Fatalf("expected ErrOpen, got %v", err)
+	}
+	if inner.calls != 4 {
+		t.Fatalf("expected open circuit to skip the sender, got %d calls", inner.calls)
+	}
+	if err := sut.Check(context.Background()); !errors.Is(err, ErrOpen) {
+		t.Fatalf("expected readiness to report open circuit, got %v", err)
+	}
+}
+
+func TestBreakerStaysClosedBelowMinCalls(t *testing.T) {
+	inner := &stubSender{err: errors.New("downstream unavailable")}
+	sut, _ := newTestBreaker(t, inner, Config{WindowSize: 10, MinCalls: 5})
+
+	for i := 0; i < 4; i++ {
+		_ = sut.Send(context.Background(), testCmd)
+	}
+	if sut.State() != StateClosed {
+		t.Fatalf("expected closed circuit, got %s", sut.State())
+	}
+}
+
+func TestBreakerHalfOpenTrialClosesOnSuccess(t *testing.T) {
+	inner := &stubSender{err: errors.New("downstream unavailable")}
+	sut, clock := newTestBreaker(t, inner, Config{WindowSize: 2, MinCalls: 2, CoolDown: 10 * time.Second})
+
+	_ = sut.Send(context.Background(), testCmd)
+	_ = sut.Send(context.Background(), testCmd)
+	if sut.State() != StateOpen {
+		t.Fatalf("expected open circuit, got %s", sut.State())
+	}
+
+	clock.now = clock.now.Add(11 * time.Second)
+	if sut.State() != StateHalfOpen {
+		t.Fatalf("expected half-open circuit after cool-down, got %s", sut.State())
+	}
+	inner.err = nil
+	if err := sut.Send(context.Background(), testCmd); err != nil {
+		t.Fatalf("expected trial call to succeed, got %v", err)
+	}
+	if sut.State() != StateClosed {
+		t.Fatalf("expected closed circuit after successful trial, got %s", sut.State())
+	}
+}
+
+func TestBreakerHalfOpenTrialReopensOnFailure(t *testing.T) {
+	inner := &stubSender{err: errors.New("downstream unavailable")}
+	sut, clock := newTestBreaker(t, inner, Config{WindowSize: 2, MinCalls: 2, CoolDown: 10 * time.Second})
+
+	_ = sut.Send(context.Background(), testCmd)
+	_ = sut.Send(context.Background(), testCmd)
+	clock.now = clock.now.Add(11 * time.Second)
+
+	_ = sut.Send(context.Background(), testCmd)
+	if sut.State() != StateOpen {
+		t.Fatalf("expected circuit to reopen after failed trial, got %s", sut.State())
+	}
+}
+
+func TestBreakerIgnoresCallerAndDeviceErrors(t *testing.T) {
+	for name, err := range map[string]error{
+		"cancelled":     fmt.Errorf("publish: %w", context.Canceled),
+		"invalid":       domain.NewValidationError("brightness level must be between 0 and 100"),
+		"device errors": application.DeviceError(errors.New("device is not connected")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			inner := &stubSender{err: err}
+			sut, clock := newTestBreaker(t, inner, Config{WindowSize: 2, MinCalls: 2, CoolDown: 10 * time.Second})
+			for i := 0; i < 4; i++ {
+				_ = sut.Send(context.Background(), testCmd)
+			}
+			if sut.State() != StateClosed {
+				t.Fatalf("expected closed circuit, got %s", sut.State())
+			}
+
+			// An ignored trial call frees its slot without deciding the state.
+			inner.err = errors.New("downstream unavailable")
+			_ = sut.Send(context.Background(), testCmd)
+			_ = sut.Send(context.Background(), testCmd)
+			clock.now = clock.now.Add(11 * time.Second)
+			inner.err = err
+			_ = sut.Send(context.Background(), testCmd)
+			if sut.State() != StateHalfOpen {
+				t.Fatalf("expected the circuit to stay half-open, got %s", sut.State())
+			}
+			inner.err = nil
+			if err := sut.Send(context.Background(), testCmd); err != nil {
+				t.Fatalf("expected another trial call, got %v", err)
+			}
+		})
+	}
+}
+
+func TestBreakerCountsSlowCallsAsFailures(t *testing.T) {
+	inner := &stubSender{delay: 5 * time.Millisecond}
+	sut, err := NewSender("rest", inner, Config{SlowCallThreshold: time.Millisecond, WindowSize: 2, MinCalls: 2})
+	if err != nil {
+		t.Fatalf("new breaker: %v", err)
+	}
+
+	_ = sut.Send(context.Background(), testCmd)
+	_ = sut.Send(context.Background(), testCmd)
+	if sut.State() != StateOpen {
+		t.Fatalf("expected slow calls to open circuit, got %s", sut.State())
+	}
+}
+
+func TestBreakerDelegatesCheckAndClose(t *testing.T) {
+	inner := &stubSender{checkErr: errors.New("mqtt not connected")}
+	sut, _ := newTestBreaker(t, inner, Config{})
+
+	if err := sut.Check(context.Background()); err == nil || err.Error() != "mqtt not connected" {
+		t.Fatalf("expected delegated readiness error, got %v", err)
+	}
+	sut.Close()
+	if !inner.closed {
+		t.Fatal("expected Close to reach the wrapped sender")
+	}
+}
+
+func TestBreakerMetrics(t *testing.T) {
+	inner := &stubSender{err: errors.New("downstream unavailable")}
+	sut, _ := newTestBreaker(t, inner, Config{WindowSize: 1, MinCalls: 1})
+
+	_ = sut.Send(context.Background(), testCmd)
+	_ = sut.Send(context.Background(), testCmd)
+
+	values := map[string]float64{}
+	for _, metric := range sut.Metrics() {
+		if metric.Labels["sender"] != "rest" {
+			t.Fatalf("expected sender label, got %#v", metric.Labels)
+		}
+		values[metric.Name] = metric.Value
+	}
+	if values["clock_sender_circuit_state"] != float64(StateOpen) {
+		t.Fatalf("expected open state metric, got %v", values["clock_sender_circuit_state"])
+	}
+	if values["clock_sender_calls_failed_total"] != 1 || values["clock_sender_calls_rejected_total"] != 1 {
+		t.Fatalf("unexpected counters: %#v", values)
+	}
+}
+
+func TestNewSenderRejectsInvalidConfig(t *testing.T) {
+	if _, err := NewSender("rest", nil, Config{}); err == nil {
+		t.Fatal("expected error for nil sender")
+	}
+	if _, err := NewSender("rest", &stubSender{}, Config{FailureRateThreshold: 1.5}); err == nil {
+		t.Fatal("expected error for invalid failure rate")
+	}
+}
@@ -4,39 +4,199 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/paul/clock-server/internal/application"
 	"github.com/paul/clock-server/internal/domain"
 )
 
-// Sender dispatches commands through multiple senders sequentially.
+// Policy decides when a fan-out delivery counts as successful.
+type Policy string
+
+const (
+	// PolicyAll requires every sender to deliver.
+	PolicyAll Policy = "all"
+	// PolicyAny requires at least one sender to deliver.
+	PolicyAny Policy = "any"
+	// PolicyQuorum requires a strict majority of senders to deliver.
+	PolicyQuorum Policy = "quorum"
+	// PolicyPrimaryWithFallback tries the first sender and only falls back
+	// to the remaining senders, in order, when it fails.
+	PolicyPrimaryWithFallback Policy = "primary-with-fallback"
+)
+
+// ParsePolicy validates a policy name.
+func ParsePolicy(raw string) (Policy, error) {
+	switch p := Policy(strings.ToLower(strings.TrimSpace(raw))); p {
+	case "":
+		return PolicyAll, nil
+	case PolicyAll, PolicyAny, PolicyQuorum, PolicyPrimaryWithFallback:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unsupported delivery policy %q", raw)
+	}
+}
+
+// Target is a named sender participating in the fan-out.
+type Target struct {
+	Name   string
+	Sender application.ClockCommandSender
+}
+
+// Options configures the composite sender.
+type Options struct {
+	Policy  Policy
+	Timeout time.Duration
+}
+
+// Sender dispatches commands through multiple senders according to a delivery policy.
 type Sender struct {
-	senders []application.ClockCommandSender
+	targets []Target
+	policy  Policy
+	timeout time.Duration
 }
 
-// NewSender constructs a composite sender.
+// NewSender constructs a composite sender that requires all senders to deliver.
 func NewSender(senders ...application.ClockCommandSender) *Sender {
-	return &Sender{senders: senders}
+	targets := make([]Target, 0, len(senders))
+	for idx, sender := range senders {
+		targets = append(targets, Target{Name: fmt.Sprintf("sender-%d", idx), Sender: sender})
+	}
+	return &Sender{targets: targets, policy: PolicyAll}
+}
+
+// NewPolicySender constructs a composite sender with an explicit delivery policy
+// and a deadline of opts.Timeout: shared by all senders under the concurrent
+// policies, and given to each attempt in turn under primary-with-fallback.
+func NewPolicySender(opts Options, targets ...Target) (*Sender, error) {
+	policy, err := ParsePolicy(string(opts.Policy))
+	if err != nil {
+		return nil, err
+	}
+	if opts.Timeout < 0 {
+		return nil, errors.New("delivery timeout must not be negative")
+	}
+	return &Sender{targets: targets, policy: policy, timeout: opts.Timeout}, nil
 }
 
-// Send forwards command to each configured sender in sequence.
+// Send forwards command to the configured senders and applies the delivery policy.
 func (s *Sender) Send(ctx context.Context, cmd domain.ClockCommand) error {
-	if len(s.senders) == 0 {
-		return errors.New("no command senders configured")
+	_, err := s.SendWithReport(ctx, cmd)
+	return err
+}
+
+// SendWithReport forwards command to the configured senders and returns the
+// per-sender outcome alongside the policy decision.
+func (s *Sender) SendWithReport(ctx context.Context, cmd domain.ClockCommand) (application.DeliveryReport, error) {
+	report := application.DeliveryReport{Policy: string(s.policy)}
+	if len(s.targets) == 0 {
+		return report, errors.New("no command senders configured")
 	}
 
-	var errs []error
-	for idx, sender := range s.senders {
-		if sender == nil {
-			errs = append(errs, fmt.Errorf("sender at index %d is nil", idx))
+	if s.policy == PolicyPrimaryWithFallback {
+		report.Results = s.sendWithFallback(ctx, cmd)
+		return report, s.evaluate(report.Results)
+	}
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	report.Results = s.sendConcurrently(ctx, cmd)
+	return report, s.evaluate(report.Results)
+}
+
+func (s *Sender) sendConcurrently(ctx context.Context, cmd domain.ClockCommand) []application.SenderResult {
+	results := make([]application.SenderResult, len(s.targets))
+	var wg sync.WaitGroup
+	for idx, target := range s.targets {
+		wg.Add(1)
+		go func(idx int, target Target) {
+			defer wg.Done()
+			results[idx] = sendOne(ctx, target, cmd)
+		}(idx, target)
+	}
+	wg.Wait()
+	return results
+}
+
+// sendWithFallback tries the targets in order until one delivers. Each
+// attempt gets its own deadline, so that a primary that timed out -- the
+// usual reason to fall back -- does not leave the fallback an expired
+// context.
+func (s *Sender) sendWithFallback(ctx context.Context, cmd domain.ClockCommand) []application.SenderResult {
+	results := make([]application.SenderResult, 0, len(s.targets))
+	delivered := false
+	for _, target := range s.targets {
+		if delivered {
+			results = append(results, application.SenderResult{Sender: target.Name, Status: application.DeliverySkipped})
 			continue
 		}
-		if err := sender.Send(ctx, cmd); err != nil {
-			errs = append(errs, fmt.Errorf("sender %d failed: %w", idx, err))
+		result := s.sendAttempt(ctx, target, cmd)
+		delivered = result.Status == application.DeliveryDelivered
+		results = append(results, result)
+	}
+	return results
+}
+
+func (s *Sender) sendAttempt(ctx context.Context, target Target, cmd domain.ClockCommand) application.SenderResult {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	return sendOne(ctx, target, cmd)
+}
+
+func sendOne(ctx context.Context, target Target, cmd domain.ClockCommand) application.SenderResult {
+	result := application.SenderResult{Sender: target.Name}
+	if target.Sender == nil {
+		result.Status = application.DeliveryFailed
+		result.Err = fmt.Errorf("sender %s is nil", target.Name)
+		return result
+	}
+	started := time.Now()
+	err := target.Sender.Send(ctx, cmd)
+	result.Duration = time.Since(started)
+	switch {
+	case err == nil:
+		result.Status = application.DeliveryDelivered
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.Status = application.DeliveryTimeout
+		result.Err = fmt.Errorf("sender %s timed out: %w", target.Name, err)
+	default:
+		result.Status = application.DeliveryFailed
+		result.Err = fmt.Errorf("sender %s failed: %w", target.Name, err)
+	}
+	return result
+}
+
+func (s *Sender) evaluate(results []application.SenderResult) error {
+	delivered := 0
+	var errs []error
+	for _, result := range results {
+		if result.Status == application.DeliveryDelivered {
+			delivered++
+		}
+		if result.Err != nil {
+			errs = append(errs, result.Err)
 		}
 	}
-	if len(errs) > 0 {
-		return errors.Join(errs...)
+
+	var ok bool
+	switch s.policy {
+	case PolicyAny, PolicyPrimaryWithFallback:
+		ok = delivered > 0
+	case PolicyQuorum:
+		ok = delivered >= len(s.targets)/2+1
+	default:
+		ok = len(errs) == 0
+	}
+	if ok {
+		return nil
 	}
-	return nil
+	policyErr := fmt.Errorf("delivery policy %s not satisfied: %d of %d senders delivered", s.policy, delivered, len(s.targets))
+	return errors.Join(append([]error{policyErr}, errs...)...)
 }
@@ -3,9 +3,11 @@ package composite
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/paul/clock-server/internal/application"
 	"github.com/paul/clock-server/internal/domain"
 )
 
@@ -47,3 +49,175 @@ func TestCompositeSenderCollectsErrors(t *testing.T) {
 		t.Fatalf("expected each sender to be called once, got a=%d b=%d", failA.calls, failB.calls)
 	}
 }
+
+type slowSender struct{}
+
+func (slowSender) Send(ctx context.Context, _ domain.ClockCommand) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestParsePolicy(t *testing.T) {
+	cases := map[string]Policy{
+		"":                      PolicyAll,
+		"all":                   PolicyAll,
+		" ANY ":                 PolicyAny,
+		"quorum":                PolicyQuorum,
+		"primary-with-fallback": PolicyPrimaryWithFallback,
+	}
+	for raw, want := range cases {
+		got, err := ParsePolicy(raw)
+		if err != nil {
+			t.Fatalf("ParsePolicy(%q) unexpected error: %v", raw, err)
+		}
+		if got != want {
+			t.Fatalf("ParsePolicy(%q) = %q, want %q", raw, got, want)
+		}
+	}
+	if _, err := ParsePolicy("most"); err == nil {
+		t.Fatal("expected error for unknown policy")
+	}
+}
+
+func TestPolicyAnySucceedsWhenOneSenderDelivers(t *testing.T) {
+	ok := &mockSender{}
+	fail := &mockSender{err: errors.New("rest down")}
+	sut, err := NewPolicySender(Options{Policy: PolicyAny}, Target{Name: "mqtt", Sender: ok}, Target{Name: "rest", Sender: fail})
+	if err != nil {
+		t.Fatalf("new policy sender: %v", err)
+	}
+	cmd := domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 20}
+
+	report, err := sut.SendWithReport(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("expected success under any policy, got %v", err)
+	}
+	if report.Policy != "any" || len(report.Results) != 2 {
+		t.Fatalf("unexpected report: %#v", report)
+	}
+	if report.Results[0].Sender != "mqtt" || report.Results[0].Status != application.DeliveryDelivered {
+		t.Fatalf("unexpected mqtt result: %#v", report.Results[0])
+	}
+	if report.Results[1].Sender != "rest" || report.Results[1].Status != application.DeliveryFailed {
+		t.Fatalf("unexpected rest result: %#v", report.Results[1])
+	}
+}
+
+func TestPolicyAllFailsWhenOneSenderFails(t *testing.T) {
+	sut, err := NewPolicySender(Options{Policy: PolicyAll},
+		Target{Name: "mqtt", Sender: &mockSender{}},
+		Target{Name: "rest", Sender: &mockSender{err: errors.New("rest down")}},
+	)
+	if err != nil {
+		t.Fatalf("new policy sender: %v", err)
+	}
+	err = sut.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 20})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 senders delivered") {
+		t.Fatalf("expected policy error, got %v", err)
+	}
+}
+
+func TestPolicyQuorumRequiresMajority(t *testing.T) {
+	cmd := domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 20}
+	fail := func() *mockSender { return &mockSender{err: errors.New("down")} }
+
+	majority, _ := NewPolicySender(Options{Policy: PolicyQuorum},
+		Target{Name: "a", Sender: &mockSender{}},
+		Target{Name: "b", Sender: &mockSender{}},
+		Target{Name: "c", Sender: fail()},
+	)
+	if err := majority.Send(context.Background(), cmd); err != nil {
+		t.Fatalf("expected quorum success, got %v", err)
+	}
+
+	minority, _ := NewPolicySender(Options{Policy: PolicyQuorum},
+		Target{Name: "a", Sender: &mockSender{}},
+		Target{Name: "b", Sender: fail()},
+		Target{Name: "c", Sender: fail()},
+	)
+	if err := minority.Send(context.Background(), cmd); err == nil {
+		t.Fatal("expected quorum failure")
+	}
+}
+
+func TestPolicyPrimaryWithFallbackSkipsFallbackOnSuccess(t *testing.T) {
+	primary := &mockSender{}
+	fallback := &mockSender{}
+	sut, _ := NewPolicySender(Options{Policy: PolicyPrimaryWithFallback},
+		Target{Name: "mqtt", Sender: primary},
+		Target{Name: "rest", Sender: fallback},
+	)
+
+	report, err := sut.SendWithReport(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 20})
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if primary.calls != 1 || fallback.calls != 0 {
+		t.Fatalf("expected only primary to be called, got primary=%d fallback=%d", primary.calls, fallback.calls)
+	}
+	if report.Results[1].Status != application.DeliverySkipped {
+		t.Fatalf("expected fallback to be skipped, got %#v", report.Results[1])
+	}
+}
+
+func TestPolicyPrimaryWithFallbackUsesFallbackOnFailure(t *testing.T) {
+	primary := &mockSender{err: errors.New("broker down")}
+	fallback := &mockSender{}
+	sut, _ := NewPolicySender(Options{Policy: PolicyPrimaryWithFallback},
+		Target{Name: "mqtt", Sender: primary},
+		Target{Name: "rest", Sender: fallback},
+	)
+
+	if err := sut.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 20}); err != nil {
+		t.Fatalf("expected fallback success, got %v", err)
+	}
+	if primary.calls != 1 || fallback.calls != 1 {
+		t.Fatalf("expected both senders to be called, got primary=%d fallback=%d", primary.calls, fallback.calls)
+	}
+}
+
+func TestSharedDeadlineMarksSlowSendersAsTimedOut(t *testing.T) {
+	sut, _ := NewPolicySender(Options{Policy: PolicyAny, Timeout: 20 * time.Millisecond},
+		Target{Name: "fast", Sender: &mockSender{}},
+		Target{Name: "slow", Sender: slowSender{}},
+	)
+
+	started := time.Now()
+	report, err := sut.SendWithReport(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 20})
+	if err != nil {
+		t.Fatalf("expected success under any policy, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("expected shared deadline to bound the send, took %s", elapsed)
+	}
+	if report.Results[1].Status != application.DeliveryTimeout {
+		t.Fatalf("expected slow sender to time out, got %#v", report.Results[1])
+	}
+}
+
+// delayedSender delivers after delay unless its context ends first.
+type delayedSender struct{ delay time.Duration }
+
+func (d delayedSender) Send(ctx context.Context, _ domain.ClockCommand) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d.delay):
+		return nil
+	}
+}
+
+func TestPolicyPrimaryWithFallbackGivesFallbackItsOwnDeadline(t *testing.T) {
+	sut, _ := NewPolicySender(Options{Policy: PolicyPrimaryWithFallback, Timeout: 30 * time.Millisecond},
+		Target{Name: "mqtt", Sender: slowSender{}},
+		Target{Name: "rest", Sender: delayedSender{delay: 5 * time.Millisecond}},
+	)
+
+	report, err := sut.SendWithReport(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 20})
+	if err != nil {
+		t.Fatalf("expected the fallback to deliver after the primary timed out, got %v", err)
+	}
+	if report.Results[0].Status != application.DeliveryTimeout || report.Results[1].Status != application.DeliveryDelivered {
+		t.Fatalf("unexpected results %#v", report.Results)
+	}
+}
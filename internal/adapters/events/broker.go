@@ -0,0 +1,122 @@
+// Package events is an in-process application.EventStream backed by a
+// bounded ring buffer.
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+)
+
+const (
+	defaultCapacity   = 1024
+	subscriberBacklog = 64
+)
+
+// Broker keeps the last Capacity events for Last-Event-ID resume and fans
+// new events out to subscribers. A subscriber whose buffer is full is
+// dropped rather than slowing down publishers.
+type Broker struct {
+	now func() time.Time
+
+	mu          sync.Mutex
+	ring        []application.Event
+	start       int
+	size        int
+	nextID      uint64
+	subscribers map[*subscription]struct{}
+}
+
+// NewBroker creates a broker retaining up to capacity events.
+func NewBroker(capacity int) *Broker {
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	return &Broker{
+		now:         time.Now,
+		ring:        make([]application.Event, capacity),
+		nextID:      1,
+		subscribers: make(map[*subscription]struct{}),
+	}
+}
+
+// Publish assigns the event an ID and time, retains it and delivers it to
+// every subscriber.
+func (b *Broker) Publish(event application.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event.ID = b.nextID
+	b.nextID++
+	if event.Time.IsZero() {
+		event.Time = b.now().UTC()
+	}
+
+	capacity := len(b.ring)
+	if b.size < capacity {
+		b.ring[(b.start+b.size)%capacity] = event
+		b.size++
+	} else {
+		b.ring[b.start] = event
+		b.start = (b.start + 1) % capacity
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			b.removeLocked(sub)
+		}
+	}
+}
+
+// Subscribe replays the retained events after lastID and follows new ones.
+func (b *Broker) Subscribe(lastID uint64) ([]application.Event, application.EventSubscription, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []application.Event
+	complete := true
+	if lastID > 0 {
+		capacity := len(b.ring)
+		for i := 0; i < b.size; i++ {
+			event := b.ring[(b.start+i)%capacity]
+			if event.ID > lastID {
+				replay = append(replay, event)
+			}
+		}
+		oldest := b.nextID
+		if b.size > 0 {
+			oldest = b.ring[b.start].ID
+		}
+		complete = lastID+1 >= oldest
+	}
+
+	sub := &subscription{broker: b, events: make(chan application.Event, subscriberBacklog)}
+	b.subscribers[sub] = struct{}{}
+	return replay, sub, complete
+}
+
+func (b *Broker) removeLocked(sub *subscription) {
+	if _, ok := b.subscribers[sub]; !ok {
+		return
+	}
+	delete(b.subscribers, sub)
+	close(sub.events)
+}
+
+type subscription struct {
+	broker *Broker
+	events chan application.Event
+}
+
+func (s *subscription) Events() <-chan application.Event {
+	return s.events
+}
+
+func (s *subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.removeLocked(s)
+}
@@ -0,0 +1,74 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/paul/clock-server/internal/application"
+)
+
+func publishN(b *Broker, n int) {
+	for i := 0; i < n; i++ {
+		b.Publish(application.Event{Type: application.EventAudit, DeviceID: "clock-1"})
+	}
+}
+
+func TestBrokerAssignsIDsAndDeliversLive(t *testing.T) {
+	b := NewBroker(8)
+	replay, sub, complete := b.Subscribe(0)
+	defer sub.Close()
+	if len(replay) != 0 || !complete {
+		t.Fatalf("fresh subscription must not replay: %v %v", replay, complete)
+	}
+
+	publishN(b, 2)
+	first, second := <-sub.Events(), <-sub.Events()
+	if first.ID != 1 || second.ID != 2 || first.Time.IsZero() {
+		t.Fatalf("unexpected events: %+v %+v", first, second)
+	}
+}
+
+func TestBrokerReplaysAfterLastID(t *testing.T) {
+	b := NewBroker(8)
+	publishN(b, 5)
+
+	replay, sub, complete := b.Subscribe(3)
+	defer sub.Close()
+	if !complete || len(replay) != 2 || replay[0].ID != 4 || replay[1].ID != 5 {
+		t.Fatalf("unexpected replay: complete=%v %+v", complete, replay)
+	}
+}
+
+func TestBrokerReportsEvictedEvents(t *testing.T) {
+	b := NewBroker(3)
+	publishN(b, 10)
+
+	replay, sub, complete := b.Subscribe(2)
+	defer sub.Close()
+	if complete {
+		t.Fatal("expected incomplete replay after eviction")
+	}
+	if len(replay) != 3 || replay[0].ID != 8 || replay[2].ID != 10 {
+		t.Fatalf("unexpected replay: %+v", replay)
+	}
+
+	_, sub2, complete := b.Subscribe(7)
+	defer sub2.Close()
+	if !complete {
+		t.Fatal("expected complete replay when the next event is retained")
+	}
+}
+
+func TestBrokerDropsSlowSubscribers(t *testing.T) {
+	b := NewBroker(8)
+	_, sub, _ := b.Subscribe(0)
+
+	publishN(b, subscriberBacklog+1)
+	received := 0
+	for range sub.Events() {
+		received++
+	}
+	if received != subscriberBacklog {
+		t.Fatalf("expected %d buffered events before drop, got %d", subscriberBacklog, received)
+	}
+	sub.Close() // closing a dropped subscription is a no-op
+}
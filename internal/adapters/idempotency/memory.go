@@ -0,0 +1,130 @@
+package idempotency
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+)
+
+const (
+	defaultTTL        = 24 * time.Hour
+	defaultMaxEntries = 10000
+)
+
+// MemoryStore is an in-process application.IdempotencyStore. Records expire
+// after the TTL and the oldest records are evicted once MaxEntries is reached.
+type MemoryStore struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	records map[storeKey]application.IdempotencyRecord
+}
+
+type storeKey struct {
+	principal string
+	key       string
+}
+
+// NewMemoryStore creates a store keeping records for ttl.
+func NewMemoryStore(ttl time.Duration, maxEntries int) *MemoryStore {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+	return &MemoryStore{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		records:    make(map[storeKey]application.IdempotencyRecord),
+	}
+}
+
+// Reserve claims key for principal unless an unexpired record exists.
+func (s *MemoryStore) Reserve(principal, key, requestHash string) (application.IdempotencyRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	id := storeKey{principal: principal, key: key}
+	if existing, ok := s.records[id]; ok {
+		if now.Sub(existing.CreatedAt) < s.ttl {
+			return existing, false
+		}
+		delete(s.records, id)
+	}
+	s.evictIfNeeded(now)
+	record := application.IdempotencyRecord{
+		Principal:   principal,
+		Key:         key,
+		RequestHash: requestHash,
+		CreatedAt:   now,
+	}
+	s.records[id] = record
+	return record, true
+}
+
+// Complete stores the response of a reserved request.
+func (s *MemoryStore) Complete(record application.IdempotencyRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := storeKey{principal: record.Principal, key: record.Key}
+	existing, ok := s.records[id]
+	if !ok || existing.RequestHash != record.RequestHash {
+		return
+	}
+	record.CreatedAt = existing.CreatedAt
+	record.Body = append([]byte(nil), record.Body...)
+	s.records[id] = record
+}
+
+// Release forgets a reservation.
+func (s *MemoryStore) Release(principal, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, storeKey{principal: principal, key: key})
+}
+
+// Lookup returns the unexpired record of key for principal.
+func (s *MemoryStore) Lookup(principal, key string) (application.IdempotencyRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[storeKey{principal: principal, key: key}]
+	if !ok || s.now().Sub(record.CreatedAt) >= s.ttl {
+		return application.IdempotencyRecord{}, false
+	}
+	record.Body = append([]byte(nil), record.Body...)
+	return record, true
+}
+
+// evictIfNeeded keeps the store below maxEntries, dropping expired records
+// first and then the oldest ones. Must be called with s.mu held.
+func (s *MemoryStore) evictIfNeeded(now time.Time) {
+	if len(s.records) < s.maxEntries {
+		return
+	}
+	for id, record := range s.records {
+		if now.Sub(record.CreatedAt) >= s.ttl {
+			delete(s.records, id)
+		}
+	}
+	if len(s.records) < s.maxEntries {
+		return
+	}
+
+	ids := make([]storeKey, 0, len(s.records))
+	for id := range s.records {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return s.records[ids[i]].CreatedAt.Before(s.records[ids[j]].CreatedAt)
+	})
+	toRemove := len(s.records) - s.maxEntries + 1
+	for i := 0; i < toRemove && i < len(ids); i++ {
+		delete(s.records, ids[i])
+	}
+}
@@ -0,0 +1,119 @@
+package idempotency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+)
+
+func newTestStore(ttl time.Duration, maxEntries int) (*MemoryStore, *time.Time) {
+	now := time.Date(2030, 1, 1, 8, 0, 0, 0, time.UTC)
+	store := NewMemoryStore(ttl, maxEntries)
+	store.now = func() time.Time { return now }
+	return store, &now
+}
+
+func TestReserveReturnsExistingRecord(t *testing.T) {
+	store, _ := newTestStore(time.Hour, 10)
+
+	if _, ok := store.Reserve("svc", "key-1", "hash"); !ok {
+		t.Fatal("expected first reservation to succeed")
+	}
+	existing, ok := store.Reserve("svc", "key-1", "other")
+	if ok {
+		t.Fatal("expected second reservation to be rejected")
+	}
+	if existing.RequestHash != "hash" || existing.Completed() {
+		t.Fatalf("unexpected existing record: %#v", existing)
+	}
+}
+
+func TestReserveScopesKeysByPrincipal(t *testing.T) {
+	store, _ := newTestStore(time.Hour, 10)
+
+	store.Reserve("svc-a", "key-1", "hash")
+	if _, ok := store.Reserve("svc-b", "key-1", "hash"); !ok {
+		t.Fatal("expected key to be independent per principal")
+	}
+}
+
+func TestCompleteStoresResponse(t *testing.T) {
+	store, _ := newTestStore(time.Hour, 10)
+
+	record, _ := store.Reserve("svc", "key-1", "hash")
+	record.StatusCode = 202
+	record.Body = []byte(`{"result":"sent"}`)
+	store.Complete(record)
+
+	existing, ok := store.Reserve("svc", "key-1", "hash")
+	if ok {
+		t.Fatal("expected completed key to stay reserved")
+	}
+	if !existing.Completed() || existing.StatusCode != 202 || string(existing.Body) != `{"result":"sent"}` {
+		t.Fatalf("unexpected stored record: %#v", existing)
+	}
+}
+
+func TestLookupReturnsUnexpiredRecords(t *testing.T) {
+	store, now := newTestStore(time.Hour, 10)
+
+	if _, ok := store.Lookup("svc", "key-1"); ok {
+		t.Fatal("expected unknown key to be missing")
+	}
+	record, _ := store.Reserve("svc", "key-1", "hash")
+	if existing, ok := store.Lookup("svc", "key-1"); !ok || existing.Completed() {
+		t.Fatalf("expected an in-progress record, got %#v %v", existing, ok)
+	}
+	record.StatusCode = 200
+	store.Complete(record)
+	if existing, ok := store.Lookup("svc", "key-1"); !ok || existing.StatusCode != 200 {
+		t.Fatalf("expected the completed record, got %#v %v", existing, ok)
+	}
+	if _, ok := store.Lookup("other", "key-1"); ok {
+		t.Fatal("expected lookups to be scoped by principal")
+	}
+	*now = now.Add(time.Hour)
+	if _, ok := store.Lookup("svc", "key-1"); ok {
+		t.Fatal("expected expired record to be missing")
+	}
+}
+
+func TestReleaseAllowsRetry(t *testing.T) {
+	store, _ := newTestStore(time.Hour, 10)
+
+	store.Reserve("svc", "key-1", "hash")
+	store.Release("svc", "key-1")
+	if _, ok := store.Reserve("svc", "key-1", "hash"); !ok {
+		t.Fatal("expected released key to be reservable")
+	}
+}
+
+func TestRecordsExpireAfterTTL(t *testing.T) {
+	store, now := newTestStore(time.Minute, 10)
+
+	store.Reserve("svc", "key-1", "hash")
+	*now = now.Add(time.Minute)
+	if _, ok := store.Reserve("svc", "key-1", "other"); !ok {
+		t.Fatal("expected expired key to be reservable")
+	}
+}
+
+func TestEvictsOldestWhenFull(t *testing.T) {
+	store, now := newTestStore(time.Hour, 2)
+
+	store.Reserve("svc", "key-1", "hash")
+	*now = now.Add(time.Second)
+	store.Reserve("svc", "key-2", "hash")
+	*now = now.Add(time.Second)
+	store.Reserve("svc", "key-3", "hash")
+
+	if _, ok := store.Reserve("svc", "key-1", "hash"); !ok {
+		t.Fatal("expected oldest key to be evicted")
+	}
+	if _, ok := store.Reserve("svc", "key-3", "hash"); ok {
+		t.Fatal("expected newest key to be kept")
+	}
+}
+
+var _ application.IdempotencyStore = (*MemoryStore)(nil)
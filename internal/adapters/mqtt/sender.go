@@ -14,6 +14,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/paul/clock-server/internal/adapters/retry"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
 	"github.com/paul/clock-server/internal/domain"
 )
 
@@ -36,6 +39,9 @@ type Config struct {
 	TLSInsecureSkipVerify  bool
 	AllowInsecureTLS       bool
 	AllowInsecureTransport bool
+	// Retry controls publish retries when ConnectRetry is enabled. A zero
+	// MaxAttempts keeps the historical three attempts.
+	Retry retry.Policy
 }
 
 // Sender publishes smart clock commands to an MQTT broker using a persistent in-process connection.
@@ -97,39 +103,51 @@ func (s *Sender) Send(ctx context.Context, cmd domain.ClockCommand) error {
 	if err != nil {
 		return err
 	}
+	if key := application.IdempotencyKeyFromContext(ctx); key != "" {
+		payload["idempotencyKey"] = key
+	}
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("marshal mqtt payload: %w", err)
 	}
 	topic := buildTopic(s.cfg.TopicPrefix, cmd)
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	attempts := 1
-	if s.cfg.ConnectRetry {
-		attempts = 3
+	policy := s.cfg.Retry
+	switch {
+	case !s.cfg.ConnectRetry:
+		policy.MaxAttempts = 1
+	case policy.MaxAttempts <= 0:
+		policy.MaxAttempts = 3
 	}
 
-	var lastErr error
-	for i := 0; i < attempts; i++ {
-		if ctx.Err() != nil {
-			return ctx.Err()
-		}
-		if s.conn == nil {
-			if err := s.connect(); err != nil {
-				lastErr = err
-				continue
-			}
+	attempts, err := policy.Do(ctx, retry.Allowed(ctx, cmd), func(context.Context) error {
+		return s.attempt(topic, body)
+	})
+	if err != nil {
+		if attempts == 0 {
+			return err
 		}
-		if err := s.publish(topic, body); err != nil {
-			lastErr = err
-			s.closeLocked()
-			continue
+		return fmt.Errorf("mqtt publish failed after %d attempts: %w", attempts, err)
+	}
+	return nil
+}
+
+// attempt publishes once, reconnecting first when the connection was dropped.
+// Connection failures are always retryable because nothing was published.
+func (s *Sender) attempt(topic string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return retry.RetryableBeforeSend(err)
 		}
-		return nil
 	}
-	return fmt.Errorf("mqtt publish failed after %d attempts: %w", attempts, lastErr)
+	if err := s.publish(topic, body); err != nil {
+		s.closeLocked()
+		return retry.Retryable(err, 0)
+	}
+	return nil
 }
 
 // Check verifies adapter readiness.
@@ -387,23 +405,5 @@ func buildTopic(prefix string, cmd domain.ClockCommand) string {
 }
 
 func buildPayload(cmd domain.ClockCommand) (map[string]any, error) {
-	base := map[string]any{
-		"deviceId": cmd.TargetDeviceID(),
-		"type":     cmd.CommandType(),
-	}
-
-	switch c := cmd.(type) {
-	case domain.SetAlarmCommand:
-		base["alarmTime"] = c.AlarmTime.Format(time.RFC3339)
-		base["label"] = c.Label
-	case domain.DisplayMessageCommand:
-		base["message"] = c.Message
-		base["durationSeconds"] = c.DurationSeconds
-	case domain.SetBrightnessCommand:
-		base["level"] = c.Level
-	default:
-		return nil, fmt.Errorf("unsupported command type %T", cmd)
-	}
-
-	return base, nil
+	return commands.DevicePayload(cmd)
 }
@@ -12,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/paul/clock-server/internal/application"
 	"github.com/paul/clock-server/internal/domain"
 )
 
@@ -796,6 +797,31 @@ func TestSend_SetBrightness(t *testing.T) {
 	}
 }
 
+func TestSend_IncludesIdempotencyKeyInPayload(t *testing.T) {
+	mb := newMockBroker(t, behaviorAccept)
+	defer mb.close()
+
+	s, err := newSenderWithBroker(t, mb, nil)
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+
+	ctx := application.WithIdempotencyKey(context.Background(), "key-123")
+	cmd := domain.SetBrightnessCommand{DeviceID: "clock-3", Level: 75}
+	if err := s.Send(ctx, cmd); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	records := mb.waitForPublishes(1, 500*time.Millisecond)
+	if len(records) != 1 {
+		t.Fatalf("expected 1 payload, got %d", len(records))
+	}
+	if !bytes.Contains(records[0].payload, []byte(`"idempotencyKey":"key-123"`)) {
+		t.Errorf("payload missing idempotency key: %s", records[0].payload)
+	}
+}
+
 func TestSend_MultipleCommands(t *testing.T) {
 	mb := newMockBroker(t, behaviorAccept)
 	defer mb.close()
@@ -938,6 +964,29 @@ func TestSend_PublishFailureNoRetry(t *testing.T) {
 	}
 }
 
+func TestSend_PublishFailureDoesNotRetryNonIdempotentCommand(t *testing.T) {
+	// A SetAlarm publish may already have reached the broker, so without an
+	// idempotency key it must not be repeated.
+	mb := newMockBroker(t, behaviorCloseOnPublish)
+	defer mb.close()
+
+	s, err := newSenderWithBroker(t, mb, func(c *Config) {
+		c.ConnectRetry = true
+		c.QoS = 1
+	})
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	s.pubTimeout = 500 * time.Millisecond
+	defer s.Close()
+
+	cmd := domain.SetAlarmCommand{DeviceID: "dev-1", AlarmTime: time.Now().Add(time.Hour)}
+	err = s.Send(context.Background(), cmd)
+	if err == nil || !strings.Contains(err.Error(), "mqtt publish failed after 1 attempts") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func TestSend_QoS1_PubAckTimeout(t *testing.T) {
 	// Broker accepts but never sends PUBACK
 	mb := newMockBroker(t, behaviorAcceptNoPubAck)
@@ -0,0 +1,359 @@
+// Package redis keeps rate-limit counters in a Redis server, or any server
+// speaking its protocol, so that every replica of the API enforces the same
+// limits. It implements the small RESP2 subset the limiter needs, without
+// external dependencies.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+)
+
+const (
+	defaultTimeout   = time.Second
+	defaultKeyPrefix = "clock-server:"
+	defaultPoolSize  = 8
+
+	// maxTakeAttempts bounds the optimistic transactions of Take when other
+	// replicas change the same limits concurrently.
+	maxTakeAttempts = 10
+)
+
+// Config defines Redis limiter settings.
+type Config struct {
+	// URL is rediss://[user:password@]host[:port][/db] or, when
+	// AllowInsecureTransport is set, redis://. Credentials in the URL are
+	// used unless Password is set.
+	URL      string
+	Username string
+	Password string
+	// KeyPrefix is prepended to every key, so that several deployments can
+	// share a server.
+	KeyPrefix              string
+	Timeout                time.Duration
+	PoolSize               int
+	TLSInsecureSkipVerify  bool
+	AllowInsecureTLS       bool
+	AllowInsecureTransport bool
+}
+
+// Limiter is an application.RateLimiter backed by Redis. Auth failures are
+// counters that expire with their window; token buckets and quotas are
+// updated in WATCH/MULTI/EXEC transactions, so concurrent replicas never
+// charge the same token twice.
+type Limiter struct {
+	cfg        Config
+	address    string
+	tlsEnabled bool
+	serverName string
+	username   string
+	password   string
+	db         int
+
+	pool chan *conn
+}
+
+var _ application.RateLimiter = (*Limiter)(nil)
+
+// conn is a pooled connection.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// NewLimiter validates cfg. Connections are opened on first use; call
+// Check to verify that the server is reachable.
+func NewLimiter(cfg Config) (*Limiter, error) {
+	if strings.TrimSpace(cfg.URL) == "" {
+		return nil, errors.New("redis url is required")
+	}
+	if cfg.TLSInsecureSkipVerify && !cfg.AllowInsecureTLS {
+		return nil, errors.New("REDIS_TLS_INSECURE_SKIP_VERIFY requires ALLOW_INSECURE_TLS_VERIFY=true")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = defaultPoolSize
+	}
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = defaultKeyPrefix
+	}
+	parsed, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	l := &Limiter{cfg: cfg, pool: make(chan *conn, cfg.PoolSize)}
+	switch strings.ToLower(parsed.Scheme) {
+	case "redis":
+		if !cfg.AllowInsecureTransport {
+			return nil, errors.New("insecure redis:// is disabled; use rediss:// or set ALLOW_INSECURE_REDIS=true")
+		}
+	case "rediss":
+		l.tlsEnabled = true
+	default:
+		return nil, fmt.Errorf("unsupported redis scheme %q", parsed.Scheme)
+	}
+	host := parsed.Hostname()
+	if host == "" {
+		return nil, errors.New("redis host is required")
+	}
+	port := parsed.Port()
+	if port == "" {
+		port = "6379"
+	}
+	l.address, l.serverName = net.JoinHostPort(host, port), host
+	if db := strings.TrimPrefix(parsed.Path, "/"); db != "" {
+		if l.db, err = strconv.Atoi(db); err != nil || l.db < 0 {
+			return nil, fmt.Errorf("redis database %q must be a non-negative number", db)
+		}
+	}
+	if parsed.User != nil {
+		l.username = parsed.User.Username()
+		l.password, _ = parsed.User.Password()
+	}
+	if cfg.Password != "" {
+		l.username, l.password = cfg.Username, cfg.Password
+	}
+	return l, nil
+}
+
+// AuthFailures returns the failures counted for key in its current window.
+func (l *Limiter) AuthFailures(ctx context.Context, key string) (int, error) {
+	var n int
+	err := l.do(ctx, func(c *conn) error {
+		reply, err := c.call("GET", l.key("authfail:"+key))
+		if err != nil || reply == nil {
+			return err
+		}
+		n, err = strconv.Atoi(reply.(string))
+		return err
+	})
+	return n, err
+}
+
+// RecordAuthFailure counts a failure for key. The counter is created with
+// the window as its expiry, which INCR keeps.
+func (l *Limiter) RecordAuthFailure(ctx context.Context, key string, window time.Duration) error {
+	k := l.key("authfail:" + key)
+	return l.do(ctx, func(c *conn) error {
+		_, err := c.pipeline(
+			[]string{"SET", k, "0", "NX", "PX", strconv.FormatInt(max(window.Milliseconds(), 1), 10)},
+			[]string{"INCR", k},
+		)
+		return err
+	})
+}
+
+// Take charges one command to limits in an optimistic transaction: the
+// states are read under WATCH and written with MULTI/EXEC, which the server
+// aborts when another client changed one of them in between.
+func (l *Limiter) Take(ctx context.Context, limits []application.Limit, now time.Time) (application.LimitStatus, error) {
+	keys := make([]string, len(limits))
+	for i, limit := range limits {
+		keys[i] = l.key("limit:" + limit.Key)
+	}
+	var status application.LimitStatus
+	err := l.do(ctx, func(c *conn) error {
+		for attempt := 0; attempt < maxTakeAttempts; attempt++ {
+			if _, err := c.call(append([]string{"WATCH"}, keys...)...); err != nil {
+				return err
+			}
+			reply, err := c.call(append([]string{"MGET"}, keys...)...)
+			if err != nil {
+				return err
+			}
+			values, ok := reply.([]any)
+			if !ok || len(values) != len(keys) {
+				return errors.New("redis: unexpected MGET reply")
+			}
+			states := make([]application.LimitState, len(limits))
+			for i, value := range values {
+				if value != nil {
+					if states[i], err = decodeState(value.(string)); err != nil {
+						return err
+					}
+				}
+			}
+			var next []application.LimitState
+			status, next = application.ChargeLimits(limits, states, now)
+			if next == nil {
+				_, err := c.call("UNWATCH")
+				return err
+			}
+			cmds := [][]string{{"MULTI"}}
+			for i, state := range next {
+				ttl := max(limits[i].StateTTL(now).Milliseconds(), 1)
+				cmds = append(cmds, []string{"SET", keys[i], encodeState(state), "PX", strconv.FormatInt(ttl, 10)})
+			}
+			replies, err := c.pipeline(append(cmds, []string{"EXEC"})...)
+			if err != nil {
+				return err
+			}
+			if replies[len(replies)-1] != nil {
+				return nil
+			}
+			// EXEC returned nil: a watched key changed, so start over.
+		}
+		return fmt.Errorf("redis: limits still contended after %d attempts", maxTakeAttempts)
+	})
+	return status, err
+}
+
+// Check verifies that the server is reachable and accepts the credentials.
+func (l *Limiter) Check(ctx context.Context) error {
+	return l.do(ctx, func(c *conn) error {
+		_, err := c.call("PING")
+		return err
+	})
+}
+
+// Close closes the pooled connections.
+func (l *Limiter) Close() {
+	for {
+		select {
+		case c := <-l.pool:
+			_ = c.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (l *Limiter) key(name string) string {
+	return l.cfg.KeyPrefix + name
+}
+
+// do runs fn on a pooled connection within the timeout. A connection that
+// failed is closed rather than returned to the pool, since its replies may
+// be out of step with its commands.
+func (l *Limiter) do(ctx context.Context, fn func(*conn) error) error {
+	var c *conn
+	select {
+	case c = <-l.pool:
+	default:
+		var err error
+		if c, err = l.dial(ctx); err != nil {
+			return err
+		}
+	}
+	deadline := time.Now().Add(l.cfg.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := c.SetDeadline(deadline); err != nil {
+		_ = c.Close()
+		return err
+	}
+	if err := fn(c); err != nil {
+		_ = c.Close()
+		return err
+	}
+	select {
+	case l.pool <- c:
+	default:
+		_ = c.Close()
+	}
+	return nil
+}
+
+// dial connects, authenticates and selects the database.
+func (l *Limiter) dial(ctx context.Context) (*conn, error) {
+	dialer := &net.Dialer{Timeout: l.cfg.Timeout}
+	var nc net.Conn
+	var err error
+	if l.tlsEnabled {
+		td := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ServerName:         l.serverName,
+			InsecureSkipVerify: l.cfg.TLSInsecureSkipVerify,
+		}}
+		nc, err = td.DialContext(ctx, "tcp", l.address)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", l.address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dial redis: %w", err)
+	}
+	c := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if err := c.SetDeadline(time.Now().Add(l.cfg.Timeout)); err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	var setup [][]string
+	switch {
+	case l.password != "" && l.username != "":
+		setup = append(setup, []string{"AUTH", l.username, l.password})
+	case l.password != "":
+		setup = append(setup, []string{"AUTH", l.password})
+	}
+	if l.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(l.db)})
+	}
+	if len(setup) > 0 {
+		if _, err := c.pipeline(setup...); err != nil {
+			_ = nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// call sends one command and returns its reply.
+func (c *conn) call(args ...string) (any, error) {
+	replies, err := c.pipeline(args)
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// pipeline sends cmds at once and reads their replies. The first error
+// reply is returned as an error, after every reply has been read.
+func (c *conn) pipeline(cmds ...[]string) ([]any, error) {
+	for _, args := range cmds {
+		writeCommand(c.w, args...)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]any, len(cmds))
+	var replyErr error
+	for i := range cmds {
+		reply, err := readReply(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if serr, ok := reply.(serverError); ok && replyErr == nil {
+			replyErr = serr
+		}
+		replies[i] = reply
+	}
+	return replies, replyErr
+}
+
+// encodeState stores a limit state as "<tokens>:<unix nanoseconds>".
+func encodeState(s application.LimitState) string {
+	return strconv.FormatFloat(s.Tokens, 'g', -1, 64) + ":" + strconv.FormatInt(s.Updated.UnixNano(), 10)
+}
+
+func decodeState(raw string) (application.LimitState, error) {
+	tokens, updated, ok := strings.Cut(raw, ":")
+	t, err := strconv.ParseFloat(tokens, 64)
+	nanos, err2 := strconv.ParseInt(updated, 10, 64)
+	if !ok || err != nil || err2 != nil {
+		return application.LimitState{}, fmt.Errorf("redis: malformed limit state %q", raw)
+	}
+	return application.LimitState{Tokens: t, Updated: time.Unix(0, nanos)}, nil
+}
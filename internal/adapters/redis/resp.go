@@ -0,0 +1,96 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxBulkLen bounds the bulk strings accepted from the server; the limiter
+// only reads short counters and bucket states.
+const maxBulkLen = 1 << 20
+
+// serverError is an error reply, such as "WRONGPASS invalid password".
+type serverError string
+
+func (e serverError) Error() string {
+	return "redis: " + string(e)
+}
+
+// writeCommand encodes args as a RESP array of bulk strings.
+// Errors surface when the buffer is flushed.
+func writeCommand(w *bufio.Writer, args ...string) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// readReply decodes one RESP2 reply: a string for simple strings, a
+// serverError for errors, an int64 for integers, a string or nil for bulk
+// strings and a []any or nil for arrays.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return serverError(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid integer reply %q", line)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxBulkLen {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxBulkLen {
+			return nil, fmt.Errorf("redis: invalid array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+// readLine reads a CRLF-terminated line without the terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
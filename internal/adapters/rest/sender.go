@@ -12,6 +12,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/paul/clock-server/internal/adapters/retry"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
 	"github.com/paul/clock-server/internal/domain"
 )
 
@@ -22,6 +25,8 @@ type Config struct {
 	Timeout           time.Duration
 	HealthPath        string
 	AllowInsecureHTTP bool
+	// Retry controls retries of failed requests; the zero value makes a single attempt.
+	Retry retry.Policy
 }
 
 // Sender sends clock commands to a downstream REST service.
@@ -30,6 +35,7 @@ type Sender struct {
 	baseURL    string
 	token      string
 	healthPath string
+	retry      retry.Policy
 }
 
 // NewSender creates a REST sender.
@@ -54,6 +60,7 @@ func NewSender(cfg Config) (*Sender, error) {
 		baseURL:    base,
 		token:      cfg.AuthToken,
 		healthPath: strings.TrimSpace(cfg.HealthPath),
+		retry:      cfg.Retry,
 	}, nil
 }
 
@@ -73,6 +80,18 @@ func (s *Sender) Send(ctx context.Context, cmd domain.ClockCommand) error {
 		return fmt.Errorf("marshal rest payload: %w", err)
 	}
 
+	attempts, err := s.retry.Do(ctx, retry.Allowed(ctx, cmd), func(ctx context.Context) error {
+		return s.attempt(ctx, method, path, body)
+	})
+	if err != nil && attempts > 1 {
+		return fmt.Errorf("rest request failed after %d attempts: %w", attempts, err)
+	}
+	return err
+}
+
+// attempt performs a single downstream request. Transport failures and
+// retryable status codes are marked for the retry policy.
+func (s *Sender) attempt(ctx context.Context, method, path string, body []byte) error {
 	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("build request: %w", err)
@@ -81,40 +100,42 @@ func (s *Sender) Send(ctx context.Context, cmd domain.ClockCommand) error {
 	if strings.TrimSpace(s.token) != "" {
 		req.Header.Set("Authorization", "Bearer "+s.token)
 	}
+	if key := application.IdempotencyKeyFromContext(ctx); key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("send rest request: %w", err)
+		err = fmt.Errorf("send rest request: %w", err)
+		if ctx.Err() != nil {
+			return err
+		}
+		return retry.Retryable(err, 0)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-		return fmt.Errorf("rest request failed: status=%d body=%s", resp.StatusCode, strings.TrimSpace(string(message)))
+		err := fmt.Errorf("rest request failed: status=%d body=%s", resp.StatusCode, strings.TrimSpace(string(message)))
+		if s.retry.IsRetryableStatus(resp.StatusCode) {
+			return retry.Retryable(err, retry.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()))
+		}
+		return err
 	}
 	return nil
 }
 
 func mapRequest(cmd domain.ClockCommand) (method string, path string, payload map[string]any, err error) {
-	deviceID := url.PathEscape(cmd.TargetDeviceID())
-	switch c := cmd.(type) {
-	case domain.SetAlarmCommand:
-		return http.MethodPost, fmt.Sprintf("/clocks/%s/alarms", deviceID), map[string]any{
-			"alarmTime": c.AlarmTime.Format(time.RFC3339),
-			"label":     c.Label,
-		}, nil
-	case domain.DisplayMessageCommand:
-		return http.MethodPost, fmt.Sprintf("/clocks/%s/messages", deviceID), map[string]any{
-			"message":         c.Message,
-			"durationSeconds": c.DurationSeconds,
-		}, nil
-	case domain.SetBrightnessCommand:
-		return http.MethodPut, fmt.Sprintf("/clocks/%s/brightness", deviceID), map[string]any{
-			"level": c.Level,
-		}, nil
-	default:
+	def, ok := commands.Lookup(cmd.CommandType())
+	if !ok {
 		return "", "", nil, fmt.Errorf("unsupported command type %T", cmd)
 	}
+	payload, err = def.Payload(cmd)
+	if err != nil {
+		return "", "", nil, err
+	}
+	path = strings.ReplaceAll(def.RESTPath, "{deviceId}", url.PathEscape(cmd.TargetDeviceID()))
+	return def.RESTMethod, path, payload, nil
 }
 
 // Check verifies downstream readiness.
@@ -12,6 +12,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/paul/clock-server/internal/adapters/retry"
+	"github.com/paul/clock-server/internal/application"
 	"github.com/paul/clock-server/internal/domain"
 )
 
@@ -206,6 +208,22 @@ func TestSend_AlarmCommand_NoAuthHeader_WhenTokenEmpty(t *testing.T) {
 	}
 }
 
+func TestSend_PropagatesIdempotencyKeyHeader(t *testing.T) {
+	var gotKey string
+	s := newTestSender(t, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		gotKey = r.Header.Get("Idempotency-Key")
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+	}))
+	ctx := application.WithIdempotencyKey(context.Background(), "key-123")
+	cmd := domain.SetAlarmCommand{DeviceID: "dev1", AlarmTime: time.Date(2030, 1, 1, 8, 0, 0, 0, time.UTC)}
+	if err := s.Send(ctx, cmd); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if gotKey != "key-123" {
+		t.Fatalf("expected idempotency key header, got %q", gotKey)
+	}
+}
+
 func TestSend_AlarmCommand_ContentTypeJSON(t *testing.T) {
 	var gotContentType string
 	s := newTestSender(t, roundTripFunc(func(r *http.Request) (*http.Response, error) {
@@ -665,3 +683,103 @@ func TestCheck_HealthPath_UsesGETMethod(t *testing.T) {
 		t.Fatalf("expected GET for health check, got %s", gotMethod)
 	}
 }
+
+// ── Send: retry policy ───────────────────────────────────────────────────────
+
+func newRetryingSender(t *testing.T, statuses []int, headers http.Header) (*Sender, *int) {
+	t.Helper()
+	calls := 0
+	s, err := NewSender(Config{
+		BaseURL:           "http://clock-api.local",
+		AllowInsecureHTTP: true,
+		Retry:             retry.Policy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: 2 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	s.client = &http.Client{Timeout: 2 * time.Second, Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		status := statuses[len(statuses)-1]
+		if calls < len(statuses) {
+			status = statuses[calls]
+		}
+		calls++
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("")), Header: headers.Clone()}, nil
+	})}
+	return s, &calls
+}
+
+func TestSend_RetriesIdempotentCommandOnRetryableStatus(t *testing.T) {
+	s, calls := newRetryingSender(t, []int{503, 502, 202}, make(http.Header))
+
+	if err := s.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "dev1", Level: 50}); err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if *calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", *calls)
+	}
+}
+
+func TestSend_DoesNotRetryNonRetryableStatus(t *testing.T) {
+	s, calls := newRetryingSender(t, []int{500}, make(http.Header))
+
+	if err := s.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "dev1", Level: 50}); err == nil {
+		t.Fatal("expected error")
+	}
+	if *calls != 1 {
+		t.Fatalf("expected a single attempt, got %d", *calls)
+	}
+}
+
+func TestSend_DoesNotRetryNonIdempotentCommandWithoutKey(t *testing.T) {
+	s, calls := newRetryingSender(t, []int{503}, make(http.Header))
+	cmd := domain.SetAlarmCommand{DeviceID: "dev1", AlarmTime: time.Now().Add(time.Hour)}
+
+	if err := s.Send(context.Background(), cmd); err == nil {
+		t.Fatal("expected error")
+	}
+	if *calls != 1 {
+		t.Fatalf("expected alarm not to be retried, got %d attempts", *calls)
+	}
+}
+
+func TestSend_RetriesNonIdempotentCommandWithIdempotencyKey(t *testing.T) {
+	s, calls := newRetryingSender(t, []int{503, 201}, make(http.Header))
+	cmd := domain.SetAlarmCommand{DeviceID: "dev1", AlarmTime: time.Now().Add(time.Hour)}
+	ctx := application.WithIdempotencyKey(context.Background(), "key-1")
+
+	if err := s.Send(ctx, cmd); err != nil {
+		t.Fatalf("expected success after retry, got %v", err)
+	}
+	if *calls != 2 {
+		t.Fatalf("expected 2 attempts, got %d", *calls)
+	}
+}
+
+func TestSend_HonoursRetryAfter(t *testing.T) {
+	headers := make(http.Header)
+	headers.Set("Retry-After", "1")
+	s, calls := newRetryingSender(t, []int{429, 202}, headers)
+
+	started := time.Now()
+	if err := s.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "dev1", Level: 50}); err != nil {
+		t.Fatalf("expected success after retry, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed < 900*time.Millisecond {
+		t.Fatalf("expected Retry-After delay, retried after %s", elapsed)
+	}
+	if *calls != 2 {
+		t.Fatalf("expected 2 attempts, got %d", *calls)
+	}
+}
+
+func TestSend_RetryExhaustionReportsAttempts(t *testing.T) {
+	s, calls := newRetryingSender(t, []int{503}, make(http.Header))
+
+	err := s.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "dev1", Level: 50})
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") || !strings.Contains(err.Error(), "status=503") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", *calls)
+	}
+}
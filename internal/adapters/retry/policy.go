@@ -0,0 +1,177 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+// DefaultRetryableStatus lists the HTTP status codes retried by default.
+var DefaultRetryableStatus = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// Policy defines bounded exponential backoff shared by sender adapters.
+type Policy struct {
+	MaxAttempts     int
+	BaseBackoff     time.Duration
+	MaxBackoff      time.Duration
+	Jitter          float64
+	RetryableStatus []int
+}
+
+// Error marks an attempt failure as retryable.
+type Error struct {
+	Err error
+	// RetryAfter is a server-requested delay that overrides the backoff when longer.
+	RetryAfter time.Duration
+	// Safe reports that the attempt failed before reaching the downstream, so
+	// retrying cannot cause a duplicate delivery.
+	Safe bool
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Retryable marks err as retryable after at least the given delay.
+func Retryable(err error, retryAfter time.Duration) error {
+	return &Error{Err: err, RetryAfter: retryAfter}
+}
+
+// RetryableBeforeSend marks err as retryable regardless of command idempotency,
+// because nothing reached the downstream.
+func RetryableBeforeSend(err error) error {
+	return &Error{Err: err, Safe: true}
+}
+
+// Allowed reports whether cmd may be delivered more than once: either it is
+// idempotent by nature or the caller supplied an idempotency key.
+func Allowed(ctx context.Context, cmd domain.ClockCommand) bool {
+	if application.IdempotencyKeyFromContext(ctx) != "" {
+		return true
+	}
+	idempotent, ok := cmd.(domain.IdempotentCommand)
+	return ok && idempotent.Idempotent()
+}
+
+// IsRetryableStatus reports whether an HTTP status code should be retried.
+func (p Policy) IsRetryableStatus(code int) bool {
+	statuses := p.RetryableStatus
+	if statuses == nil {
+		statuses = DefaultRetryableStatus
+	}
+	for _, candidate := range statuses {
+		if candidate == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Do runs fn until it succeeds, returns a non-retryable error, attempts are
+// exhausted or ctx is done. allowed reports whether non-safe failures may be
+// retried. A Retry-After longer than MaxBackoff, or than the time left before
+// the ctx deadline, ends the loop early. It returns the number of attempts
+// made and the last error.
+func (p Policy) Do(ctx context.Context, allowed bool, fn func(ctx context.Context) error) (int, error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			if lastErr != nil {
+				return attempt - 1, lastErr
+			}
+			return attempt - 1, err
+		}
+		lastErr = fn(ctx)
+		if lastErr == nil {
+			return attempt, nil
+		}
+
+		var retryErr *Error
+		if !errors.As(lastErr, &retryErr) {
+			return attempt, lastErr
+		}
+		if !allowed && !retryErr.Safe {
+			return attempt, lastErr
+		}
+		if attempt == maxAttempts {
+			return attempt, lastErr
+		}
+
+		delay := p.backoff(attempt)
+		if retryErr.RetryAfter > delay {
+			if p.MaxBackoff > 0 && retryErr.RetryAfter > p.MaxBackoff {
+				return attempt, lastErr
+			}
+			delay = retryErr.RetryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return attempt, lastErr
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, lastErr
+		case <-timer.C:
+		}
+	}
+	return maxAttempts, lastErr
+}
+
+// backoff returns the jittered exponential delay before the next attempt.
+func (p Policy) backoff(attempt int) time.Duration {
+	if p.BaseBackoff <= 0 {
+		return 0
+	}
+	delay := p.BaseBackoff << (attempt - 1)
+	if delay <= 0 || (p.MaxBackoff > 0 && delay > p.MaxBackoff) {
+		delay = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay -= time.Duration(rand.Float64() * jitter * float64(delay))
+	}
+	return delay
+}
+
+// ParseRetryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,159 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+func TestDoRetriesRetryableErrorsUntilSuccess(t *testing.T) {
+	policy := Policy{MaxAttempts: 3, BaseBackoff: time.Millisecond}
+	calls := 0
+	attempts, err := policy.Do(context.Background(), true, func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return Retryable(errors.New("unavailable"), 0)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if attempts != 3 || calls != 3 {
+		t.Fatalf("expected 3 attempts, got attempts=%d calls=%d", attempts, calls)
+	}
+}
+
+func TestDoStopsOnNonRetryableError(t *testing.T) {
+	policy := Policy{MaxAttempts: 5}
+	calls := 0
+	attempts, err := policy.Do(context.Background(), true, func(context.Context) error {
+		calls++
+		return errors.New("bad request")
+	})
+	if err == nil || attempts != 1 || calls != 1 {
+		t.Fatalf("expected single failed attempt, got attempts=%d calls=%d err=%v", attempts, calls, err)
+	}
+}
+
+func TestDoOnlyRetriesSafeErrorsWhenNotAllowed(t *testing.T) {
+	policy := Policy{MaxAttempts: 3}
+
+	calls := 0
+	_, _ = policy.Do(context.Background(), false, func(context.Context) error {
+		calls++
+		return Retryable(errors.New("unavailable"), 0)
+	})
+	if calls != 1 {
+		t.Fatalf("expected non-idempotent failure not to be retried, got %d calls", calls)
+	}
+
+	calls = 0
+	_, _ = policy.Do(context.Background(), false, func(context.Context) error {
+		calls++
+		return RetryableBeforeSend(errors.New("dial failed"))
+	})
+	if calls != 3 {
+		t.Fatalf("expected failures before send to be retried, got %d calls", calls)
+	}
+}
+
+func TestDoIsBoundedByContextDeadline(t *testing.T) {
+	policy := Policy{MaxAttempts: 10, BaseBackoff: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	attempts, err := policy.Do(ctx, true, func(context.Context) error {
+		return Retryable(errors.New("unavailable"), 0)
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if attempts != 1 {
+		t.Fatalf("expected backoff beyond the deadline to stop retries, got %d attempts", attempts)
+	}
+	if time.Since(started) > 500*time.Millisecond {
+		t.Fatalf("expected retries to stop at the deadline, took %s", time.Since(started))
+	}
+}
+
+func TestDoStopsWhenRetryAfterExceedsMaxBackoff(t *testing.T) {
+	policy := Policy{MaxAttempts: 3, MaxBackoff: 100 * time.Millisecond}
+	calls := 0
+	_, _ = policy.Do(context.Background(), true, func(context.Context) error {
+		calls++
+		return Retryable(errors.New("throttled"), time.Hour)
+	})
+	if calls != 1 {
+		t.Fatalf("expected long Retry-After to stop retries, got %d calls", calls)
+	}
+}
+
+func TestBackoffGrowsExponentiallyAndIsCapped(t *testing.T) {
+	policy := Policy{BaseBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	if got := policy.backoff(1); got != 100*time.Millisecond {
+		t.Fatalf("attempt 1 backoff = %s", got)
+	}
+	if got := policy.backoff(2); got != 200*time.Millisecond {
+		t.Fatalf("attempt 2 backoff = %s", got)
+	}
+	if got := policy.backoff(5); got != 300*time.Millisecond {
+		t.Fatalf("attempt 5 backoff = %s", got)
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		if got := policy.backoff(1); got < 50*time.Millisecond || got > 100*time.Millisecond {
+			t.Fatalf("jittered backoff out of range: %s", got)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	alarm := domain.SetAlarmCommand{DeviceID: "clock-1", AlarmTime: time.Now().Add(time.Hour)}
+	brightness := domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 10}
+
+	if Allowed(context.Background(), alarm) {
+		t.Fatal("expected alarm without idempotency key not to be retried")
+	}
+	if !Allowed(context.Background(), brightness) {
+		t.Fatal("expected brightness to be retried")
+	}
+	if !Allowed(application.WithIdempotencyKey(context.Background(), "key-1"), alarm) {
+		t.Fatal("expected alarm with idempotency key to be retried")
+	}
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	policy := Policy{}
+	for _, code := range []int{429, 502, 503, 504} {
+		if !policy.IsRetryableStatus(code) {
+			t.Fatalf("expected %d to be retryable by default", code)
+		}
+	}
+	if policy.IsRetryableStatus(http.StatusInternalServerError) {
+		t.Fatal("expected 500 not to be retryable by default")
+	}
+	if !(Policy{RetryableStatus: []int{500}}).IsRetryableStatus(500) {
+		t.Fatal("expected custom status list to be honoured")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := ParseRetryAfter("3", now); got != 3*time.Second {
+		t.Fatalf("seconds form = %s", got)
+	}
+	if got := ParseRetryAfter(now.Add(5*time.Second).Format(http.TimeFormat), now); got != 5*time.Second {
+		t.Fatalf("date form = %s", got)
+	}
+	if got := ParseRetryAfter("soon", now); got != 0 {
+		t.Fatalf("invalid form = %s", got)
+	}
+}
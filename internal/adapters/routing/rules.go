@@ -0,0 +1,119 @@
+package routing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Rules is the routing rules file format.
+//
+//	{
+//	  "deviceTags": {"clock-lobby": ["legacy"]},
+//	  "rules": [
+//	    {"name": "legacy", "device": "legacy-*", "senders": ["rest"]},
+//	    {"tag": "legacy", "senders": ["rest"]},
+//	    {"commandType": "set_alarm", "senders": ["mqtt", "rest"], "policy": "all"}
+//	  ],
+//	  "default": ["mqtt"]
+//	}
+//
+// Rules are evaluated in order and the first match wins. When no rule matches,
+// Default is used; an empty Default selects every enabled sender.
+type Rules struct {
+	DeviceTags map[string][]string `json:"deviceTags"`
+	Rules      []Rule              `json:"rules"`
+	Default    []string            `json:"default"`
+}
+
+// Rule selects senders for commands matching every non-empty criterion.
+type Rule struct {
+	Name        string   `json:"name"`
+	Device      string   `json:"device"`
+	Tag         string   `json:"tag"`
+	CommandType string   `json:"commandType"`
+	Senders     []string `json:"senders"`
+	Policy      string   `json:"policy"`
+}
+
+// LoadRules reads and validates a JSON rules file.
+func LoadRules(filename string) (Rules, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return Rules{}, fmt.Errorf("read routing rules: %w", err)
+	}
+	return ParseRules(raw)
+}
+
+// ParseRules decodes and validates JSON routing rules.
+func ParseRules(raw []byte) (Rules, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	var rules Rules
+	if err := decoder.Decode(&rules); err != nil {
+		return Rules{}, fmt.Errorf("decode routing rules: %w", err)
+	}
+	if err := rules.validate(); err != nil {
+		return Rules{}, err
+	}
+	return rules, nil
+}
+
+func (r Rules) validate() error {
+	for idx, rule := range r.Rules {
+		if rule.Device == "" && rule.Tag == "" && rule.CommandType == "" {
+			return fmt.Errorf("routing rule %d needs a device, tag or commandType criterion", idx)
+		}
+		if rule.Device != "" {
+			if _, err := path.Match(rule.Device, ""); err != nil {
+				return fmt.Errorf("routing rule %d has invalid device pattern %q: %w", idx, rule.Device, err)
+			}
+		}
+		if len(rule.Senders) == 0 {
+			return fmt.Errorf("routing rule %d needs at least one sender", idx)
+		}
+	}
+	return nil
+}
+
+// match returns the first rule matching the device and command type.
+func (r Rules) match(deviceID, commandType string) (int, bool) {
+	deviceID = strings.TrimSpace(deviceID)
+	for idx, rule := range r.Rules {
+		if rule.Device != "" {
+			if ok, _ := path.Match(rule.Device, deviceID); !ok {
+				continue
+			}
+		}
+		if rule.Tag != "" && !r.hasTag(deviceID, rule.Tag) {
+			continue
+		}
+		if rule.CommandType != "" && rule.CommandType != commandType {
+			continue
+		}
+		return idx, true
+	}
+	return 0, false
+}
+
+func (r Rules) hasTag(deviceID, tag string) bool {
+	for _, candidate := range r.DeviceTags[deviceID] {
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func ruleName(rule Rule, idx int) string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return fmt.Sprintf("rule-%d", idx)
+}
+
+var errNoSenders = errors.New("no senders selected by routing rules")
@@ -0,0 +1,72 @@
+package routing
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRulesMatchesInOrder(t *testing.T) {
+	rules, err := ParseRules([]byte(`{
+		"deviceTags": {"clock-lobby": ["legacy"]},
+		"rules": [
+			{"name": "legacy-prefix", "device": "legacy-*", "senders": ["rest"]},
+			{"name": "legacy-tag", "tag": "legacy", "senders": ["rest"]},
+			{"name": "alarms", "commandType": "set_alarm", "senders": ["mqtt", "rest"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("parse rules: %v", err)
+	}
+
+	cases := []struct {
+		device, command string
+		want            int
+		matched         bool
+	}{
+		{"legacy-1", "set_alarm", 0, true},
+		{"clock-lobby", "set_brightness", 1, true},
+		{"clock-1", "set_alarm", 2, true},
+		{"clock-1", "set_brightness", 0, false},
+	}
+	for _, tc := range cases {
+		idx, ok := rules.match(tc.device, tc.command)
+		if ok != tc.matched || (ok && idx != tc.want) {
+			t.Fatalf("match(%q, %q) = %d,%v want %d,%v", tc.device, tc.command, idx, ok, tc.want, tc.matched)
+		}
+	}
+}
+
+func TestParseRulesRejectsInvalidRules(t *testing.T) {
+	cases := map[string]string{
+		"no criterion":   `{"rules":[{"senders":["rest"]}]}`,
+		"no senders":     `{"rules":[{"device":"clock-*"}]}`,
+		"bad pattern":    `{"rules":[{"device":"clock-[","senders":["rest"]}]}`,
+		"unknown field":  `{"rules":[],"extra":true}`,
+		"malformed json": `{"rules":`,
+	}
+	for name, raw := range cases {
+		if _, err := ParseRules([]byte(raw)); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+}
+
+func TestLoadRulesReadsFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "routes.json")
+	if err := os.WriteFile(filename, []byte(`{"default":["mqtt"]}`), 0o600); err != nil {
+		t.Fatalf("write rules: %v", err)
+	}
+	rules, err := LoadRules(filename)
+	if err != nil {
+		t.Fatalf("load rules: %v", err)
+	}
+	if len(rules.Default) != 1 || rules.Default[0] != "mqtt" {
+		t.Fatalf("unexpected default: %#v", rules.Default)
+	}
+
+	if _, err := LoadRules(filepath.Join(t.TempDir(), "missing.json")); err == nil || !strings.Contains(err.Error(), "read routing rules") {
+		t.Fatalf("expected read error, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/paul/clock-server/internal/adapters/composite"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+type route struct {
+	name    string
+	senders []string
+	policy  composite.Policy
+	sender  *composite.Sender
+}
+
+// Sender selects sender adapters per command using routing rules and
+// delivers through a composite fan-out built for the selected senders.
+type Sender struct {
+	rules    Rules
+	routes   []route
+	fallback route
+}
+
+// NewSender builds a routing sender over the named targets. Every sender
+// referenced by the rules must be present in targets.
+func NewSender(opts composite.Options, rules Rules, targets ...composite.Target) (*Sender, error) {
+	if err := rules.validate(); err != nil {
+		return nil, err
+	}
+	byName := make(map[string]composite.Target, len(targets))
+	names := make([]string, 0, len(targets))
+	for _, target := range targets {
+		byName[target.Name] = target
+		names = append(names, target.Name)
+	}
+
+	s := &Sender{rules: rules, routes: make([]route, 0, len(rules.Rules))}
+	for idx, rule := range rules.Rules {
+		built, err := buildRoute(ruleName(rule, idx), rule.Senders, rule.Policy, opts, byName)
+		if err != nil {
+			return nil, err
+		}
+		s.routes = append(s.routes, built)
+	}
+
+	defaults := rules.Default
+	if len(defaults) == 0 {
+		defaults = names
+	}
+	fallback, err := buildRoute("default", defaults, "", opts, byName)
+	if err != nil {
+		return nil, err
+	}
+	s.fallback = fallback
+	return s, nil
+}
+
+func buildRoute(name string, senders []string, policy string, opts composite.Options, byName map[string]composite.Target) (route, error) {
+	selected := make([]composite.Target, 0, len(senders))
+	for _, senderName := range senders {
+		target, ok := byName[senderName]
+		if !ok {
+			return route{}, fmt.Errorf("routing %s references sender %q which is not enabled", name, senderName)
+		}
+		selected = append(selected, target)
+	}
+	if policy != "" {
+		opts.Policy = composite.Policy(policy)
+	}
+	sender, err := composite.NewPolicySender(opts, selected...)
+	if err != nil {
+		return route{}, fmt.Errorf("routing %s: %w", name, err)
+	}
+	parsed, _ := composite.ParsePolicy(string(opts.Policy))
+	return route{name: name, senders: senders, policy: parsed, sender: sender}, nil
+}
+
+// Route reports which senders would handle a command for the device.
+func (s *Sender) Route(deviceID, commandType string) application.RouteDecision {
+	selected := s.resolve(deviceID, commandType)
+	return application.RouteDecision{
+		Rule:    selected.name,
+		Senders: append([]string(nil), selected.senders...),
+		Policy:  string(selected.policy),
+	}
+}
+
+// Send routes and delivers the command.
+func (s *Sender) Send(ctx context.Context, cmd domain.ClockCommand) error {
+	_, err := s.SendWithReport(ctx, cmd)
+	return err
+}
+
+// SendWithReport routes and delivers the command, returning per-sender results.
+func (s *Sender) SendWithReport(ctx context.Context, cmd domain.ClockCommand) (application.DeliveryReport, error) {
+	if cmd == nil {
+		return application.DeliveryReport{}, errors.New("command is required")
+	}
+	selected := s.resolve(cmd.TargetDeviceID(), cmd.CommandType())
+	if len(selected.senders) == 0 {
+		return application.DeliveryReport{}, errNoSenders
+	}
+	return selected.sender.SendWithReport(ctx, cmd)
+}
+
+func (s *Sender) resolve(deviceID, commandType string) route {
+	if idx, ok := s.rules.match(deviceID, commandType); ok {
+		return s.routes[idx]
+	}
+	return s.fallback
+}
@@ -0,0 +1,93 @@
+package routing
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paul/clock-server/internal/adapters/composite"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+type mockSender struct {
+	calls int
+}
+
+func (m *mockSender) Send(_ context.Context, _ domain.ClockCommand) error {
+	m.calls++
+	return nil
+}
+
+func newRoutingSender(t *testing.T, raw string) (*Sender, *mockSender, *mockSender) {
+	t.Helper()
+	rules, err := ParseRules([]byte(raw))
+	if err != nil {
+		t.Fatalf("parse rules: %v", err)
+	}
+	mqttSender := &mockSender{}
+	restSender := &mockSender{}
+	sut, err := NewSender(composite.Options{Policy: composite.PolicyAll}, rules,
+		composite.Target{Name: "mqtt", Sender: mqttSender},
+		composite.Target{Name: "rest", Sender: restSender},
+	)
+	if err != nil {
+		t.Fatalf("new routing sender: %v", err)
+	}
+	return sut, mqttSender, restSender
+}
+
+func TestSendRoutesLegacyDevicesToRest(t *testing.T) {
+	sut, mqttSender, restSender := newRoutingSender(t, `{"rules":[{"name":"legacy","device":"legacy-*","senders":["rest"]}]}`)
+
+	report, err := sut.SendWithReport(context.Background(), domain.SetBrightnessCommand{DeviceID: "legacy-7", Level: 10})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if mqttSender.calls != 0 || restSender.calls != 1 {
+		t.Fatalf("expected rest only, got mqtt=%d rest=%d", mqttSender.calls, restSender.calls)
+	}
+	if len(report.Results) != 1 || report.Results[0].Sender != "rest" {
+		t.Fatalf("unexpected report: %#v", report)
+	}
+}
+
+func TestSendUsesAllSendersWhenNoRuleMatches(t *testing.T) {
+	sut, mqttSender, restSender := newRoutingSender(t, `{"rules":[{"device":"legacy-*","senders":["rest"]}]}`)
+
+	cmd := domain.SetAlarmCommand{DeviceID: "clock-1", AlarmTime: time.Now().Add(time.Hour)}
+	if err := sut.Send(context.Background(), cmd); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if mqttSender.calls != 1 || restSender.calls != 1 {
+		t.Fatalf("expected both senders, got mqtt=%d rest=%d", mqttSender.calls, restSender.calls)
+	}
+}
+
+func TestRouteExplainsDecision(t *testing.T) {
+	sut, _, _ := newRoutingSender(t, `{
+		"rules":[{"name":"alarms","commandType":"set_alarm","senders":["mqtt","rest"],"policy":"any"}],
+		"default":["mqtt"]
+	}`)
+
+	decision := sut.Route("clock-1", "set_alarm")
+	if decision.Rule != "alarms" || decision.Policy != "any" || strings.Join(decision.Senders, ",") != "mqtt,rest" {
+		t.Fatalf("unexpected alarm decision: %#v", decision)
+	}
+
+	decision = sut.Route("clock-1", "display_message")
+	if decision.Rule != "default" || decision.Policy != "all" || strings.Join(decision.Senders, ",") != "mqtt" {
+		t.Fatalf("unexpected default decision: %#v", decision)
+	}
+}
+
+func TestNewSenderRejectsUnknownSender(t *testing.T) {
+	rules, err := ParseRules([]byte(`{"rules":[{"device":"clock-*","senders":["amqp"]}]}`))
+	if err != nil {
+		t.Fatalf("parse rules: %v", err)
+	}
+	_, err = NewSender(composite.Options{}, rules, composite.Target{Name: "mqtt", Sender: &mockSender{}})
+	if err == nil || !strings.Contains(err.Error(), "not enabled") {
+		t.Fatalf("expected unknown sender error, got %v", err)
+	}
+}
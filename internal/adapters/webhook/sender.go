@@ -0,0 +1,273 @@
+// Package webhook delivers commands as signed JSON envelopes to generic HTTP
+// endpoints, for integrations that speak neither MQTT nor the clock REST
+// API.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paul/clock-server/internal/adapters/retry"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+// SignatureHeader carries the request timestamp and one HMAC-SHA256
+// signature per active secret: t=<unix seconds>,v1=<hex>[,v1=<hex>...].
+// Each signature covers "<t>.<body>".
+const SignatureHeader = "X-Clock-Signature"
+
+const defaultTimeout = 5 * time.Second
+
+// Endpoint is a webhook receiver. An empty Types list receives every command.
+type Endpoint struct {
+	URL   string
+	Types []string
+}
+
+// accepts reports whether the endpoint subscribes to commandType.
+func (e Endpoint) accepts(commandType string) bool {
+	if len(e.Types) == 0 {
+		return true
+	}
+	for _, t := range e.Types {
+		if t == commandType {
+			return true
+		}
+	}
+	return false
+}
+
+// Config defines webhook adapter settings.
+type Config struct {
+	Endpoints []Endpoint
+	// Secrets sign every request. During rotation list the new secret
+	// alongside the old one; receivers accept any matching signature.
+	Secrets           []string
+	Timeout           time.Duration
+	AllowInsecureHTTP bool
+	// Retry controls retries per endpoint; the zero value makes a single attempt.
+	Retry retry.Policy
+}
+
+// Envelope is the canonical body POSTed to every endpoint.
+type Envelope struct {
+	// ID identifies the command across retries and endpoints.
+	ID             string         `json:"id"`
+	Type           string         `json:"type"`
+	DeviceID       string         `json:"deviceId"`
+	Payload        map[string]any `json:"payload"`
+	Timestamp      time.Time      `json:"timestamp"`
+	IdempotencyKey string         `json:"idempotencyKey,omitempty"`
+}
+
+// ParseEndpoints parses semicolon-separated endpoints, each optionally
+// followed by a comma-separated command-type filter:
+// https://a.example/hook|set_alarm,display_message;https://b.example/hook
+func ParseEndpoints(raw string) ([]Endpoint, error) {
+	var out []Endpoint
+	for _, entry := range strings.Split(raw, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		rawURL, filter, _ := strings.Cut(entry, "|")
+		endpoint := Endpoint{URL: strings.TrimSpace(rawURL)}
+		for _, t := range strings.Split(filter, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				if _, ok := commands.Lookup(t); !ok {
+					return nil, fmt.Errorf("unknown command type %q in %q", t, entry)
+				}
+				endpoint.Types = append(endpoint.Types, t)
+			}
+		}
+		out = append(out, endpoint)
+	}
+	return out, nil
+}
+
+// Sender posts command envelopes to the configured endpoints.
+type Sender struct {
+	client    *http.Client
+	endpoints []Endpoint
+	secrets   [][]byte
+	retry     retry.Policy
+	now       func() time.Time
+}
+
+// NewSender creates a webhook sender.
+func NewSender(cfg Config) (*Sender, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("at least one webhook url is required")
+	}
+	for _, endpoint := range cfg.Endpoints {
+		parsed, err := url.Parse(endpoint.URL)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid webhook url %q", endpoint.URL)
+		}
+		if parsed.Scheme != "https" && !cfg.AllowInsecureHTTP {
+			return nil, errors.New("insecure webhook http is disabled; use https:// or set ALLOW_INSECURE_DOWNSTREAM_HTTP=true")
+		}
+	}
+	secrets := make([][]byte, 0, len(cfg.Secrets))
+	for _, secret := range cfg.Secrets {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			secrets = append(secrets, []byte(secret))
+		}
+	}
+	if len(secrets) == 0 {
+		return nil, errors.New("at least one webhook signing secret is required")
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	return &Sender{
+		client:    &http.Client{Timeout: timeout},
+		endpoints: cfg.Endpoints,
+		secrets:   secrets,
+		retry:     cfg.Retry,
+		now:       time.Now,
+	}, nil
+}
+
+// Send posts the command envelope to every endpoint subscribed to its type,
+// concurrently. It fails if any of them fails. A command no endpoint
+// subscribes to is not sent and counts as delivered; use routing rules to
+// keep such commands away from this sender.
+func (s *Sender) Send(ctx context.Context, cmd domain.ClockCommand) error {
+	if cmd == nil {
+		return errors.New("command is required")
+	}
+	payload, err := commands.Payload(cmd)
+	if err != nil {
+		return err
+	}
+	id, err := newCommandID()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(Envelope{
+		ID:             id,
+		Type:           cmd.CommandType(),
+		DeviceID:       cmd.TargetDeviceID(),
+		Payload:        payload,
+		Timestamp:      s.now().UTC(),
+		IdempotencyKey: application.IdempotencyKeyFromContext(ctx),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal webhook envelope: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	allowed := retry.Allowed(ctx, cmd)
+	for _, endpoint := range s.endpoints {
+		if !endpoint.accepts(cmd.CommandType()) {
+			continue
+		}
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			attempts, err := s.retry.Do(ctx, allowed, func(ctx context.Context) error {
+				return s.attempt(ctx, target, id, body)
+			})
+			if err == nil {
+				return
+			}
+			if attempts > 1 {
+				err = fmt.Errorf("webhook %s failed after %d attempts: %w", target, attempts, err)
+			}
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}(endpoint.URL)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// attempt performs a single signed POST. Transport failures and retryable
+// status codes are marked for the retry policy.
+func (s *Sender) attempt(ctx context.Context, target, id string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Clock-Command-Id", id)
+	req.Header.Set(SignatureHeader, s.sign(s.now(), body))
+	if key := application.IdempotencyKeyFromContext(ctx); key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		err = fmt.Errorf("send webhook %s: %w", target, err)
+		if ctx.Err() != nil {
+			return err
+		}
+		return retry.Retryable(err, 0)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("webhook %s failed: status=%d body=%s", target, resp.StatusCode, strings.TrimSpace(string(message)))
+		if s.retry.IsRetryableStatus(resp.StatusCode) {
+			return retry.Retryable(err, retry.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()))
+		}
+		return err
+	}
+	return nil
+}
+
+// sign builds the signature header value for body sent at now.
+func (s *Sender) sign(now time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	parts := []string{"t=" + timestamp}
+	for _, secret := range s.secrets {
+		parts = append(parts, "v1="+Signature(secret, timestamp, body))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Signature returns the hex HMAC-SHA256 of "<timestamp>.<body>". Receivers
+// recompute it to verify a request.
+func Signature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Check reports readiness. Webhook receivers have no common health
+// endpoint, so the adapter is always ready once configured.
+func (s *Sender) Check(_ context.Context) error {
+	return nil
+}
+
+func newCommandID() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate command id: %w", err)
+	}
+	return "cmd-" + hex.EncodeToString(raw), nil
+}
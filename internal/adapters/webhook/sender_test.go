@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paul/clock-server/internal/adapters/retry"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver records requests and answers with the queued statuses, then 204.
+type receiver struct {
+	mu       sync.Mutex
+	requests []receivedRequest
+	statuses []int
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, *httptest.Server) {
+	t.Helper()
+	rec := &receiver{statuses: statuses}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, receivedRequest{header: r.Header.Clone(), body: body})
+		status := http.StatusNoContent
+		if len(rec.statuses) > 0 {
+			status, rec.statuses = rec.statuses[0], rec.statuses[1:]
+		}
+		rec.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return rec, srv
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedRequest(nil), r.requests...)
+}
+
+func newTestSender(t *testing.T, cfg Config) *Sender {
+	t.Helper()
+	cfg.AllowInsecureHTTP = true
+	if cfg.Secrets == nil {
+		cfg.Secrets = []string{"secret-new", "secret-old"}
+	}
+	s, err := NewSender(cfg)
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	s.now = func() time.Time { return time.Unix(1700000000, 0) }
+	return s
+}
+
+func TestSendPostsSignedEnvelope(t *testing.T) {
+	rec, srv := newReceiver(t)
+	s := newTestSender(t, Config{Endpoints: []Endpoint{{URL: srv.URL + "/hook"}}})
+
+	ctx := application.WithIdempotencyKey(context.Background(), "key-1")
+	if err := s.Send(ctx, domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 30}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	requests := rec.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected one request, got %d", len(requests))
+	}
+	req := requests[0]
+
+	var envelope Envelope
+	if err := json.Unmarshal(req.body, &envelope); err != nil {
+		t.Fatalf("decode envelope: %v", err)
+	}
+	if !strings.HasPrefix(envelope.ID, "cmd-") || envelope.Type != "set_brightness" || envelope.DeviceID != "clock-1" ||
+		envelope.Payload["level"] != float64(30) || envelope.IdempotencyKey != "key-1" || !envelope.Timestamp.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("unexpected envelope: %+v", envelope)
+	}
+	if req.header.Get("X-Clock-Command-Id") != envelope.ID || req.header.Get("Idempotency-Key") != "key-1" {
+		t.Fatalf("unexpected headers: %v", req.header)
+	}
+
+	want := "t=1700000000" +
+		",v1=" + Signature([]byte("secret-new"), "1700000000", req.body) +
+		",v1=" + Signature([]byte("secret-old"), "1700000000", req.body)
+	if got := req.header.Get(SignatureHeader); got != want {
+		t.Fatalf("unexpected signature header:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestSendHonoursEndpointTypeFilters(t *testing.T) {
+	alarms, alarmSrv := newReceiver(t)
+	everything, allSrv := newReceiver(t)
+	s := newTestSender(t, Config{Endpoints: []Endpoint{
+		{URL: alarmSrv.URL, Types: []string{"set_alarm"}},
+		{URL: allSrv.URL},
+	}})
+
+	if err := s.Send(context.Background(), domain.DisplayMessageCommand{DeviceID: "clock-1", Message: "hi", DurationSeconds: 5}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if len(alarms.received()) != 0 || len(everything.received()) != 1 {
+		t.Fatalf("unexpected fan-out: alarms=%d all=%d", len(alarms.received()), len(everything.received()))
+	}
+}
+
+func TestSendSkipsCommandsWithoutSubscribers(t *testing.T) {
+	rec, srv := newReceiver(t)
+	s := newTestSender(t, Config{Endpoints: []Endpoint{{URL: srv.URL, Types: []string{"set_alarm"}}}})
+
+	if err := s.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 1}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(rec.received()) != 0 {
+		t.Fatal("expected no request")
+	}
+}
+
+func TestSendFailsWhenAnyEndpointFails(t *testing.T) {
+	_, okSrv := newReceiver(t)
+	_, badSrv := newReceiver(t, http.StatusBadRequest)
+	s := newTestSender(t, Config{Endpoints: []Endpoint{{URL: okSrv.URL}, {URL: badSrv.URL}}})
+
+	err := s.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 1})
+	if err == nil || !strings.Contains(err.Error(), "status=400") || !strings.Contains(err.Error(), badSrv.URL) {
+		t.Fatalf("expected failure naming the endpoint, got %v", err)
+	}
+}
+
+func TestSendRetriesWithSameCommandID(t *testing.T) {
+	rec, srv := newReceiver(t, http.StatusServiceUnavailable)
+	s := newTestSender(t, Config{
+		Endpoints: []Endpoint{{URL: srv.URL}},
+		Retry:     retry.Policy{MaxAttempts: 2, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond, RetryableStatus: retry.DefaultRetryableStatus},
+	})
+
+	if err := s.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 1}); err != nil {
+		t.Fatalf("expected retry to succeed, got %v", err)
+	}
+	requests := rec.received()
+	if len(requests) != 2 || requests[0].header.Get("X-Clock-Command-Id") != requests[1].header.Get("X-Clock-Command-Id") {
+		t.Fatalf("expected two attempts with one command id, got %d", len(requests))
+	}
+}
+
+func TestNewSenderValidatesConfig(t *testing.T) {
+	cases := map[string]Config{
+		"no endpoints":  {Secrets: []string{"s"}},
+		"no secret":     {Endpoints: []Endpoint{{URL: "https://hooks.local"}}, Secrets: []string{" "}},
+		"insecure http": {Endpoints: []Endpoint{{URL: "http://hooks.local"}}, Secrets: []string{"s"}},
+		"not a url":     {Endpoints: []Endpoint{{URL: "hooks"}}, Secrets: []string{"s"}},
+	}
+	for name, cfg := range cases {
+		if _, err := NewSender(cfg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := NewSender(Config{Endpoints: []Endpoint{{URL: "https://hooks.local"}}, Secrets: []string{"s"}}); err != nil {
+		t.Fatalf("expected valid config, got %v", err)
+	}
+}
+
+func TestParseEndpoints(t *testing.T) {
+	endpoints, err := ParseEndpoints("https://a.local/hook|set_alarm, display_message ; https://b.local/hook")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(endpoints) != 2 || endpoints[0].URL != "https://a.local/hook" || len(endpoints[0].Types) != 2 || endpoints[1].Types != nil {
+		t.Fatalf("unexpected endpoints: %+v", endpoints)
+	}
+	if _, err := ParseEndpoints("https://a.local/hook|reboot"); err == nil {
+		t.Fatal("expected unknown command type to be rejected")
+	}
+}
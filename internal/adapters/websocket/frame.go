@@ -0,0 +1,166 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// acceptGUID is the RFC 6455 constant mixed into Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxMessageSize bounds a single (possibly fragmented) device message.
+const maxMessageSize = 64 * 1024
+
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xA
+)
+
+// Close status codes used by the server.
+const (
+	closeNormal          = 1000
+	closeGoingAway       = 1001
+	closeProtocolError   = 1002
+	closeUnsupportedData = 1003
+	closeMessageTooBig   = 1009
+)
+
+var errMessageTooBig = errors.New("websocket message too big")
+
+// frame is a decoded frame with its payload unmasked.
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// upgrade validates the opening handshake and takes over the connection.
+// Only the handshake is negotiated: no subprotocols or extensions.
+func upgrade(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.Reader, error) {
+	if r.Method != http.MethodGet {
+		return nil, nil, errors.New("websocket handshake requires GET")
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		return nil, nil, errors.New("missing websocket upgrade headers")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, nil, errors.New("unsupported websocket version")
+	}
+	key := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, nil, errors.New("invalid Sec-WebSocket-Key")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, nil, fmt.Errorf("hijack connection: %w", err)
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("%w: %v", errConnectionLost, err)
+	}
+	return conn, rw.Reader, nil
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readFrame reads one client frame. Client frames must be masked.
+func readFrame(r io.Reader) (frame, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return frame{}, err
+	}
+	f := frame{fin: header[0]&0x80 != 0, opcode: header[0] & 0x0F}
+	if header[0]&0x70 != 0 {
+		return frame{}, errors.New("reserved bits set without a negotiated extension")
+	}
+	if header[1]&0x80 == 0 {
+		return frame{}, errors.New("client frame is not masked")
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return frame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return frame{}, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if f.opcode >= opClose && (length > 125 || !f.fin) {
+		return frame{}, errors.New("invalid control frame")
+	}
+	if length > maxMessageSize {
+		return frame{}, errMessageTooBig
+	}
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(r, mask); err != nil {
+		return frame{}, err
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return frame{}, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+// encodeFrame builds an unmasked, unfragmented server frame.
+func encodeFrame(opcode byte, payload []byte) []byte {
+	out := make([]byte, 0, len(payload)+10)
+	out = append(out, 0x80|opcode)
+	switch n := len(payload); {
+	case n < 126:
+		out = append(out, byte(n))
+	case n <= 0xFFFF:
+		out = append(out, 126, 0, 0)
+		binary.BigEndian.PutUint16(out[len(out)-2:], uint16(n))
+	default:
+		out = append(out, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(out[len(out)-8:], uint64(n))
+	}
+	return append(out, payload...)
+}
+
+func closePayload(code int, reason string) []byte {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return append(payload, reason...)
+}
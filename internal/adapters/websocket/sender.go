@@ -0,0 +1,484 @@
+// Package websocket pushes commands to clocks that hold an outbound WebSocket
+// to the server, for devices that cannot reach the MQTT broker. Each device
+// authenticates with its own token; the sender keeps one connection per
+// device and waits for the device to acknowledge every command.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+const (
+	defaultAckTimeout   = 5 * time.Second
+	defaultPingInterval = 30 * time.Second
+	writeTimeout        = 5 * time.Second
+)
+
+// Ack statuses sent by devices.
+const (
+	AckOK    = "ok"
+	AckError = "error"
+)
+
+// ErrNotConnected is returned when the target device has no open connection.
+var ErrNotConnected = errors.New("device is not connected")
+
+// errConnectionLost reports a failure after the connection was hijacked,
+// when no HTTP response can be written any more.
+var errConnectionLost = errors.New("websocket connection lost during handshake")
+
+// DeviceToken authorizes one device to open a push connection.
+type DeviceToken struct {
+	DeviceID string
+	Token    string
+}
+
+// Config defines WebSocket adapter settings.
+type Config struct {
+	DeviceTokens []DeviceToken
+	// AckTimeout bounds the wait for a device acknowledgement.
+	AckTimeout time.Duration
+	// PingInterval is how often idle connections are pinged. A connection
+	// silent for two intervals is closed.
+	PingInterval time.Duration
+}
+
+// ParseDeviceTokens parses semicolon-separated device tokens in the format:
+// deviceId|token;deviceId2|token2
+func ParseDeviceTokens(raw string) ([]DeviceToken, error) {
+	var out []DeviceToken
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(raw, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, "|")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid device token entry %q", entry)
+		}
+		deviceID := strings.TrimSpace(parts[0])
+		token := strings.TrimSpace(parts[1])
+		if deviceID == "" || token == "" {
+			return nil, fmt.Errorf("device id and token are required in %q", entry)
+		}
+		if seen[deviceID] {
+			return nil, fmt.Errorf("duplicate device token for %q", deviceID)
+		}
+		seen[deviceID] = true
+		out = append(out, DeviceToken{DeviceID: deviceID, Token: token})
+	}
+	return out, nil
+}
+
+// Sender pushes commands over the connections registered by ServeDevice.
+type Sender struct {
+	cfg    Config
+	nextID atomic.Uint64
+	events application.EventPublisher
+
+	mu      sync.Mutex
+	devices map[string]*deviceConn
+	closed  bool
+}
+
+// NewSender creates a sender with an empty connection registry.
+func NewSender(cfg Config) (*Sender, error) {
+	if len(cfg.DeviceTokens) == 0 {
+		return nil, errors.New("websocket device tokens are required")
+	}
+	if cfg.AckTimeout <= 0 {
+		cfg.AckTimeout = defaultAckTimeout
+	}
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = defaultPingInterval
+	}
+	return &Sender{cfg: cfg, devices: make(map[string]*deviceConn)}, nil
+}
+
+// WithEvents publishes an EventPresence event when a device connects or
+// disconnects and an EventDeviceAck event for every ack a device sends.
+// It must be called before devices connect.
+func (s *Sender) WithEvents(events application.EventPublisher) *Sender {
+	s.events = events
+	return s
+}
+
+// AuthenticateDevice returns the device that token belongs to.
+func (s *Sender) AuthenticateDevice(token string) (string, bool) {
+	deviceID := ""
+	for _, candidate := range s.cfg.DeviceTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate.Token)) == 1 {
+			deviceID = candidate.DeviceID
+		}
+	}
+	return deviceID, deviceID != ""
+}
+
+// ServeDevice upgrades the request to a WebSocket and holds the connection
+// of an authenticated device until it closes. A newer connection replaces
+// an older one for the same device. An error means the handshake was
+// rejected and nothing has been written to w.
+func (s *Sender) ServeDevice(w http.ResponseWriter, r *http.Request, deviceID string) error {
+	conn, reader, err := upgrade(w, r)
+	if errors.Is(err, errConnectionLost) {
+		log.Printf("websocket device=%s: %v", deviceID, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// The server read and write timeouts do not apply to the push channel.
+	_ = conn.SetDeadline(time.Time{})
+
+	dc := &deviceConn{
+		deviceID: deviceID,
+		conn:     conn,
+		pending:  make(map[string]pendingCommand),
+		done:     make(chan struct{}),
+	}
+	if !s.register(dc) {
+		dc.close(closeGoingAway, "server shutting down")
+		return nil
+	}
+	defer s.unregister(dc)
+
+	go s.keepAlive(dc)
+	s.readLoop(dc, reader)
+	return nil
+}
+
+// Send pushes cmd to its device and waits for the device's ack. Each device
+// has its own connection, so every failure is marked as an
+// application.DeviceError: one offline clock must not open the circuit for
+// the others.
+func (s *Sender) Send(ctx context.Context, cmd domain.ClockCommand) error {
+	return application.DeviceError(s.push(ctx, cmd))
+}
+
+func (s *Sender) push(ctx context.Context, cmd domain.ClockCommand) error {
+	if cmd == nil {
+		return errors.New("command is required")
+	}
+	payload, err := commands.DevicePayload(cmd)
+	if err != nil {
+		return err
+	}
+	if key := application.IdempotencyKeyFromContext(ctx); key != "" {
+		payload["idempotencyKey"] = key
+	}
+	id := strconv.FormatUint(s.nextID.Add(1), 10)
+	payload["id"] = id
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal websocket payload: %w", err)
+	}
+
+	deviceID := cmd.TargetDeviceID()
+	dc := s.lookup(deviceID)
+	if dc == nil {
+		return fmt.Errorf("websocket device %s: %w", deviceID, ErrNotConnected)
+	}
+	acks := dc.expect(id, cmd.CommandType())
+	defer dc.forget(id)
+	if err := dc.write(opText, body); err != nil {
+		dc.close(closeGoingAway, "")
+		return fmt.Errorf("websocket push to %s: %w", deviceID, err)
+	}
+
+	timer := time.NewTimer(s.cfg.AckTimeout)
+	defer timer.Stop()
+	select {
+	case a := <-acks:
+		if a.Status != AckOK {
+			return fmt.Errorf("device %s rejected command %s: %s", deviceID, id, a.Error)
+		}
+		return nil
+	case <-dc.done:
+		return fmt.Errorf("websocket connection to %s closed before ack", deviceID)
+	case <-timer.C:
+		return fmt.Errorf("no ack from device %s within %s", deviceID, s.cfg.AckTimeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Check verifies adapter readiness. Having no devices connected is not an
+// error: clocks connect on their own schedule.
+func (s *Sender) Check(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("websocket sender closed")
+	}
+	return nil
+}
+
+// ReadinessDetails reports how many devices are connected.
+func (s *Sender) ReadinessDetails() map[string]any {
+	return map[string]any{"websocket": map[string]any{"connectedDevices": s.ConnectedDevices()}}
+}
+
+// ConnectedDevices returns the number of open device connections.
+func (s *Sender) ConnectedDevices() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.devices)
+}
+
+// Close disconnects every device and rejects new connections.
+func (s *Sender) Close() {
+	s.mu.Lock()
+	s.closed = true
+	conns := make([]*deviceConn, 0, len(s.devices))
+	for _, dc := range s.devices {
+		conns = append(conns, dc)
+	}
+	s.devices = map[string]*deviceConn{}
+	s.mu.Unlock()
+	for _, dc := range conns {
+		dc.close(closeGoingAway, "server shutting down")
+		s.publishPresence(dc.deviceID, false)
+	}
+}
+
+func (s *Sender) register(dc *deviceConn) bool {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return false
+	}
+	previous := s.devices[dc.deviceID]
+	s.devices[dc.deviceID] = dc
+	s.mu.Unlock()
+	if previous != nil {
+		previous.close(closeNormal, "replaced by a new connection")
+	}
+	s.publishPresence(dc.deviceID, true)
+	return true
+}
+
+// unregister removes dc unless a newer connection replaced it; the device
+// only goes offline when its current connection ends.
+func (s *Sender) unregister(dc *deviceConn) {
+	s.mu.Lock()
+	current := s.devices[dc.deviceID] == dc
+	if current {
+		delete(s.devices, dc.deviceID)
+	}
+	s.mu.Unlock()
+	dc.close(closeNormal, "")
+	if current {
+		s.publishPresence(dc.deviceID, false)
+	}
+}
+
+func (s *Sender) publishPresence(deviceID string, online bool) {
+	if s.events == nil {
+		return
+	}
+	status := "offline"
+	if online {
+		status = "online"
+	}
+	s.events.Publish(application.Event{
+		Type:     application.EventPresence,
+		DeviceID: deviceID,
+		Data:     map[string]any{"status": status, "channel": "websocket"},
+	})
+}
+
+// publishAck reports an ack; late is set when no send was waiting for it
+// any more, usually because the ack timeout had passed.
+func (s *Sender) publishAck(deviceID string, a ack, commandType string, late bool) {
+	if s.events == nil {
+		return
+	}
+	data := map[string]any{"commandId": a.ID, "status": a.Status, "late": late}
+	if commandType != "" {
+		data["commandType"] = commandType
+	}
+	if a.Error != "" {
+		data["error"] = a.Error
+	}
+	s.events.Publish(application.Event{
+		Type:     application.EventDeviceAck,
+		DeviceID: deviceID,
+		Data:     data,
+	})
+}
+
+func (s *Sender) lookup(deviceID string) *deviceConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.devices[deviceID]
+}
+
+// readLoop handles device frames until the connection fails or closes.
+func (s *Sender) readLoop(dc *deviceConn, reader *bufio.Reader) {
+	var message []byte
+	var messageOp byte
+	for {
+		_ = dc.conn.SetReadDeadline(time.Now().Add(2 * s.cfg.PingInterval))
+		f, err := readFrame(reader)
+		if err != nil {
+			code := closeProtocolError
+			if errors.Is(err, errMessageTooBig) {
+				code = closeMessageTooBig
+			}
+			dc.close(code, "")
+			return
+		}
+		switch f.opcode {
+		case opPing:
+			_ = dc.write(opPong, f.payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			dc.close(closeNormal, "")
+			return
+		case opText, opBinary:
+			if message != nil {
+				dc.close(closeProtocolError, "expected continuation frame")
+				return
+			}
+			messageOp, message = f.opcode, f.payload
+		case opContinuation:
+			if message == nil {
+				dc.close(closeProtocolError, "unexpected continuation frame")
+				return
+			}
+			if len(message)+len(f.payload) > maxMessageSize {
+				dc.close(closeMessageTooBig, "")
+				return
+			}
+			message = append(message, f.payload...)
+		default:
+			dc.close(closeProtocolError, "unknown opcode")
+			return
+		}
+		if !f.fin {
+			continue
+		}
+		if messageOp != opText {
+			dc.close(closeUnsupportedData, "text frames only")
+			return
+		}
+		if a, commandType, ok := dc.handle(message); ok {
+			s.publishAck(dc.deviceID, a, commandType, commandType == "")
+		}
+		message = nil
+	}
+}
+
+func (s *Sender) keepAlive(dc *deviceConn) {
+	ticker := time.NewTicker(s.cfg.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-dc.done:
+			return
+		case <-ticker.C:
+			if err := dc.write(opPing, nil); err != nil {
+				dc.close(closeGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+// ack is the frame a device sends after handling a command:
+// {"type":"ack","id":"17","status":"ok"} or with "status":"error" and "error".
+type ack struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// deviceConn is one device connection and its commands awaiting an ack.
+type deviceConn struct {
+	deviceID string
+	conn     net.Conn
+	writeMu  sync.Mutex
+
+	mu        sync.Mutex
+	pending   map[string]pendingCommand
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *deviceConn) write(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(encodeFrame(opcode, payload))
+	return err
+}
+
+func (c *deviceConn) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		_ = c.write(opClose, closePayload(code, reason))
+		_ = c.conn.Close()
+		close(c.done)
+	})
+}
+
+// pendingCommand is a pushed command waiting for its ack.
+type pendingCommand struct {
+	acks        chan ack
+	commandType string
+}
+
+func (c *deviceConn) expect(id, commandType string) <-chan ack {
+	ch := make(chan ack, 1)
+	c.mu.Lock()
+	c.pending[id] = pendingCommand{acks: ch, commandType: commandType}
+	c.mu.Unlock()
+	return ch
+}
+
+func (c *deviceConn) forget(id string) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// handle routes a device message and returns it when it is an ack, with
+// the type of the command it acknowledges if a send is still waiting for it.
+// Only acks are understood; anything else is ignored so devices can add
+// message types ahead of the server.
+func (c *deviceConn) handle(message []byte) (ack, string, bool) {
+	var a ack
+	if err := json.Unmarshal(message, &a); err != nil || a.Type != "ack" {
+		return ack{}, "", false
+	}
+	c.mu.Lock()
+	cmd, ok := c.pending[a.ID]
+	delete(c.pending, a.ID)
+	c.mu.Unlock()
+	if ok {
+		cmd.acks <- a
+	}
+	return a, cmd.commandType, true
+}
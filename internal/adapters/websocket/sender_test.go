@@ -0,0 +1,348 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+// testDevice is a minimal WebSocket client speaking for one clock.
+type testDevice struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newTestServer(t *testing.T, cfg Config) (*Sender, *httptest.Server) {
+	t.Helper()
+	if cfg.DeviceTokens == nil {
+		cfg.DeviceTokens = []DeviceToken{{DeviceID: "clock-1", Token: "token-1"}, {DeviceID: "clock-2", Token: "token-2"}}
+	}
+	sender, err := NewSender(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceID, ok := sender.AuthenticateDevice(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := sender.ServeDevice(w, r, deviceID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(func() {
+		sender.Close()
+		srv.Close()
+	})
+	return sender, srv
+}
+
+func connectDevice(t *testing.T, srv *httptest.Server, token string) *testDevice {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	request := "GET /devices/connect HTTP/1.1\r\nHost: clock\r\n" +
+		"Authorization: Bearer " + token + "\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %q", got)
+	}
+	return &testDevice{t: t, conn: conn, reader: reader}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not reached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// read returns the next server frame, which is never masked.
+func (d *testDevice) read() (byte, []byte) {
+	d.t.Helper()
+	_ = d.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(d.reader, header); err != nil {
+		d.t.Fatalf("read frame: %v", err)
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		ext := make([]byte, 2)
+		_, _ = io.ReadFull(d.reader, ext)
+		length = int(binary.BigEndian.Uint16(ext))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(d.reader, payload); err != nil {
+		d.t.Fatalf("read payload: %v", err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+func (d *testDevice) readCommand() map[string]any {
+	d.t.Helper()
+	opcode, payload := d.read()
+	if opcode != opText {
+		d.t.Fatalf("expected text frame, got opcode %d", opcode)
+	}
+	var msg map[string]any
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		d.t.Fatalf("invalid command %q: %v", payload, err)
+	}
+	return msg
+}
+
+func (d *testDevice) write(opcode byte, payload []byte) {
+	d.t.Helper()
+	mask := []byte{1, 2, 3, 4}
+	var buf bytes.Buffer
+	buf.WriteByte(0x80 | opcode)
+	buf.WriteByte(0x80 | byte(len(payload)))
+	buf.Write(mask)
+	for i, b := range payload {
+		buf.WriteByte(b ^ mask[i%4])
+	}
+	if _, err := d.conn.Write(buf.Bytes()); err != nil {
+		d.t.Fatalf("write frame: %v", err)
+	}
+}
+
+func (d *testDevice) ack(id any, status, reason string) {
+	d.t.Helper()
+	body, _ := json.Marshal(map[string]any{"type": "ack", "id": id, "status": status, "error": reason})
+	d.write(opText, body)
+}
+
+func sendAsync(sender *Sender, ctx context.Context, cmd domain.ClockCommand) <-chan error {
+	result := make(chan error, 1)
+	go func() { result <- sender.Send(ctx, cmd) }()
+	return result
+}
+
+func TestSenderPushesCommandAndWaitsForAck(t *testing.T) {
+	sender, srv := newTestServer(t, Config{})
+	device := connectDevice(t, srv, "token-1")
+	waitFor(t, func() bool { return sender.ConnectedDevices() == 1 })
+
+	ctx := application.WithIdempotencyKey(context.Background(), "key-1")
+	result := sendAsync(sender, ctx, domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 40})
+
+	msg := device.readCommand()
+	if msg["type"] != "set_brightness" || msg["deviceId"] != "clock-1" || msg["level"] != float64(40) || msg["idempotencyKey"] != "key-1" {
+		t.Fatalf("unexpected command payload: %v", msg)
+	}
+	device.ack(msg["id"], AckOK, "")
+	if err := <-result; err != nil {
+		t.Fatalf("expected acknowledged send, got %v", err)
+	}
+}
+
+func TestSenderReportsRejectedCommand(t *testing.T) {
+	sender, srv := newTestServer(t, Config{})
+	device := connectDevice(t, srv, "token-1")
+	waitFor(t, func() bool { return sender.ConnectedDevices() == 1 })
+
+	result := sendAsync(sender, context.Background(), domain.DisplayMessageCommand{DeviceID: "clock-1", Message: "hi", DurationSeconds: 5})
+	msg := device.readCommand()
+	device.ack(msg["id"], AckError, "display busy")
+	if err := <-result; err == nil || !strings.Contains(err.Error(), "display busy") {
+		t.Fatalf("expected rejection, got %v", err)
+	}
+}
+
+func TestSenderTimesOutWithoutAck(t *testing.T) {
+	sender, srv := newTestServer(t, Config{AckTimeout: 50 * time.Millisecond})
+	device := connectDevice(t, srv, "token-1")
+	waitFor(t, func() bool { return sender.ConnectedDevices() == 1 })
+
+	result := sendAsync(sender, context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 1})
+	device.readCommand()
+	if err := <-result; err == nil || !strings.Contains(err.Error(), "no ack") {
+		t.Fatalf("expected ack timeout, got %v", err)
+	}
+}
+
+func TestSenderRejectsDisconnectedDevice(t *testing.T) {
+	sender, _ := newTestServer(t, Config{})
+	err := sender.Send(context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-2", Level: 1})
+	if !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("expected ErrNotConnected, got %v", err)
+	}
+}
+
+func TestSenderReplacesConnectionAndReportsCount(t *testing.T) {
+	sender, srv := newTestServer(t, Config{})
+	first := connectDevice(t, srv, "token-1")
+	waitFor(t, func() bool { return sender.ConnectedDevices() == 1 })
+	connectDevice(t, srv, "token-2")
+	waitFor(t, func() bool { return sender.ConnectedDevices() == 2 })
+
+	connectDevice(t, srv, "token-1")
+	if opcode, _ := first.read(); opcode != opClose {
+		t.Fatalf("expected replaced connection to be closed, got opcode %d", opcode)
+	}
+	details := sender.ReadinessDetails()["websocket"].(map[string]any)
+	if details["connectedDevices"] != 2 {
+		t.Fatalf("unexpected readiness details: %v", details)
+	}
+	if err := sender.Check(context.Background()); err != nil {
+		t.Fatalf("expected ready, got %v", err)
+	}
+}
+
+func TestSenderAnswersPingAndUnregistersOnClose(t *testing.T) {
+	sender, srv := newTestServer(t, Config{})
+	device := connectDevice(t, srv, "token-1")
+	waitFor(t, func() bool { return sender.ConnectedDevices() == 1 })
+
+	device.write(opPing, []byte("hello"))
+	if opcode, payload := device.read(); opcode != opPong || string(payload) != "hello" {
+		t.Fatalf("expected pong, got %d %q", opcode, payload)
+	}
+	device.write(opClose, closePayload(closeNormal, ""))
+	waitFor(t, func() bool { return sender.ConnectedDevices() == 0 })
+}
+
+// recordingPublisher collects published events.
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []application.Event
+}
+
+func (p *recordingPublisher) Publish(event application.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+}
+
+func (p *recordingPublisher) snapshot() []application.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]application.Event(nil), p.events...)
+}
+
+func TestSenderPublishesPresenceAndAcks(t *testing.T) {
+	sender, srv := newTestServer(t, Config{AckTimeout: 50 * time.Millisecond})
+	published := &recordingPublisher{}
+	sender.WithEvents(published)
+
+	first := connectDevice(t, srv, "token-1")
+	waitFor(t, func() bool { return sender.ConnectedDevices() == 1 })
+	device := connectDevice(t, srv, "token-1")
+	if opcode, _ := first.read(); opcode != opClose {
+		t.Fatalf("expected replaced connection to be closed, got opcode %d", opcode)
+	}
+
+	result := sendAsync(sender, context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 40})
+	msg := device.readCommand()
+	device.ack(msg["id"], AckError, "dimmer stuck")
+	if err := <-result; err == nil {
+		t.Fatal("expected the rejected command to fail")
+	}
+	result = sendAsync(sender, context.Background(), domain.SetBrightnessCommand{DeviceID: "clock-1", Level: 50})
+	late := device.readCommand()
+	<-result
+	device.ack(late["id"], AckOK, "")
+	device.write(opClose, closePayload(closeNormal, ""))
+	waitFor(t, func() bool { return len(published.snapshot()) == 5 })
+
+	got := published.snapshot()
+	want := []struct{ typ, key, value string }{
+		{application.EventPresence, "status", "online"},
+		{application.EventPresence, "status", "online"},
+		{application.EventDeviceAck, "commandType", "set_brightness"},
+		{application.EventDeviceAck, "status", AckOK},
+		{application.EventPresence, "status", "offline"},
+	}
+	for i, w := range want {
+		if got[i].Type != w.typ || got[i].DeviceID != "clock-1" || got[i].Data[w.key] != w.value {
+			t.Fatalf("event %d: expected %s with %s=%s, got %+v", i, w.typ, w.key, w.value, got[i])
+		}
+	}
+	if got[2].Data["error"] != "dimmer stuck" || got[2].Data["late"] != false || got[3].Data["late"] != true {
+		t.Fatalf("unexpected ack events %+v %+v", got[2], got[3])
+	}
+}
+
+func TestServeDeviceRejectsPlainRequests(t *testing.T) {
+	sender, srv := newTestServer(t, Config{})
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Authorization", "Bearer token-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || sender.ConnectedDevices() != 0 {
+		t.Fatalf("expected rejected handshake, got %d", resp.StatusCode)
+	}
+}
+
+func TestAuthenticateDevice(t *testing.T) {
+	sender, _ := newTestServer(t, Config{})
+	if id, ok := sender.AuthenticateDevice("token-2"); !ok || id != "clock-2" {
+		t.Fatalf("expected clock-2, got %q %v", id, ok)
+	}
+	if _, ok := sender.AuthenticateDevice("nope"); ok {
+		t.Fatal("unknown token must not authenticate")
+	}
+}
+
+func TestParseDeviceTokens(t *testing.T) {
+	tokens, err := ParseDeviceTokens(" clock-1|abc ; clock-2|def ;")
+	if err != nil || len(tokens) != 2 || tokens[1] != (DeviceToken{DeviceID: "clock-2", Token: "def"}) {
+		t.Fatalf("unexpected tokens %v %v", tokens, err)
+	}
+	for _, raw := range []string{"clock-1", "clock-1|", "clock-1|a;clock-1|b"} {
+		if _, err := ParseDeviceTokens(raw); err == nil {
+			t.Fatalf("expected error for %q", raw)
+		}
+	}
+}
+
+func TestNewSenderRequiresTokens(t *testing.T) {
+	if _, err := NewSender(Config{}); err == nil {
+		t.Fatal("expected error without device tokens")
+	}
+}
+
+func TestReadFrameRejectsUnmaskedFrames(t *testing.T) {
+	if _, err := readFrame(bytes.NewReader(encodeFrame(opText, []byte("x")))); err == nil {
+		t.Fatal("expected unmasked client frame to be rejected")
+	}
+}
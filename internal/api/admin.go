@@ -0,0 +1,377 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/paul/clock-server/internal/domain"
+	"github.com/paul/clock-server/internal/security"
+)
+
+// Credential sources reported by the admin API.
+const (
+	credentialSourceConfig = "config"
+	credentialSourceStore  = "store"
+	credentialSourceAdmin  = "admin"
+)
+
+// WithAdminCredential accepts cred, the dedicated admin credential, in
+// addition to the static credentials.
+func (h *Handler) WithAdminCredential(cred security.Credential) *Handler {
+	h.adminCredential = security.NewCredentialIndex([]security.Credential{cred}, h.tokenPepper)
+	return h
+}
+
+// WithCredentialStore enables issuing, updating and revoking credentials
+// through the admin API; tokens in store authenticate like static ones.
+func (h *Handler) WithCredentialStore(store *security.CredentialStore) *Handler {
+	h.store = store
+	return h
+}
+
+// adminRoutes are administrative endpoints, relative to the version prefix.
+// Unlike apiRoutes they have no unversioned alias.
+func (h *Handler) adminRoutes() []route {
+	return []route{
+		{pattern: "/admin/credentials", methods: []string{http.MethodGet, http.MethodPost}, handler: h.handleCredentials},
+		{pattern: "/admin/credentials/", path: "/admin/credentials/{id}", methods: []string{http.MethodPatch, http.MethodDelete}, handler: h.handleCredential},
+		{pattern: "/admin/audit", methods: []string{http.MethodGet}, handler: h.handleAuditEntries},
+	}
+}
+
+type credentialResponse struct {
+	ID          string     `json:"id"`
+	Source      string     `json:"source"`
+	Devices     []string   `json:"devices"`
+	Roles       []string   `json:"roles"`
+	Description string     `json:"description,omitempty"`
+	Owner       string     `json:"owner,omitempty"`
+	NotBefore   *time.Time `json:"notBefore,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	Disabled    bool       `json:"disabled"`
+	Status      string     `json:"status"`
+	Hashed      bool       `json:"hashed"`
+}
+
+type credentialListResponse struct {
+	Credentials []credentialResponse `json:"credentials"`
+}
+
+// issuedCredentialResponse carries the generated token, which is only ever
+// returned by the request that created it.
+type issuedCredentialResponse struct {
+	credentialResponse
+	Token string `json:"token"`
+}
+
+type createCredentialRequest struct {
+	ID          string     `json:"id"`
+	Devices     []string   `json:"devices"`
+	Roles       []string   `json:"roles"`
+	Description string     `json:"description"`
+	Owner       string     `json:"owner"`
+	NotBefore   *time.Time `json:"notBefore"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+}
+
+// updateCredentialRequest changes the fields that are present.
+type updateCredentialRequest struct {
+	Devices     *[]string  `json:"devices"`
+	Roles       *[]string  `json:"roles"`
+	Description *string    `json:"description"`
+	Owner       *string    `json:"owner"`
+	NotBefore   *time.Time `json:"notBefore"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	Disabled    *bool      `json:"disabled"`
+}
+
+// handleCredentials serves GET (list) and POST (issue) on /admin/credentials.
+func (h *Handler) handleCredentials(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.handleListCredentials(w, r)
+	case http.MethodPost:
+		h.handleCreateCredential(w, r)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// handleCredential serves PATCH (update) and DELETE (revoke) on
+// /admin/credentials/{id}.
+func (h *Handler) handleCredential(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, requestAPIVersion(r.Context()).prefix+"/admin/credentials/")
+	switch r.Method {
+	case http.MethodPatch:
+		h.handleUpdateCredential(w, r, id)
+	case http.MethodDelete:
+		h.handleRevokeCredential(w, r, id)
+	default:
+		methodNotAllowed(w)
+	}
+}
+
+// handleListCredentials lists the static, stored and admin credentials and
+// their metadata. Tokens and token hashes are never included.
+func (h *Handler) handleListCredentials(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorizeAdmin(r.Context()); err != nil {
+		writeError(w, http.StatusForbidden, codeForbidden, err.Error())
+		return
+	}
+	now := h.now()
+	out := credentialListResponse{Credentials: []credentialResponse{}}
+	add := func(source string, creds []security.Credential) {
+		for _, cred := range creds {
+			out.Credentials = append(out.Credentials, describeCredential(cred, source, now))
+		}
+	}
+	add(credentialSourceConfig, h.credentials.Load().Credentials())
+	if h.store != nil {
+		add(credentialSourceStore, h.store.Credentials())
+	}
+	add(credentialSourceAdmin, h.adminCredential.Credentials())
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleCreateCredential issues a credential with a generated token.
+func (h *Handler) handleCreateCredential(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeCredentialChange(w, r, "credential_create", "-") {
+		return
+	}
+	var req createCredentialRequest
+	if err := h.decodeJSON(w, r, &req); err != nil {
+		writeAppError(w, err)
+		return
+	}
+	cred := security.Credential{
+		ID:          strings.TrimSpace(req.ID),
+		Devices:     trimmedList(req.Devices),
+		Roles:       trimmedList(req.Roles),
+		Description: req.Description,
+		Owner:       req.Owner,
+	}
+	if req.NotBefore != nil {
+		cred.NotBefore = *req.NotBefore
+	}
+	if req.ExpiresAt != nil {
+		cred.ExpiresAt = *req.ExpiresAt
+	}
+	if h.isConfiguredCredential(cred.ID) {
+		h.adminAudit(r, "credential_create", cred.ID, "conflict")
+		writeError(w, http.StatusConflict, codeCredentialExists, fmt.Sprintf("credential %q is defined in the server configuration", cred.ID))
+		return
+	}
+	if err := h.checkIssuedRoles(cred.Roles); err != nil {
+		h.adminAudit(r, "credential_create", cred.ID, "invalid")
+		writeAppError(w, domain.ValidationError{Field: "roles", Message: err.Error()})
+		return
+	}
+	created, token, err := h.store.Create(cred)
+	if err != nil {
+		h.writeStoreError(w, r, "credential_create", cred.ID, err)
+		return
+	}
+	h.adminAudit(r, "credential_create", created.ID, "created")
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, issuedCredentialResponse{
+		credentialResponse: describeCredential(created, credentialSourceStore, h.now()),
+		Token:              token,
+	})
+}
+
+// handleUpdateCredential changes the scopes, roles, metadata or validity of
+// a stored credential. Its token stays the same.
+func (h *Handler) handleUpdateCredential(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.authorizeCredentialChange(w, r, "credential_update", id) {
+		return
+	}
+	if h.isConfiguredCredential(id) {
+		h.adminAudit(r, "credential_update", id, "read_only")
+		writeError(w, http.StatusConflict, codeCredentialReadOnly, fmt.Sprintf("credential %q is defined in the server configuration", id))
+		return
+	}
+	var req updateCredentialRequest
+	if err := h.decodeJSON(w, r, &req); err != nil {
+		writeAppError(w, err)
+		return
+	}
+	if req.Roles != nil {
+		if err := h.checkIssuedRoles(trimmedList(*req.Roles)); err != nil {
+			h.adminAudit(r, "credential_update", id, "invalid")
+			writeAppError(w, domain.ValidationError{Field: "roles", Message: err.Error()})
+			return
+		}
+	}
+	updated, err := h.store.Update(id, func(cred *security.Credential) {
+		if req.Devices != nil {
+			cred.Devices = trimmedList(*req.Devices)
+		}
+		if req.Roles != nil {
+			cred.Roles = trimmedList(*req.Roles)
+		}
+		if req.Description != nil {
+			cred.Description = *req.Description
+		}
+		if req.Owner != nil {
+			cred.Owner = *req.Owner
+		}
+		if req.NotBefore != nil {
+			cred.NotBefore = *req.NotBefore
+		}
+		if req.ExpiresAt != nil {
+			cred.ExpiresAt = *req.ExpiresAt
+		}
+		if req.Disabled != nil {
+			cred.Disabled = *req.Disabled
+		}
+	})
+	if err != nil {
+		h.writeStoreError(w, r, "credential_update", id, err)
+		return
+	}
+	h.adminAudit(r, "credential_update", id, "updated")
+	writeJSON(w, http.StatusOK, describeCredential(updated, credentialSourceStore, h.now()))
+}
+
+// handleRevokeCredential deletes a stored credential; its token stops
+// working immediately.
+func (h *Handler) handleRevokeCredential(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.authorizeCredentialChange(w, r, "credential_revoke", id) {
+		return
+	}
+	if h.isConfiguredCredential(id) {
+		h.adminAudit(r, "credential_revoke", id, "read_only")
+		writeError(w, http.StatusConflict, codeCredentialReadOnly, fmt.Sprintf("credential %q is defined in the server configuration", id))
+		return
+	}
+	if _, err := h.store.Revoke(id); err != nil {
+		h.writeStoreError(w, r, "credential_revoke", id, err)
+		return
+	}
+	h.adminAudit(r, "credential_revoke", id, "revoked")
+	setSecurityHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeCredentialChange requires the admin credential and a credential
+// store, writing the problem otherwise.
+func (h *Handler) authorizeCredentialChange(w http.ResponseWriter, r *http.Request, action, id string) bool {
+	if err := h.authorizeAdmin(r.Context()); err != nil {
+		h.adminAudit(r, action, id, "forbidden")
+		writeError(w, http.StatusForbidden, codeForbidden, err.Error())
+		return false
+	}
+	if h.store == nil {
+		writeError(w, http.StatusNotFound, codeCredentialStoreNotConfigured, "the credential store is not configured")
+		return false
+	}
+	return true
+}
+
+// isConfiguredCredential reports whether id names a static or the admin
+// credential, which only the server configuration can change. The admin ID
+// is reserved even when no admin credential is configured.
+func (h *Handler) isConfiguredCredential(id string) bool {
+	if id == security.AdminCredentialID {
+		return true
+	}
+	for _, creds := range [][]security.Credential{h.credentials.Load().Credentials(), h.adminCredential.Credentials()} {
+		for _, cred := range creds {
+			if cred.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkIssuedRoles rejects undefined roles and roles granting the admin
+// permission: issued credentials cannot issue further credentials.
+func (h *Handler) checkIssuedRoles(roles []string) error {
+	if err := h.policy.CheckRoles(roles); err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if h.policy.Permits([]string{role}, security.PermissionAdmin) {
+			return fmt.Errorf("role %q grants the admin permission, which issued credentials cannot have", role)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) writeStoreError(w http.ResponseWriter, r *http.Request, action, id string, err error) {
+	switch {
+	case errors.Is(err, security.ErrCredentialExists):
+		h.adminAudit(r, action, id, "conflict")
+		writeError(w, http.StatusConflict, codeCredentialExists, fmt.Sprintf("credential %q already exists", id))
+	case errors.Is(err, security.ErrCredentialNotFound):
+		h.adminAudit(r, action, id, "not_found")
+		writeError(w, http.StatusNotFound, codeCredentialNotFound, fmt.Sprintf("credential %q does not exist", id))
+	case errors.Is(err, security.ErrInvalidCredential):
+		h.adminAudit(r, action, id, "invalid")
+		writeAppError(w, domain.ValidationError{Message: err.Error()})
+	default:
+		log.Printf("credential store: %v", err)
+		h.adminAudit(r, action, id, "failed")
+		writeAppError(w, err)
+	}
+}
+
+// sanitizeAuditValue keeps a client-supplied value, such as a credential ID
+// from the path, to characters that cannot forge audit fields.
+func sanitizeAuditValue(value string) string {
+	if len(value) > maxRequestIDLen {
+		value = value[:maxRequestIDLen]
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r > '~' {
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+func describeCredential(cred security.Credential, source string, now time.Time) credentialResponse {
+	return credentialResponse{
+		ID:          cred.ID,
+		Source:      source,
+		Devices:     nonNil(cred.Devices),
+		Roles:       nonNil(cred.Roles),
+		Description: cred.Description,
+		Owner:       cred.Owner,
+		NotBefore:   optionalTime(cred.NotBefore),
+		ExpiresAt:   optionalTime(cred.ExpiresAt),
+		Disabled:    cred.Disabled,
+		Status:      cred.Status(now),
+		Hashed:      security.IsTokenHash(cred.Token),
+	}
+}
+
+// trimmedList trims every entry and drops empty ones.
+func trimmedList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
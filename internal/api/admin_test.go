@@ -0,0 +1,222 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paul/clock-server/internal/security"
+)
+
+func TestAdminListsCredentialsWithoutTokens(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	admin, err := security.NewAdminCredential("admin-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(&stubSender{}).WithAdminCredential(admin)
+	h.now = func() time.Time { return now }
+	h.ReplaceCredentials([]security.Credential{
+		{ID: "ops", Token: "ops-secret", Devices: []string{"*"}, Owner: "ops@example.org"},
+		{ID: "kiosk", Token: "kiosk-secret", Devices: []string{"lobby-*"}, Description: "lobby kiosk", ExpiresAt: now.Add(-time.Hour)},
+	})
+	router := h.Routes()
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/v1/admin/credentials", "admin-secret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), "secret") {
+		t.Fatalf("credential listing leaks tokens: %s", rr.Body.String())
+	}
+	var resp credentialListResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Credentials) != 3 || resp.Credentials[0].Owner != "ops@example.org" || resp.Credentials[0].Status != security.CredentialActive ||
+		resp.Credentials[1].Status != security.CredentialExpired || resp.Credentials[1].ExpiresAt == nil || resp.Credentials[1].Hashed {
+		t.Fatalf("unexpected credential listing: %+v", resp)
+	}
+
+	h.ReplaceCredentials([]security.Credential{{ID: "test", Token: "test-token", Devices: []string{"*"}}})
+	if rr := get("/v1/admin/credentials", "test-token"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected a credential other than the admin credential to be forbidden, got %d", rr.Code)
+	}
+	if rr := get("/admin/credentials", "test-token"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected no unversioned admin endpoint, got %d", rr.Code)
+	}
+}
+
+func TestAdminRoleDoesNotGrantCredentialManagement(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	enc := base64.RawURLEncoding.EncodeToString
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","x":%q}]}`, enc(pub))), 0o600); err != nil {
+		t.Fatalf("write jwks: %v", err)
+	}
+	verifier, err := security.NewJWTVerifier(security.JWTConfig{Issuer: "https://idp.example", Audience: "clock-server", JWKSFile: path})
+	if err != nil {
+		t.Fatalf("new verifier: %v", err)
+	}
+	claims := fmt.Sprintf(`{"iss":"https://idp.example","aud":"clock-server","sub":"admin","exp":%d,"clock_devices":["*"],"clock_roles":["admin"]}`, time.Now().Add(time.Hour).Unix())
+	signed := enc([]byte(`{"alg":"EdDSA","kid":"k1"}`)) + "." + enc([]byte(claims))
+	jwt := signed + "." + enc(ed25519.Sign(key, []byte(signed)))
+
+	store, err := security.OpenCredentialStore(filepath.Join(t.TempDir(), "store.json"), nil)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	admin, err := security.NewAdminCredential("admin-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(&stubSender{}).WithJWTVerifier(verifier).WithCredentialStore(store).WithAdminCredential(admin)
+	h.ReplaceCredentials([]security.Credential{{ID: "ops", Token: "ops-token", Devices: []string{"*"}, Roles: []string{security.RoleAdmin}}})
+	router := h.Routes()
+	call := func(method, token, body string) int {
+		req := httptest.NewRequest(method, "/v1/admin/credentials", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for name, token := range map[string]string{"static admin role": "ops-token", "jwt admin role": jwt} {
+		if code := call(http.MethodGet, token, ""); code != http.StatusForbidden {
+			t.Errorf("%s: expected listing to be forbidden, got %d", name, code)
+		}
+		if code := call(http.MethodPost, token, `{"id":"kiosk","devices":["*"]}`); code != http.StatusForbidden {
+			t.Errorf("%s: expected issuing to be forbidden, got %d", name, code)
+		}
+	}
+	if code := call(http.MethodPost, "admin-token", `{"id":"kiosk","devices":["*"]}`); code != http.StatusCreated {
+		t.Fatalf("expected the admin credential to issue credentials, got %d", code)
+	}
+}
+
+func TestAdminManagesStoredCredentials(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	store, err := security.OpenCredentialStore(filepath.Join(t.TempDir(), "credentials.json"), nil)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	policy, err := security.ParseRoles("messenger=command:display_message;superuser=*")
+	if err != nil {
+		t.Fatalf("parse roles: %v", err)
+	}
+	admin, _ := security.NewAdminCredential("admin-token")
+	h := newTestHandler(&stubSender{}).WithPolicy(policy).WithAdminCredential(admin).WithCredentialStore(store)
+	router := h.Routes()
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := call(http.MethodPost, "/v1/admin/credentials", "admin-token", `{"id":"kiosk","devices":["lobby-*"],"roles":["messenger"],"owner":"facilities"}`)
+	if rr.Code != http.StatusCreated || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected 201 with Cache-Control: no-store, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var issued issuedCredentialResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &issued); err != nil || issued.Token == "" || issued.Source != credentialSourceStore || !issued.Hashed {
+		t.Fatalf("unexpected issued credential %+v (%v)", issued, err)
+	}
+	if strings.Contains(call(http.MethodGet, "/v1/admin/credentials", "admin-token", "").Body.String(), issued.Token) {
+		t.Fatal("the token must only be shown when it is issued")
+	}
+
+	message := `{"deviceId":"lobby-1","message":"hi","durationSeconds":5}`
+	if rr := call(http.MethodPost, "/v1/commands/display_message", issued.Token, message); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected the issued token to authenticate, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := call(http.MethodPost, "/v1/commands/display_message", "admin-token", message); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected the admin credential to have no device scopes, got %d", rr.Code)
+	}
+	if rr := call(http.MethodPost, "/v1/admin/credentials", issued.Token, `{"id":"other","devices":["*"]}`); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected an issued credential not to manage credentials, got %d", rr.Code)
+	}
+
+	conflicts := map[string]struct {
+		method, path, body string
+		status             int
+	}{
+		"duplicate":        {http.MethodPost, "/v1/admin/credentials", `{"id":"kiosk","devices":["*"]}`, http.StatusConflict},
+		"static id":        {http.MethodPost, "/v1/admin/credentials", `{"id":"test","devices":["*"]}`, http.StatusConflict},
+		"admin id":         {http.MethodPost, "/v1/admin/credentials", `{"id":"admin","devices":["*"]}`, http.StatusConflict},
+		"admin role":       {http.MethodPost, "/v1/admin/credentials", `{"id":"root","devices":["*"],"roles":["admin"]}`, http.StatusBadRequest},
+		"admin permission": {http.MethodPatch, "/v1/admin/credentials/kiosk", `{"roles":["superuser"]}`, http.StatusBadRequest},
+		"undefined role":   {http.MethodPost, "/v1/admin/credentials", `{"id":"x","devices":["*"],"roles":["ghost"]}`, http.StatusBadRequest},
+		"no scopes":        {http.MethodPost, "/v1/admin/credentials", `{"id":"x","devices":[]}`, http.StatusBadRequest},
+		"update static":    {http.MethodPatch, "/v1/admin/credentials/test", `{"devices":["*"]}`, http.StatusConflict},
+		"revoke admin":     {http.MethodDelete, "/v1/admin/credentials/admin", ``, http.StatusConflict},
+		"unknown":          {http.MethodDelete, "/v1/admin/credentials/nobody", ``, http.StatusNotFound},
+	}
+	for name, tc := range conflicts {
+		if rr := call(tc.method, tc.path, "admin-token", tc.body); rr.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d: %s", name, tc.status, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr = call(http.MethodPatch, "/v1/admin/credentials/kiosk", "admin-token", `{"devices":["office-*"]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected update to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := call(http.MethodPost, "/v1/commands/display_message", issued.Token, message); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected narrowed scopes to apply immediately, got %d", rr.Code)
+	}
+	if rr := call(http.MethodDelete, "/v1/admin/credentials/kiosk", "admin-token", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected revoke to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := call(http.MethodPost, "/v1/commands/display_message", issued.Token, message); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a revoked token to be rejected, got %d", rr.Code)
+	}
+
+	for _, line := range []string{
+		"audit action=credential_create principal=admin cert=- remote=192.0.2.1 credential=kiosk result=created",
+		"audit action=credential_update principal=admin cert=- remote=192.0.2.1 credential=kiosk result=updated",
+		"audit action=credential_revoke principal=admin cert=- remote=192.0.2.1 credential=kiosk result=revoked",
+		"audit action=credential_create principal=kiosk cert=- remote=192.0.2.1 credential=- result=forbidden",
+	} {
+		if !strings.Contains(logs.String(), line) {
+			t.Errorf("missing audit line %q in:\n%s", line, logs.String())
+		}
+	}
+}
+
+func TestAdminCredentialChangesRequireStore(t *testing.T) {
+	admin, _ := security.NewAdminCredential("admin-token")
+	h := newTestHandler(&stubSender{}).WithAdminCredential(admin)
+	req := httptest.NewRequest(http.MethodPost, "/v1/admin/credentials", strings.NewReader(`{"id":"kiosk","devices":["*"]}`))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), codeCredentialStoreNotConfigured) {
+		t.Fatalf("expected 404 %s, got %d: %s", codeCredentialStoreNotConfigured, rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
+	"github.com/paul/clock-server/internal/domain"
+	"github.com/paul/clock-server/internal/security"
+)
+
+// Audit results of commands; rate-limited commands use the problem code.
+const (
+	auditAccepted  = "accepted"
+	auditFailed    = "failed"
+	auditForbidden = "forbidden"
+)
+
+// maxAuditQueryLimit bounds the entries returned by one audit query.
+const maxAuditQueryLimit = 1000
+
+// WithAuditLog writes audit records to auditLog instead of the server log and
+// enables the audit query endpoint.
+func (h *Handler) WithAuditLog(auditLog application.AuditLog) *Handler {
+	h.auditLog = auditLog
+	return h
+}
+
+// audit records the outcome of a command, with its parameters and the
+// per-sender results of report.
+func (h *Handler) audit(r *http.Request, cmd domain.ClockCommand, result string, report application.DeliveryReport) {
+	record := h.auditRecord(r, application.AuditActionCommand, result)
+	record.DeviceID = cmd.TargetDeviceID()
+	record.CommandType = cmd.CommandType()
+	record.Deliveries = application.AuditDeliveries(report)
+	if h.auditLog != nil {
+		// Parameters are only written to the audit log, which redacts them.
+		record.Parameters, _ = commands.Payload(cmd)
+	}
+	h.writeAudit(record)
+	if h.events != nil {
+		h.events.Publish(application.Event{
+			Type:     application.EventAudit,
+			DeviceID: record.DeviceID,
+			Data: map[string]any{
+				"principal":   record.Principal,
+				"cert":        record.CertIdentity,
+				"method":      record.Method,
+				"path":        record.Path,
+				"commandType": record.CommandType,
+				"result":      record.Result,
+				"requestId":   record.RequestID,
+			},
+		})
+	}
+}
+
+// adminAudit records an admin action on a credential. Unlike command audits
+// it is not published to the event stream: credential activity concerns no
+// device, so device scopes cannot restrict who sees it. It is available
+// through the audit log to callers with audit:read.
+func (h *Handler) adminAudit(r *http.Request, action, credentialID, result string) {
+	record := h.auditRecord(r, action, result)
+	record.Credential = sanitizeAuditValue(credentialID)
+	h.writeAudit(record)
+}
+
+// AuditCredentialsReload records the outcome of a credentials file reload,
+// which no request or principal is behind.
+func (h *Handler) AuditCredentialsReload(reload security.CredentialReload) {
+	params := map[string]any{
+		"source":      reload.Source,
+		"trigger":     reload.Trigger,
+		"digest":      reload.Digest,
+		"credentials": reload.Credentials,
+	}
+	if reload.Err != nil {
+		params["error"] = reload.Err.Error()
+	}
+	h.writeAudit(application.AuditRecord{
+		Time:         h.now().UTC(),
+		Action:       application.AuditActionCredentialsReload,
+		Principal:    "system",
+		CertIdentity: "-",
+		Parameters:   params,
+		Result:       reload.Result,
+	})
+}
+
+// auditRecord describes the request r and its authenticated principal.
+func (h *Handler) auditRecord(r *http.Request, action, result string) application.AuditRecord {
+	record := application.AuditRecord{
+		Time:         h.now().UTC(),
+		Action:       action,
+		Principal:    "unknown",
+		CertIdentity: "-",
+		RemoteIP:     clientIP(r),
+		RequestID:    sanitizeRequestID(r.Header.Get("X-Request-Id")),
+		Method:       r.Method,
+		Path:         r.URL.Path,
+		Result:       result,
+	}
+	if pr, ok := r.Context().Value(principalContextKey).(principal); ok {
+		record.Principal = pr.ID
+		if pr.CertIdentity != "" {
+			record.CertIdentity = pr.CertIdentity
+		}
+	}
+	return record
+}
+
+// writeAudit appends record to the audit log or, without one or when it
+// fails, writes it as a line to the server log.
+func (h *Handler) writeAudit(record application.AuditRecord) {
+	if h.auditLog != nil {
+		err := h.auditLog.Append(record)
+		if err == nil {
+			return
+		}
+		log.Printf("audit log unavailable, writing to the server log: %v", err)
+	}
+	if record.Action == application.AuditActionCredentialsReload {
+		detail := ""
+		if msg, ok := record.Parameters["error"]; ok {
+			detail = fmt.Sprintf(" error=%q", msg)
+		}
+		log.Printf("audit action=%s source=%v trigger=%v result=%s digest=%v credentials=%v%s",
+			record.Action, record.Parameters["source"], record.Parameters["trigger"], record.Result,
+			record.Parameters["digest"], record.Parameters["credentials"], detail)
+		return
+	}
+	if record.Action != application.AuditActionCommand {
+		log.Printf("audit action=%s principal=%s cert=%s remote=%s credential=%s result=%s request_id=%s",
+			record.Action, record.Principal, record.CertIdentity, record.RemoteIP, record.Credential, record.Result, record.RequestID)
+		return
+	}
+	log.Printf("audit principal=%s cert=%s remote=%s method=%s path=%s device=%s command=%s result=%s request_id=%s",
+		record.Principal,
+		record.CertIdentity,
+		record.RemoteIP,
+		record.Method,
+		record.Path,
+		record.DeviceID,
+		record.CommandType,
+		record.Result,
+		record.RequestID,
+	)
+}
+
+type auditEntriesResponse struct {
+	Entries []application.AuditEntry `json:"entries"`
+}
+
+// handleAuditEntries serves GET /admin/audit: the audit log entries matching
+// the query parameters, newest first.
+func (h *Handler) handleAuditEntries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	if err := h.authorize(r.Context(), security.PermissionAuditRead); err != nil {
+		writeError(w, http.StatusForbidden, codeForbidden, err.Error())
+		return
+	}
+	if h.auditLog == nil {
+		writeError(w, http.StatusNotFound, codeAuditLogNotConfigured, "the audit log is not configured")
+		return
+	}
+	query, err := parseAuditQuery(r)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	entries, err := h.auditLog.Query(query)
+	if err != nil {
+		log.Printf("audit log query: %v", err)
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, auditEntriesResponse{Entries: entries})
+}
+
+func parseAuditQuery(r *http.Request) (application.AuditQuery, error) {
+	values := r.URL.Query()
+	query := application.AuditQuery{
+		Principal:   strings.TrimSpace(values.Get("principal")),
+		DeviceID:    strings.TrimSpace(values.Get("deviceId")),
+		CommandType: strings.TrimSpace(values.Get("commandType")),
+		Action:      strings.TrimSpace(values.Get("action")),
+		Result:      strings.TrimSpace(values.Get("result")),
+		Limit:       100,
+	}
+	for _, bound := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		raw := strings.TrimSpace(values.Get(bound.name))
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return query, domain.NewFieldValidationError(bound.name, bound.name+" must be RFC3339")
+		}
+		*bound.dst = t
+	}
+	if raw := strings.TrimSpace(values.Get("limit")); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxAuditQueryLimit {
+			return query, domain.NewFieldValidationError("limit", "limit must be between 1 and "+strconv.Itoa(maxAuditQueryLimit))
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
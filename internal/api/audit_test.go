@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paul/clock-server/internal/adapters/auditlog"
+	"github.com/paul/clock-server/internal/adapters/composite"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/security"
+)
+
+func newAuditedHandler(t *testing.T, redact string) (*Handler, *auditlog.FileLog) {
+	t.Helper()
+	redactions, err := auditlog.ParseRedactions(redact)
+	if err != nil {
+		t.Fatalf("parse redactions: %v", err)
+	}
+	auditLog, err := auditlog.Open(auditlog.Config{Path: filepath.Join(t.TempDir(), "audit.log"), MaxBytes: 1 << 20, Redactions: redactions})
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	t.Cleanup(func() { _ = auditLog.Close() })
+	sender, err := composite.NewPolicySender(composite.Options{Policy: composite.PolicyAny},
+		composite.Target{Name: "mqtt", Sender: &stubSender{}},
+		composite.Target{Name: "rest", Sender: &stubSender{err: errors.New("rest down")}},
+	)
+	if err != nil {
+		t.Fatalf("new policy sender: %v", err)
+	}
+	h := newTestHandler(sender).WithAuditLog(auditLog)
+	h.ReplaceCredentials([]security.Credential{
+		{ID: "ops", Token: "ops-token", Devices: []string{"*"}},
+		{ID: "auditor", Token: "auditor-token", Devices: []string{"*"}, Roles: []string{"auditor"}},
+	})
+	policy, err := security.ParseRoles("auditor=audit:read")
+	if err != nil {
+		t.Fatalf("parse roles: %v", err)
+	}
+	return h.WithPolicy(policy), auditLog
+}
+
+func queryAudit(router http.Handler, token, query string) (*httptest.ResponseRecorder, auditEntriesResponse) {
+	req := httptest.NewRequest(http.MethodGet, "/v1/admin/audit"+query, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var resp auditEntriesResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	return rr, resp
+}
+
+func TestCommandsAreWrittenToAuditLog(t *testing.T) {
+	h, auditLog := newAuditedHandler(t, "display_message.message")
+	router := h.Routes()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/commands/display_message", strings.NewReader(`{"deviceId":"clock-1","message":"door code 1234","durationSeconds":5}`))
+	req.Header.Set("Authorization", "Bearer ops-token")
+	req.Header.Set("X-Request-Id", "req-audit-1")
+	req.RemoteAddr = "192.0.2.7:41000"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	entries, err := auditLog.Query(application.AuditQuery{})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one audit entry, got %+v (%v)", entries, err)
+	}
+	e := entries[0]
+	if e.Action != application.AuditActionCommand || e.Principal != "ops" || e.RemoteIP != "192.0.2.7" || e.RequestID != "req-audit-1" ||
+		e.DeviceID != "clock-1" || e.CommandType != "display_message" || e.Result != auditAccepted {
+		t.Fatalf("unexpected audit entry %+v", e)
+	}
+	if e.Parameters["message"] != auditlog.Redacted || e.Parameters["durationSeconds"] != float64(5) {
+		t.Fatalf("expected the message to be redacted and the duration kept, got %v", e.Parameters)
+	}
+	if len(e.Deliveries) != 2 || e.Deliveries[0].Sender != "mqtt" || e.Deliveries[1].Status != application.DeliveryFailed || e.Deliveries[1].Error == "" {
+		t.Fatalf("unexpected per-sender outcomes %+v", e.Deliveries)
+	}
+}
+
+func TestCredentialsReloadsAreWrittenToAuditLog(t *testing.T) {
+	h, auditLog := newAuditedHandler(t, "")
+	h.AuditCredentialsReload(security.CredentialReload{Source: "/etc/credentials", Trigger: "signal", Result: "rejected", Digest: "3f1c9a0e5b7d2c41", Err: errors.New("undefined role")})
+
+	entries, err := auditLog.Query(application.AuditQuery{Action: application.AuditActionCredentialsReload})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one reload entry, got %+v (%v)", entries, err)
+	}
+	e := entries[0]
+	if e.Principal != "system" || e.Result != "rejected" || e.Parameters["source"] != "/etc/credentials" ||
+		e.Parameters["trigger"] != "signal" || e.Parameters["digest"] != "3f1c9a0e5b7d2c41" || e.Parameters["error"] != "undefined role" {
+		t.Fatalf("unexpected reload entry %+v", e)
+	}
+}
+
+func TestAuditQueryEndpoint(t *testing.T) {
+	h, _ := newAuditedHandler(t, "")
+	router := h.Routes()
+	for _, device := range []string{"clock-1", "clock-2", "clock-1"} {
+		if rr := sendCommand(router, "ops-token", "set_brightness", `{"deviceId":"`+device+`","level":10}`); rr.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d", rr.Code)
+		}
+	}
+
+	if rr, _ := queryAudit(router, "ops-token", ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected the default permissions not to include audit:read, got %d", rr.Code)
+	}
+	rr, resp := queryAudit(router, "auditor-token", "?deviceId=clock-1&limit=5")
+	if rr.Code != http.StatusOK || len(resp.Entries) != 2 || resp.Entries[0].Seq != 3 || resp.Entries[1].Seq != 1 {
+		t.Fatalf("expected entries 3 and 1, got %d %+v", rr.Code, resp.Entries)
+	}
+	if resp.Entries[0].PrevHash == "" || resp.Entries[0].Hash == "" {
+		t.Fatalf("expected entries to carry their chain hashes, got %+v", resp.Entries[0])
+	}
+	if rr, _ := queryAudit(router, "auditor-token", "?since=yesterday"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid time bound to be rejected, got %d", rr.Code)
+	}
+	if rr, _ := queryAudit(router, "auditor-token", "?limit=0"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid limit to be rejected, got %d", rr.Code)
+	}
+
+	h.auditLog = nil
+	if rr, _ := queryAudit(router, "auditor-token", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without an audit log, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+const (
+	// batchModeAtomicValidate dispatches nothing unless every item is valid.
+	batchModeAtomicValidate = "atomic-validate"
+	// batchModeBestEffort dispatches every valid item and reports the rest.
+	batchModeBestEffort = "best-effort"
+
+	maxBatchItems = 100
+)
+
+// Per-item batch statuses.
+const (
+	batchItemAccepted  = "accepted"
+	batchItemFailed    = "failed"
+	batchItemInvalid   = "invalid"
+	batchItemForbidden = "forbidden"
+	batchItemSkipped   = "skipped"
+	batchItemLimited   = "rate_limited"
+)
+
+type batchRequest struct {
+	Mode     string            `json:"mode"`
+	Commands []json.RawMessage `json:"commands"`
+}
+
+type batchItemResult struct {
+	Index      int              `json:"index"`
+	Type       string           `json:"type,omitempty"`
+	DeviceID   string           `json:"deviceId,omitempty"`
+	Status     string           `json:"status"`
+	Code       string           `json:"code,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	Deliveries []deliveryResult `json:"deliveries,omitempty"`
+}
+
+type batchResponse struct {
+	Mode    string            `json:"mode"`
+	Results []batchItemResult `json:"results"`
+}
+
+// handleBatch validates every item of a batch and charges the valid ones to
+// the rate limits before dispatching any of them. In atomic-validate mode a
+// single invalid or forbidden item rejects the whole batch, and the items
+// are charged together so that a limit rejects all of them or none; in
+// best-effort mode each item is charged on its own and the remaining items
+// are still dispatched. The RateLimit headers report the most restrictive
+// status of the batch.
+func (h *Handler) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+
+	var payload batchRequest
+	if err := h.decodeJSON(w, r, &payload); err != nil {
+		writeAppError(w, err)
+		return
+	}
+	mode := payload.Mode
+	if mode == "" {
+		mode = batchModeAtomicValidate
+	}
+	if mode != batchModeAtomicValidate && mode != batchModeBestEffort {
+		writeError(w, http.StatusBadRequest, codeInvalidBatch, fmt.Sprintf("mode must be %s or %s", batchModeAtomicValidate, batchModeBestEffort))
+		return
+	}
+	if len(payload.Commands) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidBatch, "commands must not be empty")
+		return
+	}
+	if len(payload.Commands) > maxBatchItems {
+		writeError(w, http.StatusBadRequest, codeInvalidBatch, fmt.Sprintf("batch exceeds %d commands", maxBatchItems))
+		return
+	}
+
+	results := make([]batchItemResult, len(payload.Commands))
+	cmds := make([]domain.ClockCommand, len(payload.Commands))
+	invalid := false
+	for idx, raw := range payload.Commands {
+		cmd, result := h.validateBatchItem(r, idx, raw)
+		results[idx] = result
+		cmds[idx] = cmd
+		if cmd == nil {
+			invalid = true
+		}
+	}
+
+	if invalid && mode == batchModeAtomicValidate {
+		for idx := range results {
+			if cmds[idx] != nil {
+				results[idx].Status = batchItemSkipped
+			}
+		}
+		writeProblem(w, problem{
+			Status:  http.StatusBadRequest,
+			Code:    codeBatchValidationFailed,
+			Detail:  "batch validation failed",
+			Mode:    mode,
+			Results: results,
+		})
+		return
+	}
+
+	if mode == batchModeAtomicValidate {
+		if status, limited := h.chargeCommands(r, cmds...); limited {
+			setRateLimitHeaders(w, status)
+			if status.Exhausted {
+				failure := rateLimitProblem(status)
+				for idx, cmd := range cmds {
+					h.audit(r, cmd, failure.Code, application.DeliveryReport{})
+					results[idx] = results[idx].reject(batchItemLimited, failure.Code, failure.Detail)
+				}
+				failure.Mode = mode
+				failure.Results = results
+				writeProblem(w, failure)
+				return
+			}
+		}
+	}
+
+	allAccepted := !invalid
+	if mode == batchModeBestEffort {
+		var most application.LimitStatus
+		anyLimited := false
+		for idx, cmd := range cmds {
+			if cmd == nil {
+				continue
+			}
+			status, limited := h.chargeCommands(r, cmd)
+			if !limited {
+				continue
+			}
+			if !anyLimited || status.MoreRestrictive(most) {
+				most = status
+			}
+			anyLimited = true
+			if status.Exhausted {
+				failure := rateLimitProblem(status)
+				h.audit(r, cmd, failure.Code, application.DeliveryReport{})
+				results[idx] = results[idx].reject(batchItemLimited, failure.Code, failure.Detail)
+				cmds[idx] = nil
+				allAccepted = false
+			}
+		}
+		if anyLimited {
+			setRateLimitHeaders(w, most)
+		}
+	}
+
+	for idx, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		report, err := h.dispatcher.DispatchWithReport(r.Context(), cmd)
+		results[idx].Deliveries = deliveryResults(report)
+		if err != nil {
+			h.audit(r, cmd, auditFailed, report)
+			failure := describeError(err)
+			results[idx].Status = batchItemFailed
+			results[idx].Code = failure.Code
+			results[idx].Error = failure.Detail
+			allAccepted = false
+			continue
+		}
+		h.audit(r, cmd, auditAccepted, report)
+		results[idx].Status = batchItemAccepted
+	}
+
+	status := http.StatusAccepted
+	if !allAccepted {
+		status = http.StatusMultiStatus
+	}
+	writeJSON(w, status, batchResponse{Mode: mode, Results: results})
+}
+
+// validateBatchItem decodes, authorizes and validates a single batch item. It
+// returns a nil command when the item must not be dispatched.
+func (h *Handler) validateBatchItem(r *http.Request, idx int, raw json.RawMessage) (domain.ClockCommand, batchItemResult) {
+	result := batchItemResult{Index: idx}
+
+	var header struct {
+		Type     string `json:"type"`
+		DeviceID string `json:"deviceId"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, result.reject(batchItemInvalid, codeInvalidBody, "command must be a JSON object with string type and deviceId")
+	}
+	result.Type = header.Type
+	result.DeviceID = header.DeviceID
+
+	def, ok := commands.Lookup(header.Type)
+	if !ok {
+		return nil, result.reject(batchItemInvalid, codeUnknownCommandType, fmt.Sprintf("unknown command type %q", header.Type))
+	}
+
+	cmd, err := def.Decode(withoutTypeField(raw))
+	if err != nil {
+		failure := describeError(err)
+		return nil, result.reject(batchItemInvalid, failure.Code, failure.Detail)
+	}
+	if err := h.authorizeCommand(r.Context(), def.Type, cmd.TargetDeviceID()); err != nil {
+		h.audit(r, cmd, auditForbidden, application.DeliveryReport{})
+		return nil, result.reject(batchItemForbidden, codeForbidden, err.Error())
+	}
+	if err := cmd.Validate(); err != nil {
+		failure := describeError(err)
+		return nil, result.reject(batchItemInvalid, failure.Code, failure.Detail)
+	}
+	return cmd, result
+}
+
+func (r batchItemResult) reject(status, code, detail string) batchItemResult {
+	r.Status = status
+	r.Code = code
+	r.Error = detail
+	return r
+}
+
+// withoutTypeField removes the batch "type" discriminator so the item can be
+// decoded like a single-command request body.
+func withoutTypeField(raw json.RawMessage) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return raw
+	}
+	delete(fields, "type")
+	stripped, err := json.Marshal(fields)
+	if err != nil {
+		return raw
+	}
+	return stripped
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/security"
+)
+
+func postBatch(t *testing.T, handler http.Handler, body string) (*httptest.ResponseRecorder, batchResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/commands/batch", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var resp batchResponse
+	if rr.Code != http.StatusMethodNotAllowed {
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	}
+	return rr, resp
+}
+
+func TestBatchDispatchesHeterogeneousCommands(t *testing.T) {
+	sender := &stubSender{}
+	h := newTestHandler(sender)
+
+	rr, resp := postBatch(t, h.Routes(), `{"commands":[
+		{"type":"set_brightness","deviceId":"room-1","level":40},
+		{"type":"set_alarm","deviceId":"room-1","alarmTime":"2099-01-01T07:00:00Z","label":"standup"},
+		{"type":"display_message","deviceId":"room-2","message":"welcome","durationSeconds":30}
+	]}`)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if sender.calls != 3 {
+		t.Fatalf("expected 3 dispatches, got %d", sender.calls)
+	}
+	if resp.Mode != batchModeAtomicValidate || len(resp.Results) != 3 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	for _, result := range resp.Results {
+		if result.Status != batchItemAccepted {
+			t.Fatalf("expected all items accepted, got %+v", resp.Results)
+		}
+	}
+	if resp.Results[1].Type != "set_alarm" || resp.Results[2].DeviceID != "room-2" {
+		t.Fatalf("unexpected item metadata: %+v", resp.Results)
+	}
+}
+
+func TestBatchAtomicValidateRejectsWholeBatch(t *testing.T) {
+	sender := &stubSender{}
+	h := newTestHandler(sender)
+
+	rr, resp := postBatch(t, h.Routes(), `{"mode":"atomic-validate","commands":[
+		{"type":"set_brightness","deviceId":"room-1","level":40},
+		{"type":"set_brightness","deviceId":"room-1","level":400}
+	]}`)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+	if sender.calls != 0 {
+		t.Fatalf("expected no dispatch, got %d", sender.calls)
+	}
+	if resp.Results[0].Status != batchItemSkipped || resp.Results[1].Status != batchItemInvalid {
+		t.Fatalf("unexpected item statuses: %+v", resp.Results)
+	}
+	if resp.Results[1].Code != codeValidationFailed || resp.Results[1].Error != "brightness level must be between 0 and 100" {
+		t.Fatalf("expected validation detail on invalid item, got %+v", resp.Results[1])
+	}
+	if !strings.Contains(rr.Body.String(), `"code":"batch_validation_failed"`) {
+		t.Fatalf("expected batch_validation_failed problem, got %s", rr.Body.String())
+	}
+}
+
+func TestBatchBestEffortDispatchesValidItems(t *testing.T) {
+	sender := &stubSender{}
+	h := newTestHandler(sender)
+
+	rr, resp := postBatch(t, h.Routes(), `{"mode":"best-effort","commands":[
+		{"type":"set_brightness","deviceId":"room-1","level":40},
+		{"type":"reboot","deviceId":"room-1"},
+		{"type":"set_alarm","deviceId":"room-1","alarmTime":"tomorrow"}
+	]}`)
+
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("expected status 207, got %d", rr.Code)
+	}
+	if sender.calls != 1 {
+		t.Fatalf("expected 1 dispatch, got %d", sender.calls)
+	}
+	want := []string{batchItemAccepted, batchItemInvalid, batchItemInvalid}
+	for idx, status := range want {
+		if resp.Results[idx].Status != status {
+			t.Fatalf("item %d: expected %s, got %+v", idx, status, resp.Results[idx])
+		}
+	}
+}
+
+func TestBatchReportsDispatchFailuresPerItem(t *testing.T) {
+	sender := &stubSender{err: errors.New("downstream unavailable")}
+	h := newTestHandler(sender)
+
+	rr, resp := postBatch(t, h.Routes(), `{"mode":"best-effort","commands":[
+		{"type":"set_brightness","deviceId":"room-1","level":40}
+	]}`)
+
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("expected status 207, got %d", rr.Code)
+	}
+	if resp.Results[0].Status != batchItemFailed || resp.Results[0].Code != codeDispatchFailed || resp.Results[0].Error != "command dispatch failed" {
+		t.Fatalf("unexpected item result: %+v", resp.Results[0])
+	}
+}
+
+func TestBatchEnforcesPerItemDeviceScope(t *testing.T) {
+	sender := &stubSender{}
+	h := NewHandler(
+		application.NewCommandDispatcher(sender),
+		[]security.Credential{{ID: "room-admin", Token: "test-token", Devices: []string{"room-*"}}},
+		false, false, true, 64*1024, 100,
+	)
+
+	rr, resp := postBatch(t, h.Routes(), `{"mode":"best-effort","commands":[
+		{"type":"set_brightness","deviceId":"room-1","level":40},
+		{"type":"set_brightness","deviceId":"lobby-1","level":40}
+	]}`)
+
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("expected status 207, got %d", rr.Code)
+	}
+	if sender.calls != 1 {
+		t.Fatalf("expected 1 dispatch, got %d", sender.calls)
+	}
+	if resp.Results[1].Status != batchItemForbidden {
+		t.Fatalf("expected forbidden item, got %+v", resp.Results[1])
+	}
+}
+
+func TestBatchRejectsInvalidEnvelope(t *testing.T) {
+	h := newTestHandler(&stubSender{})
+
+	cases := map[string]string{
+		"empty":        `{"commands":[]}`,
+		"unknown mode": `{"mode":"yolo","commands":[{"type":"set_brightness","deviceId":"room-1","level":40}]}`,
+		"unknown key":  `{"commands":[],"extra":true}`,
+		"too many":     `{"commands":[` + strings.TrimSuffix(strings.Repeat(`{"type":"set_brightness","deviceId":"room-1","level":1},`, maxBatchItems+1), ",") + `]}`,
+	}
+	for name, body := range cases {
+		rr, _ := postBatch(t, h.Routes(), body)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected status 400, got %d", name, rr.Code)
+		}
+	}
+}
+
+func TestBatchEnforcesPerItemCommandPermission(t *testing.T) {
+	sender := &stubSender{}
+	policy, err := security.ParseRoles("messenger=command:display_message")
+	if err != nil {
+		t.Fatalf("parse roles: %v", err)
+	}
+	h := NewHandler(
+		application.NewCommandDispatcher(sender),
+		[]security.Credential{{ID: "lobby", Token: "test-token", Devices: []string{"lobby-*"}, Roles: []string{"messenger"}}},
+		false, false, true, 64*1024, 100,
+	).WithPolicy(policy)
+
+	rr, resp := postBatch(t, h.Routes(), `{"mode":"best-effort","commands":[
+		{"type":"display_message","deviceId":"lobby-1","message":"hi","durationSeconds":5},
+		{"type":"set_alarm","deviceId":"lobby-1","alarmTime":"2030-01-01T07:00:00Z"}
+	]}`)
+
+	if rr.Code != http.StatusMultiStatus || sender.calls != 1 {
+		t.Fatalf("expected one dispatch in a 207, got %d with %d calls", rr.Code, sender.calls)
+	}
+	if resp.Results[1].Status != batchItemForbidden {
+		t.Fatalf("expected forbidden set_alarm item, got %+v", resp.Results[1])
+	}
+}
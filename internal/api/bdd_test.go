@@ -10,8 +10,10 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cucumber/godog"
+	"github.com/paul/clock-server/internal/adapters/idempotency"
 	"github.com/paul/clock-server/internal/application"
 	"github.com/paul/clock-server/internal/domain"
 	"github.com/paul/clock-server/internal/security"
@@ -96,6 +98,7 @@ func (w *bddWorld) ensureHandler() {
 		w.authFailLimitPerMin,
 		checkers...,
 	)
+	h.WithIdempotency(idempotency.NewMemoryStore(time.Hour, 100))
 	w.handler = h.Routes()
 }
 
@@ -0,0 +1,52 @@
+package api
+
+import "net/http"
+
+// DeviceChannel is the inbound side of a push transport: clocks open a
+// long-lived connection to the server, authenticated by their own device
+// token rather than an API credential.
+type DeviceChannel interface {
+	// AuthenticateDevice returns the device a token belongs to.
+	AuthenticateDevice(token string) (deviceID string, ok bool)
+	// ServeDevice takes over the connection of an authenticated device. An
+	// error means the upgrade was rejected and nothing has been written.
+	ServeDevice(w http.ResponseWriter, r *http.Request, deviceID string) error
+}
+
+// WithDeviceChannel enables GET /devices/connect.
+func (h *Handler) WithDeviceChannel(channel DeviceChannel) *Handler {
+	h.devices = channel
+	return h
+}
+
+// handleDeviceConnect authenticates a device token and hands the connection
+// to the device channel. Failed attempts count towards the same per-IP auth
+// failure limit as API tokens.
+func (h *Handler) handleDeviceConnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	if h.devices == nil {
+		writeError(w, http.StatusNotFound, codeDeviceChannelNotConfigured, "device channel is not configured")
+		return
+	}
+	rateLimitKey := "device:" + clientIP(r)
+	if h.authBlocked(r.Context(), rateLimitKey) {
+		writeError(w, http.StatusTooManyRequests, codeTooManyAuthFailures, "too many auth failures")
+		return
+	}
+	token := bearerToken(r)
+	deviceID, ok := "", false
+	if token != "" {
+		deviceID, ok = h.devices.AuthenticateDevice(token)
+	}
+	if !ok {
+		h.recordAuthFailure(r.Context(), rateLimitKey)
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+		return
+	}
+	if err := h.devices.ServeDevice(w, r, deviceID); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidUpgrade, "a WebSocket upgrade request is required")
+	}
+}
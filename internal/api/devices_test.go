@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/security"
+)
+
+type stubDeviceChannel struct {
+	served     string
+	upgradeErr error
+}
+
+func (c *stubDeviceChannel) AuthenticateDevice(token string) (string, bool) {
+	if token == "device-token" {
+		return "clock-1", true
+	}
+	return "", false
+}
+
+func (c *stubDeviceChannel) ServeDevice(w http.ResponseWriter, _ *http.Request, deviceID string) error {
+	if c.upgradeErr != nil {
+		return c.upgradeErr
+	}
+	c.served = deviceID
+	w.WriteHeader(http.StatusSwitchingProtocols)
+	return nil
+}
+
+type reportingChecker struct{ connected int }
+
+func (reportingChecker) Check(context.Context) error { return nil }
+
+func (c reportingChecker) ReadinessDetails() map[string]any {
+	return map[string]any{"websocket": map[string]any{"connectedDevices": c.connected}}
+}
+
+func deviceConnect(h *Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/devices/connect", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestDeviceConnectUsesDeviceTokens(t *testing.T) {
+	channel := &stubDeviceChannel{}
+	h := newTestHandler(&stubSender{}).WithDeviceChannel(channel)
+
+	if rr := deviceConnect(h, "test-token"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("API tokens must not open device connections, got %d", rr.Code)
+	}
+	if rr := deviceConnect(h, "device-token"); rr.Code != http.StatusSwitchingProtocols || channel.served != "clock-1" {
+		t.Fatalf("expected clock-1 to connect, got %d %q", rr.Code, channel.served)
+	}
+}
+
+func TestDeviceConnectRejectsInvalidUpgrade(t *testing.T) {
+	h := newTestHandler(&stubSender{}).WithDeviceChannel(&stubDeviceChannel{upgradeErr: errors.New("missing websocket upgrade headers")})
+	rr := deviceConnect(h, "device-token")
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"code":"invalid_upgrade"`) {
+		t.Fatalf("expected 400 invalid_upgrade, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDeviceConnectRateLimitsFailures(t *testing.T) {
+	dispatcher := application.NewCommandDispatcher(&stubSender{})
+	h := NewHandler(
+		dispatcher,
+		[]security.Credential{{ID: "test", Token: "test-token", Devices: []string{"*"}}},
+		false,
+		false,
+		true,
+		64*1024,
+		2,
+	).WithDeviceChannel(&stubDeviceChannel{})
+
+	for i := 0; i < 2; i++ {
+		deviceConnect(h, "guess")
+	}
+	if rr := deviceConnect(h, "device-token"); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 after repeated failures, got %d", rr.Code)
+	}
+}
+
+func TestDeviceConnectNotConfigured(t *testing.T) {
+	rr := deviceConnect(newTestHandler(&stubSender{}), "device-token")
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), `"code":"device_channel_not_configured"`) {
+		t.Fatalf("expected 404 device_channel_not_configured, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestReadyReportsCheckerDetails(t *testing.T) {
+	dispatcher := application.NewCommandDispatcher(&stubSender{})
+	h := NewHandler(
+		dispatcher,
+		[]security.Credential{{ID: "test", Token: "test-token", Devices: []string{"*"}}},
+		false,
+		false,
+		false,
+		64*1024,
+		100,
+		reportingChecker{connected: 3},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/ready", nil)
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"details":{"websocket":{"connectedDevices":3}}`) {
+		t.Fatalf("expected connected device count, got %d %s", rr.Code, rr.Body.String())
+	}
+}
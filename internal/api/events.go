@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/security"
+)
+
+// eventsHeartbeatInterval keeps idle streams alive through proxies.
+const eventsHeartbeatInterval = 15 * time.Second
+
+// eventStreamReset is sent first on a resumed stream when events after
+// Last-Event-ID were already evicted from the buffer.
+const eventStreamReset = "reset"
+
+// WithEvents enables GET /events and publishes audit events to stream.
+func (h *Handler) WithEvents(stream application.EventStream) *Handler {
+	h.events = stream
+	return h
+}
+
+// handleEvents streams activity events as Server-Sent Events. Only events
+// for devices covered by the caller's credential are sent. A reconnecting
+// client resumes after Last-Event-ID from the retained events.
+func (h *Handler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	if h.events == nil {
+		writeError(w, http.StatusNotFound, codeEventsNotConfigured, "event stream is not configured")
+		return
+	}
+	pr, ok := r.Context().Value(principalContextKey).(principal)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+		return
+	}
+	if err := h.authorize(r.Context(), security.PermissionEventsRead); err != nil {
+		writeError(w, http.StatusForbidden, codeForbidden, err.Error())
+		return
+	}
+	var lastID uint64
+	if raw := strings.TrimSpace(r.Header.Get("Last-Event-ID")); raw != "" {
+		parsed, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidLastEventID, "Last-Event-ID must be an event id")
+			return
+		}
+		lastID = parsed
+	}
+	types := splitEventTypes(r.URL.Query().Get("types"))
+	cred := security.Credential{ID: pr.ID, Devices: pr.Devices}
+	visible := func(event application.Event) bool {
+		return cred.Allows(event.DeviceID) && (len(types) == 0 || types[event.Type])
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, codeInternal, "streaming is not supported")
+		return
+	}
+	// The stream outlives the server write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	replay, sub, complete := h.events.Subscribe(lastID)
+	defer sub.Close()
+
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if !complete {
+		_, _ = fmt.Fprintf(w, "event: %s\ndata: {}\n\n", eventStreamReset)
+	}
+	for _, event := range replay {
+		if visible(event) {
+			writeEvent(w, event)
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, open := <-sub.Events():
+			if !open {
+				// Dropped for falling behind; the client reconnects with
+				// Last-Event-ID and resumes from the buffer.
+				return
+			}
+			if visible(event) {
+				writeEvent(w, event)
+				flusher.Flush()
+			}
+		case <-heartbeat.C:
+			_, _ = io.WriteString(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w io.Writer, event application.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
+
+func splitEventTypes(raw string) map[string]bool {
+	types := map[string]bool{}
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			types[part] = true
+		}
+	}
+	return types
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paul/clock-server/internal/adapters/events"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/security"
+)
+
+type sseFrame struct {
+	id    string
+	event string
+	data  string
+}
+
+func newEventsServer(t *testing.T, broker *events.Broker) *httptest.Server {
+	t.Helper()
+	dispatcher := application.NewCommandDispatcher(&stubSender{}).WithEvents(broker)
+	h := NewHandler(
+		dispatcher,
+		[]security.Credential{
+			{ID: "admin", Token: "admin-token", Devices: []string{"*"}},
+			{ID: "kitchen", Token: "kitchen-token", Devices: []string{"kitchen-*"}},
+		},
+		false,
+		false,
+		true,
+		64*1024,
+		100,
+	).WithEvents(broker)
+	srv := httptest.NewServer(h.Routes())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func openEvents(t *testing.T, ctx context.Context, url, token, lastID string) *bufio.Reader {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func readFrame(t *testing.T, r *bufio.Reader) sseFrame {
+	t.Helper()
+	var frame sseFrame
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if frame.event != "" {
+				return frame
+			}
+		case strings.HasPrefix(line, "id: "):
+			frame.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			frame.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			frame.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func postCommand(t *testing.T, url, deviceID string) {
+	t.Helper()
+	body := `{"deviceId":"` + deviceID + `","level":5}`
+	req, _ := http.NewRequest(http.MethodPost, url+"/v1/commands/set_brightness", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", resp.StatusCode)
+	}
+}
+
+func TestEventsStreamIsScopedToCredentialDevices(t *testing.T) {
+	srv := newEventsServer(t, events.NewBroker(64))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream := openEvents(t, ctx, srv.URL, "kitchen-token", "")
+
+	postCommand(t, srv.URL, "bedroom-1")
+	postCommand(t, srv.URL, "kitchen-1")
+
+	for _, want := range []string{application.EventDispatch, application.EventAudit} {
+		frame := readFrame(t, stream)
+		var event application.Event
+		if err := json.Unmarshal([]byte(frame.data), &event); err != nil {
+			t.Fatalf("invalid event data %q: %v", frame.data, err)
+		}
+		if frame.event != want || event.DeviceID != "kitchen-1" {
+			t.Fatalf("expected %s for kitchen-1, got %s %+v", want, frame.event, event)
+		}
+	}
+}
+
+func TestEventsStreamResumesAfterLastEventID(t *testing.T) {
+	broker := events.NewBroker(64)
+	srv := newEventsServer(t, broker)
+	for _, id := range []string{"clock-1", "clock-2", "clock-3"} {
+		broker.Publish(application.Event{Type: application.EventPresence, DeviceID: id})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream := openEvents(t, ctx, srv.URL, "admin-token", "1")
+	for _, want := range []string{"2", "3"} {
+		if frame := readFrame(t, stream); frame.id != want {
+			t.Fatalf("expected event %s, got %+v", want, frame)
+		}
+	}
+}
+
+func TestEventsStreamSignalsEvictedEvents(t *testing.T) {
+	broker := events.NewBroker(2)
+	srv := newEventsServer(t, broker)
+	for i := 0; i < 5; i++ {
+		broker.Publish(application.Event{Type: application.EventPresence, DeviceID: "clock-1"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream := openEvents(t, ctx, srv.URL, "admin-token", "1")
+	if frame := readFrame(t, stream); frame.event != eventStreamReset {
+		t.Fatalf("expected reset, got %+v", frame)
+	}
+	if frame := readFrame(t, stream); frame.id != "4" {
+		t.Fatalf("expected oldest retained event 4, got %+v", frame)
+	}
+}
+
+func TestEventsRejectsInvalidLastEventID(t *testing.T) {
+	h := newTestHandler(&stubSender{}).WithEvents(events.NewBroker(8))
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("Last-Event-ID", "abc")
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"code":"invalid_last_event_id"`) {
+		t.Fatalf("expected 400 invalid_last_event_id, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestEventsNotConfigured(t *testing.T) {
+	h := newTestHandler(&stubSender{})
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), `"code":"events_not_configured"`) {
+		t.Fatalf("expected 404 events_not_configured, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCredentialChangesAreNotPublished(t *testing.T) {
+	broker := events.NewBroker(8)
+	store, err := security.OpenCredentialStore(filepath.Join(t.TempDir(), "credentials.json"), nil)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	admin, _ := security.NewAdminCredential("admin-token")
+	h := newTestHandler(&stubSender{}).WithEvents(broker).WithAdminCredential(admin).WithCredentialStore(store)
+	req := httptest.NewRequest(http.MethodPost, "/v1/admin/credentials", strings.NewReader(`{"id":"kiosk","devices":["*"],"owner":"facilities"}`))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	replay, sub, _ := broker.Subscribe(0)
+	sub.Close()
+	if len(replay) != 0 {
+		t.Fatalf("expected credential changes to stay off the event stream, got %+v", replay)
+	}
+}
@@ -22,7 +22,9 @@ Feature: Clock server command APIs
       {"deviceId":"clock-1","message":"hello","durationSeconds":0}
       """
     Then the response status should be 400
-    And the JSON response field "error" should equal "invalid command"
+    And the JSON response field "code" should equal "validation_failed"
+    And the JSON response field "detail" should equal "duration seconds must be greater than zero"
+    And the response header "Content-Type" should equal "application/problem+json"
     And exactly 0 command should be dispatched
 
   Scenario: Message command rejects unknown JSON fields
@@ -33,7 +35,8 @@ Feature: Clock server command APIs
       {"deviceId":"clock-1","message":"hello","durationSeconds":10,"unexpected":"value"}
       """
     Then the response status should be 400
-    And the JSON response field "error" should contain "unknown field"
+    And the JSON response field "code" should equal "invalid_body"
+    And the JSON response field "detail" should contain "unknown field"
     And exactly 0 command should be dispatched
 
   Scenario: Alarm command accepts valid payloads
@@ -56,7 +59,8 @@ Feature: Clock server command APIs
       {"deviceId":"clock-1","alarmTime":"tomorrow at seven","label":"wake up"}
       """
     Then the response status should be 400
-    And the JSON response field "error" should equal "alarmTime must be RFC3339"
+    And the JSON response field "code" should equal "validation_failed"
+    And the JSON response field "detail" should equal "alarmTime must be RFC3339"
     And exactly 0 command should be dispatched
 
   Scenario: Alarm command rejects past timestamps
@@ -67,7 +71,8 @@ Feature: Clock server command APIs
       {"deviceId":"clock-1","alarmTime":"2000-01-01T07:00:00Z","label":"wake up"}
       """
     Then the response status should be 400
-    And the JSON response field "error" should equal "invalid command"
+    And the JSON response field "code" should equal "validation_failed"
+    And the JSON response field "detail" should contain "is in the past"
     And exactly 0 command should be dispatched
 
   Scenario: Brightness command accepts valid payloads
@@ -97,7 +102,8 @@ Feature: Clock server command APIs
       {"deviceId":"clock-denied","message":"hello","durationSeconds":10}
       """
     Then the response status should be 403
-    And the JSON response field "error" should equal "forbidden for target device"
+    And the JSON response field "code" should equal "forbidden"
+    And the JSON response field "detail" should equal "forbidden for target device"
     And exactly 0 command should be dispatched
 
   Scenario: Downstream send failures return bad gateway
@@ -108,7 +114,8 @@ Feature: Clock server command APIs
       {"deviceId":"clock-1","message":"hello","durationSeconds":10}
       """
     Then the response status should be 502
-    And the JSON response field "error" should equal "command dispatch failed"
+    And the JSON response field "code" should equal "dispatch_failed"
+    And the JSON response field "detail" should equal "command dispatch failed"
     And exactly 1 command should be dispatched
 
   Scenario: Request body size limit is enforced
@@ -118,6 +125,19 @@ Feature: Clock server command APIs
       """
       {"deviceId":"clock-1","message":"hello","durationSeconds":10}
       """
-    Then the response status should be 400
-    And the JSON response field "error" should contain "request body too large"
+    Then the response status should be 413
+    And the JSON response field "code" should equal "body_too_large"
+    And the JSON response field "detail" should contain "request body too large"
     And exactly 0 command should be dispatched
+
+  Scenario: Generic command endpoint dispatches registered command types
+    Given the API handler is running
+    And I use bearer token "test-token"
+    When I send a "POST" request to "/commands/display_message" with JSON:
+      """
+      {"deviceId":"clock-1","message":"hello","durationSeconds":10}
+      """
+    Then the response status should be 202
+    And the JSON response field "result" should equal "sent"
+    And exactly 1 command should be dispatched
+    And the last dispatched command type should be "display_message"
@@ -9,7 +9,7 @@ Feature: Authentication and transport security
       {"deviceId":"clock-1","message":"hello","durationSeconds":10}
       """
     Then the response status should be 401
-    And the JSON response field "error" should equal "unauthorized"
+    And the JSON response field "code" should equal "unauthorized"
     And exactly 0 command should be dispatched
 
   Scenario: Command endpoint rejects invalid bearer token
@@ -20,7 +20,7 @@ Feature: Authentication and transport security
       {"deviceId":"clock-1","message":"hello","durationSeconds":10}
       """
     Then the response status should be 401
-    And the JSON response field "error" should equal "unauthorized"
+    And the JSON response field "code" should equal "unauthorized"
     And exactly 0 command should be dispatched
 
   Scenario: Auth failure rate limiter blocks repeated unauthorized calls
@@ -31,14 +31,14 @@ Feature: Authentication and transport security
     Then the response status should be 401
     When I send a "GET" request to "/ready"
     Then the response status should be 429
-    And the JSON response field "error" should equal "too many auth failures"
+    And the JSON response field "code" should equal "too_many_auth_failures"
 
   Scenario: TLS is required when enabled
     Given the API handler requires TLS
     And I use bearer token "test-token"
     When I send a "GET" request to "/ready"
     Then the response status should be 426
-    And the JSON response field "error" should equal "https required"
+    And the JSON response field "code" should equal "https_required"
 
   Scenario: Proxy TLS headers are honored when configured
     Given the API handler requires TLS and trusts proxy headers
@@ -0,0 +1,36 @@
+Feature: Idempotent command requests
+  Command endpoints should replay the original response for a repeated Idempotency-Key.
+
+  Scenario: Repeated request with the same key is dispatched once
+    Given the API handler is running
+    And I use bearer token "test-token"
+    And I set request header "Idempotency-Key" to "alarm-1"
+    When I send a "POST" request to "/commands/alarms" with JSON:
+      """
+      {"deviceId":"clock-1","alarmTime":"2099-01-01T07:00:00Z","label":"wake up"}
+      """
+    Then the response status should be 202
+    When I send a "POST" request to "/commands/alarms" with JSON:
+      """
+      {"deviceId":"clock-1","alarmTime":"2099-01-01T07:00:00Z","label":"wake up"}
+      """
+    Then the response status should be 202
+    And the JSON response field "result" should equal "scheduled"
+    And the response header "Idempotent-Replayed" should equal "true"
+    And exactly 1 command should be dispatched
+
+  Scenario: Reusing a key with a different body is rejected
+    Given the API handler is running
+    And I use bearer token "test-token"
+    And I set request header "Idempotency-Key" to "alarm-1"
+    When I send a "POST" request to "/commands/alarms" with JSON:
+      """
+      {"deviceId":"clock-1","alarmTime":"2099-01-01T07:00:00Z","label":"wake up"}
+      """
+    Then the response status should be 202
+    When I send a "POST" request to "/commands/alarms" with JSON:
+      """
+      {"deviceId":"clock-1","alarmTime":"2099-01-01T08:00:00Z","label":"wake up"}
+      """
+    Then the response status should be 422
+    And exactly 1 command should be dispatched
@@ -0,0 +1,33 @@
+Feature: Versioned API
+  Command routes are served under /v1; the unversioned routes keep working but are deprecated.
+
+  Scenario: Versioned command route is not deprecated
+    Given the API handler is running
+    And I use bearer token "test-token"
+    When I send a "POST" request to "/v1/commands/display_message" with JSON:
+      """
+      {"deviceId":"clock-1","message":"hello","durationSeconds":10}
+      """
+    Then the response status should be 202
+    And the JSON response field "result" should equal "sent"
+    And the response header "API-Version" should equal "v1"
+    And the response header "Deprecation" should equal ""
+    And exactly 1 command should be dispatched
+
+  Scenario: Legacy command route announces its successor
+    Given the API handler is running
+    And I use bearer token "test-token"
+    When I send a "POST" request to "/commands/messages" with JSON:
+      """
+      {"deviceId":"clock-1","message":"hello","durationSeconds":10}
+      """
+    Then the response status should be 202
+    And the response header "Deprecation" should have prefix "@"
+    And the response header "Link" should have prefix "</v1/commands/display_message>"
+    And exactly 1 command should be dispatched
+
+  Scenario: Version discovery does not require authentication
+    Given the API handler is running
+    When I send a "GET" request to "/versions"
+    Then the response status should be 200
+    And the JSON response field "preferred" should equal "v1"
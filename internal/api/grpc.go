@@ -0,0 +1,423 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
+	"github.com/paul/clock-server/internal/domain"
+	clockv1 "github.com/paul/clock-server/proto/clock/v1"
+)
+
+const (
+	// grpcErrorCodeKey is the trailer that carries the stable error code of a
+	// failed call, the same value as the problem "code" of the HTTP API.
+	grpcErrorCodeKey = "clock-error-code"
+	// grpcResponseType marks idempotency records of calls made over gRPC,
+	// whose body is an encoded CommandResponse.
+	grpcResponseType = "application/grpc"
+)
+
+// grpcCommands maps the command methods of ClockService onto registered
+// command types. Their request messages use the JSON field names of the
+// command type, so that both APIs share decoding and validation.
+var grpcCommands = map[string]string{
+	clockv1.ClockService_SetAlarm_FullMethodName:       "set_alarm",
+	clockv1.ClockService_DisplayMessage_FullMethodName: "display_message",
+	clockv1.ClockService_SetBrightness_FullMethodName:  "set_brightness",
+}
+
+// grpcError is a failed call: a gRPC status and, where one exists, the
+// stable error code of the HTTP API.
+type grpcError struct {
+	status    *status.Status
+	errorCode string
+}
+
+func grpcFailure(code codes.Code, errorCode, message string) error {
+	return &grpcError{status: status.New(code, message), errorCode: errorCode}
+}
+
+func (e *grpcError) Error() string { return e.status.Err().Error() }
+
+// GRPCStatus is the status grpc-go sends for the call.
+func (e *grpcError) GRPCStatus() *status.Status { return e.status }
+
+// grpcCall is the state the interceptors share about a call: the call as the
+// HTTP request read by the helpers shared with the HTTP API and, for command
+// methods, the decoded command and its delivery report.
+type grpcCall struct {
+	r      *http.Request
+	cmd    domain.ClockCommand
+	result string
+	report application.DeliveryReport
+}
+
+type grpcCallContextKey struct{}
+
+func grpcCallFrom(ctx context.Context) *grpcCall {
+	call, _ := ctx.Value(grpcCallContextKey{}).(*grpcCall)
+	return call
+}
+
+// request returns the call's request with ctx, which carries the principal
+// once the call is authenticated.
+func (c *grpcCall) request(ctx context.Context) *http.Request {
+	return c.r.WithContext(ctx)
+}
+
+// GRPCServer returns a server for the gRPC API with opts applied. It shares
+// the dispatcher, credentials, device scoping, rate limits, idempotency keys
+// and audit log with the HTTP API, and serves grpc.health.v1.Health on top
+// of the readiness checkers.
+func (h *Handler) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{
+		grpc.MaxRecvMsgSize(int(h.maxBodyBytes)),
+		grpc.ChainUnaryInterceptor(
+			h.grpcRequestInterceptor,
+			h.grpcAuthInterceptor,
+			h.grpcIdempotencyInterceptor,
+			h.grpcCommandInterceptor,
+			h.grpcAuditInterceptor,
+		),
+	}, opts...)
+	server := grpc.NewServer(opts...)
+	clockv1.RegisterClockServiceServer(server, grpcClockServer{h: h})
+	healthpb.RegisterHealthServer(server, grpcHealthServer{h: h})
+	return server
+}
+
+// grpcRequestInterceptor assigns the call its request ID, enforces
+// REQUIRE_TLS and sends the error code of a failed call as a trailer.
+func (h *Handler) grpcRequestInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	call := &grpcCall{r: grpcRequest(ctx, info.FullMethod)}
+	requestID := sanitizeRequestID(call.r.Header.Get("X-Request-Id"))
+	if strings.TrimSpace(requestID) == "" {
+		requestID = fmt.Sprintf("req-%d", atomic.AddUint64(&h.requestCounter, 1))
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs("x-request-id", requestID))
+
+	var resp any
+	var err error
+	if h.requireTLS && !h.isSecureRequest(call.r) {
+		err = grpcFailure(codes.FailedPrecondition, codeHTTPSRequired, "tls required")
+	} else {
+		resp, err = handler(context.WithValue(ctx, grpcCallContextKey{}, call), req)
+	}
+	var failure *grpcError
+	if errors.As(err, &failure) && failure.errorCode != "" {
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(grpcErrorCodeKey, failure.errorCode))
+	}
+	return resp, err
+}
+
+// grpcRequest describes a call as an HTTP request: its metadata as headers,
+// the peer address and the TLS connection state.
+func grpcRequest(ctx context.Context, fullMethod string) *http.Request {
+	r := &http.Request{
+		Method:     http.MethodPost,
+		URL:        &url.URL{Path: fullMethod},
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     make(http.Header),
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		if !strings.HasPrefix(key, ":") {
+			r.Header[textproto.CanonicalMIMEHeaderKey(key)] = values
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+	return r.WithContext(ctx)
+}
+
+// grpcAuthInterceptor authenticates calls with a bearer token in the
+// authorization metadata or, without one, a mapped client certificate.
+// Health checks, like GET /ready, only require it when
+// READINESS_REQUIRE_AUTH is set.
+func (h *Handler) grpcAuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if strings.HasPrefix(info.FullMethod, "/"+healthpb.Health_ServiceDesc.ServiceName+"/") && !h.readinessRequireAuth {
+		return handler(ctx, req)
+	}
+	pr, err := h.grpcAuthenticate(grpcCallFrom(ctx).request(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return handler(context.WithValue(ctx, principalContextKey, pr), req)
+}
+
+func (h *Handler) grpcAuthenticate(r *http.Request) (principal, error) {
+	token := bearerToken(r)
+	if token == "" {
+		if pr, ok := h.certPrincipal(r); ok {
+			return pr, nil
+		}
+	}
+	rateLimitKey := authRateLimitKey(clientIP(r), token)
+	if h.authBlocked(r.Context(), rateLimitKey) {
+		return principal{}, grpcFailure(codes.ResourceExhausted, codeTooManyAuthFailures, "too many auth failures")
+	}
+	pr, ok := principal{}, false
+	gate := h.newArgon2Gate(r.Context(), clientIP(r))
+	if token != "" {
+		pr, ok = h.lookupCredential(r.Context(), token, gate)
+		pr.CertIdentity = clientCertIdentity(r)
+	}
+	if !ok {
+		h.recordAuthFailure(r.Context(), rateLimitKey)
+		if gate.denied {
+			return principal{}, grpcFailure(codes.ResourceExhausted, codeTooManyAuthFailures, "too many auth failures")
+		}
+		return principal{}, grpcFailure(codes.Unauthenticated, codeUnauthorized, "unauthorized")
+	}
+	return pr, nil
+}
+
+// grpcIdempotencyInterceptor applies the Idempotency-Key semantics of the
+// HTTP API to command calls with idempotency-key metadata: a repeated call
+// replays the stored response. Only successful calls are stored; a failed
+// call releases the key so that the client can retry it.
+func (h *Handler) grpcIdempotencyInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	call := grpcCallFrom(ctx)
+	key := strings.TrimSpace(call.r.Header.Get(idempotencyKeyHeader))
+	if _, ok := grpcCommands[info.FullMethod]; !ok || h.idempotency == nil || key == "" {
+		return handler(ctx, req)
+	}
+	if !validIdempotencyKey(key) {
+		return nil, grpcFailure(codes.InvalidArgument, codeInvalidIdempotencyKey,
+			fmt.Sprintf("idempotency-key must be 1-%d printable ASCII characters", maxIdempotencyKeyLen))
+	}
+	msg, err := proto.MarshalOptions{Deterministic: true}.Marshal(req.(proto.Message))
+	if err != nil {
+		return nil, status.Error(codes.Internal, "request could not be encoded")
+	}
+	pr, _ := ctx.Value(principalContextKey).(principal)
+	requestHash := hashRequest(call.r.Method, call.r.URL.Path, msg)
+	record, reserved := h.idempotency.Reserve(pr.ID, key, requestHash)
+	if !reserved {
+		switch {
+		case record.RequestHash != requestHash:
+			return nil, grpcFailure(codes.FailedPrecondition, codeIdempotencyKeyReused, "idempotency key was already used with a different request")
+		case !record.Completed():
+			return nil, grpcFailure(codes.Aborted, codeIdempotencyKeyInFlight, "a request with this idempotency key is still in progress")
+		}
+		resp := new(clockv1.CommandResponse)
+		if err := proto.Unmarshal(record.Body, resp); err != nil {
+			return nil, status.Error(codes.Internal, "stored response could not be decoded")
+		}
+		_ = grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(idempotentReplayedHeader), "true"))
+		return resp, nil
+	}
+
+	resp, err := handler(application.WithIdempotencyKey(ctx, key), req)
+	if err != nil {
+		h.idempotency.Release(pr.ID, key)
+		return nil, err
+	}
+	body, err := proto.Marshal(resp.(proto.Message))
+	if err != nil {
+		h.idempotency.Release(pr.ID, key)
+		return nil, status.Error(codes.Internal, "response could not be encoded")
+	}
+	record.StatusCode = http.StatusOK
+	record.ContentType = grpcResponseType
+	record.Body = body
+	h.idempotency.Complete(record)
+	return resp, nil
+}
+
+// grpcCommandInterceptor decodes the request of a command method into its
+// command, checks the principal's command permission and device scope and
+// charges a valid command to the rate limits.
+func (h *Handler) grpcCommandInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	commandType, ok := grpcCommands[info.FullMethod]
+	if !ok {
+		return handler(ctx, req)
+	}
+	def, ok := commands.Lookup(commandType)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "command type %q is not registered", commandType)
+	}
+	body, err := protojson.Marshal(req.(proto.Message))
+	if err != nil {
+		return nil, grpcFailure(codes.InvalidArgument, codeInvalidBody, err.Error())
+	}
+	cmd, err := def.Decode(body)
+	if err != nil {
+		return nil, grpcProblem(describeError(err))
+	}
+	if err := h.authorizeCommand(ctx, def.Type, cmd.TargetDeviceID()); err != nil {
+		return nil, grpcFailure(codes.PermissionDenied, codeForbidden, err.Error())
+	}
+
+	call := grpcCallFrom(ctx)
+	// Invalid commands never reach a device, so they are rejected by the
+	// dispatcher without counting against the rate limits.
+	if cmd.Validate() == nil {
+		if limit, limited := h.chargeCommands(call.request(ctx), cmd); limited && limit.Exhausted {
+			h.audit(call.request(ctx), cmd, rateLimitCode(limit), application.DeliveryReport{})
+			failure := rateLimitProblem(limit)
+			return nil, grpcFailure(codes.ResourceExhausted, failure.Code, failure.Detail)
+		}
+	}
+	call.cmd, call.result = cmd, def.Result
+	return handler(ctx, req)
+}
+
+// grpcAuditInterceptor audits the outcome of every dispatched command with
+// the per-sender results of its delivery report.
+func (h *Handler) grpcAuditInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	resp, err := handler(ctx, req)
+	call := grpcCallFrom(ctx)
+	if call.cmd == nil {
+		return resp, err
+	}
+	result := auditAccepted
+	if err != nil {
+		result = auditFailed
+	}
+	h.audit(call.request(ctx), call.cmd, result, call.report)
+	return resp, err
+}
+
+// grpcClockServer implements ClockService. By the time a command method
+// runs, the interceptors have decoded and authorized its command.
+type grpcClockServer struct {
+	clockv1.UnimplementedClockServiceServer
+	h *Handler
+}
+
+func (s grpcClockServer) SetAlarm(ctx context.Context, _ *clockv1.SetAlarmRequest) (*clockv1.CommandResponse, error) {
+	return s.h.grpcDispatch(ctx)
+}
+
+func (s grpcClockServer) DisplayMessage(ctx context.Context, _ *clockv1.DisplayMessageRequest) (*clockv1.CommandResponse, error) {
+	return s.h.grpcDispatch(ctx)
+}
+
+func (s grpcClockServer) SetBrightness(ctx context.Context, _ *clockv1.SetBrightnessRequest) (*clockv1.CommandResponse, error) {
+	return s.h.grpcDispatch(ctx)
+}
+
+// grpcDispatch dispatches the command of the call and returns its response.
+func (h *Handler) grpcDispatch(ctx context.Context) (*clockv1.CommandResponse, error) {
+	call := grpcCallFrom(ctx)
+	if call == nil || call.cmd == nil {
+		return nil, status.Error(codes.Internal, "command was not decoded")
+	}
+	report, err := h.dispatcher.DispatchWithReport(ctx, call.cmd)
+	call.report = report
+	if err != nil {
+		failure := describeError(err)
+		code, message := grpcCode(failure.Status), failure.Detail
+		// A dispatch cut short by the deadline or by the client reports
+		// why, as gRPC clients retry on these codes differently.
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			code, message = codes.DeadlineExceeded, "deadline exceeded while dispatching the command"
+		case errors.Is(ctx.Err(), context.Canceled):
+			code, message = codes.Canceled, "call cancelled while dispatching the command"
+		}
+		return nil, grpcFailure(code, failure.Code, message)
+	}
+	resp := &clockv1.CommandResponse{Result: call.result}
+	for _, result := range deliveryResults(report) {
+		resp.Deliveries = append(resp.Deliveries, &clockv1.Delivery{
+			Sender:     result.Sender,
+			Status:     result.Status,
+			DurationMs: result.DurationMS,
+		})
+	}
+	return resp, nil
+}
+
+// GetCommandStatus reports a call made with an idempotency key by the same
+// principal for as long as the idempotency store keeps it.
+func (s grpcClockServer) GetCommandStatus(ctx context.Context, req *clockv1.CommandStatusRequest) (*clockv1.CommandStatus, error) {
+	if s.h.idempotency == nil {
+		return nil, status.Error(codes.Unimplemented, "idempotency keys are not enabled")
+	}
+	key := strings.TrimSpace(req.GetIdempotencyKey())
+	if key == "" || !validIdempotencyKey(key) {
+		return nil, grpcFailure(codes.InvalidArgument, codeInvalidIdempotencyKey,
+			fmt.Sprintf("idempotency_key must be 1-%d printable ASCII characters", maxIdempotencyKeyLen))
+	}
+	pr, _ := ctx.Value(principalContextKey).(principal)
+	record, ok := s.h.idempotency.Lookup(pr.ID, key)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "no command was sent with this idempotency key")
+	}
+	switch {
+	case !record.Completed():
+		return &clockv1.CommandStatus{State: "in_progress"}, nil
+	case record.ContentType == grpcResponseType:
+		resp := new(clockv1.CommandResponse)
+		if err := proto.Unmarshal(record.Body, resp); err != nil {
+			return nil, status.Error(codes.Internal, "stored response could not be decoded")
+		}
+		return &clockv1.CommandStatus{State: "completed", Response: resp}, nil
+	default:
+		// Sent through the HTTP API, which replays the JSON response.
+		return &clockv1.CommandStatus{State: "completed", HttpStatus: int32(record.StatusCode)}, nil
+	}
+}
+
+// grpcHealthServer implements grpc.health.v1.Health/Check on top of the
+// readiness checkers behind GET /ready.
+type grpcHealthServer struct {
+	healthpb.UnimplementedHealthServer
+	h *Handler
+}
+
+func (s grpcHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if service := req.GetService(); service != "" && service != clockv1.ClockService_ServiceDesc.ServiceName {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", service)
+	}
+	resp := &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}
+	for _, checker := range s.h.checkers {
+		if checker != nil && checker.Check(ctx) != nil {
+			resp.Status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+	}
+	return resp, nil
+}
+
+// grpcProblem maps an HTTP API problem onto the equivalent failed call.
+func grpcProblem(p problem) error {
+	return grpcFailure(grpcCode(p.Status), p.Code, p.Detail)
+}
+
+// grpcCode maps the HTTP status of a problem onto a gRPC status code.
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusRequestEntityTooLarge:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
@@ -0,0 +1,348 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/paul/clock-server/internal/adapters/composite"
+	"github.com/paul/clock-server/internal/adapters/idempotency"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
+	"github.com/paul/clock-server/internal/domain"
+	"github.com/paul/clock-server/internal/security"
+	clockv1 "github.com/paul/clock-server/proto/clock/v1"
+)
+
+// newGRPCTestConn serves h's gRPC API over an in-memory listener and returns
+// a client connection to it.
+func newGRPCTestConn(t *testing.T, h *Handler) *grpc.ClientConn {
+	t.Helper()
+	ln := bufconn.Listen(1 << 20)
+	server := h.GRPCServer()
+	go func() { _ = server.Serve(ln) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func newGRPCTestClient(t *testing.T, h *Handler) clockv1.ClockServiceClient {
+	t.Helper()
+	return clockv1.NewClockServiceClient(newGRPCTestConn(t, h))
+}
+
+func newScopedGRPCHandler(sender application.ClockCommandSender, checkers ...application.ReadinessChecker) *Handler {
+	return NewHandler(
+		application.NewCommandDispatcher(sender),
+		[]security.Credential{{ID: "lobby", Token: "lobby-token", Devices: []string{"lobby-*"}}},
+		false,
+		false,
+		false,
+		64*1024,
+		100,
+		checkers...,
+	)
+}
+
+// withMetadata returns a call context with the token and any further
+// metadata key/value pairs.
+func withMetadata(token string, kv ...string) context.Context {
+	if token != "" {
+		kv = append(kv, "authorization", "Bearer "+token)
+	}
+	return metadata.NewOutgoingContext(context.Background(), metadata.Pairs(kv...))
+}
+
+// errorCode returns the clock-error-code trailer of a call.
+func errorCode(trailer metadata.MD) string {
+	if values := trailer.Get(grpcErrorCodeKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func TestGRPCSetBrightness(t *testing.T) {
+	sender := &stubSender{}
+	client := newGRPCTestClient(t, newScopedGRPCHandler(sender))
+
+	var header metadata.MD
+	resp, err := client.SetBrightness(withMetadata("lobby-token", "x-request-id", "req-grpc-1"),
+		&clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 40}, grpc.Header(&header))
+	if err != nil {
+		t.Fatalf("expected OK, got %v", err)
+	}
+	got, ok := sender.lastCmd.(domain.SetBrightnessCommand)
+	if !ok || got.DeviceID != "lobby-1" || got.Level != 40 {
+		t.Fatalf("unexpected command %#v", sender.lastCmd)
+	}
+	if resp.GetResult() != "updated" {
+		t.Fatalf("expected result updated, got %q", resp.GetResult())
+	}
+	if ids := header.Get("x-request-id"); len(ids) != 1 || ids[0] != "req-grpc-1" {
+		t.Fatalf("expected the request ID to be echoed, got %v", ids)
+	}
+}
+
+func TestGRPCSetAlarmReturnsDeliveries(t *testing.T) {
+	sender, err := composite.NewPolicySender(composite.Options{Policy: composite.PolicyAny},
+		composite.Target{Name: "mqtt", Sender: &stubSender{}},
+		composite.Target{Name: "rest", Sender: &stubSender{err: errors.New("rest down")}},
+	)
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	client := newGRPCTestClient(t, newScopedGRPCHandler(sender))
+
+	resp, err := client.SetAlarm(withMetadata("lobby-token"), &clockv1.SetAlarmRequest{
+		DeviceId:  "lobby-1",
+		AlarmTime: time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		Label:     "wake up",
+	})
+	if err != nil {
+		t.Fatalf("expected OK, got %v", err)
+	}
+	statuses := map[string]string{}
+	for _, delivery := range resp.GetDeliveries() {
+		statuses[delivery.GetSender()] = delivery.GetStatus()
+	}
+	if resp.GetResult() != "scheduled" || statuses["mqtt"] != application.DeliveryDelivered || statuses["rest"] != application.DeliveryFailed {
+		t.Fatalf("unexpected response %v", resp)
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	cases := []struct {
+		name      string
+		sender    *stubSender
+		token     string
+		req       *clockv1.SetBrightnessRequest
+		code      codes.Code
+		errorCode string
+	}{
+		{name: "no token", req: &clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 1}, code: codes.Unauthenticated, errorCode: codeUnauthorized},
+		{name: "bad token", token: "nope", req: &clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 1}, code: codes.Unauthenticated, errorCode: codeUnauthorized},
+		{name: "out of scope", token: "lobby-token", req: &clockv1.SetBrightnessRequest{DeviceId: "office-1", Level: 1}, code: codes.PermissionDenied, errorCode: codeForbidden},
+		{name: "invalid", token: "lobby-token", req: &clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 101}, code: codes.InvalidArgument, errorCode: codeValidationFailed},
+		{name: "downstream", sender: &stubSender{err: errors.New("broker down")}, token: "lobby-token", req: &clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 1}, code: codes.Unavailable, errorCode: codeDispatchFailed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sender := tc.sender
+			if sender == nil {
+				sender = &stubSender{}
+			}
+			client := newGRPCTestClient(t, newScopedGRPCHandler(sender))
+			var trailer metadata.MD
+			_, err := client.SetBrightness(withMetadata(tc.token), tc.req, grpc.Trailer(&trailer))
+			if status.Code(err) != tc.code || errorCode(trailer) != tc.errorCode {
+				t.Fatalf("expected %s code %q, got %v (%q)", tc.code, tc.errorCode, err, errorCode(trailer))
+			}
+		})
+	}
+}
+
+func TestGRPCUnknownMethod(t *testing.T) {
+	conn := newGRPCTestConn(t, newScopedGRPCHandler(&stubSender{}))
+	err := conn.Invoke(withMetadata("lobby-token"), "/clock.v1.ClockService/Reboot", &clockv1.SetBrightnessRequest{}, &clockv1.CommandResponse{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Fatalf("expected UNIMPLEMENTED, got %v", err)
+	}
+}
+
+func TestGRPCRequiresTLS(t *testing.T) {
+	h := NewHandler(
+		application.NewCommandDispatcher(&stubSender{}),
+		[]security.Credential{{ID: "lobby", Token: "lobby-token", Devices: []string{"lobby-*"}}},
+		false,
+		true,
+		false,
+		64*1024,
+		100,
+	)
+	client := newGRPCTestClient(t, h)
+	var trailer metadata.MD
+	_, err := client.SetBrightness(withMetadata("lobby-token"), &clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 1}, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.FailedPrecondition || errorCode(trailer) != codeHTTPSRequired {
+		t.Fatalf("expected FAILED_PRECONDITION https_required, got %v (%q)", err, errorCode(trailer))
+	}
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	limits, _ := security.ParseRateLimits("set_brightness=1/m")
+	client := newGRPCTestClient(t, newScopedGRPCHandler(&stubSender{}).WithRateLimits(limits, nil, nil))
+
+	if _, err := client.SetBrightness(withMetadata("lobby-token"), &clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 10}); err != nil {
+		t.Fatalf("expected OK, got %v", err)
+	}
+	var trailer metadata.MD
+	_, err := client.SetBrightness(withMetadata("lobby-token"), &clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 20}, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.ResourceExhausted || errorCode(trailer) != codeRateLimited {
+		t.Fatalf("expected RESOURCE_EXHAUSTED rate_limited, got %v (%q)", err, errorCode(trailer))
+	}
+}
+
+func TestGRPCCommandsAreAudited(t *testing.T) {
+	h, auditLog := newAuditedHandler(t, "")
+	client := newGRPCTestClient(t, h)
+
+	if _, err := client.DisplayMessage(withMetadata("ops-token", "x-request-id", "req-grpc-audit"),
+		&clockv1.DisplayMessageRequest{DeviceId: "clock-1", Message: "hello", DurationSeconds: 5}); err != nil {
+		t.Fatalf("expected OK, got %v", err)
+	}
+	entries, err := auditLog.Query(application.AuditQuery{})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one audit entry, got %+v (%v)", entries, err)
+	}
+	e := entries[0]
+	if e.Principal != "ops" || e.RequestID != "req-grpc-audit" || e.Path != clockv1.ClockService_DisplayMessage_FullMethodName ||
+		e.DeviceID != "clock-1" || e.CommandType != "display_message" || e.Result != auditAccepted || len(e.Deliveries) != 2 {
+		t.Fatalf("unexpected audit entry %+v", e)
+	}
+}
+
+func TestGRPCHealthCheck(t *testing.T) {
+	checker := &stubChecker{}
+	health := healthpb.NewHealthClient(newGRPCTestConn(t, newScopedGRPCHandler(&stubSender{}, checker)))
+
+	resp, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected SERVING, got %v %v", resp.GetStatus(), err)
+	}
+	checker.err = errors.New("mqtt not connected")
+	resp, err = health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: clockv1.ClockService_ServiceDesc.ServiceName})
+	if err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("expected NOT_SERVING, got %v %v", resp.GetStatus(), err)
+	}
+	if _, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "other.Service"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NOT_FOUND for an unknown service, got %v", err)
+	}
+}
+
+// Every registered command type must be callable over gRPC with a request
+// field for each of its fields, and every method must name a registered
+// type.
+func TestGRPCCoversCommandRegistry(t *testing.T) {
+	requests := map[string]protoreflect.MessageDescriptor{}
+	methods := clockv1.File_clock_proto.Services().ByName("ClockService").Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		requests["/"+string(method.Parent().FullName())+"/"+string(method.Name())] = method.Input()
+	}
+	covered := map[string]bool{}
+	for method, commandType := range grpcCommands {
+		def, ok := commands.Lookup(commandType)
+		if !ok {
+			t.Errorf("%s maps to unregistered command type %q", method, commandType)
+			continue
+		}
+		request, ok := requests[method]
+		if !ok {
+			t.Errorf("%s is not a ClockService method", method)
+			continue
+		}
+		covered[commandType] = true
+		for _, field := range def.Fields {
+			if request.Fields().ByJSONName(field.Name) == nil {
+				t.Errorf("%s has no request field for %q", method, field.Name)
+			}
+		}
+	}
+	for _, def := range commands.All() {
+		if !covered[def.Type] {
+			t.Errorf("command type %q has no gRPC method", def.Type)
+		}
+	}
+}
+
+func TestGRPCIdempotencyKeyAndCommandStatus(t *testing.T) {
+	sender := &stubSender{}
+	client := newGRPCTestClient(t, newScopedGRPCHandler(sender).WithIdempotency(idempotency.NewMemoryStore(time.Hour, 100)))
+	ctx := withMetadata("lobby-token", "idempotency-key", "key-1")
+
+	first, err := client.SetBrightness(ctx, &clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 40})
+	if err != nil {
+		t.Fatalf("expected OK, got %v", err)
+	}
+	var header metadata.MD
+	second, err := client.SetBrightness(ctx, &clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 40}, grpc.Header(&header))
+	if err != nil || !proto.Equal(first, second) {
+		t.Fatalf("expected the response to be replayed, got %v %v", second, err)
+	}
+	if sender.calls != 1 || len(header.Get("idempotent-replayed")) != 1 {
+		t.Fatalf("expected one dispatch and a replayed response, got %d calls", sender.calls)
+	}
+	var trailer metadata.MD
+	_, err = client.SetBrightness(ctx, &clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 41}, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.FailedPrecondition || errorCode(trailer) != codeIdempotencyKeyReused {
+		t.Fatalf("expected FAILED_PRECONDITION for a reused key, got %v (%q)", err, errorCode(trailer))
+	}
+
+	commandStatus, err := client.GetCommandStatus(withMetadata("lobby-token"), &clockv1.CommandStatusRequest{IdempotencyKey: "key-1"})
+	if err != nil {
+		t.Fatalf("expected OK, got %v", err)
+	}
+	if commandStatus.GetState() != "completed" || !proto.Equal(commandStatus.GetResponse(), first) {
+		t.Fatalf("expected the completed call's response, got %v", commandStatus)
+	}
+	if _, err := client.GetCommandStatus(withMetadata("lobby-token"), &clockv1.CommandStatusRequest{IdempotencyKey: "key-2"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NOT_FOUND for an unknown key, got %v", err)
+	}
+}
+
+func TestGRPCFailedCallReleasesIdempotencyKey(t *testing.T) {
+	sender := &stubSender{err: errors.New("broker down")}
+	client := newGRPCTestClient(t, newScopedGRPCHandler(sender).WithIdempotency(idempotency.NewMemoryStore(time.Hour, 100)))
+	ctx := withMetadata("lobby-token", "idempotency-key", "key-1")
+
+	if _, err := client.SetBrightness(ctx, &clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 40}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected UNAVAILABLE, got %v", err)
+	}
+	sender.err = nil
+	if _, err := client.SetBrightness(ctx, &clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 40}); err != nil {
+		t.Fatalf("expected the retry to be dispatched, got %v", err)
+	}
+	if sender.calls != 2 {
+		t.Fatalf("expected two dispatches, got %d", sender.calls)
+	}
+}
+
+func TestGRPCReportsDeadlineExceeded(t *testing.T) {
+	client := newGRPCTestClient(t, newScopedGRPCHandler(blockingSender{}))
+	ctx, cancel := context.WithTimeout(withMetadata("lobby-token"), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.SetBrightness(ctx, &clockv1.SetBrightnessRequest{DeviceId: "lobby-1", Level: 40}); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DEADLINE_EXCEEDED, got %v", err)
+	}
+}
+
+// blockingSender waits until the call's context ends.
+type blockingSender struct{}
+
+func (blockingSender) Send(ctx context.Context, _ domain.ClockCommand) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+type stubChecker struct{ err error }
+
+func (c *stubChecker) Check(context.Context) error { return c.err }
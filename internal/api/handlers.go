@@ -3,14 +3,16 @@ package api
 import (
 	"context"
 	"crypto/sha256"
-	"crypto/subtle"
+	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -18,6 +20,7 @@ import (
 	"time"
 
 	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
 	"github.com/paul/clock-server/internal/domain"
 	"github.com/paul/clock-server/internal/security"
 )
@@ -32,15 +35,35 @@ const (
 
 // Handler exposes REST endpoints for issuing smart clock commands.
 type Handler struct {
-	dispatcher             *application.CommandDispatcher
-	credentials            []security.Credential
-	trustProxyTLS          bool
-	requireTLS             bool
-	readinessRequireAuth   bool
-	maxBodyBytes           int64
-	authFailureRateLimiter *authFailureLimiter
-	requestCounter         uint64
-	checkers               []application.ReadinessChecker
+	dispatcher           *application.CommandDispatcher
+	credentials          atomic.Pointer[security.CredentialIndex]
+	tokenPepper          []byte
+	adminCredential      *security.CredentialIndex
+	store                *security.CredentialStore
+	jwt                  *security.JWTVerifier
+	certPrincipals       []security.CertPrincipal
+	policy               security.Policy
+	trustProxyTLS        bool
+	requireTLS           bool
+	readinessRequireAuth bool
+	maxBodyBytes         int64
+	authFailLimit        int
+	limiter              application.RateLimiter
+	fallbackLimiter      *memoryLimiter
+	limiterErrorAt       atomic.Int64
+	limiterErrors        atomic.Uint64
+	limiterDegraded      atomic.Bool
+	rateLimits           commandLimits
+	auditLog             application.AuditLog
+	requestCounter       uint64
+	checkers             []application.ReadinessChecker
+	router               application.CommandRouter
+	metrics              []application.MetricsProvider
+	idempotency          application.IdempotencyStore
+	legacySunset         time.Time
+	events               application.EventStream
+	devices              DeviceChannel
+	now                  func() time.Time
 }
 
 // NewHandler builds a new API handler.
@@ -60,49 +83,169 @@ func NewHandler(
 	if authFailLimitPerMinute <= 0 {
 		authFailLimitPerMinute = 60
 	}
-	return &Handler{
-		dispatcher:             dispatcher,
-		credentials:            credentials,
-		trustProxyTLS:          trustProxyTLS,
-		requireTLS:             requireTLS,
-		readinessRequireAuth:   readinessRequireAuth,
-		maxBodyBytes:           maxBodyBytes,
-		authFailureRateLimiter: newAuthFailureLimiter(authFailLimitPerMinute),
-		checkers:               checkers,
+	h := &Handler{
+		dispatcher:           dispatcher,
+		trustProxyTLS:        trustProxyTLS,
+		requireTLS:           requireTLS,
+		readinessRequireAuth: readinessRequireAuth,
+		maxBodyBytes:         maxBodyBytes,
+		authFailLimit:        authFailLimitPerMinute,
+		limiter:              newMemoryLimiter(),
+		checkers:             checkers,
+		now:                  time.Now,
+	}
+	h.credentials.Store(security.NewCredentialIndex(credentials, nil))
+	return h
+}
+
+// WithTokenPepper sets the pepper that HMAC-SHA256 credential hashes were
+// created with.
+func (h *Handler) WithTokenPepper(pepper []byte) *Handler {
+	h.tokenPepper = pepper
+	h.ReplaceCredentials(h.credentials.Load().Credentials())
+	if h.adminCredential != nil {
+		h.adminCredential = security.NewCredentialIndex(h.adminCredential.Credentials(), pepper)
 	}
+	return h
+}
+
+// ReplaceCredentials atomically swaps the static credentials. Requests
+// authenticated before the swap keep the principal they were given.
+func (h *Handler) ReplaceCredentials(credentials []security.Credential) {
+	h.credentials.Store(security.NewCredentialIndex(credentials, h.tokenPepper))
+}
+
+// Credentials returns the current static credentials followed by those in
+// the credential store.
+func (h *Handler) Credentials() []security.Credential {
+	creds := h.credentials.Load().Credentials()
+	if h.store != nil {
+		creds = append(slices.Clone(creds), h.store.Credentials()...)
+	}
+	return creds
+}
+
+// WithJWTVerifier accepts JWT bearer tokens verified by v, in addition to
+// the static credentials.
+func (h *Handler) WithJWTVerifier(v *security.JWTVerifier) *Handler {
+	h.jwt = v
+	return h
+}
+
+// WithClientCertPrincipals authenticates requests without a bearer token by
+// their verified client certificate, mapped through principals.
+func (h *Handler) WithClientCertPrincipals(principals []security.CertPrincipal) *Handler {
+	h.certPrincipals = principals
+	return h
+}
+
+// WithPolicy sets the role definitions that credential roles refer to.
+// Without it only the predefined admin role is known.
+func (h *Handler) WithPolicy(policy security.Policy) *Handler {
+	h.policy = policy
+	return h
+}
+
+// WithRouter enables the routing debug endpoint backed by router.
+func (h *Handler) WithRouter(router application.CommandRouter) *Handler {
+	h.router = router
+	return h
 }
 
 // Routes returns the HTTP router for the command API.
 func (h *Handler) Routes() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/health", h.handleHealth)
-	mux.HandleFunc("/ready", h.handleReady)
-	mux.HandleFunc("/commands/alarms", h.handleSetAlarm)
-	mux.HandleFunc("/commands/messages", h.handleDisplayMessage)
-	mux.HandleFunc("/commands/brightness", h.handleSetBrightness)
+	for _, rt := range h.routes() {
+		mux.HandleFunc(rt.pattern, rt.handler)
+	}
 	return h.authMiddleware(mux)
 }
 
-type setAlarmRequest struct {
-	DeviceID  string `json:"deviceId"`
-	AlarmTime string `json:"alarmTime"`
-	Label     string `json:"label"`
+// route is a registered endpoint. path is the OpenAPI path template when it
+// differs from the mux pattern, as for prefix patterns.
+type route struct {
+	pattern string
+	path    string
+	methods []string
+	handler http.HandlerFunc
 }
 
-type displayMessageRequest struct {
-	DeviceID        string `json:"deviceId"`
-	Message         string `json:"message"`
-	DurationSeconds int    `json:"durationSeconds"`
+// routes lists every endpoint. The OpenAPI document must describe exactly
+// these paths and methods.
+func (h *Handler) routes() []route {
+	routes := []route{
+		{pattern: "/health", methods: []string{http.MethodGet}, handler: h.handleHealth},
+		{pattern: "/ready", methods: []string{http.MethodGet}, handler: h.handleReady},
+		{pattern: "/openapi.json", methods: []string{http.MethodGet}, handler: h.handleOpenAPI},
+		{pattern: "/versions", methods: []string{http.MethodGet}, handler: h.handleVersions},
+		{pattern: "/metrics", methods: []string{http.MethodGet}, handler: h.handleMetrics},
+		{pattern: "/events", methods: []string{http.MethodGet}, handler: h.handleEvents},
+		{pattern: "/devices/connect", methods: []string{http.MethodGet}, handler: h.handleDeviceConnect},
+	}
+	for _, version := range apiVersions {
+		for _, rt := range h.apiRoutes() {
+			rt.pattern = version.prefix + rt.pattern
+			if rt.path != "" {
+				rt.path = version.prefix + rt.path
+			}
+			rt.handler = versioned(version, rt.handler)
+			routes = append(routes, rt)
+		}
+	}
+	for _, version := range apiVersions {
+		for _, rt := range h.adminRoutes() {
+			rt.pattern = version.prefix + rt.pattern
+			if rt.path != "" {
+				rt.path = version.prefix + rt.path
+			}
+			rt.handler = versioned(version, rt.handler)
+			routes = append(routes, rt)
+		}
+	}
+	for _, rt := range h.apiRoutes() {
+		rt.handler = h.legacy("", rt.handler)
+		routes = append(routes, rt)
+	}
+	return append(routes, h.legacyAliasRoutes()...)
 }
 
-type setBrightnessRequest struct {
-	DeviceID string `json:"deviceId"`
-	Level    int    `json:"level"`
+// apiRoutes are the versioned endpoints, relative to the version prefix.
+func (h *Handler) apiRoutes() []route {
+	return []route{
+		{pattern: "/commands/batch", methods: []string{http.MethodPost}, handler: h.idempotent(h.handleBatch)},
+		{pattern: "/commands/", path: "/commands/{type}", methods: []string{http.MethodPost}, handler: h.idempotent(h.handleCommand)},
+		{pattern: "/debug/routing", methods: []string{http.MethodGet}, handler: h.handleDebugRouting},
+	}
+}
+
+// legacyAliasRoutes are the per-type command endpoints that predate
+// /commands/{type}. They are only served unversioned.
+func (h *Handler) legacyAliasRoutes() []route {
+	alias := func(path, method, commandType string) route {
+		successor := preferredAPIVersion().prefix + "/commands/" + commandType
+		return route{
+			pattern: path,
+			methods: []string{method},
+			handler: h.legacy(successor, h.idempotent(h.commandAlias(method, commandType))),
+		}
+	}
+	return []route{
+		alias("/commands/alarms", http.MethodPost, "set_alarm"),
+		alias("/commands/messages", http.MethodPost, "display_message"),
+		alias("/commands/brightness", http.MethodPut, "set_brightness"),
+	}
 }
 
 type principal struct {
 	ID      string
 	Devices []string
+	Roles   []string
+	// CertIdentity is the identity of the verified client certificate the
+	// request arrived with, if any.
+	CertIdentity string
+	// Admin reports whether the request authenticated with the admin
+	// credential (ADMIN_AUTH_TOKEN), the only one that manages credentials.
+	Admin bool
 }
 
 func (h *Handler) handleHealth(w http.ResponseWriter, _ *http.Request) {
@@ -114,144 +257,191 @@ func (h *Handler) handleReady(w http.ResponseWriter, r *http.Request) {
 		methodNotAllowed(w)
 		return
 	}
+	status, code := "ready", http.StatusOK
+	details := map[string]any{}
 	for _, checker := range h.checkers {
 		if checker == nil {
 			continue
 		}
 		if err := checker.Check(r.Context()); err != nil {
-			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not_ready"})
-			return
+			status, code = "not_ready", http.StatusServiceUnavailable
+		}
+		if reporter, ok := checker.(application.ReadinessReporter); ok {
+			for component, detail := range reporter.ReadinessDetails() {
+				details[component] = detail
+			}
 		}
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	if h.fallbackLimiter != nil {
+		details["rateLimiter"] = map[string]any{"degraded": h.limiterDegraded.Load()}
+	}
+	writeJSON(w, code, readinessResponse{Status: status, Details: details})
 }
 
-func (h *Handler) handleSetAlarm(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+type readinessResponse struct {
+	Status  string         `json:"status"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type routingDecisionResponse struct {
+	DeviceID string   `json:"deviceId"`
+	Type     string   `json:"type"`
+	Rule     string   `json:"rule"`
+	Policy   string   `json:"policy"`
+	Senders  []string `json:"senders"`
+}
+
+func (h *Handler) handleDebugRouting(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		methodNotAllowed(w)
 		return
 	}
-
-	var payload setAlarmRequest
-	if err := h.decodeJSON(w, r, &payload); err != nil {
-		writeError(w, http.StatusBadRequest, err)
+	if h.router == nil {
+		writeError(w, http.StatusNotFound, codeRoutingNotConfigured, "routing is not configured")
 		return
 	}
-	if err := h.authorizeDevice(r.Context(), payload.DeviceID); err != nil {
-		writeError(w, http.StatusForbidden, err)
+	deviceID := strings.TrimSpace(r.URL.Query().Get("deviceId"))
+	commandType := strings.TrimSpace(r.URL.Query().Get("type"))
+	if deviceID == "" || commandType == "" {
+		writeError(w, http.StatusBadRequest, codeMissingParameter, "deviceId and type query parameters are required")
 		return
 	}
-
-	alarmTime, err := time.Parse(time.RFC3339, payload.AlarmTime)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("alarmTime must be RFC3339"))
+	if err := h.authorize(r.Context(), security.PermissionRoutingRead); err != nil {
+		writeError(w, http.StatusForbidden, codeForbidden, err.Error())
 		return
 	}
-
-	cmd := domain.SetAlarmCommand{
-		DeviceID:  payload.DeviceID,
-		AlarmTime: alarmTime,
-		Label:     payload.Label,
-	}
-	if err := h.dispatcher.Dispatch(r.Context(), cmd); err != nil {
-		h.audit(r, payload.DeviceID, cmd.CommandType(), "failed")
-		writeAppError(w, err)
+	if err := h.authorizeDevice(r.Context(), deviceID); err != nil {
+		writeError(w, http.StatusForbidden, codeForbidden, err.Error())
 		return
 	}
 
-	h.audit(r, payload.DeviceID, cmd.CommandType(), "accepted")
-	writeJSON(w, http.StatusAccepted, map[string]string{"result": "scheduled"})
+	decision := h.router.Route(deviceID, commandType)
+	writeJSON(w, http.StatusOK, routingDecisionResponse{
+		DeviceID: deviceID,
+		Type:     commandType,
+		Rule:     decision.Rule,
+		Policy:   decision.Policy,
+		Senders:  decision.Senders,
+	})
 }
 
-func (h *Handler) handleDisplayMessage(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		methodNotAllowed(w)
-		return
+// commandAlias serves one command type on its legacy endpoint and method.
+func (h *Handler) commandAlias(method, commandType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			methodNotAllowed(w)
+			return
+		}
+		def, _ := commands.Lookup(commandType)
+		h.decodeAndDispatch(w, r, def)
 	}
+}
 
-	var payload displayMessageRequest
-	if err := h.decodeJSON(w, r, &payload); err != nil {
-		writeError(w, http.StatusBadRequest, err)
+// handleCommand serves POST /commands/{type} for every registered command type.
+func (h *Handler) handleCommand(w http.ResponseWriter, r *http.Request) {
+	commandType := commandTypeFromPath(r)
+	def, ok := commands.Lookup(commandType)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeUnknownCommandType, fmt.Sprintf("unknown command type %q", commandType))
 		return
 	}
-	if err := h.authorizeDevice(r.Context(), payload.DeviceID); err != nil {
-		writeError(w, http.StatusForbidden, err)
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
 		return
 	}
+	h.decodeAndDispatch(w, r, def)
+}
 
-	cmd := domain.DisplayMessageCommand{
-		DeviceID:        payload.DeviceID,
-		Message:         payload.Message,
-		DurationSeconds: payload.DurationSeconds,
+func (h *Handler) decodeAndDispatch(w http.ResponseWriter, r *http.Request, def commands.Definition) {
+	body, err := h.readBody(w, r)
+	if err != nil {
+		writeAppError(w, err)
+		return
 	}
-	if err := h.dispatcher.Dispatch(r.Context(), cmd); err != nil {
-		h.audit(r, payload.DeviceID, cmd.CommandType(), "failed")
+	cmd, err := def.Decode(body)
+	if err != nil {
 		writeAppError(w, err)
 		return
 	}
+	if err := h.authorizeCommand(r.Context(), def.Type, cmd.TargetDeviceID()); err != nil {
+		writeError(w, http.StatusForbidden, codeForbidden, err.Error())
+		return
+	}
+	h.dispatch(w, r, cmd, def.Result)
+}
 
-	h.audit(r, payload.DeviceID, cmd.CommandType(), "accepted")
-	writeJSON(w, http.StatusAccepted, map[string]string{"result": "sent"})
+type commandResponse struct {
+	Result     string           `json:"result,omitempty"`
+	Deliveries []deliveryResult `json:"deliveries,omitempty"`
 }
 
-func (h *Handler) handleSetBrightness(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		methodNotAllowed(w)
-		return
-	}
+type deliveryResult struct {
+	Sender     string `json:"sender"`
+	Status     string `json:"status"`
+	DurationMS int64  `json:"durationMs"`
+}
 
-	var payload setBrightnessRequest
-	if err := h.decodeJSON(w, r, &payload); err != nil {
-		writeError(w, http.StatusBadRequest, err)
-		return
+// dispatch sends a decoded command, audits the outcome and writes the response,
+// including the per-sender delivery results when the sender reports them.
+func (h *Handler) dispatch(w http.ResponseWriter, r *http.Request, cmd domain.ClockCommand, result string) {
+	// Invalid commands never reach a device, so they are rejected by the
+	// dispatcher without counting against the rate limits.
+	if cmd.Validate() == nil {
+		if status, ok := h.allowCommand(w, r, cmd); !ok {
+			writeProblem(w, rateLimitProblem(status))
+			return
+		}
 	}
-	if err := h.authorizeDevice(r.Context(), payload.DeviceID); err != nil {
-		writeError(w, http.StatusForbidden, err)
+	report, err := h.dispatcher.DispatchWithReport(r.Context(), cmd)
+	deliveries := deliveryResults(report)
+	if err != nil {
+		h.audit(r, cmd, auditFailed, report)
+		failure := describeError(err)
+		failure.Deliveries = deliveries
+		writeProblem(w, failure)
 		return
 	}
 
-	cmd := domain.SetBrightnessCommand{
-		DeviceID: payload.DeviceID,
-		Level:    payload.Level,
+	h.audit(r, cmd, auditAccepted, report)
+	writeJSON(w, http.StatusAccepted, commandResponse{Result: result, Deliveries: deliveries})
+}
+
+func deliveryResults(report application.DeliveryReport) []deliveryResult {
+	if len(report.Results) == 0 {
+		return nil
 	}
-	if err := h.dispatcher.Dispatch(r.Context(), cmd); err != nil {
-		h.audit(r, payload.DeviceID, cmd.CommandType(), "failed")
-		writeAppError(w, err)
-		return
+	out := make([]deliveryResult, 0, len(report.Results))
+	for _, result := range report.Results {
+		out = append(out, deliveryResult{
+			Sender:     result.Sender,
+			Status:     result.Status,
+			DurationMS: result.Duration.Milliseconds(),
+		})
 	}
-
-	h.audit(r, payload.DeviceID, cmd.CommandType(), "accepted")
-	writeJSON(w, http.StatusAccepted, map[string]string{"result": "updated"})
+	return out
 }
 
 func (h *Handler) decodeJSON(w http.ResponseWriter, r *http.Request, out any) error {
-	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodyBytes)
-	defer r.Body.Close()
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
-	if err := decoder.Decode(out); err != nil {
-		return fmt.Errorf("invalid request body: %w", err)
+	body, err := h.readBody(w, r)
+	if err != nil {
+		return err
 	}
-	return nil
-}
-
-func methodNotAllowed(w http.ResponseWriter) {
-	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	return commands.DecodeStrict(body, out)
 }
 
-func writeError(w http.ResponseWriter, status int, err error) {
-	writeJSON(w, status, map[string]string{"error": err.Error()})
-}
-
-func writeAppError(w http.ResponseWriter, err error) {
-	switch {
-	case errors.Is(err, application.ErrValidation):
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid command"})
-	case errors.Is(err, application.ErrDownstream):
-		writeJSON(w, http.StatusBadGateway, map[string]string{"error": "command dispatch failed"})
-	default:
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+// readBody reads the request body up to the configured limit.
+func (h *Handler) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodyBytes)
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		return nil, commands.BodyError{Message: "request body could not be read"}
 	}
+	return body, nil
 }
 
 func writeJSON(w http.ResponseWriter, status int, payload any) {
@@ -276,12 +466,14 @@ func (h *Handler) authMiddleware(next http.Handler) http.Handler {
 		w.Header().Set("X-Request-Id", requestID)
 
 		if h.requireTLS && !h.isSecureRequest(r) {
-			writeJSON(w, http.StatusUpgradeRequired, map[string]string{"error": "https required"})
+			writeError(w, http.StatusUpgradeRequired, codeHTTPSRequired, "https required")
 			return
 		}
 
-		// /health is intentionally exempt from authentication but not from TLS enforcement
-		if r.URL.Path == "/health" {
+		// /health, the API description and version discovery are
+		// intentionally exempt from authentication but not from TLS
+		// enforcement. Devices authenticate with their own tokens.
+		if r.URL.Path == "/health" || r.URL.Path == "/openapi.json" || r.URL.Path == "/versions" || r.URL.Path == "/devices/connect" {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -291,40 +483,37 @@ func (h *Handler) authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		remoteIP := clientIP(r)
-
-		authHeader := r.Header.Get("Authorization")
-		const prefix = "Bearer "
-
-		// Build a rate-limit key that incorporates both IP and a hash of
-		// the presented credential. This prevents one bad actor behind a
-		// shared IP (NAT / reverse-proxy) from blocking all other users
-		// on that IP.  When no token is presented we fall back to
-		// IP-only keying to limit unauthenticated probing.
-		rateLimitKey := remoteIP
-		token := ""
-		if strings.HasPrefix(authHeader, prefix) {
-			token = strings.TrimSpace(strings.TrimPrefix(authHeader, prefix))
-		}
-		if token != "" {
-			hash := sha256.Sum256([]byte(token))
-			rateLimitKey = remoteIP + ":" + hex.EncodeToString(hash[:4])
+		token := bearerToken(r)
+		// A mapped client certificate was verified during the handshake and
+		// authenticates requests without a bearer token, even when
+		// unauthenticated probes have blocked the source IP.
+		if token == "" {
+			if principal, ok := h.certPrincipal(r); ok {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey, principal)))
+				return
+			}
 		}
-
-		if h.authFailureRateLimiter.IsBlocked(rateLimitKey) {
-			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "too many auth failures"})
+		rateLimitKey := authRateLimitKey(clientIP(r), token)
+		if h.authBlocked(r.Context(), rateLimitKey) {
+			writeError(w, http.StatusTooManyRequests, codeTooManyAuthFailures, "too many auth failures")
 			return
 		}
 
 		if token == "" {
-			h.authFailureRateLimiter.RecordFailure(rateLimitKey)
-			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			h.recordAuthFailure(r.Context(), rateLimitKey)
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
 			return
 		}
-		principal, ok := h.lookupCredential(token)
+		gate := h.newArgon2Gate(r.Context(), clientIP(r))
+		principal, ok := h.lookupCredential(r.Context(), token, gate)
+		principal.CertIdentity = clientCertIdentity(r)
 		if !ok {
-			h.authFailureRateLimiter.RecordFailure(rateLimitKey)
-			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			h.recordAuthFailure(r.Context(), rateLimitKey)
+			if gate.denied {
+				writeError(w, http.StatusTooManyRequests, codeTooManyAuthFailures, "too many auth failures")
+				return
+			}
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
 			return
 		}
 
@@ -333,13 +522,95 @@ func (h *Handler) authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func (h *Handler) lookupCredential(token string) (principal, bool) {
-	for _, cred := range h.credentials {
-		if subtle.ConstantTimeCompare([]byte(token), []byte(cred.Token)) == 1 {
-			return principal{ID: cred.ID, Devices: cred.Devices}, true
+// bearerToken returns the token of a "Bearer" Authorization header, or "".
+// gRPC clients send it as authorization metadata, which arrives the same way.
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, prefix) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(authHeader, prefix))
+}
+
+// authRateLimitKey builds a rate-limit key that incorporates both IP and a
+// hash of the presented credential. This prevents one bad actor behind a
+// shared IP (NAT / reverse-proxy) from blocking all other users on that IP.
+// When no token is presented we fall back to IP-only keying to limit
+// unauthenticated probing.
+func authRateLimitKey(remoteIP, token string) string {
+	if token == "" {
+		return remoteIP
+	}
+	hash := sha256.Sum256([]byte(token))
+	return remoteIP + ":" + hex.EncodeToString(hash[:4])
+}
+
+// lookupCredential authenticates a bearer token. JWTs are verified when a
+// verifier is configured; other tokens are matched against the static
+// credentials, the credential store and the admin credential, and gate must
+// admit a token before it is verified against Argon2id entries. A matched
+// credential must be enabled and within its validity window.
+func (h *Handler) lookupCredential(ctx context.Context, token string, gate *argon2Gate) (principal, bool) {
+	if h.jwt != nil && security.LooksLikeJWT(token) {
+		cred, err := h.jwt.Verify(ctx, token)
+		if err != nil {
+			return principal{}, false
 		}
+		return principal{ID: cred.ID, Devices: cred.Devices, Roles: cred.Roles}, true
 	}
-	return principal{}, false
+	cred, ok := h.credentials.Load().LookupGated(token, gate.admit)
+	if !ok && h.store != nil {
+		cred, ok = h.store.LookupGated(token, gate.admit)
+	}
+	admin := false
+	if !ok {
+		cred, ok = h.adminCredential.LookupGated(token, gate.admit)
+		admin = ok
+	}
+	if !ok {
+		return principal{}, false
+	}
+	if status := cred.Status(h.now()); status != security.CredentialActive {
+		log.Printf("auth rejected principal=%s reason=%s", cred.ID, status)
+		return principal{}, false
+	}
+	return principal{ID: cred.ID, Devices: cred.Devices, Roles: cred.Roles, Admin: admin}, true
+}
+
+// authorize checks that the principal's roles grant permission.
+func (h *Handler) authorize(ctx context.Context, permission string) error {
+	pr, ok := ctx.Value(principalContextKey).(principal)
+	if !ok {
+		return errors.New("unauthorized")
+	}
+	if !h.policy.Permits(pr.Roles, permission) {
+		return fmt.Errorf("forbidden: requires permission %s", permission)
+	}
+	return nil
+}
+
+// authorizeAdmin checks that the request authenticated with the admin
+// credential. Roles granting the admin permission are not enough, so neither
+// a static credential nor an identity provider can grant credential changes.
+func (h *Handler) authorizeAdmin(ctx context.Context) error {
+	pr, ok := ctx.Value(principalContextKey).(principal)
+	if !ok {
+		return errors.New("unauthorized")
+	}
+	if !pr.Admin || pr.ID != security.AdminCredentialID {
+		return errors.New("forbidden: requires the admin credential")
+	}
+	return nil
+}
+
+// authorizeCommand checks both the command type permission and the device
+// scope before a command is dispatched.
+func (h *Handler) authorizeCommand(ctx context.Context, commandType, deviceID string) error {
+	if err := h.authorize(ctx, security.CommandPermission(commandType)); err != nil {
+		return err
+	}
+	return h.authorizeDevice(ctx, deviceID)
 }
 
 func (h *Handler) authorizeDevice(ctx context.Context, deviceID string) error {
@@ -354,6 +625,9 @@ func (h *Handler) authorizeDevice(ctx context.Context, deviceID string) error {
 	return nil
 }
 
+// isSecureRequest reports whether r arrived over TLS, directly or through a
+// trusted proxy. Only direct TLS connections can carry a client certificate
+// identity (see verifiedClientCert).
 func (h *Handler) isSecureRequest(r *http.Request) bool {
 	if r.TLS != nil {
 		return true
@@ -364,21 +638,40 @@ func (h *Handler) isSecureRequest(r *http.Request) bool {
 	return strings.EqualFold(strings.TrimSpace(r.Header.Get("X-Forwarded-Proto")), "https")
 }
 
-func (h *Handler) audit(r *http.Request, deviceID, commandType, result string) {
-	principalID := "unknown"
-	if pr, ok := r.Context().Value(principalContextKey).(principal); ok {
-		principalID = pr.ID
+// verifiedClientCert returns the client certificate leaf that the TLS
+// handshake verified against the client CA bundle, or nil.
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// clientCertIdentity is the most specific identity of the verified client
+// certificate, or "".
+func clientCertIdentity(r *http.Request) string {
+	cert := verifiedClientCert(r)
+	if cert == nil {
+		return ""
 	}
-	log.Printf("audit principal=%s remote=%s method=%s path=%s device=%s command=%s result=%s request_id=%s",
-		principalID,
-		clientIP(r),
-		r.Method,
-		r.URL.Path,
-		deviceID,
-		commandType,
-		result,
-		sanitizeRequestID(r.Header.Get("X-Request-Id")),
-	)
+	if ids := security.CertIdentities(cert); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// certPrincipal maps the verified client certificate onto a configured
+// principal.
+func (h *Handler) certPrincipal(r *http.Request) (principal, bool) {
+	cert := verifiedClientCert(r)
+	if cert == nil || len(h.certPrincipals) == 0 {
+		return principal{}, false
+	}
+	cred, identity, ok := security.MatchCertPrincipal(h.certPrincipals, cert)
+	if !ok {
+		return principal{}, false
+	}
+	return principal{ID: cred.ID, Devices: cred.Devices, Roles: cred.Roles, CertIdentity: identity}, true
 }
 
 func clientIP(r *http.Request) string {
@@ -407,49 +700,48 @@ func sanitizeRequestID(id string) string {
 
 const maxLimiterEntries = 10000
 
+// authFailureLimiter counts auth failures of memoryLimiter in fixed windows.
 type authFailureLimiter struct {
 	mu      sync.Mutex
-	limit   int
-	window  time.Duration
 	entries map[string]authFailureEntry
 }
 
 type authFailureEntry struct {
 	windowStart time.Time
+	window      time.Duration
 	count       int
 }
 
-func newAuthFailureLimiter(limit int) *authFailureLimiter {
+func newAuthFailureLimiter() *authFailureLimiter {
 	return &authFailureLimiter{
-		limit:   limit,
-		window:  time.Minute,
 		entries: make(map[string]authFailureEntry),
 	}
 }
 
-func (l *authFailureLimiter) IsBlocked(key string) bool {
+// Failures returns the failures of key in its current window.
+func (l *authFailureLimiter) Failures(key string) int {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	now := time.Now()
 	entry, ok := l.entries[key]
 	if !ok {
-		return false
+		return 0
 	}
-	if now.Sub(entry.windowStart) >= l.window {
+	if now.Sub(entry.windowStart) >= entry.window {
 		delete(l.entries, key)
-		return false
+		return 0
 	}
-	return entry.count >= l.limit
+	return entry.count
 }
 
-func (l *authFailureLimiter) RecordFailure(key string) {
+func (l *authFailureLimiter) RecordFailure(key string, window time.Duration) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	now := time.Now()
 	entry, ok := l.entries[key]
-	if !ok || now.Sub(entry.windowStart) >= l.window {
+	if !ok || now.Sub(entry.windowStart) >= entry.window {
 		l.evictIfNeeded(now)
-		l.entries[key] = authFailureEntry{windowStart: now, count: 1}
+		l.entries[key] = authFailureEntry{windowStart: now, window: window, count: 1}
 		return
 	}
 	entry.count++
@@ -466,7 +758,7 @@ func (l *authFailureLimiter) evictIfNeeded(now time.Time) {
 
 	// First pass: remove expired entries.
 	for k, e := range l.entries {
-		if now.Sub(e.windowStart) >= l.window {
+		if now.Sub(e.windowStart) >= e.window {
 			delete(l.entries, k)
 		}
 	}
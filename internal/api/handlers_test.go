@@ -3,14 +3,26 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/paul/clock-server/internal/adapters/composite"
 	"github.com/paul/clock-server/internal/application"
 	"github.com/paul/clock-server/internal/domain"
 	"github.com/paul/clock-server/internal/security"
@@ -122,12 +134,29 @@ func TestSetBrightnessValidationErrorReturnsBadRequest(t *testing.T) {
 
 	req := httptest.NewRequest(http.MethodPut, "/commands/brightness", bytes.NewReader(body))
 	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("X-Request-Id", "req-brightness")
 	rr := httptest.NewRecorder()
 	h.Routes().ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusBadRequest {
 		t.Fatalf("expected status 400, got %d", rr.Code)
 	}
+	if got := rr.Header().Get("Content-Type"); got != problemContentType {
+		t.Fatalf("expected problem content type, got %q", got)
+	}
+	var payload problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if payload.Code != codeValidationFailed || payload.Status != http.StatusBadRequest || payload.Type != problemTypePrefix+codeValidationFailed {
+		t.Fatalf("unexpected problem: %+v", payload)
+	}
+	if payload.Detail != "brightness level must be between 0 and 100" || payload.RequestID != "req-brightness" {
+		t.Fatalf("unexpected problem detail: %+v", payload)
+	}
+	if len(payload.Errors) != 1 || payload.Errors[0].Field != "level" {
+		t.Fatalf("expected field error on level, got %+v", payload.Errors)
+	}
 }
 
 func TestSetMessageSenderFailureReturnsBadGateway(t *testing.T) {
@@ -143,12 +172,15 @@ func TestSetMessageSenderFailureReturnsBadGateway(t *testing.T) {
 		t.Fatalf("expected status 502, got %d", rr.Code)
 	}
 
-	var payload map[string]string
+	var payload problem
 	if err := json.Unmarshal(rr.Body.Bytes(), &payload); err != nil {
 		t.Fatalf("unmarshal response: %v", err)
 	}
-	if payload["error"] != "command dispatch failed" {
-		t.Fatalf("unexpected error message: %q", payload["error"])
+	if payload.Code != codeDispatchFailed || payload.Detail != "command dispatch failed" {
+		t.Fatalf("unexpected problem: %+v", payload)
+	}
+	if strings.Contains(rr.Body.String(), "downstream unavailable") {
+		t.Fatalf("downstream error leaked to client: %s", rr.Body.String())
 	}
 }
 
@@ -270,6 +302,46 @@ func TestAuthFailureRateLimit(t *testing.T) {
 	}
 }
 
+func TestArgon2VerificationsAreLimitedPerIP(t *testing.T) {
+	hash, err := security.HashTokenArgon2id("argon-token")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	h := NewHandler(
+		application.NewCommandDispatcher(&stubSender{}),
+		[]security.Credential{{ID: "argon", Token: hash, Devices: []string{"*"}}},
+		false,
+		false,
+		true,
+		64*1024,
+		2,
+	)
+	router := h.Routes()
+	call := func(remoteAddr, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/ready", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Every guess uses a fresh token, so the per-token failure key never
+	// fills up; the per-IP Argon2id budget does.
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if got := call("198.51.100.7:1000", fmt.Sprintf("guess-%d", i)); got != want {
+			t.Fatalf("guess %d: expected %d, got %d", i, want, got)
+		}
+	}
+	if got := call("198.51.100.8:1000", "argon-token"); got != http.StatusOK {
+		t.Fatalf("expected another IP to authenticate, got %d", got)
+	}
+	// The verified token is cached and no longer charged.
+	if got := call("198.51.100.7:1000", "argon-token"); got != http.StatusOK {
+		t.Fatalf("expected a cached token to skip the Argon2id budget, got %d", got)
+	}
+}
+
 func TestSharedIPThrottlingDoesNotBlockOtherTokens(t *testing.T) {
 	// Two callers behind the same NAT IP use different tokens.
 	// One attacker fails auth repeatedly; the legitimate user must
@@ -322,6 +394,221 @@ func TestSharedIPThrottlingDoesNotBlockOtherTokens(t *testing.T) {
 	}
 }
 
+func TestHashedCredentialsAuthenticate(t *testing.T) {
+	pepper := []byte("test-pepper")
+	hash, err := security.HashTokenSHA256("hashed-token", pepper)
+	if err != nil {
+		t.Fatalf("hash token: %v", err)
+	}
+	h := NewHandler(
+		application.NewCommandDispatcher(&stubSender{}),
+		[]security.Credential{{ID: "hashed", Token: hash, Devices: []string{"*"}}},
+		false,
+		false,
+		true,
+		64*1024,
+		100,
+	).WithTokenPepper(pepper)
+	router := h.Routes()
+
+	for token, want := range map[string]int{"hashed-token": http.StatusOK, hash: http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "/ready", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Fatalf("token %q: expected %d, got %d", token, want, rr.Code)
+		}
+	}
+}
+
+func TestJWTAuthenticationWithStaticFallback(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	enc := base64.RawURLEncoding.EncodeToString
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","x":%q}]}`, enc(pub))
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, []byte(jwks), 0o600); err != nil {
+		t.Fatalf("write jwks: %v", err)
+	}
+	verifier, err := security.NewJWTVerifier(security.JWTConfig{Issuer: "https://idp.example", Audience: "clock-server", JWKSFile: path})
+	if err != nil {
+		t.Fatalf("new verifier: %v", err)
+	}
+	sign := func(claims string) string {
+		signed := enc([]byte(`{"alg":"EdDSA","kid":"k1"}`)) + "." + enc([]byte(claims))
+		return signed + "." + enc(ed25519.Sign(key, []byte(signed)))
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	sender := &stubSender{}
+	h := newTestHandler(sender).WithJWTVerifier(verifier)
+	router := h.Routes()
+
+	cases := []struct {
+		name   string
+		token  string
+		device string
+		want   int
+	}{
+		{"jwt in scope", sign(fmt.Sprintf(`{"iss":"https://idp.example","aud":"clock-server","sub":"alice","exp":%d,"clock_devices":["lobby-*"]}`, exp)), "lobby-1", http.StatusAccepted},
+		{"jwt out of scope", sign(fmt.Sprintf(`{"iss":"https://idp.example","aud":"clock-server","sub":"alice","exp":%d,"clock_devices":"lobby-*"}`, exp)), "office-1", http.StatusForbidden},
+		{"jwt wrong audience", sign(fmt.Sprintf(`{"iss":"https://idp.example","aud":"other","sub":"alice","exp":%d,"clock_devices":["*"]}`, exp)), "lobby-1", http.StatusUnauthorized},
+		{"static fallback", "test-token", "office-1", http.StatusAccepted},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/v1/commands/set_brightness", strings.NewReader(`{"deviceId":"`+tc.device+`","level":10}`))
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestClientCertificateAuthentication(t *testing.T) {
+	agentID, _ := url.Parse("spiffe://example.org/clock-agent")
+	agent := &x509.Certificate{URIs: []*url.URL{agentID}, Subject: pkix.Name{CommonName: "clock-agent"}}
+	stranger := &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}}
+	h := newTestHandler(&stubSender{}).WithClientCertPrincipals([]security.CertPrincipal{
+		{ID: "agent", Identity: "uri:spiffe://example.org/clock-agent", Devices: []string{"lobby-*"}},
+	})
+	router := h.Routes()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	cases := []struct {
+		name   string
+		cert   *x509.Certificate
+		token  string
+		device string
+		want   int
+		audit  string
+	}{
+		{"mapped cert in scope", agent, "", "lobby-1", http.StatusAccepted, "principal=agent cert=uri:spiffe://example.org/clock-agent"},
+		{"mapped cert out of scope", agent, "", "office-1", http.StatusForbidden, ""},
+		{"unmapped cert", stranger, "", "lobby-1", http.StatusUnauthorized, ""},
+		{"token with cert", stranger, "test-token", "office-1", http.StatusAccepted, "principal=test cert=cn:stranger"},
+		{"token over plain tls", nil, "test-token", "office-1", http.StatusAccepted, "principal=test cert=-"},
+	}
+	for _, tc := range cases {
+		logs.Reset()
+		req := httptest.NewRequest(http.MethodPost, "/v1/commands/set_brightness", strings.NewReader(`{"deviceId":"`+tc.device+`","level":10}`))
+		req.TLS = &tls.ConnectionState{}
+		if tc.cert != nil {
+			req.TLS.VerifiedChains = [][]*x509.Certificate{{tc.cert}}
+		}
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.want, rr.Code, rr.Body.String())
+		}
+		if tc.audit != "" && !strings.Contains(logs.String(), tc.audit) {
+			t.Fatalf("%s: expected audit line with %q, got %q", tc.name, tc.audit, logs.String())
+		}
+	}
+}
+
+func TestRolesRestrictCommandTypesAndActions(t *testing.T) {
+	policy, err := security.ParseRoles("messenger=command:display_message;observer=events:read")
+	if err != nil {
+		t.Fatalf("parse roles: %v", err)
+	}
+	sender := &stubSender{}
+	h := NewHandler(
+		application.NewCommandDispatcher(sender),
+		[]security.Credential{
+			{ID: "lobby", Token: "lobby-token", Devices: []string{"lobby-*"}, Roles: []string{"messenger"}},
+			{ID: "ops", Token: "ops-token", Devices: []string{"*"}},
+		},
+		false, false, true, 64*1024, 100,
+	).WithPolicy(policy)
+	router := h.Routes()
+
+	cases := []struct {
+		name  string
+		token string
+		path  string
+		body  string
+		want  int
+	}{
+		{"permitted command", "lobby-token", "/v1/commands/display_message", `{"deviceId":"lobby-1","message":"hi","durationSeconds":5}`, http.StatusAccepted},
+		{"permitted command outside device scope", "lobby-token", "/v1/commands/display_message", `{"deviceId":"office-1","message":"hi","durationSeconds":5}`, http.StatusForbidden},
+		{"command type without permission", "lobby-token", "/v1/commands/set_alarm", `{"deviceId":"lobby-1","alarmTime":"2030-01-01T07:00:00Z"}`, http.StatusForbidden},
+		{"legacy alias without permission", "lobby-token", "/commands/alarms", `{"deviceId":"lobby-1","alarmTime":"2030-01-01T07:00:00Z"}`, http.StatusForbidden},
+		{"no roles keeps every command", "ops-token", "/v1/commands/set_alarm", `{"deviceId":"lobby-1","alarmTime":"2030-01-01T07:00:00Z"}`, http.StatusAccepted},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.want, rr.Code, rr.Body.String())
+		}
+	}
+	if sender.calls != 2 {
+		t.Fatalf("expected only the permitted commands to be dispatched, got %d", sender.calls)
+	}
+}
+
+func TestReplaceCredentialsSwapsTokens(t *testing.T) {
+	h := newTestHandler(&stubSender{})
+	router := h.Routes()
+	status := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/commands/set_brightness", strings.NewReader(`{"deviceId":"clock-1","level":10}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if got := status("test-token"); got != http.StatusAccepted {
+		t.Fatalf("expected the initial token to work, got %d", got)
+	}
+	h.ReplaceCredentials([]security.Credential{{ID: "test", Token: "rotated-token", Devices: []string{"*"}}})
+	if got := status("rotated-token"); got != http.StatusAccepted {
+		t.Fatalf("expected the rotated token to work, got %d", got)
+	}
+	if got := status("test-token"); got != http.StatusUnauthorized {
+		t.Fatalf("expected the replaced token to be rejected, got %d", got)
+	}
+}
+
+func TestCredentialValidityWindowIsEnforced(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	h := newTestHandler(&stubSender{})
+	h.now = func() time.Time { return now }
+	h.ReplaceCredentials([]security.Credential{
+		{ID: "current", Token: "current-token", Devices: []string{"*"}, NotBefore: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{ID: "expired", Token: "expired-token", Devices: []string{"*"}, ExpiresAt: now.Add(-time.Second)},
+		{ID: "future", Token: "future-token", Devices: []string{"*"}, NotBefore: now.Add(time.Hour)},
+		{ID: "disabled", Token: "disabled-token", Devices: []string{"*"}, Disabled: true},
+	})
+	router := h.Routes()
+	cases := map[string]int{
+		"current-token":  http.StatusAccepted,
+		"expired-token":  http.StatusUnauthorized,
+		"future-token":   http.StatusUnauthorized,
+		"disabled-token": http.StatusUnauthorized,
+	}
+	for token, want := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/v1/commands/set_brightness", strings.NewReader(`{"deviceId":"clock-1","level":10}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("%s: expected %d, got %d", token, want, rr.Code)
+		}
+	}
+}
+
 // --- Fix: X-Request-Id sanitization ---
 
 func TestRequestIDSanitization(t *testing.T) {
@@ -391,11 +678,11 @@ func TestHealthEnforcesTLS(t *testing.T) {
 // --- Fix: rate-limiter bounded map ---
 
 func TestRateLimiterEvictsAtCapacity(t *testing.T) {
-	lim := newAuthFailureLimiter(5)
+	lim := newAuthFailureLimiter()
 
 	// Fill to capacity with unique keys.
 	for i := 0; i < maxLimiterEntries; i++ {
-		lim.RecordFailure(fmt.Sprintf("key-%d", i))
+		lim.RecordFailure(fmt.Sprintf("key-%d", i), time.Minute)
 	}
 
 	if len(lim.entries) != maxLimiterEntries {
@@ -403,7 +690,7 @@ func TestRateLimiterEvictsAtCapacity(t *testing.T) {
 	}
 
 	// Adding one more should trigger eviction and stay within bounds.
-	lim.RecordFailure("overflow-key")
+	lim.RecordFailure("overflow-key", time.Minute)
 
 	if len(lim.entries) > maxLimiterEntries {
 		t.Fatalf("entries exceeded cap: got %d, max %d", len(lim.entries), maxLimiterEntries)
@@ -414,3 +701,134 @@ func TestRateLimiterEvictsAtCapacity(t *testing.T) {
 		t.Fatal("new key was not inserted after eviction")
 	}
 }
+
+func TestCommandResponseIncludesDeliveryResults(t *testing.T) {
+	sender, err := composite.NewPolicySender(composite.Options{Policy: composite.PolicyAny},
+		composite.Target{Name: "mqtt", Sender: &stubSender{}},
+		composite.Target{Name: "rest", Sender: &stubSender{err: errors.New("rest down")}},
+	)
+	if err != nil {
+		t.Fatalf("new policy sender: %v", err)
+	}
+	h := newTestHandler(sender)
+	body := []byte(`{"deviceId":"clock-1","level":40}`)
+
+	req := httptest.NewRequest(http.MethodPut, "/commands/brightness", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", rr.Code)
+	}
+	var payload commandResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if len(payload.Deliveries) != 2 {
+		t.Fatalf("expected two delivery results, got %#v", payload.Deliveries)
+	}
+	if payload.Deliveries[0].Sender != "mqtt" || payload.Deliveries[0].Status != "delivered" {
+		t.Fatalf("unexpected mqtt delivery: %#v", payload.Deliveries[0])
+	}
+	if payload.Deliveries[1].Sender != "rest" || payload.Deliveries[1].Status != "failed" {
+		t.Fatalf("unexpected rest delivery: %#v", payload.Deliveries[1])
+	}
+}
+
+type stubRouter struct{}
+
+func (stubRouter) Route(deviceID, commandType string) application.RouteDecision {
+	if strings.HasPrefix(deviceID, "legacy-") {
+		return application.RouteDecision{Rule: "legacy", Policy: "all", Senders: []string{"rest"}}
+	}
+	return application.RouteDecision{Rule: "default", Policy: "all", Senders: []string{"mqtt", "rest"}}
+}
+
+func TestDebugRoutingExplainsDecision(t *testing.T) {
+	h := newTestHandler(&stubSender{}).WithRouter(stubRouter{})
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/routing?deviceId=legacy-1&type=set_alarm", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var payload routingDecisionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if payload.Rule != "legacy" || len(payload.Senders) != 1 || payload.Senders[0] != "rest" {
+		t.Fatalf("unexpected routing response: %#v", payload)
+	}
+}
+
+func TestDebugRoutingEnforcesDeviceScope(t *testing.T) {
+	dispatcher := application.NewCommandDispatcher(&stubSender{})
+	h := NewHandler(
+		dispatcher,
+		[]security.Credential{{ID: "ops", Token: "scoped-token", Devices: []string{"clock-allowed"}}},
+		false,
+		false,
+		true,
+		64*1024,
+		100,
+	).WithRouter(stubRouter{})
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/routing?deviceId=legacy-1&type=set_alarm", nil)
+	req.Header.Set("Authorization", "Bearer scoped-token")
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403, got %d", rr.Code)
+	}
+}
+
+func TestGenericCommandEndpointDispatchesRegisteredType(t *testing.T) {
+	sender := &stubSender{}
+	h := newTestHandler(sender)
+
+	req := httptest.NewRequest(http.MethodPost, "/commands/set_brightness", strings.NewReader(`{"deviceId":"clock-1","level":30}`))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"result":"updated"`) {
+		t.Fatalf("unexpected body: %s", rr.Body.String())
+	}
+	if sender.calls != 1 || sender.lastCmd.CommandType() != "set_brightness" {
+		t.Fatalf("expected brightness command dispatch, got %d calls", sender.calls)
+	}
+}
+
+func TestGenericCommandEndpointRejectsUnknownType(t *testing.T) {
+	h := newTestHandler(&stubSender{})
+
+	req := httptest.NewRequest(http.MethodPost, "/commands/reboot", strings.NewReader(`{"deviceId":"clock-1"}`))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestGenericCommandEndpointRequiresPost(t *testing.T) {
+	h := newTestHandler(&stubSender{})
+
+	req := httptest.NewRequest(http.MethodPut, "/commands/set_brightness", strings.NewReader(`{"deviceId":"clock-1","level":30}`))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/paul/clock-server/internal/application"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLen     = 255
+)
+
+// WithIdempotency enables Idempotency-Key handling on command endpoints.
+func (h *Handler) WithIdempotency(store application.IdempotencyStore) *Handler {
+	h.idempotency = store
+	return h
+}
+
+// idempotent wraps a command handler so that a repeated request with the same
+// Idempotency-Key and body replays the stored response instead of dispatching
+// again. Keys are scoped to the authenticated principal. Server errors and
+// rate-limit rejections are not stored so that the client can retry them.
+func (h *Handler) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+		if h.idempotency == nil || key == "" {
+			next(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			writeError(w, http.StatusBadRequest, codeInvalidIdempotencyKey, fmt.Sprintf("%s must be 1-%d printable ASCII characters", idempotencyKeyHeader, maxIdempotencyKeyLen))
+			return
+		}
+		pr, ok := r.Context().Value(principalContextKey).(principal)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+			return
+		}
+
+		body, err := h.readBody(w, r)
+		if err != nil {
+			writeAppError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		requestHash := hashRequest(r.Method, r.URL.Path, body)
+
+		record, reserved := h.idempotency.Reserve(pr.ID, key, requestHash)
+		if !reserved {
+			switch {
+			case record.RequestHash != requestHash:
+				writeError(w, http.StatusUnprocessableEntity, codeIdempotencyKeyReused, "idempotency key was already used with a different request")
+			case !record.Completed():
+				writeError(w, http.StatusConflict, codeIdempotencyKeyInFlight, "a request with this idempotency key is still in progress")
+			default:
+				replayResponse(w, record)
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r.WithContext(application.WithIdempotencyKey(r.Context(), key)))
+		if recorder.status == 0 || recorder.status >= http.StatusInternalServerError || recorder.status == http.StatusTooManyRequests {
+			h.idempotency.Release(pr.ID, key)
+			return
+		}
+		record.StatusCode = recorder.status
+		record.ContentType = recorder.Header().Get("Content-Type")
+		record.Body = recorder.body.Bytes()
+		h.idempotency.Complete(record)
+	}
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func hashRequest(method, path string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func replayResponse(w http.ResponseWriter, record application.IdempotencyRecord) {
+	setSecurityHeaders(w)
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(record.StatusCode)
+	_, _ = w.Write(record.Body)
+}
+
+// responseRecorder captures the status and body written by a handler while
+// passing them through to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
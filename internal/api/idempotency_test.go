@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paul/clock-server/internal/adapters/idempotency"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+func sendWithIdempotencyKey(t *testing.T, handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/commands/messages", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestIdempotencyKeyReplaysOriginalResponse(t *testing.T) {
+	sender := &stubSender{}
+	h := newTestHandler(sender).WithIdempotency(idempotency.NewMemoryStore(time.Hour, 100)).Routes()
+	body := `{"deviceId":"clock-1","message":"hello","durationSeconds":10}`
+
+	first := sendWithIdempotencyKey(t, h, "key-1", body)
+	second := sendWithIdempotencyKey(t, h, "key-1", body)
+
+	if first.Code != http.StatusAccepted || second.Code != http.StatusAccepted {
+		t.Fatalf("expected both responses to be 202, got %d and %d", first.Code, second.Code)
+	}
+	if sender.calls != 1 {
+		t.Fatalf("expected a single dispatch, got %d", sender.calls)
+	}
+	if second.Body.String() != first.Body.String() {
+		t.Fatalf("expected replayed body %q, got %q", first.Body.String(), second.Body.String())
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatal("expected replay header on second response")
+	}
+}
+
+func TestIdempotencyKeyRejectsDifferentBody(t *testing.T) {
+	sender := &stubSender{}
+	h := newTestHandler(sender).WithIdempotency(idempotency.NewMemoryStore(time.Hour, 100)).Routes()
+
+	sendWithIdempotencyKey(t, h, "key-1", `{"deviceId":"clock-1","message":"hello","durationSeconds":10}`)
+	rr := sendWithIdempotencyKey(t, h, "key-1", `{"deviceId":"clock-1","message":"bye","durationSeconds":10}`)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422, got %d", rr.Code)
+	}
+	if sender.calls != 1 {
+		t.Fatalf("expected a single dispatch, got %d", sender.calls)
+	}
+}
+
+func TestIdempotencyKeyNotStoredOnServerError(t *testing.T) {
+	sender := &stubSender{err: errors.New("downstream unavailable")}
+	h := newTestHandler(sender).WithIdempotency(idempotency.NewMemoryStore(time.Hour, 100)).Routes()
+	body := `{"deviceId":"clock-1","message":"hello","durationSeconds":10}`
+
+	first := sendWithIdempotencyKey(t, h, "key-1", body)
+	sender.err = nil
+	second := sendWithIdempotencyKey(t, h, "key-1", body)
+
+	if first.Code != http.StatusBadGateway || second.Code != http.StatusAccepted {
+		t.Fatalf("expected 502 then 202, got %d and %d", first.Code, second.Code)
+	}
+	if sender.calls != 2 {
+		t.Fatalf("expected the retry to dispatch again, got %d calls", sender.calls)
+	}
+}
+
+func TestIdempotencyKeyRejectsInvalidKey(t *testing.T) {
+	h := newTestHandler(&stubSender{}).WithIdempotency(idempotency.NewMemoryStore(time.Hour, 100)).Routes()
+
+	rr := sendWithIdempotencyKey(t, h, strings.Repeat("k", 256), `{"deviceId":"clock-1","message":"hello","durationSeconds":10}`)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+}
+
+type keyCapturingSender struct {
+	key string
+}
+
+func (s *keyCapturingSender) Send(ctx context.Context, _ domain.ClockCommand) error {
+	s.key = application.IdempotencyKeyFromContext(ctx)
+	return nil
+}
+
+func TestIdempotencyKeyPropagatedToSender(t *testing.T) {
+	sender := &keyCapturingSender{}
+	h := newTestHandler(sender).WithIdempotency(idempotency.NewMemoryStore(time.Hour, 100)).Routes()
+
+	sendWithIdempotencyKey(t, h, "key-1", `{"deviceId":"clock-1","message":"hello","durationSeconds":10}`)
+
+	if sender.key != "key-1" {
+		t.Fatalf("expected key on sender context, got %q", sender.key)
+	}
+}
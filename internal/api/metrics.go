@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paul/clock-server/internal/application"
+)
+
+// WithMetrics registers components whose samples are exposed on /metrics.
+func (h *Handler) WithMetrics(providers ...application.MetricsProvider) *Handler {
+	h.metrics = append(h.metrics, providers...)
+	return h
+}
+
+// handleMetrics renders metrics in the Prometheus text exposition format.
+func (h *Handler) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+
+	samples := h.limiterMetrics()
+	for _, provider := range h.metrics {
+		if provider != nil {
+			samples = append(samples, provider.Metrics()...)
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Name < samples[j].Name })
+
+	var b strings.Builder
+	described := make(map[string]bool, len(samples))
+	for _, sample := range samples {
+		if !described[sample.Name] {
+			described[sample.Name] = true
+			if sample.Help != "" {
+				fmt.Fprintf(&b, "# HELP %s %s\n", sample.Name, sample.Help)
+			}
+			if sample.Type != "" {
+				fmt.Fprintf(&b, "# TYPE %s %s\n", sample.Name, sample.Type)
+			}
+		}
+		b.WriteString(sample.Name)
+		b.WriteString(formatLabels(sample.Labels))
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatFloat(sample.Value, 'g', -1, 64))
+		b.WriteByte('\n')
+	}
+
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%s", key, strconv.Quote(labels[key])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paul/clock-server/internal/application"
+)
+
+type stubMetrics []application.Metric
+
+func (s stubMetrics) Metrics() []application.Metric {
+	return s
+}
+
+func TestMetricsRendersPrometheusText(t *testing.T) {
+	h := newTestHandler(&stubSender{}).WithMetrics(stubMetrics{
+		{Name: "clock_sender_circuit_state", Help: "Circuit breaker state.", Type: "gauge", Labels: map[string]string{"sender": "rest"}, Value: 1},
+		{Name: "clock_sender_circuit_state", Help: "Circuit breaker state.", Type: "gauge", Labels: map[string]string{"sender": "mqtt"}, Value: 0},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	if strings.Count(body, "# TYPE clock_sender_circuit_state gauge") != 1 {
+		t.Fatalf("expected a single TYPE line, got:\n%s", body)
+	}
+	if !strings.Contains(body, `clock_sender_circuit_state{sender="rest"} 1`) {
+		t.Fatalf("expected rest sample, got:\n%s", body)
+	}
+	if !strings.Contains(body, `clock_sender_circuit_state{sender="mqtt"} 0`) {
+		t.Fatalf("expected mqtt sample, got:\n%s", body)
+	}
+}
+
+func TestMetricsRequiresAuth(t *testing.T) {
+	h := newTestHandler(&stubSender{})
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rr.Code)
+	}
+}
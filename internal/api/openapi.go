@@ -0,0 +1,745 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
+	"github.com/paul/clock-server/internal/security"
+)
+
+// openAPIVersion is the version of the API described by the document.
+const openAPIVersion = "1.0.0"
+
+// handleOpenAPI serves the OpenAPI 3.1 description of Routes.
+func (h *Handler) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, openAPIDocument())
+}
+
+type object = map[string]any
+
+// openAPIDocument builds the API description. Command request schemas are
+// generated from the command registry; everything else is declared here and
+// kept in line with Handler.routes by TestOpenAPIMatchesRoutes.
+func openAPIDocument() object {
+	commandTypes := commands.All()
+	typeNames := make([]any, 0, len(commandTypes))
+	commandSchemas := make([]any, 0, len(commandTypes))
+	batchItems := make([]any, 0, len(commandTypes))
+	schemas := object{
+		"Problem":         problemSchema(),
+		"Status":          statusSchema(),
+		"Delivery":        deliverySchema(),
+		"CommandResponse": commandResponseSchema(),
+		"BatchRequest":    nil,
+		"BatchItemResult": batchItemResultSchema(),
+		"BatchResponse":   batchResponseSchema(),
+		"RoutingDecision": routingDecisionSchema(),
+		"Versions":        versionsSchema(),
+		"Event":           eventSchema(),
+		"Credential":      credentialSchema(),
+		"CredentialList":  credentialListSchema(),
+		"CredentialToken": credentialTokenSchema(),
+		"NewCredential":   newCredentialSchema(),
+		"CredentialPatch": credentialPatchSchema(),
+		"AuditEntry":      auditEntrySchema(),
+		"AuditEntries":    auditEntriesSchema(),
+	}
+	for _, def := range commandTypes {
+		name := commandSchemaName(def)
+		typeNames = append(typeNames, def.Type)
+		commandSchemas = append(commandSchemas, ref(name))
+		schemas[name] = commandRequestSchema(def)
+		batchItems = append(batchItems, object{
+			"allOf": []any{
+				ref(name),
+				object{
+					"type":       "object",
+					"required":   []any{"type"},
+					"properties": object{"type": object{"const": def.Type}},
+				},
+			},
+		})
+	}
+	schemas["BatchRequest"] = batchRequestSchema(batchItems)
+
+	paths := object{
+		"/health": object{
+			"get": object{
+				"summary":   "Liveness probe",
+				"security":  []any{},
+				"responses": object{"200": jsonResponse("Server is alive", "Status")},
+			},
+		},
+		"/ready": object{
+			"get": object{
+				"summary":     "Readiness probe",
+				"description": "Checks every sender adapter. Authentication is required unless READINESS_REQUIRE_AUTH=false.",
+				"security":    []any{object{}, object{"bearerAuth": []any{}}},
+				"responses": object{
+					"200": jsonResponse("All dependencies are ready", "Status"),
+					"401": errorResponse("Missing or invalid bearer token"),
+					"503": jsonResponse("A dependency is not ready", "Status"),
+				},
+			},
+		},
+		"/openapi.json": object{
+			"get": object{
+				"summary":   "This OpenAPI document",
+				"security":  []any{},
+				"responses": object{"200": object{"description": "OpenAPI 3.1 document", "content": object{"application/json": object{"schema": object{"type": "object"}}}}},
+			},
+		},
+		"/versions": object{
+			"get": object{
+				"summary":   "API version discovery",
+				"security":  []any{},
+				"responses": object{"200": jsonResponse("Served API versions and the preferred one", "Versions")},
+			},
+		},
+		"/events": object{
+			"get": object{
+				"summary":     "Server-Sent Events stream of activity",
+				"description": "Streams dispatch, device_ack, presence and audit events for the devices covered by the caller's credential. Each SSE message has id, event (the event type) and data (an Event). Reconnect with Last-Event-ID to resume; a leading reset event means some events were already evicted.",
+				"parameters": []any{
+					object{"name": "Last-Event-ID", "in": "header", "required": false, "schema": object{"type": "string"}},
+					object{"name": "types", "in": "query", "required": false, "description": "Comma-separated event types to include", "schema": object{"type": "string"}},
+				},
+				"responses": object{
+					"200": object{"description": "Event stream", "content": object{"text/event-stream": object{"schema": object{"type": "string"}, "itemSchema": ref("Event")}}},
+					"400": errorResponse("Invalid Last-Event-ID"),
+					"401": errorResponse("Missing or invalid bearer token"),
+					"403": errorResponse("Roles lack the events:read permission"),
+					"404": errorResponse("Event stream is not configured"),
+				},
+			},
+		},
+		"/devices/connect": object{
+			"get": object{
+				"summary":     "WebSocket push channel for clocks",
+				"description": "Clocks that cannot reach the MQTT broker open a WebSocket here, authenticated with their device token (WEBSOCKET_DEVICE_TOKENS) rather than an API credential. The server pushes commands as text frames with the MQTT payload shape plus an id; the device answers each with {\"type\":\"ack\",\"id\":...,\"status\":\"ok\"|\"error\",\"error\":...}.",
+				"security":    []any{object{"deviceToken": []any{}}},
+				"responses": object{
+					"101": object{"description": "Switching to the WebSocket protocol"},
+					"400": errorResponse("Not a WebSocket upgrade request"),
+					"401": errorResponse("Missing or unknown device token"),
+					"404": errorResponse("The websocket sender is not enabled"),
+					"429": errorResponse("Too many failed authentication attempts"),
+				},
+			},
+		},
+		"/metrics": object{
+			"get": object{
+				"summary": "Prometheus metrics",
+				"responses": object{
+					"200": object{"description": "Prometheus text exposition format", "content": object{"text/plain": object{"schema": object{"type": "string"}}}},
+					"401": errorResponse("Missing or invalid bearer token"),
+				},
+			},
+		},
+	}
+
+	for _, version := range apiVersions {
+		for path, item := range apiPathItems(typeNames, commandSchemas) {
+			paths[version.prefix+path] = item
+		}
+	}
+	for _, version := range apiVersions {
+		for path, item := range adminPathItems() {
+			paths[version.prefix+path] = item
+		}
+	}
+	successor := preferredAPIVersion().prefix
+	for path, item := range apiPathItems(typeNames, commandSchemas) {
+		paths[path] = deprecated(item, successor+path)
+	}
+	for path, item := range legacyAliasPathItems() {
+		paths[path] = deprecated(item, successor+"/commands/{type}")
+	}
+
+	return object{
+		"openapi": "3.1.0",
+		"info": object{
+			"title":       "Clock Command Dispatcher API",
+			"version":     openAPIVersion,
+			"description": "Dispatches commands to smart clocks over MQTT, REST and WebSocket.",
+		},
+		"security": []any{object{"bearerAuth": []any{}}},
+		"paths":    paths,
+		"components": object{
+			"securitySchemes": object{
+				"bearerAuth":  object{"type": "http", "scheme": "bearer"},
+				"deviceToken": object{"type": "http", "scheme": "bearer", "description": "Per-device token of the WebSocket push channel"},
+			},
+			"parameters": object{
+				"IdempotencyKey": object{
+					"name":        "Idempotency-Key",
+					"in":          "header",
+					"required":    false,
+					"description": "Replays the original response for a repeated request with the same key and body.",
+					"schema":      object{"type": "string", "minLength": 1, "maxLength": maxIdempotencyKeyLen},
+				},
+			},
+			"schemas": schemas,
+		},
+	}
+}
+
+// apiPathItems describes the versioned endpoints relative to the version
+// prefix. It returns fresh objects on every call so that callers may modify
+// them.
+func apiPathItems(typeNames, commandSchemas []any) object {
+	return object{
+		"/commands/{type}": object{
+			"post": withParameters(
+				withResponse(commandOperation("Dispatch a command of any registered type", object{"oneOf": commandSchemas}), "404", errorResponse("Unknown command type")),
+				object{
+					"name":     "type",
+					"in":       "path",
+					"required": true,
+					"schema":   object{"type": "string", "enum": typeNames},
+				},
+			),
+		},
+		"/commands/batch": object{
+			"post": object{
+				"summary":     "Validate, then dispatch several commands",
+				"parameters":  []any{ref("#/components/parameters/IdempotencyKey")},
+				"requestBody": jsonBody(ref("BatchRequest")),
+				"responses": object{
+					"202": jsonResponse("Every item was dispatched", "BatchResponse"),
+					"207": jsonResponse("Some items were not dispatched or failed downstream", "BatchResponse"),
+					"400": errorResponse("Invalid envelope (invalid_batch), or an invalid item in atomic-validate mode (batch_validation_failed, with results)"),
+					"401": errorResponse("Missing or invalid bearer token"),
+					"413": errorResponse("Request body exceeds MAX_BODY_BYTES"),
+					"409": errorResponse("A request with this Idempotency-Key is still in progress"),
+					"422": errorResponse("Idempotency-Key was already used with a different request"),
+					"429": errorResponse("Too many authentication failures, or in atomic-validate mode a rate limit or quota cannot take every item (rate_limited or quota_exceeded, with results); see Retry-After"),
+				},
+			},
+		},
+		"/debug/routing": object{
+			"get": object{
+				"summary": "Explain which senders would handle a command",
+				"parameters": []any{
+					object{"name": "deviceId", "in": "query", "required": true, "schema": object{"type": "string"}},
+					object{"name": "type", "in": "query", "required": true, "schema": object{"type": "string"}},
+				},
+				"responses": object{
+					"200": jsonResponse("Routing decision", "RoutingDecision"),
+					"400": errorResponse("Missing query parameter"),
+					"401": errorResponse("Missing or invalid bearer token"),
+					"403": errorResponse("Token does not cover the device, or lacks the routing:read permission"),
+					"404": errorResponse("Routing is not configured"),
+				},
+			},
+		},
+	}
+}
+
+// adminPathItems describes the administrative endpoints relative to the
+// version prefix.
+func adminPathItems() object {
+	idParameter := object{"name": "id", "in": "path", "required": true, "schema": object{"type": "string"}}
+	return object{
+		"/admin/credentials": object{
+			"get": object{
+				"summary":     "List API credentials",
+				"description": "Returns the ID, source, scopes, roles, metadata and current status of the static, stored and admin credentials. Tokens are never included.",
+				"responses": object{
+					"200": jsonResponse("Credentials", "CredentialList"),
+					"401": errorResponse("Missing or invalid bearer token"),
+					"403": errorResponse("Not authenticated with the admin credential"),
+				},
+			},
+			"post": object{
+				"summary":     "Issue a credential with a generated token",
+				"description": "The token is returned once, in this response; the store keeps only its hash.",
+				"requestBody": jsonBody(ref("NewCredential")),
+				"responses": object{
+					"201": jsonResponse("Credential issued", "CredentialToken"),
+					"400": errorResponse("Invalid credential, or a role that is undefined or grants admin"),
+					"401": errorResponse("Missing or invalid bearer token"),
+					"403": errorResponse("Not authenticated with the admin credential"),
+					"404": errorResponse("The credential store is not configured"),
+					"409": errorResponse("A credential with this ID exists"),
+					"413": errorResponse("Request body exceeds MAX_BODY_BYTES"),
+				},
+			},
+		},
+		"/admin/credentials/{id}": object{
+			"patch": object{
+				"summary":     "Update the scopes, roles, metadata or validity of a stored credential",
+				"description": "Only the fields present are changed. The token stays the same.",
+				"parameters":  []any{idParameter},
+				"requestBody": jsonBody(ref("CredentialPatch")),
+				"responses": object{
+					"200": jsonResponse("Updated credential", "Credential"),
+					"400": errorResponse("Invalid credential, or a role that is undefined or grants admin"),
+					"401": errorResponse("Missing or invalid bearer token"),
+					"403": errorResponse("Not authenticated with the admin credential"),
+					"404": errorResponse("No such stored credential, or the credential store is not configured"),
+					"409": errorResponse("The credential is defined in the server configuration"),
+				},
+			},
+			"delete": object{
+				"summary":    "Revoke a stored credential",
+				"parameters": []any{idParameter},
+				"responses": object{
+					"204": object{"description": "Credential revoked; its token no longer authenticates"},
+					"401": errorResponse("Missing or invalid bearer token"),
+					"403": errorResponse("Not authenticated with the admin credential"),
+					"404": errorResponse("No such stored credential, or the credential store is not configured"),
+					"409": errorResponse("The credential is defined in the server configuration"),
+				},
+			},
+		},
+		"/admin/audit": object{
+			"get": object{
+				"summary":     "Query the audit log",
+				"description": "Returns the matching entries, newest first, across the active and rotated audit log files. Entries are returned as stored; verify the hash chain with clockctl audit verify.",
+				"parameters": []any{
+					queryParameter("principal", object{"type": "string"}),
+					queryParameter("deviceId", object{"type": "string"}),
+					queryParameter("commandType", object{"type": "string"}),
+					queryParameter("action", object{"type": "string"}),
+					queryParameter("result", object{"type": "string"}),
+					queryParameter("since", object{"type": "string", "format": "date-time"}),
+					queryParameter("until", object{"type": "string", "format": "date-time"}),
+					queryParameter("limit", object{"type": "integer", "minimum": 1, "maximum": maxAuditQueryLimit, "default": 100}),
+				},
+				"responses": object{
+					"200": jsonResponse("Audit entries", "AuditEntries"),
+					"400": errorResponse("Invalid time bound or limit"),
+					"401": errorResponse("Missing or invalid bearer token"),
+					"403": errorResponse("Roles lack the audit:read permission"),
+					"404": errorResponse("The audit log is not configured"),
+				},
+			},
+		},
+	}
+}
+
+func queryParameter(name string, schema object) object {
+	return object{"name": name, "in": "query", "schema": schema}
+}
+
+// legacyAliasPathItems describes the unversioned per-type command endpoints.
+func legacyAliasPathItems() object {
+	return object{
+		"/commands/alarms": object{
+			"post": commandOperation("Set an alarm", ref("SetAlarmRequest")),
+		},
+		"/commands/messages": object{
+			"post": commandOperation("Display a message", ref("DisplayMessageRequest")),
+		},
+		"/commands/brightness": object{
+			"put": commandOperation("Set brightness", ref("SetBrightnessRequest")),
+		},
+	}
+}
+
+// deprecated marks every operation of a legacy path item as deprecated in
+// favour of successor.
+func deprecated(item any, successor string) object {
+	pathItem := item.(object)
+	for _, operation := range pathItem {
+		operation.(object)["deprecated"] = true
+		operation.(object)["description"] = "Deprecated in favour of " + successor + ". Responses carry Deprecation, Sunset and Link headers."
+	}
+	return pathItem
+}
+
+func commandOperation(summary string, body any) object {
+	return object{
+		"summary":     summary,
+		"parameters":  []any{ref("#/components/parameters/IdempotencyKey")},
+		"requestBody": jsonBody(body),
+		"responses": object{
+			"202": jsonResponse("Command dispatched", "CommandResponse"),
+			"400": errorResponse("Invalid body (invalid_body) or command (validation_failed)"),
+			"401": errorResponse("Missing or invalid bearer token"),
+			"403": errorResponse("Token does not cover the target device, or its roles do not permit the command type"),
+			"409": errorResponse("A request with this Idempotency-Key is still in progress"),
+			"413": errorResponse("Request body exceeds MAX_BODY_BYTES"),
+			"422": errorResponse("Idempotency-Key was already used with a different request"),
+			"429": errorResponse("Too many authentication failures, or a rate limit (rate_limited) or the daily quota (quota_exceeded) is exhausted; see Retry-After"),
+			"502": errorResponse("Downstream delivery failed (dispatch_failed, with deliveries)"),
+		},
+	}
+}
+
+func withResponse(operation object, status string, response object) object {
+	operation["responses"].(object)[status] = response
+	return operation
+}
+
+func withParameters(operation object, parameters ...any) object {
+	existing, _ := operation["parameters"].([]any)
+	operation["parameters"] = append(append([]any{}, parameters...), existing...)
+	return operation
+}
+
+// commandSchemaName derives the schema name from the command type, e.g.
+// set_alarm becomes SetAlarmRequest.
+func commandSchemaName(def commands.Definition) string {
+	var b strings.Builder
+	for _, part := range strings.Split(def.Type, "_") {
+		if part == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]))
+		b.WriteString(part[1:])
+	}
+	b.WriteString("Request")
+	return b.String()
+}
+
+func commandRequestSchema(def commands.Definition) object {
+	properties := object{
+		"deviceId": object{"type": "string", "pattern": "^[a-zA-Z0-9_-]{1,64}$"},
+	}
+	required := []any{"deviceId"}
+	for _, field := range def.Fields {
+		property := object{"description": field.Usage}
+		switch field.Kind {
+		case commands.KindInt:
+			property["type"] = "integer"
+		case commands.KindTime:
+			property["type"] = "string"
+			property["format"] = "date-time"
+		default:
+			property["type"] = "string"
+		}
+		properties[field.Name] = property
+		if field.Required {
+			required = append(required, field.Name)
+		}
+	}
+	return object{
+		"type":                 "object",
+		"description":          def.Summary,
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// problemSchema describes the RFC 9457 problem details returned for every
+// error, including the extensions used by dispatch failures and batches.
+func problemSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"type":      object{"type": "string", "format": "uri"},
+			"title":     object{"type": "string"},
+			"status":    object{"type": "integer"},
+			"code":      object{"type": "string", "enum": problemCodes()},
+			"detail":    object{"type": "string"},
+			"requestId": object{"type": "string"},
+			"errors": object{
+				"type": "array",
+				"items": object{
+					"type": "object",
+					"properties": object{
+						"field":  object{"type": "string"},
+						"detail": object{"type": "string"},
+					},
+					"required": []any{"field", "detail"},
+				},
+			},
+			"deliveries": object{"type": "array", "items": ref("Delivery")},
+			"mode":       object{"type": "string"},
+			"results":    object{"type": "array", "items": ref("BatchItemResult")},
+		},
+		"required": []any{"type", "title", "status", "code"},
+	}
+}
+
+func problemCodes() []any {
+	return []any{
+		codeInvalidBody, codeBodyTooLarge, codeValidationFailed, codeUnknownCommandType,
+		codeUnauthorized, codeForbidden, codeHTTPSRequired, codeTooManyAuthFailures,
+		codeMethodNotAllowed, codeMissingParameter, codeRoutingNotConfigured,
+		codeEventsNotConfigured, codeInvalidLastEventID, codeDeviceChannelNotConfigured, codeInvalidUpgrade,
+		codeInvalidBatch, codeBatchValidationFailed, codeInvalidIdempotencyKey,
+		codeIdempotencyKeyReused, codeIdempotencyKeyInFlight, codeDispatchFailed, codeInternal,
+		codeCredentialStoreNotConfigured, codeCredentialNotFound, codeCredentialExists, codeCredentialReadOnly,
+		codeRateLimited, codeQuotaExceeded, codeAuditLogNotConfigured,
+	}
+}
+
+func statusSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"status":  object{"type": "string"},
+			"details": object{"type": "object", "description": "Component details reported by readiness checks, such as connected WebSocket devices"},
+		},
+	}
+}
+
+func deliverySchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"sender":     object{"type": "string"},
+			"status":     object{"type": "string", "enum": []any{"delivered", "failed", "timeout", "skipped"}},
+			"durationMs": object{"type": "integer"},
+		},
+	}
+}
+
+func commandResponseSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"result":     object{"type": "string"},
+			"deliveries": object{"type": "array", "items": ref("Delivery")},
+		},
+	}
+}
+
+func batchRequestSchema(items []any) object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"mode": object{"type": "string", "enum": []any{batchModeAtomicValidate, batchModeBestEffort}, "default": batchModeAtomicValidate},
+			"commands": object{
+				"type":     "array",
+				"minItems": 1,
+				"maxItems": maxBatchItems,
+				"items":    object{"oneOf": items},
+			},
+		},
+		"required":             []any{"commands"},
+		"additionalProperties": false,
+	}
+}
+
+func batchItemResultSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"index":      object{"type": "integer"},
+			"type":       object{"type": "string"},
+			"deviceId":   object{"type": "string"},
+			"status":     object{"type": "string", "enum": []any{batchItemAccepted, batchItemFailed, batchItemInvalid, batchItemForbidden, batchItemSkipped, batchItemLimited}},
+			"code":       object{"type": "string", "description": "Problem code of a rejected or failed item"},
+			"error":      object{"type": "string"},
+			"deliveries": object{"type": "array", "items": ref("Delivery")},
+		},
+	}
+}
+
+func batchResponseSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"mode":    object{"type": "string"},
+			"results": object{"type": "array", "items": ref("BatchItemResult")},
+		},
+	}
+}
+
+func eventSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"id":       object{"type": "integer"},
+			"type":     object{"type": "string", "enum": []any{application.EventDispatch, application.EventDeviceAck, application.EventPresence, application.EventAudit}},
+			"deviceId": object{"type": "string"},
+			"time":     object{"type": "string", "format": "date-time"},
+			"data":     object{"type": "object"},
+		},
+	}
+}
+
+func versionsSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"preferred": object{"type": "string"},
+			"versions": object{
+				"type": "array",
+				"items": object{
+					"type": "object",
+					"properties": object{
+						"version":     object{"type": "string"},
+						"path":        object{"type": "string"},
+						"status":      object{"type": "string", "enum": []any{"current", "deprecated"}},
+						"deprecation": object{"type": "string", "format": "date-time"},
+						"sunset":      object{"type": "string", "format": "date-time"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func routingDecisionSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"deviceId": object{"type": "string"},
+			"type":     object{"type": "string"},
+			"rule":     object{"type": "string"},
+			"policy":   object{"type": "string"},
+			"senders":  object{"type": "array", "items": object{"type": "string"}},
+		},
+	}
+}
+
+func credentialSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"id":          object{"type": "string"},
+			"source":      object{"type": "string", "enum": []any{credentialSourceConfig, credentialSourceStore, credentialSourceAdmin}},
+			"devices":     object{"type": "array", "items": object{"type": "string"}},
+			"roles":       object{"type": "array", "items": object{"type": "string"}},
+			"description": object{"type": "string"},
+			"owner":       object{"type": "string"},
+			"notBefore":   object{"type": "string", "format": "date-time"},
+			"expiresAt":   object{"type": "string", "format": "date-time"},
+			"disabled":    object{"type": "boolean"},
+			"status":      object{"type": "string", "enum": []any{security.CredentialActive, security.CredentialDisabled, security.CredentialNotYetValid, security.CredentialExpired}},
+			"hashed":      object{"type": "boolean", "description": "Whether the token is stored as a hash"},
+		},
+	}
+}
+
+func credentialListSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"credentials": object{"type": "array", "items": ref("Credential")},
+		},
+	}
+}
+
+func auditEntrySchema() object {
+	return object{
+		"type":        "object",
+		"description": "An audit record and its link in the hash chain: hash covers the entry without it, including prevHash, the hash of the previous entry.",
+		"properties": object{
+			"seq":         object{"type": "integer"},
+			"prevHash":    object{"type": "string"},
+			"time":        object{"type": "string", "format": "date-time"},
+			"action":      object{"type": "string", "description": "command, or the admin action"},
+			"principal":   object{"type": "string"},
+			"cert":        object{"type": "string"},
+			"remoteIp":    object{"type": "string"},
+			"requestId":   object{"type": "string"},
+			"method":      object{"type": "string"},
+			"path":        object{"type": "string"},
+			"deviceId":    object{"type": "string"},
+			"commandType": object{"type": "string"},
+			"parameters":  object{"type": "object", "description": "Command parameters after redaction"},
+			"credential":  object{"type": "string"},
+			"result":      object{"type": "string"},
+			"deliveries": object{
+				"type": "array",
+				"items": object{
+					"type": "object",
+					"properties": object{
+						"sender":     object{"type": "string"},
+						"status":     object{"type": "string"},
+						"durationMs": object{"type": "integer"},
+						"error":      object{"type": "string"},
+					},
+				},
+			},
+			"hash": object{"type": "string"},
+		},
+	}
+}
+
+func auditEntriesSchema() object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"entries": object{"type": "array", "items": ref("AuditEntry")},
+		},
+	}
+}
+
+func credentialTokenSchema() object {
+	return object{
+		"allOf": []any{
+			ref("Credential"),
+			object{
+				"type":       "object",
+				"required":   []any{"token"},
+				"properties": object{"token": object{"type": "string", "description": "The generated bearer token; it is not shown again"}},
+			},
+		},
+	}
+}
+
+func newCredentialSchema() object {
+	return object{
+		"type":                 "object",
+		"required":             []any{"id", "devices"},
+		"additionalProperties": false,
+		"properties": object{
+			"id":          object{"type": "string", "pattern": "^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$"},
+			"devices":     object{"type": "array", "minItems": 1, "items": object{"type": "string"}},
+			"roles":       object{"type": "array", "items": object{"type": "string"}},
+			"description": object{"type": "string"},
+			"owner":       object{"type": "string"},
+			"notBefore":   object{"type": "string", "format": "date-time"},
+			"expiresAt":   object{"type": "string", "format": "date-time"},
+		},
+	}
+}
+
+func credentialPatchSchema() object {
+	return object{
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties": object{
+			"devices":     object{"type": "array", "minItems": 1, "items": object{"type": "string"}},
+			"roles":       object{"type": "array", "items": object{"type": "string"}},
+			"description": object{"type": "string"},
+			"owner":       object{"type": "string"},
+			"notBefore":   object{"type": "string", "format": "date-time"},
+			"expiresAt":   object{"type": "string", "format": "date-time"},
+			"disabled":    object{"type": "boolean"},
+		},
+	}
+}
+
+// ref returns a $ref to a component schema, or to the given JSON pointer.
+func ref(name string) object {
+	if strings.HasPrefix(name, "#") {
+		return object{"$ref": name}
+	}
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonBody(schema any) object {
+	return object{
+		"required": true,
+		"content":  object{"application/json": object{"schema": schema}},
+	}
+}
+
+func jsonResponse(description, schema string) object {
+	return object{
+		"description": description,
+		"content":     object{"application/json": object{"schema": ref(schema)}},
+	}
+}
+
+func errorResponse(description string) object {
+	return object{
+		"description": description,
+		"content":     object{problemContentType: object{"schema": ref("Problem")}},
+	}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/paul/clock-server/internal/adapters/events"
+	"github.com/paul/clock-server/internal/commands"
+)
+
+func fetchOpenAPI(t *testing.T) map[string]any {
+	t.Helper()
+	h := newTestHandler(&stubSender{})
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 without auth, got %d", rr.Code)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode openapi document: %v", err)
+	}
+	return doc
+}
+
+// TestOpenAPIMatchesRoutes fails when a route is added, removed or changes its
+// methods without the OpenAPI document being updated, and vice versa.
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	doc := fetchOpenAPI(t)
+	if doc["openapi"] != "3.1.0" {
+		t.Fatalf("unexpected openapi version: %v", doc["openapi"])
+	}
+	paths := doc["paths"].(map[string]any)
+
+	h := newTestHandler(&stubSender{})
+	documented := map[string]bool{}
+	for path, item := range paths {
+		for method := range item.(map[string]any) {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	registered := map[string]bool{}
+	for _, rt := range h.routes() {
+		path := rt.path
+		if path == "" {
+			path = rt.pattern
+		}
+		for _, method := range rt.methods {
+			registered[method+" "+path] = true
+		}
+	}
+
+	if missing := difference(registered, documented); len(missing) > 0 {
+		t.Errorf("routes missing from openapi.json: %v", missing)
+	}
+	if stale := difference(documented, registered); len(stale) > 0 {
+		t.Errorf("openapi.json documents unknown routes: %v", stale)
+	}
+}
+
+// TestOpenAPIMethodsMatchHandlers checks that each documented operation is
+// served and that other methods are rejected by the handler.
+func TestOpenAPIMethodsMatchHandlers(t *testing.T) {
+	doc := fetchOpenAPI(t)
+	h := newTestHandler(&stubSender{}).WithRouter(stubRouter{}).WithEvents(events.NewBroker(8)).WithDeviceChannel(&stubDeviceChannel{})
+	routes := h.Routes()
+	// A cancelled context makes the event stream return after its replay.
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for path, item := range doc["paths"].(map[string]any) {
+		target := strings.ReplaceAll(path, "{type}", "set_brightness")
+		operations := item.(map[string]any)
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete} {
+			req := httptest.NewRequest(method, target, strings.NewReader(`{}`))
+			req.Header.Set("Authorization", "Bearer test-token")
+			if path == "/events" {
+				req = req.WithContext(done)
+			}
+			rr := httptest.NewRecorder()
+			routes.ServeHTTP(rr, req)
+
+			_, documented := operations[strings.ToLower(method)]
+			switch {
+			case documented && (rr.Code == http.StatusNotFound || rr.Code == http.StatusMethodNotAllowed):
+				t.Errorf("%s %s is documented but returned %d", method, path, rr.Code)
+			case !documented && path != "/health" && rr.Code != http.StatusMethodNotAllowed:
+				// /health answers every method and is documented as GET only.
+				t.Errorf("%s %s is not documented but returned %d", method, path, rr.Code)
+			}
+		}
+	}
+}
+
+func TestOpenAPIDescribesEveryCommandType(t *testing.T) {
+	doc := fetchOpenAPI(t)
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+
+	for _, def := range commands.All() {
+		schema, ok := schemas[commandSchemaName(def)].(map[string]any)
+		if !ok {
+			t.Fatalf("missing schema for %s", def.Type)
+		}
+		properties := schema["properties"].(map[string]any)
+		if _, ok := properties["deviceId"]; !ok {
+			t.Fatalf("%s schema lacks deviceId", def.Type)
+		}
+		for _, field := range def.Fields {
+			if _, ok := properties[field.Name]; !ok {
+				t.Fatalf("%s schema lacks field %s", def.Type, field.Name)
+			}
+		}
+	}
+}
+
+func TestOpenAPIReferencesResolve(t *testing.T) {
+	doc := fetchOpenAPI(t)
+	components := doc["components"].(map[string]any)
+
+	var walk func(node any)
+	walk = func(node any) {
+		switch value := node.(type) {
+		case map[string]any:
+			if target, ok := value["$ref"].(string); ok {
+				parts := strings.Split(strings.TrimPrefix(target, "#/components/"), "/")
+				section, _ := components[parts[0]].(map[string]any)
+				if len(parts) != 2 || section == nil || section[parts[1]] == nil {
+					t.Errorf("unresolved reference %s", target)
+				}
+			}
+			for _, child := range value {
+				walk(child)
+			}
+		case []any:
+			for _, child := range value {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+}
+
+func difference(a, b map[string]bool) []string {
+	var out []string
+	for key := range a {
+		if !b[key] {
+			out = append(out, key)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+const problemContentType = "application/problem+json"
+
+// problemTypePrefix prefixes the stable code to form the RFC 9457 type URI.
+const problemTypePrefix = "urn:clock-server:problem:"
+
+// Stable, machine-readable error codes. Clients branch on these; detail
+// messages may change.
+const (
+	codeInvalidBody                = "invalid_body"
+	codeBodyTooLarge               = "body_too_large"
+	codeValidationFailed           = "validation_failed"
+	codeUnknownCommandType         = "unknown_command_type"
+	codeUnauthorized               = "unauthorized"
+	codeForbidden                  = "forbidden"
+	codeHTTPSRequired              = "https_required"
+	codeTooManyAuthFailures        = "too_many_auth_failures"
+	codeMethodNotAllowed           = "method_not_allowed"
+	codeMissingParameter           = "missing_parameter"
+	codeRoutingNotConfigured       = "routing_not_configured"
+	codeEventsNotConfigured        = "events_not_configured"
+	codeInvalidLastEventID         = "invalid_last_event_id"
+	codeDeviceChannelNotConfigured = "device_channel_not_configured"
+	codeInvalidUpgrade             = "invalid_upgrade"
+	codeInvalidBatch               = "invalid_batch"
+	codeBatchValidationFailed      = "batch_validation_failed"
+	codeInvalidIdempotencyKey      = "invalid_idempotency_key"
+	codeIdempotencyKeyReused       = "idempotency_key_reused"
+	codeIdempotencyKeyInFlight     = "idempotency_key_in_progress"
+	codeDispatchFailed             = "dispatch_failed"
+	codeInternal                   = "internal_error"
+
+	codeCredentialStoreNotConfigured = "credential_store_not_configured"
+	codeCredentialNotFound           = "credential_not_found"
+	codeCredentialExists             = "credential_exists"
+	codeCredentialReadOnly           = "credential_read_only"
+
+	codeRateLimited   = "rate_limited"
+	codeQuotaExceeded = "quota_exceeded"
+
+	codeAuditLogNotConfigured = "audit_log_not_configured"
+)
+
+// problem is an RFC 9457 problem details object. Code is a stable identifier
+// and Detail a message that is safe to show to clients. Deliveries, Mode and
+// Results are extensions used by dispatch failures and rejected batches.
+type problem struct {
+	Type       string            `json:"type"`
+	Title      string            `json:"title"`
+	Status     int               `json:"status"`
+	Code       string            `json:"code"`
+	Detail     string            `json:"detail,omitempty"`
+	RequestID  string            `json:"requestId,omitempty"`
+	Errors     []fieldError      `json:"errors,omitempty"`
+	Deliveries []deliveryResult  `json:"deliveries,omitempty"`
+	Mode       string            `json:"mode,omitempty"`
+	Results    []batchItemResult `json:"results,omitempty"`
+}
+
+// fieldError points at a single request field.
+type fieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// writeProblem completes p with its type, title and the request ID set by the
+// auth middleware, and writes it as application/problem+json.
+func writeProblem(w http.ResponseWriter, p problem) {
+	p.Type = problemTypePrefix + p.Code
+	p.Title = http.StatusText(p.Status)
+	p.RequestID = w.Header().Get("X-Request-Id")
+	setSecurityHeaders(w)
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+func writeError(w http.ResponseWriter, status int, code, detail string) {
+	writeProblem(w, problem{Status: status, Code: code, Detail: detail})
+}
+
+func methodNotAllowed(w http.ResponseWriter) {
+	writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+}
+
+// writeAppError writes the problem describing err.
+func writeAppError(w http.ResponseWriter, err error) {
+	writeProblem(w, describeError(err))
+}
+
+// describeError maps body, validation and dispatch errors onto a problem.
+// Only messages known to be safe are exposed; anything else is reported by
+// code alone.
+func describeError(err error) problem {
+	var tooLarge *http.MaxBytesError
+	var bodyErr commands.BodyError
+	var validationErr domain.ValidationError
+	switch {
+	case errors.As(err, &tooLarge):
+		return problem{Status: http.StatusRequestEntityTooLarge, Code: codeBodyTooLarge, Detail: fmt.Sprintf("request body too large; the limit is %d bytes", tooLarge.Limit)}
+	case errors.As(err, &bodyErr):
+		return problem{Status: http.StatusBadRequest, Code: codeInvalidBody, Detail: bodyErr.Message, Errors: fieldErrors(bodyErr.Field, bodyErr.Message)}
+	case errors.As(err, &validationErr):
+		return problem{Status: http.StatusBadRequest, Code: codeValidationFailed, Detail: validationErr.Message, Errors: fieldErrors(validationErr.Field, validationErr.Message)}
+	case errors.Is(err, application.ErrValidation):
+		return problem{Status: http.StatusBadRequest, Code: codeValidationFailed, Detail: "invalid command"}
+	case errors.Is(err, application.ErrDownstream):
+		return problem{Status: http.StatusBadGateway, Code: codeDispatchFailed, Detail: "command dispatch failed"}
+	default:
+		return problem{Status: http.StatusInternalServerError, Code: codeInternal, Detail: "internal error"}
+	}
+}
+
+func fieldErrors(field, detail string) []fieldError {
+	if field == "" {
+		return nil
+	}
+	return []fieldError{{Field: field, Detail: detail}}
+}
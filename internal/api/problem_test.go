@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/commands"
+	"github.com/paul/clock-server/internal/domain"
+)
+
+func TestDescribeErrorMapsToStableCodes(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		detail string
+		field  string
+	}{
+		{"body too large", fmt.Errorf("read: %w", &http.MaxBytesError{Limit: 16}), http.StatusRequestEntityTooLarge, codeBodyTooLarge, "request body too large; the limit is 16 bytes", ""},
+		{"body", commands.BodyError{Field: "level", Message: "level must be an integer"}, http.StatusBadRequest, codeInvalidBody, "level must be an integer", "level"},
+		{"validation", fmt.Errorf("%w: execute: %w", application.ErrValidation, domain.NewFieldValidationError("message", "message is required")), http.StatusBadRequest, codeValidationFailed, "message is required", "message"},
+		{"downstream", fmt.Errorf("%w: mqtt: broker gone", application.ErrDownstream), http.StatusBadGateway, codeDispatchFailed, "command dispatch failed", ""},
+		{"unknown", errors.New("secret internals"), http.StatusInternalServerError, codeInternal, "internal error", ""},
+	}
+	for _, tc := range cases {
+		p := describeError(tc.err)
+		if p.Status != tc.status || p.Code != tc.code || p.Detail != tc.detail {
+			t.Fatalf("%s: unexpected problem %+v", tc.name, p)
+		}
+		if tc.field == "" && len(p.Errors) != 0 || tc.field != "" && (len(p.Errors) != 1 || p.Errors[0].Field != tc.field) {
+			t.Fatalf("%s: unexpected field errors %+v", tc.name, p.Errors)
+		}
+	}
+}
+
+func TestUnauthorizedResponseIsProblem(t *testing.T) {
+	h := newTestHandler(&stubSender{})
+	req := httptest.NewRequest(http.MethodPost, "/commands/messages", strings.NewReader(`{}`))
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("Content-Type") != problemContentType {
+		t.Fatalf("expected 401 problem, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `"code":"unauthorized"`) || !strings.Contains(body, `"requestId":"req-`) {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
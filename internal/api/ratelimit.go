@@ -0,0 +1,312 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/domain"
+	"github.com/paul/clock-server/internal/security"
+)
+
+// limiterErrorInterval is the minimum time between two logged limiter
+// errors, so an unreachable backend does not flood the log.
+const limiterErrorInterval = 10 * time.Second
+
+// WithRateLimiter keeps the auth failure and command limit counters in
+// limiter instead of in this process, so that replicas sharing it enforce
+// the limits together. While limiter fails, each replica enforces the
+// limits on its own counters and reports the limiter as degraded.
+func (h *Handler) WithRateLimiter(limiter application.RateLimiter) *Handler {
+	h.limiter = limiter
+	h.fallbackLimiter = newMemoryLimiter()
+	return h
+}
+
+// WithRateLimits limits, per command type, how many commands each principal
+// may send and each device may receive, and how many commands each principal
+// may send per UTC day. Empty limits and quotas are not enforced.
+func (h *Handler) WithRateLimits(principal, device security.RateLimits, quotas security.Quotas) *Handler {
+	h.rateLimits = commandLimits{principal: principal, device: device, quotas: quotas}
+	return h
+}
+
+// commandLimits are the configured command rate limits and daily quotas.
+type commandLimits struct {
+	principal security.RateLimits
+	device    security.RateLimits
+	quotas    security.Quotas
+}
+
+// limitsFor returns the limits a command of commandType from principalID to
+// deviceID is charged against at now.
+func (c commandLimits) limitsFor(principalID, deviceID, commandType string, now time.Time) []application.Limit {
+	var limits []application.Limit
+	if bucket, limit, ok := c.principal.For(commandType); ok {
+		limits = append(limits, application.Limit{Key: "principal|" + principalID + "|" + bucket, Count: limit.Count, Per: limit.Per})
+	}
+	if bucket, limit, ok := c.device.For(commandType); ok {
+		limits = append(limits, application.Limit{Key: "device|" + deviceID + "|" + bucket, Count: limit.Count, Per: limit.Per})
+	}
+	if quota, ok := c.quotas.For(principalID); ok {
+		day, until := utcDay(now)
+		limits = append(limits, application.Limit{Key: "quota|" + principalID + "|" + day, Count: quota, Until: until})
+	}
+	return limits
+}
+
+// allowCommand charges cmd to the command limits and sets the RateLimit
+// headers. When the command is rejected it is audited and false returned;
+// the caller reports the rejection.
+func (h *Handler) allowCommand(w http.ResponseWriter, r *http.Request, cmd domain.ClockCommand) (application.LimitStatus, bool) {
+	status, limited := h.chargeCommands(r, cmd)
+	if !limited {
+		return application.LimitStatus{}, true
+	}
+	setRateLimitHeaders(w, status)
+	if status.Exhausted {
+		h.audit(r, cmd, rateLimitCode(status), application.DeliveryReport{})
+		return status, false
+	}
+	return status, true
+}
+
+// chargeCommands charges cmds together to the command limits: either every
+// command is charged or, when a limit cannot take them all, none is. It
+// reports false when no limit applies.
+func (h *Handler) chargeCommands(r *http.Request, cmds ...domain.ClockCommand) (application.LimitStatus, bool) {
+	principalID := "unknown"
+	if pr, ok := r.Context().Value(principalContextKey).(principal); ok {
+		principalID = pr.ID
+	}
+	now := h.now()
+	var limits []application.Limit
+	index := make(map[string]int)
+	for _, cmd := range cmds {
+		for _, limit := range h.rateLimits.limitsFor(principalID, cmd.TargetDeviceID(), cmd.CommandType(), now) {
+			if i, ok := index[limit.Key]; ok {
+				limits[i].Cost++
+				continue
+			}
+			limit.Cost = 1
+			index[limit.Key] = len(limits)
+			limits = append(limits, limit)
+		}
+	}
+	if len(limits) == 0 {
+		return application.LimitStatus{}, false
+	}
+	return h.takeLimits(r.Context(), limits), true
+}
+
+// authBlocked reports whether key has reached the auth failure limit.
+func (h *Handler) authBlocked(ctx context.Context, key string) bool {
+	return h.authFailures(ctx, key) >= h.authFailLimit
+}
+
+// argon2Gate admits at most authFailLimit Argon2id verifications per client
+// IP and minute, whatever the token. Each admission is charged before the
+// verification runs, so concurrent requests with unknown tokens cannot all
+// reach the slow path; tokens already verified are cached and not charged.
+type argon2Gate struct {
+	h       *Handler
+	ctx     context.Context
+	key     string
+	decided bool
+	denied  bool
+}
+
+func (h *Handler) newArgon2Gate(ctx context.Context, remoteIP string) *argon2Gate {
+	return &argon2Gate{h: h, ctx: ctx, key: "argon2:" + remoteIP}
+}
+
+// admit charges the client IP once per authentication attempt, and denies
+// the attempt when the charge exceeds the limit.
+func (g *argon2Gate) admit() bool {
+	if g.decided {
+		return !g.denied
+	}
+	g.decided = true
+	g.h.recordAuthFailure(g.ctx, g.key)
+	g.denied = g.h.authFailures(g.ctx, g.key) > g.h.authFailLimit
+	return !g.denied
+}
+
+func (h *Handler) recordAuthFailure(ctx context.Context, key string) {
+	err := h.limiter.RecordAuthFailure(ctx, key, time.Minute)
+	if h.limiterResult(err) {
+		_ = h.fallbackLimiter.RecordAuthFailure(ctx, key, time.Minute)
+	}
+}
+
+func (h *Handler) authFailures(ctx context.Context, key string) int {
+	failures, err := h.limiter.AuthFailures(ctx, key)
+	if h.limiterResult(err) {
+		failures, _ = h.fallbackLimiter.AuthFailures(ctx, key)
+	}
+	return failures
+}
+
+func (h *Handler) takeLimits(ctx context.Context, limits []application.Limit) application.LimitStatus {
+	status, err := h.limiter.Take(ctx, limits, h.now())
+	if h.limiterResult(err) {
+		status, _ = h.fallbackLimiter.Take(ctx, limits, h.now())
+	}
+	return status
+}
+
+// limiterResult records the outcome of a limiter call and reports whether
+// the call has to be repeated on the fallback limiter. A failing shared
+// limiter marks the limiter degraded until a call succeeds again, so an
+// outage of its backend neither stops the API nor lifts the limits.
+func (h *Handler) limiterResult(err error) bool {
+	if err == nil {
+		h.limiterDegraded.Store(false)
+		return false
+	}
+	h.limiterErrors.Add(1)
+	h.limiterDegraded.Store(true)
+	now := h.now().UnixNano()
+	last := h.limiterErrorAt.Load()
+	if now-last >= int64(limiterErrorInterval) && h.limiterErrorAt.CompareAndSwap(last, now) {
+		log.Printf("rate limiter unavailable, enforcing limits per replica: %v", err)
+	}
+	return h.fallbackLimiter != nil
+}
+
+// limiterMetrics reports the errors and state of the shared rate limiter.
+func (h *Handler) limiterMetrics() []application.Metric {
+	if h.fallbackLimiter == nil {
+		return nil
+	}
+	degraded := 0.0
+	if h.limiterDegraded.Load() {
+		degraded = 1
+	}
+	return []application.Metric{
+		{Name: "clock_rate_limiter_errors_total", Help: "Failed calls to the shared rate limiter.", Type: "counter", Value: float64(h.limiterErrors.Load())},
+		{Name: "clock_rate_limiter_degraded", Help: "Whether limits are enforced per replica because the shared rate limiter failed.", Type: "gauge", Value: degraded},
+	}
+}
+
+func rateLimitCode(status application.LimitStatus) string {
+	if status.Quota {
+		return codeQuotaExceeded
+	}
+	return codeRateLimited
+}
+
+// rateLimitProblem describes a rejected command.
+func rateLimitProblem(status application.LimitStatus) problem {
+	detail := fmt.Sprintf("rate limit exceeded; retry in %d seconds", ceilSeconds(status.RetryAfter))
+	if status.Quota {
+		detail = fmt.Sprintf("daily command quota of %d exhausted; retry in %d seconds", status.Limit, ceilSeconds(status.RetryAfter))
+	}
+	return problem{Status: http.StatusTooManyRequests, Code: rateLimitCode(status), Detail: detail}
+}
+
+// setRateLimitHeaders reports the status in the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers of the IETF RateLimit
+// header fields draft, and in Retry-After when the command was rejected.
+func setRateLimitHeaders(w http.ResponseWriter, status application.LimitStatus) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(status.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(status.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(status.Reset), 10))
+	if status.Exhausted {
+		w.Header().Set("Retry-After", strconv.FormatInt(max(ceilSeconds(status.RetryAfter), 1), 10))
+	}
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// utcDay returns the UTC date of now and the time it ends.
+func utcDay(now time.Time) (string, time.Time) {
+	utc := now.UTC()
+	return utc.Format(time.DateOnly), time.Date(utc.Year(), utc.Month(), utc.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// memoryLimiter is the default application.RateLimiter. Its counters are
+// kept in this process, so each replica enforces the limits on its own.
+type memoryLimiter struct {
+	failures *authFailureLimiter
+
+	mu     sync.Mutex
+	states map[string]limitEntry
+}
+
+// limitEntry is the stored state of a limit and when it can be forgotten.
+type limitEntry struct {
+	state   application.LimitState
+	expires time.Time
+}
+
+func newMemoryLimiter() *memoryLimiter {
+	return &memoryLimiter{failures: newAuthFailureLimiter(), states: make(map[string]limitEntry)}
+}
+
+func (l *memoryLimiter) AuthFailures(_ context.Context, key string) (int, error) {
+	return l.failures.Failures(key), nil
+}
+
+func (l *memoryLimiter) RecordAuthFailure(_ context.Context, key string, window time.Duration) error {
+	l.failures.RecordFailure(key, window)
+	return nil
+}
+
+func (l *memoryLimiter) Take(_ context.Context, limits []application.Limit, now time.Time) (application.LimitStatus, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.evictIfNeeded(now)
+
+	states := make([]application.LimitState, len(limits))
+	for i, limit := range limits {
+		if entry, ok := l.states[limit.Key]; ok && now.Before(entry.expires) {
+			states[i] = entry.state
+		}
+	}
+	status, next := application.ChargeLimits(limits, states, now)
+	for i, state := range next {
+		l.states[limits[i].Key] = limitEntry{state: state, expires: now.Add(limits[i].StateTTL(now))}
+	}
+	return status, nil
+}
+
+// evictIfNeeded keeps the states within maxLimiterEntries. It first removes
+// expired states, which behave like missing ones, then those expiring
+// soonest. Must be called with l.mu held.
+func (l *memoryLimiter) evictIfNeeded(now time.Time) {
+	if len(l.states) < maxLimiterEntries {
+		return
+	}
+	for key, entry := range l.states {
+		if !now.Before(entry.expires) {
+			delete(l.states, key)
+		}
+	}
+	if len(l.states) < maxLimiterEntries {
+		return
+	}
+
+	type keyed struct {
+		key  string
+		time time.Time
+	}
+	all := make([]keyed, 0, len(l.states))
+	for k, e := range l.states {
+		all = append(all, keyed{k, e.expires})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].time.Before(all[j].time) })
+	toRemove := len(l.states) - maxLimiterEntries + 1
+	for i := 0; i < toRemove && i < len(all); i++ {
+		delete(l.states, all[i].key)
+	}
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paul/clock-server/internal/adapters/idempotency"
+	"github.com/paul/clock-server/internal/application"
+	"github.com/paul/clock-server/internal/security"
+)
+
+func newRateLimitedHandler(t *testing.T, principal, device, quotas string) (*Handler, *time.Time) {
+	t.Helper()
+	principalLimits, err := security.ParseRateLimits(principal)
+	if err != nil {
+		t.Fatalf("parse principal limits: %v", err)
+	}
+	deviceLimits, err := security.ParseRateLimits(device)
+	if err != nil {
+		t.Fatalf("parse device limits: %v", err)
+	}
+	dailyQuotas, err := security.ParseQuotas(quotas)
+	if err != nil {
+		t.Fatalf("parse quotas: %v", err)
+	}
+	h := NewHandler(
+		application.NewCommandDispatcher(&stubSender{}),
+		[]security.Credential{
+			{ID: "ops", Token: "ops-token", Devices: []string{"*"}},
+			{ID: "kiosk", Token: "kiosk-token", Devices: []string{"*"}},
+		},
+		false, false, true, 64*1024, 100,
+	).WithRateLimits(principalLimits, deviceLimits, dailyQuotas)
+	now := time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+	return h, &now
+}
+
+func sendCommand(router http.Handler, token, commandType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/commands/"+commandType, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+const (
+	limitedMessage    = `{"deviceId":"clock-1","message":"hi","durationSeconds":5}`
+	limitedBrightness = `{"deviceId":"clock-1","level":50}`
+)
+
+func TestPrincipalRateLimitPerCommandType(t *testing.T) {
+	h, now := newRateLimitedHandler(t, "display_message=2/m", "", "")
+	router := h.Routes()
+
+	for i, remaining := range []string{"1", "0"} {
+		rr := sendCommand(router, "ops-token", "display_message", limitedMessage)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("message %d: expected 202, got %d: %s", i, rr.Code, rr.Body.String())
+		}
+		if rr.Header().Get("RateLimit-Limit") != "2" || rr.Header().Get("RateLimit-Remaining") != remaining {
+			t.Fatalf("message %d: unexpected RateLimit headers %v", i, rr.Header())
+		}
+	}
+	rr := sendCommand(router, "ops-token", "display_message", limitedMessage)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "30" {
+		t.Fatalf("expected 429 with Retry-After: 30, got %d %q: %s", rr.Code, rr.Header().Get("Retry-After"), rr.Body.String())
+	}
+	var body problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body.Code != codeRateLimited {
+		t.Fatalf("expected problem code %s, got %+v (%v)", codeRateLimited, body, err)
+	}
+
+	if rr := sendCommand(router, "kiosk-token", "display_message", limitedMessage); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected another principal to have its own bucket, got %d", rr.Code)
+	}
+	if rr := sendCommand(router, "ops-token", "set_brightness", limitedBrightness); rr.Code != http.StatusAccepted || rr.Header().Get("RateLimit-Limit") != "" {
+		t.Fatalf("expected an unlimited command type to pass without headers, got %d %v", rr.Code, rr.Header())
+	}
+	if rr := sendCommand(router, "ops-token", "display_message", `{"deviceId":"clock-1","message":"","durationSeconds":5}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid command to be rejected as invalid, got %d", rr.Code)
+	}
+
+	*now = now.Add(30 * time.Second)
+	if rr := sendCommand(router, "ops-token", "display_message", limitedMessage); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected a token to be refilled after 30s, got %d", rr.Code)
+	}
+}
+
+func TestDeviceRateLimitIsSharedByPrincipals(t *testing.T) {
+	h, _ := newRateLimitedHandler(t, "", "*=1/h", "")
+	router := h.Routes()
+
+	if rr := sendCommand(router, "ops-token", "display_message", limitedMessage); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+	rr := sendCommand(router, "kiosk-token", "set_brightness", limitedBrightness)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "3600" {
+		t.Fatalf("expected the device limit to apply across principals and types, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if rr := sendCommand(router, "kiosk-token", "set_brightness", `{"deviceId":"clock-2","level":50}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected another device to have its own bucket, got %d", rr.Code)
+	}
+}
+
+func TestDailyQuotaResetsAtUTCMidnight(t *testing.T) {
+	h, now := newRateLimitedHandler(t, "*=10/s", "", "*=2;kiosk=1")
+	router := h.Routes()
+
+	if rr := sendCommand(router, "kiosk-token", "display_message", limitedMessage); rr.Code != http.StatusAccepted || rr.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("expected the quota to be the most restrictive limit, got %d %v", rr.Code, rr.Header())
+	}
+	rr := sendCommand(router, "kiosk-token", "display_message", limitedMessage)
+	var body problem
+	_ = json.Unmarshal(rr.Body.Bytes(), &body)
+	if rr.Code != http.StatusTooManyRequests || body.Code != codeQuotaExceeded || rr.Header().Get("Retry-After") != "3600" {
+		t.Fatalf("expected quota_exceeded until midnight, got %d %s %q", rr.Code, body.Code, rr.Header().Get("Retry-After"))
+	}
+	if rr := sendCommand(router, "ops-token", "display_message", limitedMessage); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected the default quota to apply to ops separately, got %d", rr.Code)
+	}
+
+	*now = now.Add(time.Hour)
+	if rr := sendCommand(router, "kiosk-token", "display_message", limitedMessage); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected the quota to reset the next day, got %d", rr.Code)
+	}
+}
+
+func TestRateLimitedBatchItemsAndIdempotentRetries(t *testing.T) {
+	h, now := newRateLimitedHandler(t, "display_message=1/m", "", "")
+	router := h.WithIdempotency(idempotency.NewMemoryStore(time.Hour, 100)).Routes()
+
+	batch := `{"mode":"best-effort","commands":[
+		{"type":"display_message","deviceId":"clock-1","message":"a","durationSeconds":5},
+		{"type":"display_message","deviceId":"clock-2","message":"b","durationSeconds":5},
+		{"type":"set_brightness","deviceId":"clock-1","level":10}]}`
+	rr := sendCommand(router, "ops-token", "batch", batch)
+	var resp batchResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || rr.Code != http.StatusMultiStatus {
+		t.Fatalf("expected 207, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if resp.Results[0].Status != batchItemAccepted || resp.Results[1].Status != batchItemLimited || resp.Results[1].Code != codeRateLimited || resp.Results[2].Status != batchItemAccepted {
+		t.Fatalf("unexpected batch results %+v", resp.Results)
+	}
+	if rr.Header().Get("RateLimit-Remaining") != "0" || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected the headers to report the exhausted limit, got %v", rr.Header())
+	}
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/commands/display_message", strings.NewReader(limitedMessage))
+		req.Header.Set("Authorization", "Bearer ops-token")
+		req.Header.Set(idempotencyKeyHeader, "retry-1")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := send(); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rr.Code)
+	}
+	*now = now.Add(time.Minute)
+	if rr := send(); rr.Code != http.StatusAccepted || rr.Header().Get(idempotentReplayedHeader) != "" {
+		t.Fatalf("expected the retry with the same key to be dispatched, got %d %v", rr.Code, rr.Header())
+	}
+}
+
+func TestAtomicBatchIsChargedAsAWhole(t *testing.T) {
+	h, _ := newRateLimitedHandler(t, "display_message=2/m", "", "")
+	router := h.Routes()
+	item := func(device string) string {
+		return `{"type":"display_message","deviceId":"` + device + `","message":"hi","durationSeconds":5}`
+	}
+
+	rr := sendCommand(router, "ops-token", "batch", `{"commands":[`+item("clock-1")+`,`+item("clock-2")+`,`+item("clock-3")+`]}`)
+	var rejected problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &rejected); err != nil || rr.Code != http.StatusTooManyRequests || rejected.Code != codeRateLimited || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected the batch to be rejected as a whole, got %d: %s", rr.Code, rr.Body.String())
+	}
+	for _, result := range rejected.Results {
+		if result.Status != batchItemLimited {
+			t.Fatalf("expected every item to be rate limited, got %+v", rejected.Results)
+		}
+	}
+
+	rr = sendCommand(router, "ops-token", "batch", `{"commands":[`+item("clock-1")+`,`+item("clock-2")+`]}`)
+	if rr.Code != http.StatusAccepted || rr.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("expected the rejected batch to leave the budget untouched, got %d %v", rr.Code, rr.Header())
+	}
+}
+
+// failingLimiter is a RateLimiter whose backend is unreachable.
+type failingLimiter struct{}
+
+func (failingLimiter) AuthFailures(context.Context, string) (int, error) {
+	return 0, errors.New("connection refused")
+}
+
+func (failingLimiter) RecordAuthFailure(context.Context, string, time.Duration) error {
+	return errors.New("connection refused")
+}
+
+func (failingLimiter) Take(context.Context, []application.Limit, time.Time) (application.LimitStatus, error) {
+	return application.LimitStatus{}, errors.New("connection refused")
+}
+
+func TestRateLimiterIsSharedByHandlers(t *testing.T) {
+	shared := newMemoryLimiter()
+	a, _ := newRateLimitedHandler(t, "*=1/m", "", "")
+	b, _ := newRateLimitedHandler(t, "*=1/m", "", "")
+	a.WithRateLimiter(shared)
+	b.WithRateLimiter(shared)
+
+	if rr := sendCommand(a.Routes(), "ops-token", "display_message", limitedMessage); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+	if rr := sendCommand(b.Routes(), "ops-token", "display_message", limitedMessage); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the second replica to see the shared bucket, got %d", rr.Code)
+	}
+}
+
+func TestFailingRateLimiterFallsBackToLocalLimits(t *testing.T) {
+	h, _ := newRateLimitedHandler(t, "*=1/m", "", "")
+	h.authFailLimit = 2
+	h.WithRateLimiter(failingLimiter{})
+	router := h.Routes()
+
+	if rr := sendCommand(router, "ops-token", "display_message", limitedMessage); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+	if rr := sendCommand(router, "ops-token", "display_message", limitedMessage); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the local limit to apply, got %d", rr.Code)
+	}
+	for i := 0; i < 2; i++ {
+		if rr := sendCommand(router, "wrong-token", "display_message", limitedMessage); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i, rr.Code)
+		}
+	}
+	if rr := sendCommand(router, "wrong-token", "display_message", limitedMessage); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected auth failures to stay limited, got %d", rr.Code)
+	}
+
+	// The blocked address cannot authenticate, so query from another one.
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+	req.Header.Set("Authorization", "Bearer ops-token")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if body := rr.Body.String(); !strings.Contains(body, "clock_rate_limiter_degraded 1\n") || strings.Contains(body, "clock_rate_limiter_errors_total 0\n") {
+		t.Fatalf("expected the degraded limiter in the metrics, got:\n%s", body)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/ready", nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+	req.Header.Set("Authorization", "Bearer ops-token")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), `"rateLimiter":{"degraded":true}`) {
+		t.Fatalf("expected the degraded limiter in /ready, got %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const apiVersionHeader = "API-Version"
+
+// legacyDeprecatedAt is when the unversioned routes were deprecated in favour
+// of /v1. It is sent in the Deprecation header (RFC 9745).
+var legacyDeprecatedAt = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+
+// apiVersion is a major version of the command API. Handlers are shared by
+// all versions and read the version of the request from its context, so a
+// later version only needs to branch where its payload shapes differ.
+type apiVersion struct {
+	// name identifies the payload shapes, e.g. "v1".
+	name string
+	// prefix is prepended to every route of the version.
+	prefix string
+}
+
+var (
+	apiV1 = apiVersion{name: "v1", prefix: "/v1"}
+
+	// apiVersions lists the served versions, oldest first. The last entry is
+	// the preferred version advertised by /versions.
+	apiVersions = []apiVersion{apiV1}
+
+	// legacyAPI serves the unversioned routes with v1 payload shapes.
+	legacyAPI = apiVersion{name: "v1"}
+)
+
+type versionContextKey struct{}
+
+func withAPIVersion(r *http.Request, version apiVersion) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), versionContextKey{}, version))
+}
+
+// requestAPIVersion returns the API version a request was routed to.
+func requestAPIVersion(ctx context.Context) apiVersion {
+	if version, ok := ctx.Value(versionContextKey{}).(apiVersion); ok {
+		return version
+	}
+	return legacyAPI
+}
+
+func preferredAPIVersion() apiVersion {
+	return apiVersions[len(apiVersions)-1]
+}
+
+// WithLegacySunset sets the Sunset date (RFC 8594) announced on responses of
+// the unversioned routes. The zero time omits the header.
+func (h *Handler) WithLegacySunset(sunset time.Time) *Handler {
+	h.legacySunset = sunset
+	return h
+}
+
+// versioned serves next as part of version.
+func versioned(version apiVersion, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(apiVersionHeader, version.name)
+		next(w, withAPIVersion(r, version))
+	}
+}
+
+// legacy serves next on an unversioned route and marks the response as
+// deprecated. successor is the replacement path; when empty the same path
+// under the preferred version is linked.
+func (h *Handler) legacy(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		link := successor
+		if link == "" {
+			link = preferredAPIVersion().prefix + r.URL.Path
+		}
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(legacyDeprecatedAt.Unix(), 10))
+		if !h.legacySunset.IsZero() {
+			w.Header().Set("Sunset", h.legacySunset.UTC().Format(http.TimeFormat))
+		}
+		w.Header().Add("Link", "<"+link+`>; rel="successor-version"`)
+		versioned(legacyAPI, next)(w, r)
+	}
+}
+
+type versionInfo struct {
+	Version     string `json:"version"`
+	Path        string `json:"path"`
+	Status      string `json:"status"`
+	Deprecation string `json:"deprecation,omitempty"`
+	Sunset      string `json:"sunset,omitempty"`
+}
+
+type versionsResponse struct {
+	Preferred string        `json:"preferred"`
+	Versions  []versionInfo `json:"versions"`
+}
+
+// handleVersions is the unauthenticated discovery endpoint clients use to
+// pick an API version.
+func (h *Handler) handleVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w)
+		return
+	}
+	resp := versionsResponse{Preferred: preferredAPIVersion().name}
+	for _, version := range apiVersions {
+		resp.Versions = append(resp.Versions, versionInfo{Version: version.name, Path: version.prefix, Status: "current"})
+	}
+	legacy := versionInfo{Version: "legacy", Path: "/", Status: "deprecated", Deprecation: legacyDeprecatedAt.Format(time.RFC3339)}
+	if !h.legacySunset.IsZero() {
+		legacy.Sunset = h.legacySunset.UTC().Format(time.RFC3339)
+	}
+	resp.Versions = append(resp.Versions, legacy)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// commandTypeFromPath extracts {type} from <prefix>/commands/{type}.
+func commandTypeFromPath(r *http.Request) string {
+	return strings.TrimPrefix(r.URL.Path, requestAPIVersion(r.Context()).prefix+"/commands/")
+}
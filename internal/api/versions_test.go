@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVersionedRoutesShareHandlers(t *testing.T) {
+	sender := &stubSender{}
+	h := newTestHandler(sender)
+	body := []byte(`{"deviceId":"clock-1","level":40}`)
+
+	for _, path := range []string{"/v1/commands/set_brightness", "/commands/set_brightness"} {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		h.Routes().ServeHTTP(rr, req)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("%s: expected status 202, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+		if rr.Header().Get(apiVersionHeader) != "v1" {
+			t.Fatalf("%s: expected API-Version v1, got %q", path, rr.Header().Get(apiVersionHeader))
+		}
+	}
+	if sender.calls != 2 {
+		t.Fatalf("expected 2 dispatches, got %d", sender.calls)
+	}
+}
+
+func TestLegacyRoutesCarryDeprecationHeaders(t *testing.T) {
+	sunset := time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC)
+	h := newTestHandler(&stubSender{}).WithLegacySunset(sunset)
+
+	req := httptest.NewRequest(http.MethodPut, "/commands/brightness", bytes.NewReader([]byte(`{"deviceId":"clock-1","level":40}`)))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Deprecation"); got != "@1792281600" {
+		t.Fatalf("unexpected Deprecation header: %q", got)
+	}
+	if got := rr.Header().Get("Sunset"); got != "Thu, 01 Apr 2027 00:00:00 GMT" {
+		t.Fatalf("unexpected Sunset header: %q", got)
+	}
+	if got := rr.Header().Get("Link"); got != `</v1/commands/set_brightness>; rel="successor-version"` {
+		t.Fatalf("unexpected Link header: %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/commands/set_brightness", bytes.NewReader([]byte(`{"deviceId":"clock-1","level":40}`)))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	if rr.Header().Get("Deprecation") != "" || rr.Header().Get("Sunset") != "" {
+		t.Fatalf("versioned route must not be deprecated: %v", rr.Header())
+	}
+}
+
+func TestVersionedRoutesRejectUnknownCommandType(t *testing.T) {
+	h := newTestHandler(&stubSender{})
+	req := httptest.NewRequest(http.MethodPost, "/v1/commands/reboot", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestVersionsDiscovery(t *testing.T) {
+	sunset := time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC)
+	h := newTestHandler(&stubSender{}).WithLegacySunset(sunset)
+	req := httptest.NewRequest(http.MethodGet, "/versions", nil)
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 without auth, got %d", rr.Code)
+	}
+	var resp versionsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Preferred != "v1" || len(resp.Versions) != 2 {
+		t.Fatalf("unexpected versions: %+v", resp)
+	}
+	if resp.Versions[0] != (versionInfo{Version: "v1", Path: "/v1", Status: "current"}) {
+		t.Fatalf("unexpected v1 entry: %+v", resp.Versions[0])
+	}
+	if legacy := resp.Versions[1]; legacy.Status != "deprecated" || legacy.Sunset != "2027-04-01T00:00:00Z" {
+		t.Fatalf("unexpected legacy entry: %+v", legacy)
+	}
+}
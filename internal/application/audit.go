@@ -0,0 +1,95 @@
+package application
+
+import "time"
+
+// Audit actions besides the admin actions on credentials.
+const (
+	AuditActionCommand           = "command"
+	AuditActionCredentialsReload = "credentials_reload"
+)
+
+// AuditRecord is a structured audit entry for a command or an admin action.
+// Parameters are the command-specific fields; the audit log may redact them.
+type AuditRecord struct {
+	Time         time.Time       `json:"time"`
+	Action       string          `json:"action"`
+	Principal    string          `json:"principal"`
+	CertIdentity string          `json:"cert,omitempty"`
+	RemoteIP     string          `json:"remoteIp"`
+	RequestID    string          `json:"requestId,omitempty"`
+	Method       string          `json:"method"`
+	Path         string          `json:"path"`
+	DeviceID     string          `json:"deviceId,omitempty"`
+	CommandType  string          `json:"commandType,omitempty"`
+	Parameters   map[string]any  `json:"parameters,omitempty"`
+	Credential   string          `json:"credential,omitempty"`
+	Result       string          `json:"result"`
+	Deliveries   []AuditDelivery `json:"deliveries,omitempty"`
+}
+
+// AuditDelivery is the outcome of one sender for an audited command.
+type AuditDelivery struct {
+	Sender     string `json:"sender"`
+	Status     string `json:"status"`
+	DurationMS int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// AuditDeliveries converts a delivery report for an AuditRecord.
+func AuditDeliveries(report DeliveryReport) []AuditDelivery {
+	var out []AuditDelivery
+	for _, result := range report.Results {
+		d := AuditDelivery{Sender: result.Sender, Status: result.Status, DurationMS: result.Duration.Milliseconds()}
+		if result.Err != nil {
+			d.Error = result.Err.Error()
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// AuditEntry is a stored AuditRecord with its position in the log's hash
+// chain: Hash covers the record, Seq and PrevHash, the Hash of the entry
+// before it.
+type AuditEntry struct {
+	Seq      uint64 `json:"seq"`
+	PrevHash string `json:"prevHash"`
+	AuditRecord
+	Hash string `json:"hash"`
+}
+
+// AuditQuery selects audit entries. Empty fields match every entry; a zero
+// Limit leaves the choice to the log.
+type AuditQuery struct {
+	Principal   string
+	DeviceID    string
+	CommandType string
+	Action      string
+	Result      string
+	Since       time.Time
+	Until       time.Time
+	Limit       int
+}
+
+// Matches reports whether entry is selected by q, ignoring Limit.
+func (q AuditQuery) Matches(entry AuditEntry) bool {
+	switch {
+	case q.Principal != "" && entry.Principal != q.Principal,
+		q.DeviceID != "" && entry.DeviceID != q.DeviceID,
+		q.CommandType != "" && entry.CommandType != q.CommandType,
+		q.Action != "" && entry.Action != q.Action,
+		q.Result != "" && entry.Result != q.Result,
+		!q.Since.IsZero() && entry.Time.Before(q.Since),
+		!q.Until.IsZero() && !entry.Time.Before(q.Until):
+		return false
+	}
+	return true
+}
+
+// AuditLog stores audit records in a tamper-evident sequence.
+type AuditLog interface {
+	// Append stores record as the next entry.
+	Append(record AuditRecord) error
+	// Query returns the entries selected by q, newest first.
+	Query(q AuditQuery) ([]AuditEntry, error)
+}
@@ -0,0 +1,37 @@
+package application
+
+import (
+	"context"
+	"time"
+
+	"github.com/paul/clock-server/internal/domain"
+)
+
+// Delivery statuses reported per sender.
+const (
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+	DeliveryTimeout   = "timeout"
+	DeliverySkipped   = "skipped"
+)
+
+// SenderResult describes the outcome of a single sender for one command.
+type SenderResult struct {
+	Sender   string
+	Status   string
+	Duration time.Duration
+	Err      error
+}
+
+// DeliveryReport summarizes how a command was delivered across senders.
+type DeliveryReport struct {
+	Policy  string
+	Results []SenderResult
+}
+
+// ReportingSender is an optional extension of ClockCommandSender for senders
+// that can describe per-sender outcomes, such as the composite fan-out.
+type ReportingSender interface {
+	ClockCommandSender
+	SendWithReport(ctx context.Context, cmd domain.ClockCommand) (DeliveryReport, error)
+}
@@ -11,6 +11,7 @@ import (
 // CommandDispatcher coordinates command validation and sending through output ports.
 type CommandDispatcher struct {
 	sender ClockCommandSender
+	events EventPublisher
 }
 
 // NewCommandDispatcher creates a new application service instance.
@@ -18,20 +19,75 @@ func NewCommandDispatcher(sender ClockCommandSender) *CommandDispatcher {
 	return &CommandDispatcher{sender: sender}
 }
 
+// WithEvents publishes an EventDispatch event for every dispatched command.
+func (d *CommandDispatcher) WithEvents(events EventPublisher) *CommandDispatcher {
+	d.events = events
+	return d
+}
+
 // Dispatch validates and forwards a command through the configured sender.
 func (d *CommandDispatcher) Dispatch(ctx context.Context, cmd domain.ClockCommand) error {
+	_, err := d.DispatchWithReport(ctx, cmd)
+	return err
+}
+
+// DispatchWithReport behaves like Dispatch and additionally returns the
+// per-sender delivery report when the configured sender provides one.
+func (d *CommandDispatcher) DispatchWithReport(ctx context.Context, cmd domain.ClockCommand) (DeliveryReport, error) {
 	if cmd == nil {
-		return fmt.Errorf("%w: command is required", ErrValidation)
+		return DeliveryReport{}, fmt.Errorf("%w: command is required", ErrValidation)
 	}
+	report, err := d.dispatch(ctx, cmd)
+	d.publish(cmd, report, err)
+	return report, err
+}
+
+func (d *CommandDispatcher) dispatch(ctx context.Context, cmd domain.ClockCommand) (DeliveryReport, error) {
 	if err := cmd.Execute(ctx); err != nil {
 		var validationErr domain.ValidationError
 		if errors.As(err, &validationErr) {
-			return fmt.Errorf("%w: execute command %s: %w", ErrValidation, cmd.CommandType(), err)
+			return DeliveryReport{}, fmt.Errorf("%w: execute command %s: %w", ErrValidation, cmd.CommandType(), err)
 		}
-		return fmt.Errorf("%w: execute command %s: %w", ErrDownstream, cmd.CommandType(), err)
+		return DeliveryReport{}, fmt.Errorf("%w: execute command %s: %w", ErrDownstream, cmd.CommandType(), err)
+	}
+
+	var report DeliveryReport
+	var err error
+	if reporting, ok := d.sender.(ReportingSender); ok {
+		report, err = reporting.SendWithReport(ctx, cmd)
+	} else {
+		err = d.sender.Send(ctx, cmd)
+	}
+	if err != nil {
+		return report, fmt.Errorf("%w: send command %s: %w", ErrDownstream, cmd.CommandType(), err)
 	}
-	if err := d.sender.Send(ctx, cmd); err != nil {
-		return fmt.Errorf("%w: send command %s: %w", ErrDownstream, cmd.CommandType(), err)
+	return report, nil
+}
+
+// publish reports the dispatch outcome. Error details stay out of the event;
+// only the outcome class is exposed to subscribers.
+func (d *CommandDispatcher) publish(cmd domain.ClockCommand, report DeliveryReport, err error) {
+	if d.events == nil {
+		return
+	}
+	outcome := "accepted"
+	switch {
+	case errors.Is(err, ErrValidation):
+		outcome = "invalid"
+	case err != nil:
+		outcome = "failed"
+	}
+	data := map[string]any{"commandType": cmd.CommandType(), "outcome": outcome}
+	if len(report.Results) > 0 {
+		deliveries := make([]map[string]any, 0, len(report.Results))
+		for _, result := range report.Results {
+			deliveries = append(deliveries, map[string]any{
+				"sender":     result.Sender,
+				"status":     result.Status,
+				"durationMs": result.Duration.Milliseconds(),
+			})
+		}
+		data["deliveries"] = deliveries
 	}
-	return nil
+	d.events.Publish(Event{Type: EventDispatch, DeviceID: cmd.TargetDeviceID(), Data: data})
 }
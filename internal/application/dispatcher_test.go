@@ -3,6 +3,7 @@ package application
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 	"testing"
 
@@ -103,3 +104,66 @@ func TestDispatchSuccess(t *testing.T) {
 		t.Fatalf("expected sender called once, got %d", sender.calls)
 	}
 }
+
+type testReportingSender struct {
+	testSender
+	report DeliveryReport
+}
+
+func (s *testReportingSender) SendWithReport(ctx context.Context, cmd domain.ClockCommand) (DeliveryReport, error) {
+	return s.report, s.Send(ctx, cmd)
+}
+
+func TestDispatchWithReportReturnsSenderReport(t *testing.T) {
+	sender := &testReportingSender{
+		testSender: testSender{err: errors.New("rest down")},
+		report: DeliveryReport{Policy: "all", Results: []SenderResult{
+			{Sender: "mqtt", Status: DeliveryDelivered},
+			{Sender: "rest", Status: DeliveryFailed},
+		}},
+	}
+	dispatcher := NewCommandDispatcher(sender)
+
+	report, err := dispatcher.DispatchWithReport(context.Background(), testCommand{typeName: "ok"})
+	if !errors.Is(err, ErrDownstream) {
+		t.Fatalf("expected downstream error, got %v", err)
+	}
+	if len(report.Results) != 2 || report.Results[1].Status != DeliveryFailed {
+		t.Fatalf("expected report to be returned with error, got %#v", report)
+	}
+}
+
+type recordingPublisher struct {
+	events []Event
+}
+
+func (p *recordingPublisher) Publish(event Event) {
+	p.events = append(p.events, event)
+}
+
+func TestDispatchPublishesOutcomeEvents(t *testing.T) {
+	publisher := &recordingPublisher{}
+	sender := &testReportingSender{report: DeliveryReport{Results: []SenderResult{{Sender: "mqtt", Status: DeliveryDelivered}}}}
+	dispatcher := NewCommandDispatcher(sender).WithEvents(publisher)
+
+	_ = dispatcher.Dispatch(context.Background(), testCommand{typeName: "ok"})
+	_ = dispatcher.Dispatch(context.Background(), testCommand{typeName: "bad", executeErr: domain.NewValidationError("nope")})
+	sender.err = errors.New("secret transport detail")
+	_ = dispatcher.Dispatch(context.Background(), testCommand{typeName: "down"})
+
+	if len(publisher.events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(publisher.events))
+	}
+	want := []string{"accepted", "invalid", "failed"}
+	for i, event := range publisher.events {
+		if event.Type != EventDispatch || event.DeviceID != "clock-1" || event.Data["outcome"] != want[i] {
+			t.Fatalf("event %d: unexpected %+v", i, event)
+		}
+	}
+	if _, ok := publisher.events[0].Data["deliveries"]; !ok {
+		t.Fatalf("expected deliveries in event: %+v", publisher.events[0])
+	}
+	if strings.Contains(fmt.Sprint(publisher.events[2].Data), "secret") {
+		t.Fatalf("error detail leaked into event: %+v", publisher.events[2])
+	}
+}
@@ -8,3 +8,27 @@ var (
 	// ErrDownstream indicates a downstream transport/integration problem.
 	ErrDownstream = errors.New("downstream error")
 )
+
+// DeviceError marks err as a failure of a single device, such as a clock
+// that is not connected, rather than of the transport behind a sender.
+// Circuit breakers do not count such errors.
+func DeviceError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &deviceError{err: err}
+}
+
+// IsDeviceError reports whether err was marked with DeviceError.
+func IsDeviceError(err error) bool {
+	var target *deviceError
+	return errors.As(err, &target)
+}
+
+type deviceError struct {
+	err error
+}
+
+func (e *deviceError) Error() string { return e.err.Error() }
+
+func (e *deviceError) Unwrap() error { return e.err }
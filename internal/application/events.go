@@ -0,0 +1,49 @@
+package application
+
+import "time"
+
+// Event types published on the activity stream.
+const (
+	// EventDispatch reports the outcome of dispatching a command.
+	EventDispatch = "dispatch"
+	// EventDeviceAck reports that a device acknowledged a command.
+	EventDeviceAck = "device_ack"
+	// EventPresence reports a device going online or offline.
+	EventPresence = "presence"
+	// EventAudit mirrors the audit log entry of an API request.
+	EventAudit = "audit"
+)
+
+// Event is a single activity record. Subscribers only see events whose
+// DeviceID is covered by their credential.
+type Event struct {
+	// ID is assigned by the stream and increases monotonically.
+	ID       uint64         `json:"id"`
+	Type     string         `json:"type"`
+	DeviceID string         `json:"deviceId"`
+	Time     time.Time      `json:"time"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+// EventPublisher is the output port for activity events. Publish must not
+// block on slow consumers.
+type EventPublisher interface {
+	Publish(event Event)
+}
+
+// EventSubscription delivers events published after it was opened. The
+// channel is closed when the subscription falls too far behind; the consumer
+// can resubscribe from the last event it saw.
+type EventSubscription interface {
+	Events() <-chan Event
+	Close()
+}
+
+// EventStream publishes events and lets consumers follow them.
+type EventStream interface {
+	EventPublisher
+	// Subscribe returns the retained events after lastID followed by a live
+	// subscription, with no gap or duplicate between the two. complete is
+	// false when events after lastID were already evicted.
+	Subscribe(lastID uint64) (replay []Event, sub EventSubscription, complete bool)
+}
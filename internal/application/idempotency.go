@@ -0,0 +1,55 @@
+package application
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+type idempotencyKeyContextKey struct{}
+
+// WithIdempotencyKey attaches a client-supplied idempotency key to ctx so
+// sender adapters can propagate it downstream and retry safely.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
+
+// IdempotencyKeyFromContext returns the idempotency key attached to ctx, if any.
+func IdempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyContextKey{}).(string)
+	return key
+}
+
+// IdempotencyRecord is the stored outcome of a request made with an
+// idempotency key. A record without a status is still in progress.
+type IdempotencyRecord struct {
+	Principal   string
+	Key         string
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// Completed reports whether the original request has finished.
+func (r IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
+
+// IdempotencyStore remembers responses per principal and idempotency key.
+type IdempotencyStore interface {
+	// Reserve claims the key for a new request. When the key is already known
+	// it returns the existing record and false.
+	Reserve(principal, key, requestHash string) (IdempotencyRecord, bool)
+	// Complete stores the response for a reserved key.
+	Complete(record IdempotencyRecord)
+	// Release forgets a reserved key so the request can be retried.
+	Release(principal, key string)
+	// Lookup returns the unexpired record of a key, finished or not.
+	Lookup(principal, key string) (IdempotencyRecord, bool)
+}
@@ -0,0 +1,15 @@
+package application
+
+// Metric is a single gauge or counter sample exposed on /metrics.
+type Metric struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels map[string]string
+	Value  float64
+}
+
+// MetricsProvider reports the current samples of a component.
+type MetricsProvider interface {
+	Metrics() []Metric
+}
@@ -0,0 +1,149 @@
+package application
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// RateLimiter keeps the counters behind the auth failure limit and the
+// command rate limits. An implementation whose state is shared by every
+// replica enforces the limits across the cluster.
+type RateLimiter interface {
+	// AuthFailures returns the failures recorded for key in its current
+	// window.
+	AuthFailures(ctx context.Context, key string) (int, error)
+	// RecordAuthFailure counts a failure for key. A window starts with the
+	// first failure and lasts window.
+	RecordAuthFailure(ctx context.Context, key string, window time.Duration) error
+	// Take charges one command to every limit, or to none of them when one
+	// is exhausted, and reports the most restrictive limit.
+	Take(ctx context.Context, limits []Limit, now time.Time) (LimitStatus, error)
+}
+
+// Limit is a limit a command is charged against: a token bucket of Count
+// tokens that refills at Count per Per or, when Per is zero, a quota of
+// Count commands that ends at Until. Cost is the number of tokens charged,
+// one when zero, so that several commands can be charged together.
+type Limit struct {
+	Key   string
+	Count int
+	Per   time.Duration
+	Until time.Time
+	Cost  int
+}
+
+// cost returns the tokens charged to l.
+func (l Limit) cost() float64 {
+	return float64(max(l.Cost, 1))
+}
+
+// Quota reports whether l is a quota rather than a token bucket.
+func (l Limit) Quota() bool {
+	return l.Per == 0
+}
+
+// LimitState is the stored state of a limit: the tokens left at Updated.
+// The zero LimitState is a full limit.
+type LimitState struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// LimitStatus describes the most restrictive limit a command was charged
+// against. Exhausted is set when the command was rejected; Quota when that
+// limit is a quota.
+type LimitStatus struct {
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+	Exhausted  bool
+	Quota      bool
+}
+
+// ChargeLimits charges the Cost of each limit to limits, whose current states are
+// given in the same order, at now. It returns the status and the states to
+// store, or no states when a limit is exhausted and nothing is charged.
+func ChargeLimits(limits []Limit, states []LimitState, now time.Time) (LimitStatus, []LimitState) {
+	var status LimitStatus
+	tokens := make([]float64, len(limits))
+	for i, limit := range limits {
+		tokens[i] = limit.available(states[i], now)
+		s := limit.status(tokens[i], now)
+		if i == 0 || s.MoreRestrictive(status) {
+			status = s
+		}
+	}
+	if status.Exhausted {
+		return status, nil
+	}
+	next := make([]LimitState, len(limits))
+	for i := range limits {
+		next[i] = LimitState{Tokens: tokens[i] - limits[i].cost(), Updated: now}
+	}
+	return status, next
+}
+
+// StateTTL is how long after now the state of l can be forgotten, because a
+// missing state is equivalent by then.
+func (l Limit) StateTTL(now time.Time) time.Duration {
+	if l.Quota() {
+		return max(l.Until.Sub(now), time.Millisecond)
+	}
+	return l.Per
+}
+
+// available returns the tokens of l at now, starting from state.
+func (l Limit) available(state LimitState, now time.Time) float64 {
+	capacity := float64(l.Count)
+	if state.Updated.IsZero() {
+		return capacity
+	}
+	if l.Quota() {
+		return state.Tokens
+	}
+	elapsed := max(now.Sub(state.Updated).Seconds(), 0)
+	return math.Min(capacity, state.Tokens+elapsed*capacity/l.Per.Seconds())
+}
+
+// status describes l with tokens available, before the command is charged.
+func (l Limit) status(tokens float64, now time.Time) LimitStatus {
+	s := LimitStatus{Limit: l.Count, Quota: l.Quota()}
+	cost := l.cost()
+	if l.Quota() {
+		s.Reset = max(l.Until.Sub(now), 0)
+		if tokens < cost {
+			s.Exhausted, s.RetryAfter = true, s.Reset
+		} else {
+			s.Remaining = int(tokens - cost)
+		}
+		return s
+	}
+	perToken := l.Per.Seconds() / float64(l.Count)
+	if tokens < cost {
+		s.Exhausted = true
+		s.RetryAfter = seconds((cost - tokens) * perToken)
+		s.Reset = seconds((float64(l.Count) - tokens) * perToken)
+	} else {
+		s.Remaining = int(tokens - cost)
+		s.Reset = seconds((float64(l.Count) - tokens + cost) * perToken)
+	}
+	return s
+}
+
+// MoreRestrictive reports whether s is more restrictive than than: an
+// exhausted limit with a longer wait, otherwise fewer remaining commands.
+func (s LimitStatus) MoreRestrictive(than LimitStatus) bool {
+	if s.Exhausted != than.Exhausted {
+		return s.Exhausted
+	}
+	if s.Exhausted {
+		return s.RetryAfter > than.RetryAfter
+	}
+	return s.Remaining < than.Remaining
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
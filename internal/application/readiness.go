@@ -6,3 +6,9 @@ import "context"
 type ReadinessChecker interface {
 	Check(ctx context.Context) error
 }
+
+// ReadinessReporter is an optional ReadinessChecker extension. Its details,
+// keyed by component, are included in the readiness probe response.
+type ReadinessReporter interface {
+	ReadinessDetails() map[string]any
+}
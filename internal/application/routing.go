@@ -0,0 +1,13 @@
+package application
+
+// RouteDecision describes which senders would handle a command.
+type RouteDecision struct {
+	Rule    string
+	Senders []string
+	Policy  string
+}
+
+// CommandRouter explains sender selection for a device and command type.
+type CommandRouter interface {
+	Route(deviceID, commandType string) RouteDecision
+}
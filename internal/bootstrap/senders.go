@@ -1,46 +1,128 @@
 package bootstrap
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 
+	"github.com/paul/clock-server/internal/adapters/amqp"
+	"github.com/paul/clock-server/internal/adapters/breaker"
 	"github.com/paul/clock-server/internal/adapters/composite"
 	"github.com/paul/clock-server/internal/adapters/mqtt"
 	"github.com/paul/clock-server/internal/adapters/rest"
+	"github.com/paul/clock-server/internal/adapters/routing"
+	"github.com/paul/clock-server/internal/adapters/webhook"
+	"github.com/paul/clock-server/internal/adapters/websocket"
 	"github.com/paul/clock-server/internal/application"
 	"github.com/paul/clock-server/internal/config"
 )
 
-// BuildCompositeSender wires sender adapters based on configuration.
-func BuildCompositeSender(cfg config.Config) (application.ClockCommandSender, []application.ReadinessChecker, func(), error) {
-	senders := make([]application.ClockCommandSender, 0, len(cfg.EnabledSenders))
+// BuildCompositeSender wires sender adapters based on configuration. The
+// returned sender routes each command to the senders selected by the routing
+// rules (all enabled senders by default) and implements application.CommandRouter.
+// devices is the WebSocket sender from BuildDeviceChannel; it is required
+// when the websocket sender is enabled.
+func BuildCompositeSender(cfg config.Config, devices *websocket.Sender) (application.ClockCommandSender, []application.ReadinessChecker, func(), error) {
+	targets := make([]composite.Target, 0, len(cfg.EnabledSenders))
 	checkers := make([]application.ReadinessChecker, 0, len(cfg.EnabledSenders))
 	cleanup := func() {}
 
 	for _, enabled := range cfg.EnabledSenders {
-		switch enabled {
-		case "mqtt":
-			sender, err := mqtt.NewSender(cfg.MQTT)
-			if err != nil {
-				return nil, nil, cleanup, fmt.Errorf("build mqtt sender: %w", err)
-			}
-			senders = append(senders, sender)
-			checkers = append(checkers, sender)
+		adapter, closeAdapter, err := buildAdapter(cfg, enabled, devices)
+		if err != nil {
+			return nil, nil, cleanup, err
+		}
+		if closeAdapter != nil {
 			prevCleanup := cleanup
 			cleanup = func() {
-				sender.Close()
+				closeAdapter()
 				prevCleanup()
 			}
-		case "rest":
-			sender, err := rest.NewSender(cfg.REST)
+		}
+		if cfg.BreakerEnabled {
+			protected, err := breaker.NewSender(enabled, adapter, cfg.Breaker)
 			if err != nil {
-				return nil, nil, cleanup, fmt.Errorf("build rest sender: %w", err)
+				return nil, nil, cleanup, fmt.Errorf("build %s circuit breaker: %w", enabled, err)
 			}
-			senders = append(senders, sender)
-			checkers = append(checkers, sender)
-		default:
-			return nil, nil, cleanup, fmt.Errorf("unsupported sender %q", enabled)
+			adapter = protected
+		}
+		targets = append(targets, composite.Target{Name: enabled, Sender: adapter})
+		checkers = append(checkers, adapter)
+	}
+
+	opts := composite.Options{
+		Policy:  composite.Policy(cfg.DeliveryPolicy),
+		Timeout: cfg.DeliveryTimeout,
+	}
+
+	var rules routing.Rules
+	if cfg.RoutingRulesFile != "" {
+		loaded, err := routing.LoadRules(cfg.RoutingRulesFile)
+		if err != nil {
+			return nil, nil, cleanup, fmt.Errorf("load routing rules: %w", err)
 		}
+		rules = loaded
 	}
+	sender, err := routing.NewSender(opts, rules, targets...)
+	if err != nil {
+		return nil, nil, cleanup, fmt.Errorf("build routing sender: %w", err)
+	}
+	return sender, checkers, cleanup, nil
+}
 
-	return composite.NewSender(senders...), checkers, cleanup, nil
+// adapter is implemented by every sender adapter.
+type adapter interface {
+	application.ClockCommandSender
+	application.ReadinessChecker
+}
+
+// BuildDeviceChannel creates the WebSocket sender when it is enabled, and
+// returns nil otherwise. It is both a sender adapter and the handler of the
+// device connections, so it is built before the composite sender.
+func BuildDeviceChannel(cfg config.Config) (*websocket.Sender, error) {
+	if !slices.Contains(cfg.EnabledSenders, "websocket") {
+		return nil, nil
+	}
+	sender, err := websocket.NewSender(cfg.WebSocket)
+	if err != nil {
+		return nil, fmt.Errorf("build websocket sender: %w", err)
+	}
+	return sender, nil
+}
+
+// buildAdapter creates the named sender adapter and its optional close function.
+func buildAdapter(cfg config.Config, name string, devices *websocket.Sender) (adapter, func(), error) {
+	switch name {
+	case "mqtt":
+		sender, err := mqtt.NewSender(cfg.MQTT)
+		if err != nil {
+			return nil, nil, fmt.Errorf("build mqtt sender: %w", err)
+		}
+		return sender, sender.Close, nil
+	case "amqp":
+		sender, err := amqp.NewSender(cfg.AMQP)
+		if err != nil {
+			return nil, nil, fmt.Errorf("build amqp sender: %w", err)
+		}
+		return sender, sender.Close, nil
+	case "rest":
+		sender, err := rest.NewSender(cfg.REST)
+		if err != nil {
+			return nil, nil, fmt.Errorf("build rest sender: %w", err)
+		}
+		return sender, nil, nil
+	case "webhook":
+		sender, err := webhook.NewSender(cfg.Webhook)
+		if err != nil {
+			return nil, nil, fmt.Errorf("build webhook sender: %w", err)
+		}
+		return sender, nil, nil
+	case "websocket":
+		if devices == nil {
+			return nil, nil, errors.New("websocket sender requires a device channel")
+		}
+		return devices, devices.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported sender %q", name)
+	}
 }
@@ -1,10 +1,17 @@
 package bootstrap
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/paul/clock-server/internal/adapters/amqp"
+	"github.com/paul/clock-server/internal/adapters/breaker"
 	"github.com/paul/clock-server/internal/adapters/rest"
+	"github.com/paul/clock-server/internal/adapters/webhook"
+	"github.com/paul/clock-server/internal/adapters/websocket"
+	"github.com/paul/clock-server/internal/application"
 	"github.com/paul/clock-server/internal/config"
 )
 
@@ -14,7 +21,7 @@ func TestBuildCompositeSenderRestOnly(t *testing.T) {
 		REST:           rest.Config{BaseURL: "http://clock-api.local", AllowInsecureHTTP: true},
 	}
 
-	sender, checkers, cleanup, err := BuildCompositeSender(cfg)
+	sender, checkers, cleanup, err := BuildCompositeSender(cfg, nil)
 	if err != nil {
 		t.Fatalf("expected success, got error: %v", err)
 	}
@@ -33,7 +40,7 @@ func TestBuildCompositeSenderRestOnly(t *testing.T) {
 func TestBuildCompositeSenderRejectsUnsupportedSender(t *testing.T) {
 	cfg := config.Config{EnabledSenders: []string{"not-real"}}
 
-	_, _, cleanup, err := BuildCompositeSender(cfg)
+	_, _, cleanup, err := BuildCompositeSender(cfg, nil)
 	if cleanup == nil {
 		t.Fatal("expected cleanup function")
 	}
@@ -48,7 +55,7 @@ func TestBuildCompositeSenderRejectsUnsupportedSender(t *testing.T) {
 func TestBuildCompositeSenderRestConfigError(t *testing.T) {
 	cfg := config.Config{EnabledSenders: []string{"rest"}}
 
-	_, _, _, err := BuildCompositeSender(cfg)
+	_, _, _, err := BuildCompositeSender(cfg, nil)
 	if err == nil {
 		t.Fatal("expected rest config error")
 	}
@@ -56,3 +63,129 @@ func TestBuildCompositeSenderRestConfigError(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestBuildCompositeSenderRejectsUnknownPolicy(t *testing.T) {
+	cfg := config.Config{
+		EnabledSenders: []string{"rest"},
+		DeliveryPolicy: "most",
+		REST:           rest.Config{BaseURL: "http://clock-api.local", AllowInsecureHTTP: true},
+	}
+
+	_, _, _, err := BuildCompositeSender(cfg, nil)
+	if err == nil || !strings.Contains(err.Error(), "build routing sender") || !strings.Contains(err.Error(), "most") {
+		t.Fatalf("expected delivery policy error, got %v", err)
+	}
+}
+
+func TestBuildCompositeSenderAppliesRoutingRules(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "routes.json")
+	if err := os.WriteFile(filename, []byte(`{"rules":[{"device":"legacy-*","senders":["rest"]}]}`), 0o600); err != nil {
+		t.Fatalf("write rules: %v", err)
+	}
+	cfg := config.Config{
+		EnabledSenders:   []string{"rest"},
+		RoutingRulesFile: filename,
+		REST:             rest.Config{BaseURL: "http://clock-api.local", AllowInsecureHTTP: true},
+	}
+
+	sender, _, _, err := BuildCompositeSender(cfg, nil)
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	router, ok := sender.(application.CommandRouter)
+	if !ok {
+		t.Fatalf("expected sender to implement CommandRouter, got %T", sender)
+	}
+	if decision := router.Route("legacy-1", "set_alarm"); decision.Rule != "rule-0" {
+		t.Fatalf("unexpected routing decision: %#v", decision)
+	}
+}
+
+func TestBuildCompositeSenderRejectsRulesForDisabledSender(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "routes.json")
+	if err := os.WriteFile(filename, []byte(`{"rules":[{"device":"*","senders":["mqtt"]}]}`), 0o600); err != nil {
+		t.Fatalf("write rules: %v", err)
+	}
+	cfg := config.Config{
+		EnabledSenders:   []string{"rest"},
+		RoutingRulesFile: filename,
+		REST:             rest.Config{BaseURL: "http://clock-api.local", AllowInsecureHTTP: true},
+	}
+
+	_, _, _, err := BuildCompositeSender(cfg, nil)
+	if err == nil || !strings.Contains(err.Error(), "build routing sender") {
+		t.Fatalf("expected routing error, got %v", err)
+	}
+}
+
+func TestBuildCompositeSenderWrapsAdaptersInCircuitBreaker(t *testing.T) {
+	cfg := config.Config{
+		EnabledSenders: []string{"rest"},
+		BreakerEnabled: true,
+		REST:           rest.Config{BaseURL: "http://clock-api.local", AllowInsecureHTTP: true},
+	}
+
+	_, checkers, _, err := BuildCompositeSender(cfg, nil)
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if len(checkers) != 1 {
+		t.Fatalf("expected one checker, got %d", len(checkers))
+	}
+	if _, ok := checkers[0].(*breaker.Sender); !ok {
+		t.Fatalf("expected circuit breaker checker, got %T", checkers[0])
+	}
+}
+
+func TestBuildCompositeSenderWithWebSocketDeviceChannel(t *testing.T) {
+	cfg := config.Config{
+		EnabledSenders: []string{"websocket"},
+		WebSocket:      websocket.Config{DeviceTokens: []websocket.DeviceToken{{DeviceID: "clock-1", Token: "device-token"}}},
+	}
+
+	devices, err := BuildDeviceChannel(cfg)
+	if err != nil || devices == nil {
+		t.Fatalf("expected device channel, got %v %v", devices, err)
+	}
+	_, checkers, cleanup, err := BuildCompositeSender(cfg, devices)
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	defer cleanup()
+	if reporter, ok := checkers[0].(application.ReadinessReporter); !ok || reporter.ReadinessDetails()["websocket"] == nil {
+		t.Fatalf("expected websocket readiness details, got %T", checkers[0])
+	}
+
+	if _, _, _, err := BuildCompositeSender(cfg, nil); err == nil {
+		t.Fatal("expected error without a device channel")
+	}
+	cfg.EnabledSenders = []string{"rest"}
+	if devices, err := BuildDeviceChannel(cfg); devices != nil || err != nil {
+		t.Fatalf("expected no device channel when websocket is disabled, got %v %v", devices, err)
+	}
+}
+
+func TestBuildCompositeSenderWebhook(t *testing.T) {
+	cfg := config.Config{
+		EnabledSenders: []string{"webhook"},
+		Webhook:        webhook.Config{Endpoints: []webhook.Endpoint{{URL: "https://ha.local/hook"}}, Secrets: []string{"secret"}},
+	}
+	if _, checkers, _, err := BuildCompositeSender(cfg, nil); err != nil || len(checkers) != 1 {
+		t.Fatalf("expected webhook sender, got %v", err)
+	}
+
+	cfg.Webhook.Secrets = nil
+	if _, _, _, err := BuildCompositeSender(cfg, nil); err == nil || !strings.Contains(err.Error(), "webhook") {
+		t.Fatalf("expected webhook config error, got %v", err)
+	}
+}
+
+func TestBuildCompositeSenderAMQPConfigError(t *testing.T) {
+	cfg := config.Config{
+		EnabledSenders: []string{"amqp"},
+		AMQP:           amqp.Config{URL: "amqp://rabbit.local"},
+	}
+	if _, _, _, err := BuildCompositeSender(cfg, nil); err == nil || !strings.Contains(err.Error(), "build amqp sender") {
+		t.Fatalf("expected amqp config error, got %v", err)
+	}
+}
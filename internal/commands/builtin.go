@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/paul/clock-server/internal/domain"
+)
+
+func init() {
+	MustRegister(setAlarm)
+	MustRegister(displayMessage)
+	MustRegister(setBrightness)
+}
+
+type setAlarmRequest struct {
+	DeviceID  string `json:"deviceId"`
+	AlarmTime string `json:"alarmTime"`
+	Label     string `json:"label"`
+}
+
+var setAlarm = Definition{
+	Type:    "set_alarm",
+	CLIName: "alarm",
+	Summary: "Set an alarm on a device",
+	Result:  "scheduled",
+	Fields: []Field{
+		{Name: "alarmTime", Flag: "time", Kind: KindTime, Required: true, Usage: "alarm time in RFC3339"},
+		{Name: "label", Flag: "label", Kind: KindString, Usage: "alarm label"},
+	},
+	Decode: func(data []byte) (domain.ClockCommand, error) {
+		var req setAlarmRequest
+		if err := DecodeStrict(data, &req); err != nil {
+			return nil, err
+		}
+		alarmTime, err := time.Parse(time.RFC3339, req.AlarmTime)
+		if err != nil {
+			return nil, domain.NewFieldValidationError("alarmTime", "alarmTime must be RFC3339")
+		}
+		return domain.SetAlarmCommand{DeviceID: req.DeviceID, AlarmTime: alarmTime, Label: req.Label}, nil
+	},
+	Payload: func(cmd domain.ClockCommand) (map[string]any, error) {
+		c, ok := cmd.(domain.SetAlarmCommand)
+		if !ok {
+			return nil, unexpectedCommand(cmd)
+		}
+		return map[string]any{
+			"alarmTime": c.AlarmTime.Format(time.RFC3339),
+			"label":     c.Label,
+		}, nil
+	},
+	RESTMethod: http.MethodPost,
+	RESTPath:   "/clocks/{deviceId}/alarms",
+}
+
+type displayMessageRequest struct {
+	DeviceID        string `json:"deviceId"`
+	Message         string `json:"message"`
+	DurationSeconds int    `json:"durationSeconds"`
+}
+
+var displayMessage = Definition{
+	Type:    "display_message",
+	CLIName: "message",
+	Summary: "Display a message on a device",
+	Result:  "sent",
+	Fields: []Field{
+		{Name: "message", Flag: "message", Kind: KindString, Required: true, Usage: "message text"},
+		{Name: "durationSeconds", Flag: "duration", Kind: KindInt, Default: "10", Placeholder: "seconds", Usage: "duration in seconds"},
+	},
+	Decode: func(data []byte) (domain.ClockCommand, error) {
+		var req displayMessageRequest
+		if err := DecodeStrict(data, &req); err != nil {
+			return nil, err
+		}
+		return domain.DisplayMessageCommand{DeviceID: req.DeviceID, Message: req.Message, DurationSeconds: req.DurationSeconds}, nil
+	},
+	Payload: func(cmd domain.ClockCommand) (map[string]any, error) {
+		c, ok := cmd.(domain.DisplayMessageCommand)
+		if !ok {
+			return nil, unexpectedCommand(cmd)
+		}
+		return map[string]any{
+			"message":         c.Message,
+			"durationSeconds": c.DurationSeconds,
+		}, nil
+	},
+	RESTMethod: http.MethodPost,
+	RESTPath:   "/clocks/{deviceId}/messages",
+}
+
+type setBrightnessRequest struct {
+	DeviceID string `json:"deviceId"`
+	Level    int    `json:"level"`
+}
+
+var setBrightness = Definition{
+	Type:    "set_brightness",
+	CLIName: "brightness",
+	Summary: "Set the screen brightness of a device",
+	Result:  "updated",
+	Fields: []Field{
+		{Name: "level", Flag: "level", Kind: KindInt, Default: "50", Placeholder: "0-100", Usage: "brightness 0..100"},
+	},
+	Decode: func(data []byte) (domain.ClockCommand, error) {
+		var req setBrightnessRequest
+		if err := DecodeStrict(data, &req); err != nil {
+			return nil, err
+		}
+		return domain.SetBrightnessCommand{DeviceID: req.DeviceID, Level: req.Level}, nil
+	},
+	Payload: func(cmd domain.ClockCommand) (map[string]any, error) {
+		c, ok := cmd.(domain.SetBrightnessCommand)
+		if !ok {
+			return nil, unexpectedCommand(cmd)
+		}
+		return map[string]any{"level": c.Level}, nil
+	},
+	RESTMethod: http.MethodPut,
+	RESTPath:   "/clocks/{deviceId}/brightness",
+}
@@ -0,0 +1,229 @@
+// Package commands is the registry of command types. Each type is described
+// once by a Definition: how it is decoded from JSON, which fields it carries,
+// how it maps onto MQTT payloads and downstream REST routes, and how clients
+// such as clockctl expose it. Validation stays on the domain command itself.
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/paul/clock-server/internal/domain"
+)
+
+// FieldKind is the JSON kind of a command field.
+type FieldKind string
+
+const (
+	KindString FieldKind = "string"
+	KindInt    FieldKind = "integer"
+	// KindTime is an RFC 3339 timestamp encoded as a JSON string.
+	KindTime FieldKind = "time"
+)
+
+// Field describes one command-specific JSON field. deviceId is implied for
+// every command and is not listed.
+type Field struct {
+	Name        string
+	Flag        string
+	Kind        FieldKind
+	Required    bool
+	Default     string
+	Placeholder string
+	Usage       string
+}
+
+// Definition describes a command type.
+type Definition struct {
+	// Type is the stable command type returned by domain.ClockCommand.CommandType.
+	Type string
+	// CLIName is the clockctl subcommand name; Type is accepted as well.
+	CLIName string
+	// Summary is a one-line description used in usage output.
+	Summary string
+	// Result is the "result" value of a successful API response.
+	Result string
+	Fields []Field
+	// Decode builds the domain command from a JSON request body.
+	Decode func(data []byte) (domain.ClockCommand, error)
+	// Payload returns the command-specific fields sent to devices, shared by
+	// the MQTT payload and the downstream REST body.
+	Payload func(cmd domain.ClockCommand) (map[string]any, error)
+	// RESTMethod and RESTPath define the downstream REST route. RESTPath may
+	// contain a {deviceId} placeholder, which is replaced path-escaped.
+	RESTMethod string
+	RESTPath   string
+}
+
+func (d Definition) validate() error {
+	switch {
+	case strings.TrimSpace(d.Type) == "":
+		return errors.New("command type is required")
+	case d.Decode == nil:
+		return fmt.Errorf("command type %q has no decoder", d.Type)
+	case d.Payload == nil:
+		return fmt.Errorf("command type %q has no payload mapper", d.Type)
+	case d.RESTMethod == "" || d.RESTPath == "":
+		return fmt.Errorf("command type %q has no REST route", d.Type)
+	}
+	return nil
+}
+
+var (
+	mu          sync.RWMutex
+	definitions = map[string]Definition{}
+)
+
+// Register adds a command type. Types must be unique.
+func Register(def Definition) error {
+	if err := def.validate(); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, exists := definitions[def.Type]; exists {
+		return fmt.Errorf("command type %q is already registered", def.Type)
+	}
+	definitions[def.Type] = def
+	return nil
+}
+
+// MustRegister is Register for package-level registration of known types.
+func MustRegister(def Definition) {
+	if err := Register(def); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the definition of a command type.
+func Lookup(commandType string) (Definition, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	def, ok := definitions[commandType]
+	return def, ok
+}
+
+// All returns every registered definition ordered by type.
+func All() []Definition {
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make([]Definition, 0, len(definitions))
+	for _, def := range definitions {
+		out = append(out, def)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Type < out[j].Type })
+	return out
+}
+
+// Decode builds the command of the given type from a JSON body.
+func Decode(commandType string, data []byte) (domain.ClockCommand, error) {
+	def, ok := Lookup(commandType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported command type %q", commandType)
+	}
+	return def.Decode(data)
+}
+
+// Payload returns the command-specific fields of cmd.
+func Payload(cmd domain.ClockCommand) (map[string]any, error) {
+	def, ok := Lookup(cmd.CommandType())
+	if !ok {
+		return nil, fmt.Errorf("unsupported command type %T", cmd)
+	}
+	return def.Payload(cmd)
+}
+
+// DevicePayload returns the message pushed to a device: the command-specific
+// fields plus deviceId and type. Every push transport uses this shape.
+func DevicePayload(cmd domain.ClockCommand) (map[string]any, error) {
+	payload, err := Payload(cmd)
+	if err != nil {
+		return nil, err
+	}
+	payload["deviceId"] = cmd.TargetDeviceID()
+	payload["type"] = cmd.CommandType()
+	return payload, nil
+}
+
+// BodyError reports a request body that could not be decoded. Unlike the
+// underlying decoder error, its message is safe to return to API clients.
+type BodyError struct {
+	// Field is the JSON field at fault, if any.
+	Field   string
+	Message string
+}
+
+func (e BodyError) Error() string {
+	return e.Message
+}
+
+// DecodeStrict decodes a JSON body into out, rejecting unknown fields. Errors
+// are returned as BodyError.
+func DecodeStrict(data []byte, out any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(out); err != nil {
+		return bodyError(err)
+	}
+	return nil
+}
+
+// unknownFieldPrefix is how encoding/json reports fields rejected by
+// DisallowUnknownFields; it has no typed error for them.
+const unknownFieldPrefix = "json: unknown field "
+
+func bodyError(err error) BodyError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return BodyError{Message: "request body is empty"}
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return BodyError{Message: "request body is not valid JSON"}
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return BodyError{Message: "request body must be a JSON object"}
+		}
+		return BodyError{Field: typeErr.Field, Message: fmt.Sprintf("%s must be %s", typeErr.Field, jsonKind(typeErr.Type))}
+	case strings.HasPrefix(err.Error(), unknownFieldPrefix):
+		field, unquoteErr := strconv.Unquote(strings.TrimPrefix(err.Error(), unknownFieldPrefix))
+		if unquoteErr != nil {
+			return BodyError{Message: "request body contains an unknown field"}
+		}
+		return BodyError{Field: field, Message: fmt.Sprintf("unknown field %q", field)}
+	default:
+		return BodyError{Message: "request body could not be decoded"}
+	}
+}
+
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// unexpectedCommand reports a command whose Go type does not match its
+// registered command type.
+func unexpectedCommand(cmd domain.ClockCommand) error {
+	return fmt.Errorf("unsupported command type %T", cmd)
+}
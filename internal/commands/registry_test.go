@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/paul/clock-server/internal/domain"
+)
+
+func TestBuiltinTypesRegistered(t *testing.T) {
+	var types []string
+	for _, def := range All() {
+		types = append(types, def.Type)
+	}
+	if got := strings.Join(types, ","); got != "display_message,set_alarm,set_brightness" {
+		t.Fatalf("unexpected registered types: %s", got)
+	}
+}
+
+func TestDecodeBuildsDomainCommand(t *testing.T) {
+	cmd, err := Decode("set_alarm", []byte(`{"deviceId":"clock-1","alarmTime":"2099-01-01T07:00:00Z","label":"wake"}`))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	alarm, ok := cmd.(domain.SetAlarmCommand)
+	if !ok || alarm.DeviceID != "clock-1" || alarm.Label != "wake" || alarm.AlarmTime.Year() != 2099 {
+		t.Fatalf("unexpected command: %#v", cmd)
+	}
+}
+
+func TestDecodeRejectsUnknownFieldsAndTypes(t *testing.T) {
+	if _, err := Decode("set_brightness", []byte(`{"deviceId":"clock-1","level":10,"extra":1}`)); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+	if _, err := Decode("set_alarm", []byte(`{"deviceId":"clock-1","alarmTime":"tomorrow"}`)); err == nil || !strings.Contains(err.Error(), "RFC3339") {
+		t.Fatalf("expected alarm time error, got %v", err)
+	}
+	if _, err := Decode("reboot", []byte(`{}`)); err == nil {
+		t.Fatal("expected unsupported type error")
+	}
+}
+
+func TestDecodeStrictReturnsSafeBodyErrors(t *testing.T) {
+	cases := []struct {
+		body, field, message string
+	}{
+		{``, "", "request body is empty"},
+		{`{"deviceId":`, "", "request body is not valid JSON"},
+		{`[1]`, "", "request body must be a JSON object"},
+		{`{"level":"high"}`, "level", "level must be an integer"},
+		{`{"extra":1}`, "extra", `unknown field "extra"`},
+	}
+	for _, tc := range cases {
+		var out setBrightnessRequest
+		err := DecodeStrict([]byte(tc.body), &out)
+		var bodyErr BodyError
+		if !errors.As(err, &bodyErr) {
+			t.Fatalf("%q: expected BodyError, got %v", tc.body, err)
+		}
+		if bodyErr.Field != tc.field || bodyErr.Message != tc.message {
+			t.Fatalf("%q: unexpected error %#v", tc.body, bodyErr)
+		}
+	}
+}
+
+func TestPayloadRejectsMismatchedCommand(t *testing.T) {
+	def, _ := Lookup("set_brightness")
+	if _, err := def.Payload(domain.DisplayMessageCommand{DeviceID: "clock-1"}); err == nil {
+		t.Fatal("expected error for mismatched command")
+	}
+}
+
+func TestRegisterValidatesDefinitions(t *testing.T) {
+	if err := Register(Definition{Type: "set_alarm", Decode: setAlarm.Decode, Payload: setAlarm.Payload, RESTMethod: http.MethodPost, RESTPath: "/x"}); err == nil {
+		t.Fatal("expected duplicate registration error")
+	}
+	if err := Register(Definition{Type: "reboot"}); err == nil {
+		t.Fatal("expected incomplete definition error")
+	}
+}
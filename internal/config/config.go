@@ -9,14 +9,22 @@ import (
 	"strings"
 	"time"
 
+	"github.com/paul/clock-server/internal/adapters/amqp"
+	"github.com/paul/clock-server/internal/adapters/auditlog"
+	"github.com/paul/clock-server/internal/adapters/breaker"
 	"github.com/paul/clock-server/internal/adapters/mqtt"
+	"github.com/paul/clock-server/internal/adapters/redis"
 	"github.com/paul/clock-server/internal/adapters/rest"
+	"github.com/paul/clock-server/internal/adapters/retry"
+	"github.com/paul/clock-server/internal/adapters/webhook"
+	"github.com/paul/clock-server/internal/adapters/websocket"
 	"github.com/paul/clock-server/internal/security"
 )
 
 // Config centralizes runtime settings for transport adapters and API.
 type Config struct {
 	ServerAddr           string
+	GRPCAddr             string
 	ServerReadTimeout    time.Duration
 	ServerWriteTimeout   time.Duration
 	ServerIdleTimeout    time.Duration
@@ -24,21 +32,68 @@ type Config struct {
 	ServerShutdownPeriod time.Duration
 	TLSCertFile          string
 	TLSKeyFile           string
+	TLSClientCAFile      string
+	TLSClientAuth        string
+	ClientCertPrincipals []security.CertPrincipal
 	RequireTLS           bool
 	TrustProxyTLS        bool
 	ReadinessRequireAuth bool
 	MaxBodyBytes         int64
 	AuthFailLimitPerMin  int
+	IdempotencyTTL       time.Duration
+	IdempotencyMaxKeys   int
+	LegacyAPISunset      time.Time
+	EventsBufferSize     int
 	AuthCredentials      []security.Credential
+	AuthTokenPepper      []byte
+	Roles                security.Policy
+	JWT                  security.JWTConfig
 	EnabledSenders       []string
+	DeliveryPolicy       string
+	DeliveryTimeout      time.Duration
+	RoutingRulesFile     string
+	BreakerEnabled       bool
+	Breaker              breaker.Config
 	MQTT                 mqtt.Config
+	AMQP                 amqp.Config
 	REST                 rest.Config
+	WebSocket            websocket.Config
+	Webhook              webhook.Config
+
+	// AuthCredentialsFile is watched for changes when set; the credentials
+	// were loaded from the content identified by AuthCredentialsDigest.
+	AuthCredentialsFile       string
+	AuthCredentialsDigest     string
+	CredentialsReloadInterval time.Duration
+	// CredentialExpiryWarning is how long before expiresAt a credential is
+	// reported in the log.
+	CredentialExpiryWarning time.Duration
+
+	// AdminCredential, from ADMIN_AUTH_TOKEN, may only use the admin API.
+	// CredentialStoreFile keeps the credentials issued through it.
+	AdminCredential     *security.Credential
+	CredentialStoreFile string
+
+	// PrincipalRateLimits and DeviceRateLimits limit commands per command
+	// type; DailyQuotas limit the commands of each principal per UTC day.
+	PrincipalRateLimits security.RateLimits
+	DeviceRateLimits    security.RateLimits
+	DailyQuotas         security.Quotas
+
+	// RateLimitBackend is memory, which keeps the rate limit counters per
+	// replica, or redis, which shares them through Redis.
+	RateLimitBackend string
+	Redis            redis.Config
+
+	// AuditLog is enabled when its Path, from AUDIT_LOG_FILE, is set.
+	AuditLog auditlog.Config
 }
 
 // LoadFromEnv reads configuration from environment variables.
 func LoadFromEnv() (Config, error) {
 	cfg := Config{
 		ServerAddr:           getEnv("HTTP_ADDR", ":8080"),
+		GRPCAddr:             strings.TrimSpace(os.Getenv("GRPC_ADDR")),
 		ServerReadTimeout:    mustPositiveDuration("HTTP_READ_TIMEOUT_MS", 10000),
 		ServerWriteTimeout:   mustPositiveDuration("HTTP_WRITE_TIMEOUT_MS", 10000),
 		ServerIdleTimeout:    mustPositiveDuration("HTTP_IDLE_TIMEOUT_MS", 60000),
@@ -46,14 +101,31 @@ func LoadFromEnv() (Config, error) {
 		ServerShutdownPeriod: mustPositiveDuration("HTTP_SHUTDOWN_TIMEOUT_MS", 15000),
 		TLSCertFile:          strings.TrimSpace(os.Getenv("TLS_CERT_FILE")),
 		TLSKeyFile:           strings.TrimSpace(os.Getenv("TLS_KEY_FILE")),
+		TLSClientCAFile:      strings.TrimSpace(os.Getenv("TLS_CLIENT_CA_FILE")),
+		TLSClientAuth:        strings.ToLower(getEnv("TLS_CLIENT_AUTH", "optional")),
 		RequireTLS:           parseBool("REQUIRE_TLS", true),
 		TrustProxyTLS:        parseBool("TRUST_PROXY_TLS", false),
 		ReadinessRequireAuth: parseBool("READINESS_REQUIRE_AUTH", true),
 		MaxBodyBytes:         int64(mustIntInRange("MAX_BODY_BYTES", 65536, 1024, 10*1024*1024)),
 		AuthFailLimitPerMin:  mustIntInRange("AUTH_FAIL_LIMIT_PER_MIN", 60, 1, 10000),
+		IdempotencyTTL:       mustPositiveDuration("IDEMPOTENCY_TTL_MS", 86400000),
+		IdempotencyMaxKeys:   mustIntInRange("IDEMPOTENCY_MAX_KEYS", 10000, 1, 1000000),
+		EventsBufferSize:     mustIntInRange("EVENTS_BUFFER_SIZE", 1024, 1, 100000),
 		EnabledSenders: splitCSV(
 			getEnv("ENABLED_SENDERS", "mqtt,rest"),
 		),
+		DeliveryPolicy:   strings.ToLower(getEnv("DELIVERY_POLICY", "all")),
+		DeliveryTimeout:  mustPositiveDuration("DELIVERY_TIMEOUT_MS", 10000),
+		RoutingRulesFile: strings.TrimSpace(os.Getenv("ROUTING_RULES_FILE")),
+		BreakerEnabled:   parseBool("CIRCUIT_BREAKER_ENABLED", false),
+		Breaker: breaker.Config{
+			FailureRateThreshold: float64(mustIntInRange("CIRCUIT_BREAKER_FAILURE_RATE_PCT", 50, 1, 100)) / 100,
+			SlowCallThreshold:    time.Duration(mustIntInRange("CIRCUIT_BREAKER_SLOW_CALL_MS", 0, 0, 600000)) * time.Millisecond,
+			WindowSize:           mustIntInRange("CIRCUIT_BREAKER_WINDOW_SIZE", 20, 1, 1000),
+			MinCalls:             mustIntInRange("CIRCUIT_BREAKER_MIN_CALLS", 5, 1, 1000),
+			CoolDown:             mustPositiveDuration("CIRCUIT_BREAKER_COOLDOWN_MS", 30000),
+			HalfOpenMaxCalls:     mustIntInRange("CIRCUIT_BREAKER_HALF_OPEN_CALLS", 1, 1, 100),
+		},
 		MQTT: mqtt.Config{
 			BrokerURL:              os.Getenv("MQTT_BROKER_URL"),
 			ClientID:               os.Getenv("MQTT_CLIENT_ID"),
@@ -67,6 +139,18 @@ func LoadFromEnv() (Config, error) {
 			AllowInsecureTLS:       parseBool("ALLOW_INSECURE_TLS_VERIFY", false),
 			AllowInsecureTransport: parseBool("ALLOW_INSECURE_MQTT", false),
 		},
+		AMQP: amqp.Config{
+			URL:                    os.Getenv("AMQP_URL"),
+			Username:               os.Getenv("AMQP_USERNAME"),
+			Password:               os.Getenv("AMQP_PASSWORD"),
+			Exchange:               getEnv("AMQP_EXCHANGE", "clocks.commands"),
+			RoutingKeyPrefix:       strings.TrimSpace(os.Getenv("AMQP_ROUTING_KEY_PREFIX")),
+			ConfirmTimeout:         mustPositiveDuration("AMQP_CONFIRM_TIMEOUT_MS", 5000),
+			Heartbeat:              time.Duration(mustIntInRange("AMQP_HEARTBEAT_SECONDS", 30, 1, 3600)) * time.Second,
+			TLSInsecureSkipVerify:  parseBool("AMQP_TLS_INSECURE_SKIP_VERIFY", false),
+			AllowInsecureTLS:       parseBool("ALLOW_INSECURE_TLS_VERIFY", false),
+			AllowInsecureTransport: parseBool("ALLOW_INSECURE_AMQP", false),
+		},
 		REST: rest.Config{
 			BaseURL:           os.Getenv("CLOCK_REST_BASE_URL"),
 			AuthToken:         os.Getenv("CLOCK_REST_TOKEN"),
@@ -74,24 +158,183 @@ func LoadFromEnv() (Config, error) {
 			HealthPath:        strings.TrimSpace(os.Getenv("CLOCK_REST_HEALTH_PATH")),
 			AllowInsecureHTTP: parseBool("ALLOW_INSECURE_DOWNSTREAM_HTTP", false),
 		},
+		Webhook: webhook.Config{
+			Secrets:           splitSecrets(os.Getenv("WEBHOOK_SECRETS")),
+			Timeout:           mustPositiveDuration("WEBHOOK_TIMEOUT_MS", 5000),
+			AllowInsecureHTTP: parseBool("ALLOW_INSECURE_DOWNSTREAM_HTTP", false),
+		},
+		JWT: security.JWTConfig{
+			Issuer:           strings.TrimSpace(os.Getenv("JWT_ISSUER")),
+			Audience:         strings.TrimSpace(os.Getenv("JWT_AUDIENCE")),
+			JWKSFile:         strings.TrimSpace(os.Getenv("JWT_JWKS_FILE")),
+			JWKSURL:          strings.TrimSpace(os.Getenv("JWT_JWKS_URL")),
+			CacheTTL:         mustPositiveDuration("JWT_JWKS_CACHE_TTL_MS", 300000),
+			ClockSkew:        time.Duration(mustIntInRange("JWT_CLOCK_SKEW_MS", 60000, 0, 600000)) * time.Millisecond,
+			DevicesClaim:     getEnv("JWT_DEVICES_CLAIM", "clock_devices"),
+			RolesClaim:       getEnv("JWT_ROLES_CLAIM", "clock_roles"),
+			AllowInsecureURL: parseBool("ALLOW_INSECURE_JWKS", false),
+		},
+		WebSocket: websocket.Config{
+			AckTimeout:   mustPositiveDuration("WEBSOCKET_ACK_TIMEOUT_MS", 5000),
+			PingInterval: mustPositiveDuration("WEBSOCKET_PING_INTERVAL_MS", 30000),
+		},
+	}
+	retryPolicy, err := loadRetryPolicy()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.MQTT.Retry = retryPolicy
+	cfg.AMQP.Retry = retryPolicy
+	cfg.REST.Retry = retryPolicy
+	cfg.Webhook.Retry = retryPolicy
+
+	if raw := strings.TrimSpace(os.Getenv("LEGACY_API_SUNSET")); raw != "" {
+		sunset, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid LEGACY_API_SUNSET: must be RFC3339")
+		}
+		cfg.LegacyAPISunset = sunset
+	}
+
+	endpoints, err := webhook.ParseEndpoints(os.Getenv("WEBHOOK_URLS"))
+	if err != nil {
+		return Config{}, fmt.Errorf("parse WEBHOOK_URLS: %w", err)
 	}
+	cfg.Webhook.Endpoints = endpoints
+
+	deviceTokens, err := websocket.ParseDeviceTokens(os.Getenv("WEBSOCKET_DEVICE_TOKENS"))
+	if err != nil {
+		return Config{}, fmt.Errorf("parse WEBSOCKET_DEVICE_TOKENS: %w", err)
+	}
+	cfg.WebSocket.DeviceTokens = deviceTokens
+
 	creds, err := security.ParseCredentials(os.Getenv("API_AUTH_CREDENTIALS"))
 	if err != nil {
 		return Config{}, fmt.Errorf("parse API_AUTH_CREDENTIALS: %w", err)
 	}
 	legacyToken := strings.TrimSpace(os.Getenv("API_AUTH_TOKEN"))
+	cfg.AuthCredentialsFile = strings.TrimSpace(os.Getenv("API_AUTH_CREDENTIALS_FILE"))
+	cfg.CredentialsReloadInterval = mustPositiveDuration("CREDENTIALS_RELOAD_INTERVAL_MS", 10000)
+	cfg.CredentialExpiryWarning = mustPositiveDuration("CREDENTIAL_EXPIRY_WARNING_MS", 7*24*60*60*1000)
+	if cfg.AuthCredentialsFile != "" {
+		if len(creds) > 0 || legacyToken != "" {
+			return Config{}, fmt.Errorf("set only one of API_AUTH_CREDENTIALS_FILE and API_AUTH_CREDENTIALS/API_AUTH_TOKEN")
+		}
+		content, err := os.ReadFile(cfg.AuthCredentialsFile)
+		if err != nil {
+			return Config{}, fmt.Errorf("read API_AUTH_CREDENTIALS_FILE: %w", err)
+		}
+		if creds, err = security.ParseCredentialsFile(content); err != nil {
+			return Config{}, fmt.Errorf("parse API_AUTH_CREDENTIALS_FILE: %w", err)
+		}
+		cfg.AuthCredentialsDigest = security.CredentialsDigest(content)
+	}
 	if len(creds) == 0 && legacyToken != "" {
 		creds = []security.Credential{{ID: "legacy", Token: legacyToken, Devices: []string{"*"}}}
 		log.Printf("WARNING: using legacy API_AUTH_TOKEN with wildcard device scope; migrate to API_AUTH_CREDENTIALS for scoped access")
 	}
-	if len(creds) == 0 {
-		return Config{}, fmt.Errorf("API_AUTH_CREDENTIALS or API_AUTH_TOKEN is required")
+	if cfg.JWT.Enabled() {
+		if cfg.JWT.JWKSFile != "" && cfg.JWT.JWKSURL != "" {
+			return Config{}, fmt.Errorf("set only one of JWT_JWKS_FILE and JWT_JWKS_URL")
+		}
+		if cfg.JWT.Issuer == "" || cfg.JWT.Audience == "" {
+			return Config{}, fmt.Errorf("JWT_ISSUER and JWT_AUDIENCE are required for JWT authentication")
+		}
+	}
+	certPrincipals, err := security.ParseCertPrincipals(os.Getenv("CLIENT_CERT_PRINCIPALS"))
+	if err != nil {
+		return Config{}, fmt.Errorf("parse CLIENT_CERT_PRINCIPALS: %w", err)
+	}
+	if len(certPrincipals) > 0 && cfg.TLSClientCAFile == "" {
+		return Config{}, fmt.Errorf("TLS_CLIENT_CA_FILE is required for CLIENT_CERT_PRINCIPALS")
+	}
+	cfg.ClientCertPrincipals = certPrincipals
+	if token := strings.TrimSpace(os.Getenv("ADMIN_AUTH_TOKEN")); token != "" {
+		admin, err := security.NewAdminCredential(token)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse ADMIN_AUTH_TOKEN: %w", err)
+		}
+		cfg.AdminCredential = &admin
+	}
+	cfg.CredentialStoreFile = strings.TrimSpace(os.Getenv("CREDENTIAL_STORE_FILE"))
+	if len(creds) == 0 && !cfg.JWT.Enabled() && len(certPrincipals) == 0 && cfg.AdminCredential == nil {
+		return Config{}, fmt.Errorf("API_AUTH_CREDENTIALS, API_AUTH_TOKEN, JWT_JWKS_FILE/JWT_JWKS_URL, CLIENT_CERT_PRINCIPALS or ADMIN_AUTH_TOKEN is required")
+	}
+	roles, err := security.ParseRoles(os.Getenv("API_ROLES"))
+	if err != nil {
+		return Config{}, fmt.Errorf("parse API_ROLES: %w", err)
+	}
+	for _, p := range certPrincipals {
+		if err := roles.CheckRoles(p.Roles); err != nil {
+			return Config{}, fmt.Errorf("client certificate principal %q: %w: define it in API_ROLES", p.ID, err)
+		}
+	}
+	cfg.Roles = roles
+	if cfg.PrincipalRateLimits, err = security.ParseRateLimits(os.Getenv("PRINCIPAL_RATE_LIMITS")); err != nil {
+		return Config{}, fmt.Errorf("parse PRINCIPAL_RATE_LIMITS: %w", err)
+	}
+	if cfg.DeviceRateLimits, err = security.ParseRateLimits(os.Getenv("DEVICE_RATE_LIMITS")); err != nil {
+		return Config{}, fmt.Errorf("parse DEVICE_RATE_LIMITS: %w", err)
+	}
+	if cfg.DailyQuotas, err = security.ParseQuotas(os.Getenv("DAILY_COMMAND_QUOTAS")); err != nil {
+		return Config{}, fmt.Errorf("parse DAILY_COMMAND_QUOTAS: %w", err)
+	}
+	cfg.RateLimitBackend = strings.ToLower(getEnv("RATE_LIMIT_BACKEND", "memory"))
+	switch cfg.RateLimitBackend {
+	case "memory":
+	case "redis":
+		cfg.Redis = redis.Config{
+			URL:                    strings.TrimSpace(os.Getenv("REDIS_URL")),
+			Username:               os.Getenv("REDIS_USERNAME"),
+			Password:               os.Getenv("REDIS_PASSWORD"),
+			KeyPrefix:              getEnv("REDIS_KEY_PREFIX", "clock-server:"),
+			Timeout:                mustPositiveDuration("REDIS_TIMEOUT_MS", 1000),
+			TLSInsecureSkipVerify:  parseBool("REDIS_TLS_INSECURE_SKIP_VERIFY", false),
+			AllowInsecureTLS:       parseBool("ALLOW_INSECURE_TLS_VERIFY", false),
+			AllowInsecureTransport: parseBool("ALLOW_INSECURE_REDIS", false),
+		}
+		if cfg.Redis.URL == "" {
+			return Config{}, fmt.Errorf("REDIS_URL is required when RATE_LIMIT_BACKEND=redis")
+		}
+	default:
+		return Config{}, fmt.Errorf("unknown RATE_LIMIT_BACKEND: %s", cfg.RateLimitBackend)
+	}
+	cfg.AuditLog = auditlog.Config{
+		Path:     strings.TrimSpace(os.Getenv("AUDIT_LOG_FILE")),
+		MaxBytes: int64(mustIntInRange("AUDIT_LOG_MAX_BYTES", 100*1024*1024, 4096, 1<<30)),
+		MaxFiles: mustIntInRange("AUDIT_LOG_MAX_FILES", 10, 0, 10000),
+	}
+	if key := os.Getenv("AUDIT_LOG_KEY"); key != "" {
+		cfg.AuditLog.Key = []byte(key)
+	}
+	if cfg.AuditLog.Redactions, err = auditlog.ParseRedactions(os.Getenv("AUDIT_LOG_REDACT")); err != nil {
+		return Config{}, fmt.Errorf("parse AUDIT_LOG_REDACT: %w", err)
+	}
+	if pepper := os.Getenv("API_AUTH_TOKEN_PEPPER"); pepper != "" {
+		cfg.AuthTokenPepper = []byte(pepper)
+	}
+	if err := cfg.CheckCredentials(creds); err != nil {
+		return Config{}, err
+	}
+	if cfg.AdminCredential != nil {
+		if err := cfg.checkRolesAndHashes([]security.Credential{*cfg.AdminCredential}); err != nil {
+			return Config{}, fmt.Errorf("ADMIN_AUTH_TOKEN: %w", err)
+		}
 	}
 	cfg.AuthCredentials = creds
 
+	if cfg.GRPCAddr != "" && cfg.GRPCAddr == cfg.ServerAddr {
+		return Config{}, fmt.Errorf("GRPC_ADDR must differ from HTTP_ADDR")
+	}
 	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
 		return Config{}, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must both be set")
 	}
+	if cfg.TLSClientAuth != "optional" && cfg.TLSClientAuth != "require" {
+		return Config{}, fmt.Errorf("invalid TLS_CLIENT_AUTH: expected optional or require")
+	}
+	if cfg.TLSClientCAFile != "" && cfg.TLSCertFile == "" {
+		return Config{}, fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE/TLS_KEY_FILE: client certificates need direct TLS")
+	}
 	if cfg.RequireTLS && cfg.TLSCertFile == "" && !cfg.TrustProxyTLS {
 		return Config{}, fmt.Errorf("TLS is required: set TLS_CERT_FILE/TLS_KEY_FILE or TRUST_PROXY_TLS=true")
 	}
@@ -103,15 +346,71 @@ func LoadFromEnv() (Config, error) {
 
 	for _, sender := range cfg.EnabledSenders {
 		switch sender {
-		case "mqtt", "rest":
+		case "mqtt", "amqp", "rest", "websocket", "webhook":
 		default:
 			return Config{}, fmt.Errorf("unknown sender in ENABLED_SENDERS: %s", sender)
 		}
 	}
+	switch cfg.DeliveryPolicy {
+	case "all", "any", "quorum", "primary-with-fallback":
+	default:
+		return Config{}, fmt.Errorf("unknown DELIVERY_POLICY: %s", cfg.DeliveryPolicy)
+	}
 
 	return cfg, nil
 }
 
+// CheckCredentials validates credentials against the rest of the
+// configuration: the admin ID is reserved for ADMIN_AUTH_TOKEN, their roles
+// must be defined and HMAC-SHA256 hashes need the pepper. It also applies to
+// credentials reloaded from API_AUTH_CREDENTIALS_FILE and to those of the
+// credential store.
+func (c Config) CheckCredentials(creds []security.Credential) error {
+	for _, cred := range creds {
+		if cred.ID == security.AdminCredentialID {
+			return fmt.Errorf("credential id %q is reserved for ADMIN_AUTH_TOKEN", cred.ID)
+		}
+	}
+	return c.checkRolesAndHashes(creds)
+}
+
+func (c Config) checkRolesAndHashes(creds []security.Credential) error {
+	for _, cred := range creds {
+		if err := c.Roles.CheckRoles(cred.Roles); err != nil {
+			return fmt.Errorf("credential %q: %w: define it in API_ROLES", cred.ID, err)
+		}
+	}
+	if security.RequiresPepper(creds) && len(c.AuthTokenPepper) == 0 {
+		return fmt.Errorf("API_AUTH_TOKEN_PEPPER is required for $hmac-sha256$ credential hashes")
+	}
+	return nil
+}
+
+func loadRetryPolicy() (retry.Policy, error) {
+	policy := retry.Policy{
+		MaxAttempts: mustIntInRange("RETRY_MAX_ATTEMPTS", 3, 1, 10),
+		BaseBackoff: mustPositiveDuration("RETRY_BASE_BACKOFF_MS", 100),
+		MaxBackoff:  mustPositiveDuration("RETRY_MAX_BACKOFF_MS", 2000),
+		Jitter:      float64(mustIntInRange("RETRY_JITTER_PCT", 20, 0, 100)) / 100,
+	}
+	if policy.MaxBackoff < policy.BaseBackoff {
+		return retry.Policy{}, fmt.Errorf("RETRY_MAX_BACKOFF_MS must not be less than RETRY_BASE_BACKOFF_MS")
+	}
+	raw := strings.TrimSpace(os.Getenv("RETRY_STATUS_CODES"))
+	if raw == "" {
+		policy.RetryableStatus = retry.DefaultRetryableStatus
+		return policy, nil
+	}
+	for _, part := range splitCSV(raw) {
+		code, err := strconv.Atoi(part)
+		if err != nil || code < 100 || code > 599 {
+			return retry.Policy{}, fmt.Errorf("invalid status code in RETRY_STATUS_CODES: %s", part)
+		}
+		policy.RetryableStatus = append(policy.RetryableStatus, code)
+	}
+	return policy, nil
+}
+
 func getEnv(key, fallback string) string {
 	value := strings.TrimSpace(os.Getenv(key))
 	if value == "" {
@@ -132,6 +431,17 @@ func splitCSV(raw string) []string {
 	return out
 }
 
+// splitSecrets splits comma-separated secrets, keeping their case.
+func splitSecrets(raw string) []string {
+	var out []string
+	for _, part := range strings.Split(raw, ",") {
+		if trimmed := strings.TrimSpace(part); trimmed != "" {
+			out = append(out, trimmed)
+		}
+	}
+	return out
+}
+
 func parseInt(key string, fallback int) (int, error) {
 	value := strings.TrimSpace(os.Getenv(key))
 	if value == "" {
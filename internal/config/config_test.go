@@ -1,16 +1,62 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/paul/clock-server/internal/adapters/retry"
+	"github.com/paul/clock-server/internal/security"
 )
 
 func clearConfigEnv(t *testing.T) {
 	t.Helper()
 	keys := []string{
 		"HTTP_ADDR",
+		"PRINCIPAL_RATE_LIMITS",
+		"DEVICE_RATE_LIMITS",
+		"DAILY_COMMAND_QUOTAS",
+		"RATE_LIMIT_BACKEND",
+		"REDIS_URL",
+		"REDIS_USERNAME",
+		"REDIS_PASSWORD",
+		"REDIS_KEY_PREFIX",
+		"REDIS_TIMEOUT_MS",
+		"REDIS_TLS_INSECURE_SKIP_VERIFY",
+		"ALLOW_INSECURE_REDIS",
+		"AUDIT_LOG_FILE",
+		"AUDIT_LOG_MAX_BYTES",
+		"AUDIT_LOG_MAX_FILES",
+		"AUDIT_LOG_KEY",
+		"AUDIT_LOG_REDACT",
 		"ENABLED_SENDERS",
+		"DELIVERY_POLICY",
+		"DELIVERY_TIMEOUT_MS",
+		"ROUTING_RULES_FILE",
+		"CIRCUIT_BREAKER_ENABLED",
+		"CIRCUIT_BREAKER_FAILURE_RATE_PCT",
+		"CIRCUIT_BREAKER_SLOW_CALL_MS",
+		"CIRCUIT_BREAKER_WINDOW_SIZE",
+		"CIRCUIT_BREAKER_MIN_CALLS",
+		"CIRCUIT_BREAKER_COOLDOWN_MS",
+		"CIRCUIT_BREAKER_HALF_OPEN_CALLS",
+		"RETRY_MAX_ATTEMPTS",
+		"RETRY_BASE_BACKOFF_MS",
+		"RETRY_MAX_BACKOFF_MS",
+		"RETRY_JITTER_PCT",
+		"RETRY_STATUS_CODES",
+		"IDEMPOTENCY_TTL_MS",
+		"IDEMPOTENCY_MAX_KEYS",
+		"LEGACY_API_SUNSET",
+		"EVENTS_BUFFER_SIZE",
+		"WEBSOCKET_DEVICE_TOKENS",
+		"WEBSOCKET_ACK_TIMEOUT_MS",
+		"WEBSOCKET_PING_INTERVAL_MS",
+		"WEBHOOK_URLS",
+		"WEBHOOK_SECRETS",
+		"WEBHOOK_TIMEOUT_MS",
 		"MQTT_BROKER_URL",
 		"MQTT_CLIENT_ID",
 		"MQTT_USERNAME",
@@ -20,11 +66,36 @@ func clearConfigEnv(t *testing.T) {
 		"MQTT_QOS",
 		"MQTT_RETAINED",
 		"MQTT_TLS_INSECURE_SKIP_VERIFY",
+		"AMQP_URL",
+		"AMQP_USERNAME",
+		"AMQP_PASSWORD",
+		"AMQP_EXCHANGE",
+		"AMQP_ROUTING_KEY_PREFIX",
+		"AMQP_CONFIRM_TIMEOUT_MS",
+		"AMQP_HEARTBEAT_SECONDS",
+		"AMQP_TLS_INSECURE_SKIP_VERIFY",
 		"CLOCK_REST_BASE_URL",
 		"CLOCK_REST_TOKEN",
 		"CLOCK_REST_TIMEOUT_MS",
 		"CLOCK_REST_HEALTH_PATH",
 		"API_AUTH_TOKEN",
+		"GRPC_ADDR",
+		"API_AUTH_TOKEN_PEPPER",
+		"JWT_ISSUER",
+		"JWT_AUDIENCE",
+		"JWT_JWKS_FILE",
+		"JWT_JWKS_URL",
+		"JWT_JWKS_CACHE_TTL_MS",
+		"JWT_CLOCK_SKEW_MS",
+		"JWT_DEVICES_CLAIM",
+		"JWT_ROLES_CLAIM",
+		"API_ROLES",
+		"API_AUTH_CREDENTIALS_FILE",
+		"CREDENTIALS_RELOAD_INTERVAL_MS",
+		"CREDENTIAL_EXPIRY_WARNING_MS",
+		"ADMIN_AUTH_TOKEN",
+		"CREDENTIAL_STORE_FILE",
+		"ALLOW_INSECURE_JWKS",
 		"HTTP_READ_TIMEOUT_MS",
 		"HTTP_WRITE_TIMEOUT_MS",
 		"HTTP_IDLE_TIMEOUT_MS",
@@ -32,6 +103,9 @@ func clearConfigEnv(t *testing.T) {
 		"HTTP_SHUTDOWN_TIMEOUT_MS",
 		"TLS_CERT_FILE",
 		"TLS_KEY_FILE",
+		"TLS_CLIENT_CA_FILE",
+		"TLS_CLIENT_AUTH",
+		"CLIENT_CERT_PRINCIPALS",
 		"REQUIRE_TLS",
 		"TRUST_PROXY_TLS",
 		"READINESS_REQUIRE_AUTH",
@@ -40,6 +114,7 @@ func clearConfigEnv(t *testing.T) {
 		"API_AUTH_CREDENTIALS",
 		"ALLOW_INSECURE_TLS_VERIFY",
 		"ALLOW_INSECURE_MQTT",
+		"ALLOW_INSECURE_AMQP",
 		"ALLOW_INSECURE_DOWNSTREAM_HTTP",
 	}
 	for _, key := range keys {
@@ -75,6 +150,12 @@ func TestLoadFromEnvDefaults(t *testing.T) {
 	if cfg.REST.Timeout != 5*time.Second {
 		t.Fatalf("expected default timeout 5s, got %s", cfg.REST.Timeout)
 	}
+	if cfg.DeliveryPolicy != "all" {
+		t.Fatalf("expected default delivery policy all, got %q", cfg.DeliveryPolicy)
+	}
+	if cfg.DeliveryTimeout != 10*time.Second {
+		t.Fatalf("expected default delivery timeout 10s, got %s", cfg.DeliveryTimeout)
+	}
 }
 
 func TestLoadFromEnvParsesOverrides(t *testing.T) {
@@ -187,3 +268,503 @@ func TestLoadFromEnvParsesCredentialScopes(t *testing.T) {
 		t.Fatal("expected scope to allow clock-12")
 	}
 }
+
+func TestLoadFromEnvParsesDeliveryPolicy(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("DELIVERY_POLICY", " Primary-With-Fallback ")
+	t.Setenv("DELIVERY_TIMEOUT_MS", "2500")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.DeliveryPolicy != "primary-with-fallback" {
+		t.Fatalf("unexpected delivery policy: %q", cfg.DeliveryPolicy)
+	}
+	if cfg.DeliveryTimeout != 2500*time.Millisecond {
+		t.Fatalf("expected delivery timeout 2500ms, got %s", cfg.DeliveryTimeout)
+	}
+}
+
+func TestLoadFromEnvRejectsUnknownDeliveryPolicy(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("DELIVERY_POLICY", "most")
+
+	_, err := LoadFromEnv()
+	if err == nil || !strings.Contains(err.Error(), "DELIVERY_POLICY") {
+		t.Fatalf("expected delivery policy error, got %v", err)
+	}
+}
+
+func TestLoadFromEnvParsesCircuitBreaker(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("CIRCUIT_BREAKER_ENABLED", "true")
+	t.Setenv("CIRCUIT_BREAKER_FAILURE_RATE_PCT", "25")
+	t.Setenv("CIRCUIT_BREAKER_SLOW_CALL_MS", "1500")
+	t.Setenv("CIRCUIT_BREAKER_COOLDOWN_MS", "5000")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if !cfg.BreakerEnabled {
+		t.Fatal("expected circuit breaker enabled")
+	}
+	if cfg.Breaker.FailureRateThreshold != 0.25 {
+		t.Fatalf("expected failure rate 0.25, got %v", cfg.Breaker.FailureRateThreshold)
+	}
+	if cfg.Breaker.SlowCallThreshold != 1500*time.Millisecond {
+		t.Fatalf("expected slow call threshold 1500ms, got %s", cfg.Breaker.SlowCallThreshold)
+	}
+	if cfg.Breaker.CoolDown != 5*time.Second {
+		t.Fatalf("expected cool-down 5s, got %s", cfg.Breaker.CoolDown)
+	}
+	if cfg.Breaker.WindowSize != 20 || cfg.Breaker.MinCalls != 5 || cfg.Breaker.HalfOpenMaxCalls != 1 {
+		t.Fatalf("unexpected breaker defaults: %#v", cfg.Breaker)
+	}
+}
+
+func TestLoadFromEnvParsesRetryPolicy(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("RETRY_MAX_ATTEMPTS", "5")
+	t.Setenv("RETRY_BASE_BACKOFF_MS", "50")
+	t.Setenv("RETRY_STATUS_CODES", "503, 504")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	for name, policy := range map[string]retry.Policy{"mqtt": cfg.MQTT.Retry, "rest": cfg.REST.Retry} {
+		if policy.MaxAttempts != 5 || policy.BaseBackoff != 50*time.Millisecond || policy.MaxBackoff != 2*time.Second {
+			t.Fatalf("unexpected %s retry policy: %#v", name, policy)
+		}
+		if len(policy.RetryableStatus) != 2 || policy.RetryableStatus[0] != 503 || policy.RetryableStatus[1] != 504 {
+			t.Fatalf("unexpected %s retryable statuses: %#v", name, policy.RetryableStatus)
+		}
+	}
+}
+
+func TestLoadFromEnvRejectsInvalidRetryStatus(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("RETRY_STATUS_CODES", "503,teapot")
+
+	_, err := LoadFromEnv()
+	if err == nil || !strings.Contains(err.Error(), "RETRY_STATUS_CODES") {
+		t.Fatalf("expected retry status error, got %v", err)
+	}
+}
+
+func TestLoadFromEnvParsesIdempotencySettings(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("IDEMPOTENCY_TTL_MS", "60000")
+	t.Setenv("IDEMPOTENCY_MAX_KEYS", "500")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.IdempotencyTTL != time.Minute || cfg.IdempotencyMaxKeys != 500 {
+		t.Fatalf("unexpected idempotency settings: ttl=%v maxKeys=%d", cfg.IdempotencyTTL, cfg.IdempotencyMaxKeys)
+	}
+}
+
+func TestLoadFromEnvParsesLegacySunset(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("LEGACY_API_SUNSET", "2027-04-01T00:00:00Z")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if !cfg.LegacyAPISunset.Equal(time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected sunset: %v", cfg.LegacyAPISunset)
+	}
+
+	t.Setenv("LEGACY_API_SUNSET", "next spring")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "LEGACY_API_SUNSET") {
+		t.Fatalf("expected sunset error, got %v", err)
+	}
+}
+
+func TestLoadFromEnvParsesEventsBufferSize(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	t.Setenv("REQUIRE_TLS", "false")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.EventsBufferSize != 1024 {
+		t.Fatalf("expected default events buffer 1024, got %d", cfg.EventsBufferSize)
+	}
+
+	t.Setenv("EVENTS_BUFFER_SIZE", "256")
+	if cfg, _ = LoadFromEnv(); cfg.EventsBufferSize != 256 {
+		t.Fatalf("expected events buffer 256, got %d", cfg.EventsBufferSize)
+	}
+}
+
+func TestLoadFromEnvParsesWebSocketSettings(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("ENABLED_SENDERS", "websocket")
+	t.Setenv("WEBSOCKET_DEVICE_TOKENS", "clock-1|secret-1;clock-2|secret-2")
+	t.Setenv("WEBSOCKET_ACK_TIMEOUT_MS", "2000")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if len(cfg.WebSocket.DeviceTokens) != 2 || cfg.WebSocket.AckTimeout != 2*time.Second || cfg.WebSocket.PingInterval != 30*time.Second {
+		t.Fatalf("unexpected websocket settings: %+v", cfg.WebSocket)
+	}
+
+	t.Setenv("WEBSOCKET_DEVICE_TOKENS", "clock-1")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "WEBSOCKET_DEVICE_TOKENS") {
+		t.Fatalf("expected device token error, got %v", err)
+	}
+}
+
+func TestLoadFromEnvParsesWebhookSettings(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("ENABLED_SENDERS", "webhook")
+	t.Setenv("WEBHOOK_URLS", "https://ha.local/hook|display_message;https://audit.local/hook")
+	t.Setenv("WEBHOOK_SECRETS", "NewSecret, OldSecret")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if len(cfg.Webhook.Endpoints) != 2 || cfg.Webhook.Endpoints[0].Types[0] != "display_message" {
+		t.Fatalf("unexpected endpoints: %+v", cfg.Webhook.Endpoints)
+	}
+	if len(cfg.Webhook.Secrets) != 2 || cfg.Webhook.Secrets[0] != "NewSecret" {
+		t.Fatalf("secrets must keep their case: %v", cfg.Webhook.Secrets)
+	}
+	if cfg.Webhook.Timeout != 5*time.Second || cfg.Webhook.Retry.MaxAttempts != 3 {
+		t.Fatalf("unexpected webhook settings: %+v", cfg.Webhook)
+	}
+
+	t.Setenv("WEBHOOK_URLS", "https://ha.local/hook|reboot")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "WEBHOOK_URLS") {
+		t.Fatalf("expected webhook url error, got %v", err)
+	}
+}
+
+func TestLoadFromEnvParsesAMQPSettings(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("ENABLED_SENDERS", "amqp")
+	t.Setenv("AMQP_URL", "amqps://rabbit.local/clocks")
+	t.Setenv("AMQP_ROUTING_KEY_PREFIX", "building-a")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.AMQP.URL != "amqps://rabbit.local/clocks" || cfg.AMQP.Exchange != "clocks.commands" || cfg.AMQP.RoutingKeyPrefix != "building-a" {
+		t.Fatalf("unexpected amqp settings: %+v", cfg.AMQP)
+	}
+	if cfg.AMQP.ConfirmTimeout != 5*time.Second || cfg.AMQP.Heartbeat != 30*time.Second || cfg.AMQP.Retry.MaxAttempts != 3 || cfg.AMQP.AllowInsecureTransport {
+		t.Fatalf("unexpected amqp defaults: %+v", cfg.AMQP)
+	}
+}
+
+func TestLoadFromEnvParsesGRPCAddr(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("GRPC_ADDR", ":9090")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.GRPCAddr != ":9090" {
+		t.Fatalf("unexpected grpc addr %q", cfg.GRPCAddr)
+	}
+
+	t.Setenv("GRPC_ADDR", ":8080")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected GRPC_ADDR equal to HTTP_ADDR to be rejected")
+	}
+}
+
+func TestLoadFromEnvRequiresPepperForHMACHashes(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("REQUIRE_TLS", "false")
+	hash, err := security.HashTokenSHA256("token", []byte("pepper"))
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	t.Setenv("API_AUTH_CREDENTIALS", "ops|"+hash+"|*")
+
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected missing API_AUTH_TOKEN_PEPPER to be rejected")
+	}
+	t.Setenv("API_AUTH_TOKEN_PEPPER", "pepper")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if string(cfg.AuthTokenPepper) != "pepper" {
+		t.Fatalf("unexpected pepper %q", cfg.AuthTokenPepper)
+	}
+}
+
+func TestLoadFromEnvParsesJWTSettings(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("JWT_JWKS_URL", "https://idp.example/.well-known/jwks.json")
+	t.Setenv("JWT_ISSUER", "https://idp.example")
+	t.Setenv("JWT_AUDIENCE", "clock-server")
+	t.Setenv("JWT_CLOCK_SKEW_MS", "0")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("JWT alone should satisfy the auth requirement: %v", err)
+	}
+	if !cfg.JWT.Enabled() || cfg.JWT.DevicesClaim != "clock_devices" || cfg.JWT.CacheTTL != 5*time.Minute || cfg.JWT.ClockSkew != 0 {
+		t.Fatalf("unexpected jwt settings: %+v", cfg.JWT)
+	}
+	if len(cfg.AuthCredentials) != 0 {
+		t.Fatalf("expected no static credentials, got %d", len(cfg.AuthCredentials))
+	}
+
+	t.Setenv("JWT_AUDIENCE", "")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected a missing JWT_AUDIENCE to be rejected")
+	}
+}
+
+func TestLoadFromEnvParsesClientCertSettings(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("TLS_CERT_FILE", "/tls/tls.crt")
+	t.Setenv("TLS_KEY_FILE", "/tls/tls.key")
+	t.Setenv("TLS_CLIENT_CA_FILE", "/tls/client-ca.crt")
+	t.Setenv("TLS_CLIENT_AUTH", "require")
+	t.Setenv("CLIENT_CERT_PRINCIPALS", "agent|uri:spiffe://example.org/agent|lobby-*")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("client certificate principals alone should satisfy the auth requirement: %v", err)
+	}
+	if cfg.TLSClientCAFile != "/tls/client-ca.crt" || cfg.TLSClientAuth != "require" {
+		t.Fatalf("unexpected client tls settings: %q %q", cfg.TLSClientCAFile, cfg.TLSClientAuth)
+	}
+	if len(cfg.ClientCertPrincipals) != 1 || cfg.ClientCertPrincipals[0].Identity != "uri:spiffe://example.org/agent" {
+		t.Fatalf("unexpected client certificate principals: %+v", cfg.ClientCertPrincipals)
+	}
+
+	t.Setenv("TLS_CLIENT_AUTH", "sometimes")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected an invalid TLS_CLIENT_AUTH to be rejected")
+	}
+	t.Setenv("TLS_CLIENT_AUTH", "")
+	t.Setenv("TLS_CLIENT_CA_FILE", "")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected CLIENT_CERT_PRINCIPALS without TLS_CLIENT_CA_FILE to be rejected")
+	}
+	t.Setenv("CLIENT_CERT_PRINCIPALS", "")
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	t.Setenv("TLS_CLIENT_CA_FILE", "/tls/client-ca.crt")
+	t.Setenv("TLS_CERT_FILE", "")
+	t.Setenv("TLS_KEY_FILE", "")
+	t.Setenv("TRUST_PROXY_TLS", "true")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected TLS_CLIENT_CA_FILE without a server certificate to be rejected")
+	}
+}
+
+func TestLoadFromEnvParsesRoles(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("API_ROLES", "messenger=command:display_message")
+	t.Setenv("API_AUTH_CREDENTIALS", "lobby|t1|lobby-*|messenger;root|t2|*|admin")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !cfg.Roles.Permits(cfg.AuthCredentials[0].Roles, security.CommandPermission("display_message")) ||
+		cfg.Roles.Permits(cfg.AuthCredentials[0].Roles, security.CommandPermission("set_alarm")) {
+		t.Fatalf("unexpected permissions for roles %v", cfg.AuthCredentials[0].Roles)
+	}
+	if cfg.JWT.RolesClaim != "clock_roles" {
+		t.Fatalf("unexpected JWT roles claim %q", cfg.JWT.RolesClaim)
+	}
+
+	t.Setenv("API_AUTH_CREDENTIALS", "lobby|t1|lobby-*|operator")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected a credential with an undefined role to be rejected")
+	}
+	t.Setenv("API_ROLES", "messenger=command:reboot")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected a permission for an unknown command type to be rejected")
+	}
+}
+
+func TestLoadFromEnvReadsCredentialsFile(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("REQUIRE_TLS", "false")
+	path := filepath.Join(t.TempDir(), "credentials")
+	content := []byte("# ops team\nops|t1|*\nlobby|t2|lobby-*\n")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("API_AUTH_CREDENTIALS_FILE", path)
+	t.Setenv("CREDENTIALS_RELOAD_INTERVAL_MS", "2000")
+	t.Setenv("CREDENTIAL_EXPIRY_WARNING_MS", "86400000")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(cfg.AuthCredentials) != 2 || cfg.AuthCredentialsDigest != security.CredentialsDigest(content) || cfg.CredentialsReloadInterval != 2*time.Second || cfg.CredentialExpiryWarning != 24*time.Hour {
+		t.Fatalf("unexpected credentials file settings: %+v", cfg)
+	}
+	if err := cfg.CheckCredentials([]security.Credential{{ID: "x", Token: "t", Devices: []string{"*"}, Roles: []string{"operator"}}}); err == nil {
+		t.Fatal("expected reloaded credentials with an undefined role to be rejected")
+	}
+	if err := cfg.CheckCredentials([]security.Credential{{ID: security.AdminCredentialID, Token: "t", Devices: []string{"*"}}}); err == nil {
+		t.Fatal("expected a reloaded credential named admin to be rejected")
+	}
+
+	t.Setenv("API_AUTH_TOKEN", "test-token")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected API_AUTH_TOKEN together with API_AUTH_CREDENTIALS_FILE to be rejected")
+	}
+	t.Setenv("API_AUTH_TOKEN", "")
+	t.Setenv("API_AUTH_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected a missing credentials file to be rejected")
+	}
+}
+
+func TestLoadFromEnvAdminCredential(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("ADMIN_AUTH_TOKEN", "admin-token")
+	t.Setenv("CREDENTIAL_STORE_FILE", "/var/lib/clock-server/credentials.json")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("expected the admin token alone to satisfy authentication: %v", err)
+	}
+	admin := cfg.AdminCredential
+	if admin == nil || admin.ID != security.AdminCredentialID || len(admin.Devices) != 0 || admin.Roles[0] != security.RoleAdmin {
+		t.Fatalf("unexpected admin credential: %+v", admin)
+	}
+	if cfg.CredentialStoreFile != "/var/lib/clock-server/credentials.json" {
+		t.Fatalf("unexpected credential store file %q", cfg.CredentialStoreFile)
+	}
+
+	t.Setenv("API_AUTH_CREDENTIALS", "admin|t1|*")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected a credential named admin to be rejected alongside ADMIN_AUTH_TOKEN")
+	}
+	t.Setenv("API_AUTH_CREDENTIALS", "")
+	t.Setenv("ADMIN_AUTH_TOKEN", "$hmac-sha256$not-base64!")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected a malformed admin token hash to be rejected")
+	}
+}
+
+func TestLoadFromEnvRateLimits(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("API_AUTH_TOKEN", "token")
+	t.Setenv("PRINCIPAL_RATE_LIMITS", "*=60/m;display_message=10/m")
+	t.Setenv("DEVICE_RATE_LIMITS", "display_message=6/m")
+	t.Setenv("DAILY_COMMAND_QUOTAS", "*=1000")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(cfg.PrincipalRateLimits) != 2 || cfg.DeviceRateLimits["display_message"].Count != 6 || cfg.DailyQuotas["*"] != 1000 {
+		t.Fatalf("unexpected limits %v %v %v", cfg.PrincipalRateLimits, cfg.DeviceRateLimits, cfg.DailyQuotas)
+	}
+
+	t.Setenv("DEVICE_RATE_LIMITS", "reboot=1/s")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected a limit for an unknown command type to be rejected")
+	}
+}
+
+func TestLoadFromEnvRateLimitBackend(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("API_AUTH_TOKEN", "token")
+
+	cfg, err := LoadFromEnv()
+	if err != nil || cfg.RateLimitBackend != "memory" {
+		t.Fatalf("expected the memory backend by default, got %q (%v)", cfg.RateLimitBackend, err)
+	}
+
+	t.Setenv("RATE_LIMIT_BACKEND", "redis")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "REDIS_URL") {
+		t.Fatalf("expected REDIS_URL to be required, got %v", err)
+	}
+	t.Setenv("REDIS_URL", "rediss://redis.internal:6380/1")
+	t.Setenv("REDIS_PASSWORD", "secret")
+	t.Setenv("REDIS_TIMEOUT_MS", "250")
+	cfg, err = LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Redis.URL != "rediss://redis.internal:6380/1" || cfg.Redis.Password != "secret" || cfg.Redis.KeyPrefix != "clock-server:" || cfg.Redis.Timeout != 250*time.Millisecond {
+		t.Fatalf("unexpected redis config %+v", cfg.Redis)
+	}
+
+	t.Setenv("RATE_LIMIT_BACKEND", "memcached")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected an unknown backend to be rejected")
+	}
+}
+
+func TestLoadFromEnvAuditLog(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("REQUIRE_TLS", "false")
+	t.Setenv("API_AUTH_TOKEN", "token")
+
+	cfg, err := LoadFromEnv()
+	if err != nil || cfg.AuditLog.Path != "" || cfg.AuditLog.MaxBytes != 100*1024*1024 || cfg.AuditLog.MaxFiles != 10 {
+		t.Fatalf("unexpected default audit log config %+v (%v)", cfg.AuditLog, err)
+	}
+
+	t.Setenv("AUDIT_LOG_FILE", "/var/log/clock-server/audit.log")
+	t.Setenv("AUDIT_LOG_KEY", "chain-key")
+	t.Setenv("AUDIT_LOG_REDACT", "display_message.message")
+	cfg, err = LoadFromEnv()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.AuditLog.Path != "/var/log/clock-server/audit.log" || string(cfg.AuditLog.Key) != "chain-key" || len(cfg.AuditLog.Redactions["display_message"]) != 1 {
+		t.Fatalf("unexpected audit log config %+v", cfg.AuditLog)
+	}
+
+	t.Setenv("AUDIT_LOG_REDACT", "display_message.colour")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected a redaction of an unknown field to be rejected")
+	}
+}
@@ -17,10 +17,10 @@ var deviceIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
 func ValidateDeviceID(id string) error {
 	trimmed := strings.TrimSpace(id)
 	if trimmed == "" {
-		return NewValidationError("device id is required")
+		return NewFieldValidationError("deviceId", "device id is required")
 	}
 	if !deviceIDPattern.MatchString(trimmed) {
-		return NewValidationError("device id contains invalid characters")
+		return NewFieldValidationError("deviceId", "device id contains invalid characters")
 	}
 	return nil
 }
@@ -33,6 +33,12 @@ type ClockCommand interface {
 	Validate() error
 }
 
+// IdempotentCommand is implemented by commands that can be delivered more
+// than once without changing the outcome on the device.
+type IdempotentCommand interface {
+	Idempotent() bool
+}
+
 // SetAlarmCommand instructs a clock to create a new alarm.
 type SetAlarmCommand struct {
 	DeviceID  string
@@ -61,10 +67,10 @@ func (c SetAlarmCommand) Validate() error {
 		return err
 	}
 	if c.AlarmTime.IsZero() {
-		return NewValidationError("alarm time is required")
+		return NewFieldValidationError("alarmTime", "alarm time is required")
 	}
 	if c.AlarmTime.Before(time.Now().Add(-1 * time.Minute)) {
-		return NewValidationErrorf("alarm time %s is in the past", c.AlarmTime.Format(time.RFC3339))
+		return NewFieldValidationErrorf("alarmTime", "alarm time %s is in the past", c.AlarmTime.Format(time.RFC3339))
 	}
 	return nil
 }
@@ -97,13 +103,13 @@ func (c DisplayMessageCommand) Validate() error {
 		return err
 	}
 	if strings.TrimSpace(c.Message) == "" {
-		return NewValidationError("message is required")
+		return NewFieldValidationError("message", "message is required")
 	}
 	if c.DurationSeconds <= 0 {
-		return NewValidationError("duration seconds must be greater than zero")
+		return NewFieldValidationError("durationSeconds", "duration seconds must be greater than zero")
 	}
 	if c.DurationSeconds > 3600 {
-		return NewValidationError("duration seconds must be less than or equal to 3600")
+		return NewFieldValidationError("durationSeconds", "duration seconds must be less than or equal to 3600")
 	}
 	return nil
 }
@@ -129,13 +135,18 @@ func (c SetBrightnessCommand) CommandType() string {
 	return "set_brightness"
 }
 
+// Idempotent reports that repeating a brightness change is harmless.
+func (c SetBrightnessCommand) Idempotent() bool {
+	return true
+}
+
 // Validate verifies command invariants.
 func (c SetBrightnessCommand) Validate() error {
 	if err := ValidateDeviceID(c.DeviceID); err != nil {
 		return err
 	}
 	if c.Level < 0 || c.Level > 100 {
-		return NewValidationError("brightness level must be between 0 and 100")
+		return NewFieldValidationError("level", "brightness level must be between 0 and 100")
 	}
 	return nil
 }
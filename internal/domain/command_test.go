@@ -2,6 +2,7 @@ package domain
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 )
@@ -82,7 +83,12 @@ func TestSetBrightnessCommandValidate(t *testing.T) {
 	}
 
 	invalid := SetBrightnessCommand{DeviceID: "clock-1", Level: 101}
-	if err := invalid.Validate(); err == nil {
+	err := invalid.Validate()
+	if err == nil {
 		t.Fatal("expected validation error for out-of-range brightness")
 	}
+	var validationErr ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Field != "level" {
+		t.Fatalf("expected validation error on field level, got %#v", err)
+	}
 }
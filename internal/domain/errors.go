@@ -2,8 +2,11 @@ package domain
 
 import "fmt"
 
-// ValidationError is returned when domain invariants are violated.
+// ValidationError is returned when domain invariants are violated. Field names
+// the offending request field when the violation is tied to one. The message
+// is safe to return to API clients.
 type ValidationError struct {
+	Field   string
 	Message string
 }
 
@@ -20,3 +23,13 @@ func NewValidationError(message string) error {
 func NewValidationErrorf(format string, args ...any) error {
 	return ValidationError{Message: fmt.Sprintf(format, args...)}
 }
+
+// NewFieldValidationError creates a validation error for a single field.
+func NewFieldValidationError(field, message string) error {
+	return ValidationError{Field: field, Message: message}
+}
+
+// NewFieldValidationErrorf creates a formatted validation error for a single field.
+func NewFieldValidationErrorf(field, format string, args ...any) error {
+	return ValidationError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
@@ -3,13 +3,62 @@ package security
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 // Credential represents an API credential identity with scoped device access.
+// Roles grant permissions through a Policy; a credential without roles has
+// the default permissions. The metadata fields are optional and only set by
+// the JSON credentials file format.
 type Credential struct {
 	ID      string
 	Token   string
 	Devices []string
+	Roles   []string
+
+	Description string
+	Owner       string
+	// NotBefore and ExpiresAt bound the validity window; zero means
+	// unbounded.
+	NotBefore time.Time
+	ExpiresAt time.Time
+	Disabled  bool
+}
+
+// Credential states reported by Status.
+const (
+	CredentialActive      = "active"
+	CredentialDisabled    = "disabled"
+	CredentialNotYetValid = "not_yet_valid"
+	CredentialExpired     = "expired"
+)
+
+// Status reports whether the credential can authenticate at now.
+func (c Credential) Status(now time.Time) string {
+	switch {
+	case c.Disabled:
+		return CredentialDisabled
+	case !c.NotBefore.IsZero() && now.Before(c.NotBefore):
+		return CredentialNotYetValid
+	case !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt):
+		return CredentialExpired
+	}
+	return CredentialActive
+}
+
+// ExpiringCredentials returns the enabled credentials that have expired or
+// expire within window of now.
+func ExpiringCredentials(creds []Credential, now time.Time, window time.Duration) []Credential {
+	var out []Credential
+	for _, cred := range creds {
+		if cred.Disabled || cred.ExpiresAt.IsZero() {
+			continue
+		}
+		if cred.ExpiresAt.Before(now.Add(window)) {
+			out = append(out, cred)
+		}
+	}
+	return out
 }
 
 // Allows reports whether the credential can operate on the target device.
@@ -34,8 +83,25 @@ func (c Credential) Allows(deviceID string) bool {
 	return false
 }
 
+// AdminCredentialID is the principal ID of the dedicated admin credential.
+const AdminCredentialID = "admin"
+
+// NewAdminCredential returns the dedicated admin credential for token, which
+// may be a token hash. It has the admin role but no device scopes, so it can
+// manage credentials but not command clocks.
+func NewAdminCredential(token string) (Credential, error) {
+	if IsTokenHash(token) {
+		if _, err := parseTokenHash(token); err != nil {
+			return Credential{}, err
+		}
+	}
+	return Credential{ID: AdminCredentialID, Token: token, Roles: []string{RoleAdmin}}, nil
+}
+
 // ParseCredentials parses semicolon-separated credentials in the format:
-// id|token|scope1,scope2;id2|token2|*
+// id|token|scope1,scope2;id2|token2|*|role1,role2
+// The roles field is optional. The token may be a stored hash instead (see
+// IsTokenHash).
 func ParseCredentials(raw string) ([]Credential, error) {
 	raw = strings.TrimSpace(raw)
 	if raw == "" {
@@ -49,7 +115,7 @@ func ParseCredentials(raw string) ([]Credential, error) {
 			continue
 		}
 		parts := strings.Split(entry, "|")
-		if len(parts) != 3 {
+		if len(parts) != 3 && len(parts) != 4 {
 			return nil, fmt.Errorf("invalid credential entry %q", entry)
 		}
 		id := strings.TrimSpace(parts[0])
@@ -57,6 +123,11 @@ func ParseCredentials(raw string) ([]Credential, error) {
 		if id == "" || token == "" {
 			return nil, fmt.Errorf("credential id and token are required in %q", entry)
 		}
+		if IsTokenHash(token) {
+			if _, err := parseTokenHash(token); err != nil {
+				return nil, fmt.Errorf("credential %q: %w", id, err)
+			}
+		}
 		scopeParts := strings.Split(parts[2], ",")
 		devices := make([]string, 0, len(scopeParts))
 		for _, scope := range scopeParts {
@@ -68,7 +139,11 @@ func ParseCredentials(raw string) ([]Credential, error) {
 		if len(devices) == 0 {
 			return nil, fmt.Errorf("at least one scope is required in %q", entry)
 		}
-		out = append(out, Credential{ID: id, Token: token, Devices: devices})
+		cred := Credential{ID: id, Token: token, Devices: devices}
+		if len(parts) == 4 {
+			cred.Roles = parseRoleList(parts[3])
+		}
+		out = append(out, cred)
 	}
 	if len(out) == 0 {
 		return nil, nil
@@ -1,6 +1,9 @@
 package security
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestCredentialAllows(t *testing.T) {
 	cred := Credential{
@@ -55,3 +58,37 @@ func TestParseCredentialsRejectsInvalidEntries(t *testing.T) {
 		}
 	}
 }
+
+func TestCredentialStatus(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string]struct {
+		cred Credential
+		want string
+	}{
+		"unbounded":     {Credential{}, CredentialActive},
+		"within window": {Credential{NotBefore: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)}, CredentialActive},
+		"disabled":      {Credential{Disabled: true, ExpiresAt: now.Add(-time.Hour)}, CredentialDisabled},
+		"not yet valid": {Credential{NotBefore: now.Add(time.Minute)}, CredentialNotYetValid},
+		"expired":       {Credential{ExpiresAt: now}, CredentialExpired},
+	}
+	for name, tc := range cases {
+		if got := tc.cred.Status(now); got != tc.want {
+			t.Errorf("%s: expected %s, got %s", name, tc.want, got)
+		}
+	}
+}
+
+func TestExpiringCredentials(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	creds := []Credential{
+		{ID: "forever"},
+		{ID: "soon", ExpiresAt: now.Add(2 * 24 * time.Hour)},
+		{ID: "later", ExpiresAt: now.Add(30 * 24 * time.Hour)},
+		{ID: "expired", ExpiresAt: now.Add(-time.Hour)},
+		{ID: "disabled", ExpiresAt: now.Add(time.Hour), Disabled: true},
+	}
+	got := ExpiringCredentials(creds, now, 7*24*time.Hour)
+	if len(got) != 2 || got[0].ID != "soon" || got[1].ID != "expired" {
+		t.Fatalf("unexpected expiring credentials: %+v", got)
+	}
+}
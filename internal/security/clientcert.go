@@ -0,0 +1,121 @@
+package security
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// Client certificate identity selectors, as used in CLIENT_CERT_PRINCIPALS.
+const (
+	certSelectorURI   = "uri:"
+	certSelectorDNS   = "dns:"
+	certSelectorEmail = "email:"
+	certSelectorCN    = "cn:"
+)
+
+// CertPrincipal maps a verified client certificate identity onto a principal
+// with device scopes. Identity is a selector such as
+// "uri:spiffe://example.org/clock-agent", "dns:agent.example.org",
+// "email:ops@example.org" or "cn:clock-agent"; a trailing "*" matches any
+// suffix, as in device scopes.
+type CertPrincipal struct {
+	ID       string
+	Identity string
+	Devices  []string
+	Roles    []string
+}
+
+// ParseCertPrincipals parses semicolon-separated mappings in the format:
+// id|uri:spiffe://example.org/agent|scope1,scope2;id2|cn:agent-2|*|role1
+// The roles field is optional, as for credentials.
+func ParseCertPrincipals(raw string) ([]CertPrincipal, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	var out []CertPrincipal
+	for _, entry := range strings.Split(raw, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, "|")
+		if len(parts) != 3 && len(parts) != 4 {
+			return nil, fmt.Errorf("invalid client certificate principal %q", entry)
+		}
+		id := strings.TrimSpace(parts[0])
+		identity := strings.TrimSpace(parts[1])
+		if id == "" || identity == "" {
+			return nil, fmt.Errorf("principal id and certificate identity are required in %q", entry)
+		}
+		if !hasCertSelector(identity) {
+			return nil, fmt.Errorf("certificate identity %q must start with uri:, dns:, email: or cn:", identity)
+		}
+		var devices []string
+		for _, scope := range strings.Split(parts[2], ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				devices = append(devices, scope)
+			}
+		}
+		if len(devices) == 0 {
+			return nil, fmt.Errorf("at least one scope is required in %q", entry)
+		}
+		principal := CertPrincipal{ID: id, Identity: identity, Devices: devices}
+		if len(parts) == 4 {
+			principal.Roles = parseRoleList(parts[3])
+		}
+		out = append(out, principal)
+	}
+	return out, nil
+}
+
+func hasCertSelector(identity string) bool {
+	for _, prefix := range []string{certSelectorURI, certSelectorDNS, certSelectorEmail, certSelectorCN} {
+		if strings.HasPrefix(identity, prefix) && len(identity) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CertIdentities lists the identities of cert as selectors, most specific
+// first: URI SANs (SPIFFE IDs), DNS SANs, email SANs, then the subject
+// common name.
+func CertIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	for _, u := range cert.URIs {
+		ids = append(ids, certSelectorURI+u.String())
+	}
+	for _, name := range cert.DNSNames {
+		ids = append(ids, certSelectorDNS+name)
+	}
+	for _, addr := range cert.EmailAddresses {
+		ids = append(ids, certSelectorEmail+addr)
+	}
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, certSelectorCN+cert.Subject.CommonName)
+	}
+	return ids
+}
+
+// MatchCertPrincipal returns the credential of the first mapping that matches
+// one of the identities of cert, and the identity it matched.
+func MatchCertPrincipal(principals []CertPrincipal, cert *x509.Certificate) (Credential, string, bool) {
+	identities := CertIdentities(cert)
+	for _, p := range principals {
+		for _, identity := range identities {
+			if identityMatches(p.Identity, identity) {
+				return Credential{ID: p.ID, Devices: p.Devices, Roles: p.Roles}, identity, true
+			}
+		}
+	}
+	return Credential{}, "", false
+}
+
+func identityMatches(pattern, identity string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(identity, prefix)
+	}
+	return pattern == identity
+}
@@ -0,0 +1,65 @@
+package security
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseCertPrincipals(t *testing.T) {
+	got, err := ParseCertPrincipals(" agent|uri:spiffe://example.org/agent|lobby-*, office-1 ; ops|cn:ops|* ")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := []CertPrincipal{
+		{ID: "agent", Identity: "uri:spiffe://example.org/agent", Devices: []string{"lobby-*", "office-1"}},
+		{ID: "ops", Identity: "cn:ops", Devices: []string{"*"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected principals: %+v", got)
+	}
+
+	for _, raw := range []string{
+		"agent|uri:spiffe://example.org/agent",
+		"agent|spiffe://example.org/agent|*",
+		"agent|cn:|*",
+		"|cn:agent|*",
+		"agent|cn:agent| , ",
+	} {
+		if _, err := ParseCertPrincipals(raw); err == nil {
+			t.Errorf("%q: expected parse error", raw)
+		}
+	}
+}
+
+func TestMatchCertPrincipalPrefersConfigurationOrder(t *testing.T) {
+	spiffeID, _ := url.Parse("spiffe://example.org/ns/clocks/sa/agent")
+	cert := &x509.Certificate{
+		URIs:           []*url.URL{spiffeID},
+		DNSNames:       []string{"agent.example.org"},
+		EmailAddresses: []string{"ops@example.org"},
+		Subject:        pkix.Name{CommonName: "agent"},
+	}
+	wantIDs := []string{"uri:spiffe://example.org/ns/clocks/sa/agent", "dns:agent.example.org", "email:ops@example.org", "cn:agent"}
+	if ids := CertIdentities(cert); !reflect.DeepEqual(ids, wantIDs) {
+		t.Fatalf("unexpected identities: %v", ids)
+	}
+
+	principals := []CertPrincipal{
+		{ID: "by-cn", Identity: "cn:agent", Devices: []string{"lobby-1"}},
+		{ID: "by-spiffe", Identity: "uri:spiffe://example.org/ns/clocks/*", Devices: []string{"*"}},
+	}
+	cred, identity, ok := MatchCertPrincipal(principals, cert)
+	if !ok || cred.ID != "by-cn" || identity != "cn:agent" || !cred.Allows("lobby-1") {
+		t.Fatalf("unexpected match %+v %q %v", cred, identity, ok)
+	}
+	cred, identity, ok = MatchCertPrincipal(principals[1:], cert)
+	if !ok || cred.ID != "by-spiffe" || identity != wantIDs[0] {
+		t.Fatalf("unexpected prefix match %+v %q %v", cred, identity, ok)
+	}
+	if _, _, ok := MatchCertPrincipal(principals, &x509.Certificate{Subject: pkix.Name{CommonName: "agent-2"}}); ok {
+		t.Fatal("expected an exact selector not to match a longer name")
+	}
+}
@@ -0,0 +1,247 @@
+package security
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ParseCredentialsFile parses the content of a credentials file. A file
+// starting with "{" is a JSON document that can carry credential metadata:
+//
+//	{"credentials": [{"id": "ops", "token": "$argon2id$...", "devices": ["*"],
+//	  "roles": ["admin"], "description": "on-call", "owner": "ops@example.org",
+//	  "notBefore": "2026-01-01T00:00:00Z", "expiresAt": "2027-01-01T00:00:00Z",
+//	  "disabled": false}]}
+//
+// Otherwise it is the API_AUTH_CREDENTIALS format with one or more entries
+// per line; blank lines and lines starting with # are ignored.
+func ParseCredentialsFile(content []byte) ([]Credential, error) {
+	var creds []Credential
+	var err error
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '{' {
+		creds, err = parseCredentialsJSON(trimmed)
+	} else {
+		var entries []string
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			entries = append(entries, line)
+		}
+		creds, err = ParseCredentials(strings.Join(entries, ";"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(creds) == 0 {
+		return nil, errors.New("no credentials")
+	}
+	return creds, nil
+}
+
+// credentialFileEntry is a credential in the JSON credentials file.
+type credentialFileEntry struct {
+	ID          string     `json:"id"`
+	Token       string     `json:"token"`
+	Devices     []string   `json:"devices"`
+	Roles       []string   `json:"roles,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Owner       string     `json:"owner,omitempty"`
+	NotBefore   *time.Time `json:"notBefore,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	Disabled    bool       `json:"disabled,omitempty"`
+}
+
+func parseCredentialsJSON(content []byte) ([]Credential, error) {
+	var doc struct {
+		Credentials []credentialFileEntry `json:"credentials"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid credentials document: %w", err)
+	}
+	out := make([]Credential, 0, len(doc.Credentials))
+	seen := make(map[string]bool, len(doc.Credentials))
+	for i, entry := range doc.Credentials {
+		cred := Credential{
+			ID:          strings.TrimSpace(entry.ID),
+			Token:       strings.TrimSpace(entry.Token),
+			Description: entry.Description,
+			Owner:       entry.Owner,
+			Disabled:    entry.Disabled,
+		}
+		if cred.ID == "" || cred.Token == "" {
+			return nil, fmt.Errorf("credential %d: id and token are required", i)
+		}
+		if seen[cred.ID] {
+			return nil, fmt.Errorf("credential %q is defined twice", cred.ID)
+		}
+		seen[cred.ID] = true
+		if IsTokenHash(cred.Token) {
+			if _, err := parseTokenHash(cred.Token); err != nil {
+				return nil, fmt.Errorf("credential %q: %w", cred.ID, err)
+			}
+		}
+		for _, scope := range entry.Devices {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				cred.Devices = append(cred.Devices, scope)
+			}
+		}
+		if len(cred.Devices) == 0 {
+			return nil, fmt.Errorf("credential %q: at least one device scope is required", cred.ID)
+		}
+		for _, role := range entry.Roles {
+			if role = strings.TrimSpace(role); role != "" {
+				cred.Roles = append(cred.Roles, role)
+			}
+		}
+		if entry.NotBefore != nil {
+			cred.NotBefore = *entry.NotBefore
+		}
+		if entry.ExpiresAt != nil {
+			cred.ExpiresAt = *entry.ExpiresAt
+		}
+		if !cred.NotBefore.IsZero() && !cred.ExpiresAt.IsZero() && !cred.NotBefore.Before(cred.ExpiresAt) {
+			return nil, fmt.Errorf("credential %q: notBefore must be before expiresAt", cred.ID)
+		}
+		out = append(out, cred)
+	}
+	return out, nil
+}
+
+// marshalCredentialsJSON encodes creds in the JSON credentials file format.
+func marshalCredentialsJSON(creds []Credential) ([]byte, error) {
+	doc := struct {
+		Credentials []credentialFileEntry `json:"credentials"`
+	}{Credentials: make([]credentialFileEntry, 0, len(creds))}
+	for _, cred := range creds {
+		entry := credentialFileEntry{
+			ID:          cred.ID,
+			Token:       cred.Token,
+			Devices:     cred.Devices,
+			Roles:       cred.Roles,
+			Description: cred.Description,
+			Owner:       cred.Owner,
+			Disabled:    cred.Disabled,
+		}
+		if !cred.NotBefore.IsZero() {
+			entry.NotBefore = &cred.NotBefore
+		}
+		if !cred.ExpiresAt.IsZero() {
+			entry.ExpiresAt = &cred.ExpiresAt
+		}
+		doc.Credentials = append(doc.Credentials, entry)
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// CredentialFileWatcher reloads credentials from a file, such as a mounted
+// Kubernetes secret, when its content changes or on demand. A file that
+// cannot be read, parsed or applied leaves the current credentials in place.
+// Every reload attempt is passed to the reporter set with WithReporter.
+type CredentialFileWatcher struct {
+	path     string
+	interval time.Duration
+	apply    func([]Credential) error
+	report   func(CredentialReload)
+
+	digest  string
+	lastErr string
+}
+
+// NewCredentialFileWatcher watches path every interval. apply receives each
+// new credential set and may reject it; digest identifies the content the
+// current credentials were loaded from, so an unchanged file is not reloaded.
+func NewCredentialFileWatcher(path string, interval time.Duration, digest string, apply func([]Credential) error) *CredentialFileWatcher {
+	return &CredentialFileWatcher{path: path, interval: interval, apply: apply, digest: digest}
+}
+
+// CredentialReload is the outcome of one reload attempt: Result is applied,
+// rejected or failed, and Err the reason for the latter two.
+type CredentialReload struct {
+	Source      string
+	Trigger     string
+	Result      string
+	Digest      string
+	Credentials int
+	Err         error
+}
+
+// WithReporter passes the outcome of every reload attempt to report, which
+// typically audits it.
+func (w *CredentialFileWatcher) WithReporter(report func(CredentialReload)) *CredentialFileWatcher {
+	w.report = report
+	return w
+}
+
+// CredentialsDigest identifies the content of a credentials file in the
+// audit log without revealing it.
+func CredentialsDigest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Run polls the file until ctx is done. A value on reload forces a reload
+// even when the content is unchanged, as for SIGHUP.
+func (w *CredentialFileWatcher) Run(ctx context.Context, reload <-chan os.Signal) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.Reload("poll", false)
+		case <-reload:
+			w.Reload("signal", true)
+		}
+	}
+}
+
+// Reload reads the file and applies its credentials if the content changed
+// since the last attempt, or unconditionally with force. It reports whether
+// a new credential set was applied.
+func (w *CredentialFileWatcher) Reload(trigger string, force bool) (bool, error) {
+	content, err := os.ReadFile(w.path)
+	if err != nil {
+		// A missing file during a secret update is retried on the next poll;
+		// report each distinct failure once.
+		if msg := err.Error(); force || msg != w.lastErr {
+			w.lastErr = msg
+			w.audit(trigger, "failed", "-", 0, err)
+		}
+		return false, err
+	}
+	w.lastErr = ""
+	digest := CredentialsDigest(content)
+	if digest == w.digest && !force {
+		return false, nil
+	}
+	w.digest = digest
+	creds, err := ParseCredentialsFile(content)
+	if err == nil {
+		err = w.apply(creds)
+	}
+	if err != nil {
+		w.audit(trigger, "rejected", digest, 0, err)
+		return false, err
+	}
+	w.audit(trigger, "applied", digest, len(creds), nil)
+	return true, nil
+}
+
+func (w *CredentialFileWatcher) audit(trigger, result, digest string, count int, err error) {
+	if w.report != nil {
+		w.report(CredentialReload{Source: w.path, Trigger: trigger, Result: result, Digest: digest, Credentials: count, Err: err})
+	}
+}
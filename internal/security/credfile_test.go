@@ -0,0 +1,156 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseCredentialsFile(t *testing.T) {
+	creds, err := ParseCredentialsFile([]byte("# rotated 2026-10-01\nops|t1|*\n\n  lobby|t2|lobby-*|messenger ; viewer|t3|clock-1\n"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(creds) != 3 || creds[0].ID != "ops" || creds[1].Roles[0] != "messenger" || creds[2].ID != "viewer" {
+		t.Fatalf("unexpected credentials: %+v", creds)
+	}
+	for _, content := range []string{"", "# only a comment\n", "ops|t1\n"} {
+		if _, err := ParseCredentialsFile([]byte(content)); err == nil {
+			t.Errorf("%q: expected parse error", content)
+		}
+	}
+}
+
+func TestParseCredentialsFileJSON(t *testing.T) {
+	creds, err := ParseCredentialsFile([]byte(`{"credentials": [
+		{"id": "ops", "token": "t1", "devices": ["*"], "roles": ["admin"], "description": "on-call", "owner": "ops@example.org",
+		 "notBefore": "2026-01-01T00:00:00Z", "expiresAt": "2027-01-01T00:00:00Z"},
+		{"id": "kiosk", "token": "t2", "devices": ["lobby-*"], "disabled": true}
+	]}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	ops := creds[0]
+	if len(creds) != 2 || ops.Owner != "ops@example.org" || ops.Description != "on-call" || ops.Roles[0] != "admin" ||
+		ops.ExpiresAt.Year() != 2027 || ops.NotBefore.Year() != 2026 || !creds[1].Disabled {
+		t.Fatalf("unexpected credentials: %+v", creds)
+	}
+
+	for name, content := range map[string]string{
+		"empty":           `{"credentials": []}`,
+		"unknown field":   `{"credentials": [{"id": "a", "token": "t", "devices": ["*"], "expires": "2027-01-01T00:00:00Z"}]}`,
+		"no devices":      `{"credentials": [{"id": "a", "token": "t"}]}`,
+		"no token":        `{"credentials": [{"id": "a", "devices": ["*"]}]}`,
+		"duplicate id":    `{"credentials": [{"id": "a", "token": "t", "devices": ["*"]}, {"id": "a", "token": "u", "devices": ["*"]}]}`,
+		"inverted window": `{"credentials": [{"id": "a", "token": "t", "devices": ["*"], "notBefore": "2027-01-01T00:00:00Z", "expiresAt": "2026-01-01T00:00:00Z"}]}`,
+		"bad time":        `{"credentials": [{"id": "a", "token": "t", "devices": ["*"], "expiresAt": "next year"}]}`,
+	} {
+		if _, err := ParseCredentialsFile([]byte(content)); err == nil {
+			t.Errorf("%s: expected parse error", name)
+		}
+	}
+}
+
+func TestCredentialFileWatcherKeepsCurrentSetOnFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write credentials: %v", err)
+		}
+	}
+	write("ops|t1|*\n")
+
+	var applied [][]Credential
+	rejectNext := false
+	w := NewCredentialFileWatcher(path, time.Hour, CredentialsDigest([]byte("ops|t1|*\n")), func(creds []Credential) error {
+		if rejectNext {
+			return errors.New("undefined role")
+		}
+		applied = append(applied, creds)
+		return nil
+	})
+	var results []string
+	w.WithReporter(func(reload CredentialReload) {
+		if reload.Source != path || (reload.Result == "applied") != (reload.Err == nil) {
+			t.Errorf("unexpected reload report %+v", reload)
+		}
+		results = append(results, reload.Trigger+":"+reload.Result)
+	})
+
+	if changed, err := w.Reload("poll", false); changed || err != nil {
+		t.Fatalf("expected the loaded content not to be reloaded, got %v %v", changed, err)
+	}
+	write("ops|t2|*\n")
+	if changed, err := w.Reload("poll", false); !changed || err != nil || applied[0][0].Token != "t2" {
+		t.Fatalf("expected the rotated token to be applied, got %v %v %+v", changed, err, applied)
+	}
+
+	write("ops|t3\n")
+	if _, err := w.Reload("poll", false); err == nil {
+		t.Fatal("expected a malformed file to be reported")
+	}
+	if changed, err := w.Reload("poll", false); changed || err != nil {
+		t.Fatalf("expected an unchanged malformed file not to be retried, got %v %v", changed, err)
+	}
+	write("ops|t4|*|operator\n")
+	rejectNext = true
+	if _, err := w.Reload("poll", false); err == nil {
+		t.Fatal("expected a rejected credential set to be reported")
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Reload("poll", false); err == nil {
+		t.Fatal("expected a missing file to be reported")
+	}
+	if len(applied) != 1 {
+		t.Fatalf("expected failed reloads to apply nothing, got %d sets", len(applied))
+	}
+
+	write("ops|t4|*|operator\n")
+	rejectNext = false
+	if changed, err := w.Reload("signal", true); !changed || err != nil || len(applied) != 2 {
+		t.Fatalf("expected a forced reload to apply the file, got %v %v", changed, err)
+	}
+	want := "poll:applied poll:rejected poll:rejected poll:failed signal:applied"
+	if got := strings.Join(results, " "); got != want {
+		t.Fatalf("expected reports %q, got %q", want, got)
+	}
+}
+
+func TestCredentialFileWatcherReloadsOnSignal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	if err := os.WriteFile(path, []byte("ops|t1|*\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	applied := make(chan []Credential, 1)
+	w := NewCredentialFileWatcher(path, time.Hour, CredentialsDigest([]byte("ops|t1|*\n")), func(creds []Credential) error {
+		applied <- creds
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	reload := make(chan os.Signal, 1)
+	go func() {
+		w.Run(ctx, reload)
+		close(done)
+	}()
+
+	reload <- syscall.SIGHUP
+	select {
+	case creds := <-applied:
+		if creds[0].Token != "t1" {
+			t.Fatalf("unexpected credentials %+v", creds)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the signal to reload the file")
+	}
+	cancel()
+	<-done
+}
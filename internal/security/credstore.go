@@ -0,0 +1,185 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Errors returned by CredentialStore.
+var (
+	ErrCredentialExists   = errors.New("credential already exists")
+	ErrCredentialNotFound = errors.New("credential not found")
+	ErrInvalidCredential  = errors.New("invalid credential")
+)
+
+// credentialIDPattern restricts issued credential IDs to characters that are
+// safe in log lines and in the API_AUTH_CREDENTIALS format.
+var credentialIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// CredentialStore keeps credentials issued at runtime, persisted as a JSON
+// credentials file. Tokens are generated by the store and only their hashes
+// are kept: HMAC-SHA256 under the pepper when one is set, Argon2id otherwise.
+type CredentialStore struct {
+	path   string
+	pepper []byte
+
+	mu    sync.Mutex
+	creds []Credential
+	index atomic.Pointer[CredentialIndex]
+}
+
+// OpenCredentialStore loads the store at path. A missing file is an empty
+// store; it is created on the first change.
+func OpenCredentialStore(path string, pepper []byte) (*CredentialStore, error) {
+	s := &CredentialStore{path: path, pepper: pepper}
+	content, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("read credential store: %w", err)
+	default:
+		if s.creds, err = parseCredentialsJSON(content); err != nil {
+			return nil, fmt.Errorf("parse credential store: %w", err)
+		}
+	}
+	s.index.Store(NewCredentialIndex(s.creds, pepper))
+	return s, nil
+}
+
+// Credentials returns the stored credentials in creation order.
+func (s *CredentialStore) Credentials() []Credential {
+	return s.index.Load().Credentials()
+}
+
+// Lookup returns the stored credential token authenticates, if any.
+func (s *CredentialStore) Lookup(token string) (Credential, bool) {
+	return s.index.Load().Lookup(token)
+}
+
+// LookupGated is Lookup with CredentialIndex.LookupGated's admit hook.
+func (s *CredentialStore) LookupGated(token string, admit func() bool) (Credential, bool) {
+	return s.index.Load().LookupGated(token, admit)
+}
+
+// validateIssuedCredential checks the fields of a credential to be issued
+// or updated through the store.
+func validateIssuedCredential(cred Credential) error {
+	if !credentialIDPattern.MatchString(cred.ID) {
+		return fmt.Errorf("%w: id %q must be 1-64 letters, digits, '.', '_' or '-'", ErrInvalidCredential, cred.ID)
+	}
+	if len(cred.Devices) == 0 || slices.Contains(cred.Devices, "") {
+		return fmt.Errorf("%w: at least one device scope is required", ErrInvalidCredential)
+	}
+	if !cred.NotBefore.IsZero() && !cred.ExpiresAt.IsZero() && !cred.NotBefore.Before(cred.ExpiresAt) {
+		return fmt.Errorf("%w: notBefore must be before expiresAt", ErrInvalidCredential)
+	}
+	return nil
+}
+
+// Create issues cred with a generated token and returns the stored
+// credential and the token. The token is not kept and cannot be recovered.
+func (s *CredentialStore) Create(cred Credential) (Credential, string, error) {
+	if err := validateIssuedCredential(cred); err != nil {
+		return Credential{}, "", err
+	}
+	token, err := GenerateToken()
+	if err != nil {
+		return Credential{}, "", err
+	}
+	if len(s.pepper) > 0 {
+		cred.Token, err = HashTokenSHA256(token, s.pepper)
+	} else {
+		cred.Token, err = HashTokenArgon2id(token)
+	}
+	if err != nil {
+		return Credential{}, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.find(cred.ID) >= 0 {
+		return Credential{}, "", fmt.Errorf("%w: %s", ErrCredentialExists, cred.ID)
+	}
+	if err := s.save(append(slices.Clone(s.creds), cred)); err != nil {
+		return Credential{}, "", err
+	}
+	return cred, token, nil
+}
+
+// Update applies change to the credential with id and persists the result.
+// The ID and token cannot be changed.
+func (s *CredentialStore) Update(id string, change func(*Credential)) (Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(id)
+	if i < 0 {
+		return Credential{}, fmt.Errorf("%w: %s", ErrCredentialNotFound, id)
+	}
+	cred := s.creds[i]
+	change(&cred)
+	cred.ID, cred.Token = s.creds[i].ID, s.creds[i].Token
+	if err := validateIssuedCredential(cred); err != nil {
+		return Credential{}, err
+	}
+	creds := slices.Clone(s.creds)
+	creds[i] = cred
+	if err := s.save(creds); err != nil {
+		return Credential{}, err
+	}
+	return cred, nil
+}
+
+// Revoke removes the credential with id, so its token stops working.
+func (s *CredentialStore) Revoke(id string) (Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(id)
+	if i < 0 {
+		return Credential{}, fmt.Errorf("%w: %s", ErrCredentialNotFound, id)
+	}
+	cred := s.creds[i]
+	if err := s.save(slices.Delete(slices.Clone(s.creds), i, i+1)); err != nil {
+		return Credential{}, err
+	}
+	return cred, nil
+}
+
+func (s *CredentialStore) find(id string) int {
+	return slices.IndexFunc(s.creds, func(c Credential) bool { return c.ID == id })
+}
+
+// save writes creds to a temporary file and renames it over the store, so a
+// crash never leaves a partially written store, then makes them current.
+// The caller holds s.mu.
+func (s *CredentialStore) save(creds []Credential) error {
+	content, err := marshalCredentialsJSON(creds)
+	if err != nil {
+		return fmt.Errorf("encode credential store: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("write credential store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(content); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		return fmt.Errorf("write credential store: %w", err)
+	}
+	s.creds = creds
+	s.index.Store(NewCredentialIndex(creds, s.pepper))
+	return nil
+}
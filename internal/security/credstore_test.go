@@ -0,0 +1,91 @@
+package security
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCredentialStoreLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	pepper := []byte("pepper")
+	store, err := OpenCredentialStore(path, pepper)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if len(store.Credentials()) != 0 {
+		t.Fatal("expected a missing file to open as an empty store")
+	}
+
+	expires := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	created, token, err := store.Create(Credential{ID: "kiosk", Devices: []string{"lobby-*"}, Owner: "facilities", ExpiresAt: expires})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if !strings.HasPrefix(created.Token, hmacSHA256Prefix) || created.Token == token {
+		t.Fatalf("expected an HMAC-SHA256 hash to be stored, got %q", created.Token)
+	}
+	if cred, ok := store.Lookup(token); !ok || cred.ID != "kiosk" {
+		t.Fatalf("expected the issued token to authenticate, got %+v %v", cred, ok)
+	}
+	content, _ := os.ReadFile(path)
+	if strings.Contains(string(content), token) {
+		t.Fatal("store file contains the plaintext token")
+	}
+	if _, _, err := store.Create(Credential{ID: "kiosk", Devices: []string{"*"}}); !errors.Is(err, ErrCredentialExists) {
+		t.Fatalf("expected a duplicate ID to be rejected, got %v", err)
+	}
+
+	updated, err := store.Update("kiosk", func(c *Credential) {
+		c.Devices = []string{"lobby-1"}
+		c.Token = "ignored"
+	})
+	if err != nil || updated.Devices[0] != "lobby-1" || updated.Token != created.Token {
+		t.Fatalf("unexpected update result %+v %v", updated, err)
+	}
+	if _, err := store.Update("kiosk", func(c *Credential) { c.Devices = nil }); !errors.Is(err, ErrInvalidCredential) {
+		t.Fatalf("expected an update without scopes to be rejected, got %v", err)
+	}
+
+	reopened, err := OpenCredentialStore(path, pepper)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if cred, ok := reopened.Lookup(token); !ok || cred.Devices[0] != "lobby-1" || !cred.ExpiresAt.Equal(expires) || cred.Owner != "facilities" {
+		t.Fatalf("expected the store to persist across restarts, got %+v %v", cred, ok)
+	}
+
+	if _, err := reopened.Revoke("kiosk"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, ok := reopened.Lookup(token); ok {
+		t.Fatal("expected a revoked token to stop authenticating")
+	}
+	if _, err := reopened.Revoke("kiosk"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("expected revoking twice to report not found, got %v", err)
+	}
+	if empty, err := OpenCredentialStore(path, pepper); err != nil || len(empty.Credentials()) != 0 {
+		t.Fatalf("expected an emptied store to reopen, got %v", err)
+	}
+}
+
+func TestCredentialStoreValidatesIssuedCredentials(t *testing.T) {
+	store, err := OpenCredentialStore(filepath.Join(t.TempDir(), "credentials.json"), nil)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	now := time.Now()
+	for name, cred := range map[string]Credential{
+		"empty id":        {Devices: []string{"*"}},
+		"unsafe id":       {ID: "ops|x", Devices: []string{"*"}},
+		"no devices":      {ID: "ops"},
+		"inverted window": {ID: "ops", Devices: []string{"*"}, NotBefore: now, ExpiresAt: now.Add(-time.Hour)},
+	} {
+		if _, _, err := store.Create(cred); !errors.Is(err, ErrInvalidCredential) {
+			t.Errorf("%s: expected ErrInvalidCredential, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSCacheTTL   = 5 * time.Minute
+	defaultDevicesClaim   = "clock_devices"
+	defaultRolesClaim     = "clock_roles"
+	jwksFetchTimeout      = 5 * time.Second
+	jwksMinRefetch        = 30 * time.Second
+	maxJWKSBytes          = 1 << 20
+	minRSAModulusBits     = 2048
+	jwtAlgRS256           = "RS256"
+	jwtAlgES256           = "ES256"
+	jwtAlgEdDSA           = "EdDSA"
+	jwtSegmentSeparator   = "."
+	jwtHeaderPrefixBase64 = "eyJ" // base64url of `{"`
+)
+
+var errInvalidJWT = errors.New("invalid jwt")
+
+// JWTConfig configures bearer tokens issued by an identity provider. The
+// signing keys come from a JWKS document in JWKSFile or at JWKSURL.
+type JWTConfig struct {
+	Issuer   string
+	Audience string
+	JWKSFile string
+	JWKSURL  string
+	// CacheTTL is how long keys fetched from JWKSURL are used before they
+	// are fetched again.
+	CacheTTL time.Duration
+	// ClockSkew is the leeway applied to exp and nbf.
+	ClockSkew time.Duration
+	// DevicesClaim names the claim holding the device scopes, as an array
+	// of scopes or a space-separated string.
+	DevicesClaim string
+	// RolesClaim names the claim holding the role names, in the same
+	// forms. Without it the principal has the default permissions.
+	RolesClaim string
+	// AllowInsecureURL permits an http:// JWKSURL.
+	AllowInsecureURL bool
+	HTTPClient       *http.Client
+}
+
+// Enabled reports whether a key source is configured.
+func (c JWTConfig) Enabled() bool {
+	return c.JWKSFile != "" || c.JWKSURL != ""
+}
+
+// JWTVerifier verifies RS256, ES256 and EdDSA signed JWTs and maps their
+// claims onto a Credential.
+type JWTVerifier struct {
+	cfg JWTConfig
+	now func() time.Time
+
+	mu          sync.Mutex
+	keys        []jwk
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	// refreshing is the JWKS fetch in flight, shared by every caller that
+	// needs it.
+	refreshing *jwksRefresh
+}
+
+type jwksRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+type jwk struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// NewJWTVerifier validates cfg and loads the keys of a JWKS file. Keys at a
+// URL are fetched on first use.
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	if (cfg.JWKSFile == "") == (cfg.JWKSURL == "") {
+		return nil, errors.New("exactly one of the JWKS file and JWKS URL is required")
+	}
+	if strings.TrimSpace(cfg.Issuer) == "" || strings.TrimSpace(cfg.Audience) == "" {
+		return nil, errors.New("jwt issuer and audience are required")
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = defaultJWKSCacheTTL
+	}
+	if cfg.ClockSkew < 0 {
+		cfg.ClockSkew = 0
+	}
+	if cfg.DevicesClaim == "" {
+		cfg.DevicesClaim = defaultDevicesClaim
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = defaultRolesClaim
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: jwksFetchTimeout}
+	}
+	v := &JWTVerifier{cfg: cfg, now: time.Now}
+
+	if cfg.JWKSURL != "" {
+		u, err := url.Parse(cfg.JWKSURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid JWKS URL %q", cfg.JWKSURL)
+		}
+		switch {
+		case u.Scheme == "https":
+		case u.Scheme == "http" && cfg.AllowInsecureURL:
+		case u.Scheme == "http":
+			return nil, errors.New("JWKS URL must use https (set ALLOW_INSECURE_JWKS=true to override)")
+		default:
+			return nil, fmt.Errorf("unsupported JWKS URL scheme %q", u.Scheme)
+		}
+		return v, nil
+	}
+
+	raw, err := os.ReadFile(cfg.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("read JWKS file: %w", err)
+	}
+	keys, err := parseJWKS(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse JWKS file: %w", err)
+	}
+	v.keys = keys
+	return v, nil
+}
+
+// LooksLikeJWT reports whether a bearer token has the shape of a compact JWS,
+// so opaque static tokens skip JWT verification.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, jwtSegmentSeparator) == 2 && strings.HasPrefix(token, jwtHeaderPrefixBase64)
+}
+
+// Verify checks the signature, issuer, audience and validity period of token
+// and returns the credential of its subject, scoped to the devices claim.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (Credential, error) {
+	parts := strings.Split(token, jwtSegmentSeparator)
+	if len(parts) != 3 {
+		return Credential{}, fmt.Errorf("%w: expected three segments", errInvalidJWT)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return Credential{}, fmt.Errorf("%w: header: %v", errInvalidJWT, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: signature encoding", errInvalidJWT)
+	}
+	keys, err := v.candidateKeys(ctx, header.Kid)
+	if err != nil {
+		return Credential{}, err
+	}
+	signed := []byte(parts[0] + jwtSegmentSeparator + parts[1])
+	verified := false
+	for _, key := range keys {
+		if verifyJWS(header.Alg, key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return Credential{}, fmt.Errorf("%w: no key verifies the %q signature", errInvalidJWT, header.Alg)
+	}
+
+	var claims map[string]json.RawMessage
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return Credential{}, fmt.Errorf("%w: claims: %v", errInvalidJWT, err)
+	}
+	return v.credentialFromClaims(claims)
+}
+
+func (v *JWTVerifier) credentialFromClaims(claims map[string]json.RawMessage) (Credential, error) {
+	var iss, sub string
+	if json.Unmarshal(claims["iss"], &iss) != nil || iss != v.cfg.Issuer {
+		return Credential{}, fmt.Errorf("%w: unexpected issuer", errInvalidJWT)
+	}
+	if !audienceMatches(claims["aud"], v.cfg.Audience) {
+		return Credential{}, fmt.Errorf("%w: unexpected audience", errInvalidJWT)
+	}
+	now := v.now()
+	var exp float64
+	if json.Unmarshal(claims["exp"], &exp) != nil {
+		return Credential{}, fmt.Errorf("%w: exp is required", errInvalidJWT)
+	}
+	if !now.Before(time.Unix(int64(exp), 0).Add(v.cfg.ClockSkew)) {
+		return Credential{}, fmt.Errorf("%w: expired", errInvalidJWT)
+	}
+	if raw, ok := claims["nbf"]; ok {
+		var nbf float64
+		if json.Unmarshal(raw, &nbf) != nil || now.Add(v.cfg.ClockSkew).Before(time.Unix(int64(nbf), 0)) {
+			return Credential{}, fmt.Errorf("%w: not yet valid", errInvalidJWT)
+		}
+	}
+	if json.Unmarshal(claims["sub"], &sub) != nil || strings.TrimSpace(sub) == "" {
+		return Credential{}, fmt.Errorf("%w: sub is required", errInvalidJWT)
+	}
+	devices, err := stringsClaim(claims[v.cfg.DevicesClaim])
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: %s: %v", errInvalidJWT, v.cfg.DevicesClaim, err)
+	}
+	roles, err := stringsClaim(claims[v.cfg.RolesClaim])
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: %s: %v", errInvalidJWT, v.cfg.RolesClaim, err)
+	}
+	return Credential{ID: sub, Devices: devices, Roles: roles}, nil
+}
+
+// audienceMatches accepts aud as a single string or an array of strings.
+func audienceMatches(raw json.RawMessage, audience string) bool {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return single == audience
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) != nil {
+		return false
+	}
+	for _, aud := range list {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// stringsClaim decodes a claim that is an array of strings or a
+// space-separated string. A missing claim is empty.
+func stringsClaim(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list, nil
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err != nil {
+		return nil, errors.New("must be a string or an array of strings")
+	}
+	return strings.Fields(single), nil
+}
+
+func decodeJWTSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func verifyJWS(alg string, key jwk, signed, signature []byte) bool {
+	if key.alg != "" && key.alg != alg {
+		return false
+	}
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case jwtAlgRS256:
+		pub, ok := key.key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case jwtAlgES256:
+		pub, ok := key.key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+	case jwtAlgEdDSA:
+		pub, ok := key.key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pub, signed, signature)
+	default:
+		return false
+	}
+}
+
+// candidateKeys returns the keys matching kid (all keys when kid is empty).
+// Keys from a URL are refreshed when the cache has expired, or when kid is
+// unknown, as after a key rotation, at most every jwksMinRefetch.
+func (v *JWTVerifier) candidateKeys(ctx context.Context, kid string) ([]jwk, error) {
+	if v.cfg.JWKSURL != "" {
+		if err := v.awaitRefresh(ctx, kid); err != nil {
+			return nil, err
+		}
+	}
+	v.mu.Lock()
+	keys := matchingKeys(v.keys, kid)
+	v.mu.Unlock()
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: unknown key %q", errInvalidJWT, kid)
+	}
+	return keys, nil
+}
+
+// awaitRefresh starts a JWKS fetch when the keys for kid need one, or joins
+// the fetch in flight, and waits for it while ctx allows. The fetch runs
+// without holding v.mu and under its own timeout, so a caller that gives up
+// neither blocks other verifications nor cancels the fetch for the rest. On
+// failure the previous keys stay in use; the error is only returned while
+// there are none.
+func (v *JWTVerifier) awaitRefresh(ctx context.Context, kid string) error {
+	v.mu.Lock()
+	now := v.now()
+	stale := v.fetchedAt.IsZero() || now.Sub(v.fetchedAt) >= v.cfg.CacheTTL
+	unknown := kid != "" && len(matchingKeys(v.keys, kid)) == 0
+	if !stale && !unknown {
+		v.mu.Unlock()
+		return nil
+	}
+	r := v.refreshing
+	if r == nil && now.Sub(v.lastAttempt) >= jwksMinRefetch {
+		r = &jwksRefresh{done: make(chan struct{})}
+		v.refreshing = r
+		go v.refresh(r)
+	}
+	v.mu.Unlock()
+	if r == nil {
+		return nil
+	}
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return v.refreshError(fmt.Errorf("fetch JWKS: %w", ctx.Err()))
+	}
+	return v.refreshError(r.err)
+}
+
+// refreshError returns err when no keys are available to fall back to.
+func (v *JWTVerifier) refreshError(err error) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err != nil && len(v.keys) == 0 {
+		return err
+	}
+	return nil
+}
+
+// refresh fetches the keys for r. The next attempt is allowed
+// jwksMinRefetch after this one completes.
+func (v *JWTVerifier) refresh(r *jwksRefresh) {
+	keys, err := v.fetch(context.Background())
+
+	v.mu.Lock()
+	now := v.now()
+	v.lastAttempt = now
+	if err == nil {
+		v.keys = keys
+		v.fetchedAt = now
+	}
+	v.refreshing = nil
+	v.mu.Unlock()
+
+	r.err = err
+	close(r.done)
+}
+
+func matchingKeys(keys []jwk, kid string) []jwk {
+	if kid == "" {
+		return keys
+	}
+	var out []jwk
+	for _, key := range keys {
+		if key.kid == kid {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+func (v *JWTVerifier) fetch(ctx context.Context) ([]jwk, error) {
+	ctx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.cfg.JWKSURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := v.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch JWKS: status %d", resp.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSBytes))
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	return parseJWKS(raw)
+}
+
+// parseJWKS reads the signature keys of a JWKS document. Keys of other
+// types, curves or uses are skipped; a document without usable keys is an
+// error.
+func parseJWKS(raw []byte) ([]jwk, error) {
+	var doc struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	var keys []jwk
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var pub crypto.PublicKey
+		var err error
+		switch {
+		case k.Kty == "RSA":
+			pub, err = rsaJWK(k.N, k.E)
+		case k.Kty == "EC" && k.Crv == "P-256":
+			pub, err = p256JWK(k.X, k.Y)
+		case k.Kty == "OKP" && k.Crv == "Ed25519":
+			var x []byte
+			x, err = base64.RawURLEncoding.DecodeString(k.X)
+			if err == nil && len(x) != ed25519.PublicKeySize {
+				err = errors.New("invalid Ed25519 key size")
+			}
+			pub = ed25519.PublicKey(x)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys = append(keys, jwk{kid: k.Kid, alg: k.Alg, key: pub})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no RSA, P-256 or Ed25519 signature keys")
+	}
+	return keys, nil
+}
+
+func rsaJWK(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, errors.New("invalid RSA modulus")
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil || len(eb) == 0 || len(eb) > 4 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(new(big.Int).SetBytes(eb).Int64())}
+	if pub.N.BitLen() < minRSAModulusBits {
+		return nil, fmt.Errorf("RSA keys must be at least %d bits", minRSAModulusBits)
+	}
+	return pub, nil
+}
+
+func p256JWK(x, y string) (*ecdsa.PublicKey, error) {
+	xb, errX := base64.RawURLEncoding.DecodeString(x)
+	yb, errY := base64.RawURLEncoding.DecodeString(y)
+	if errX != nil || errY != nil || len(xb) != 32 || len(yb) != 32 {
+		return nil, errors.New("invalid P-256 coordinates")
+	}
+	// crypto/ecdh rejects points that are not on the curve.
+	point := append([]byte{4}, append(xb, yb...)...)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, errors.New("P-256 point is not on the curve")
+	}
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(xb),
+		Y:     new(big.Int).SetBytes(yb),
+	}, nil
+}
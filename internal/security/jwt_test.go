@@ -0,0 +1,320 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testSigner signs JWTs with a locally generated key and describes the key
+// as a JWK.
+type testSigner struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+func newTestSigners(t *testing.T) []testSigner {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ec key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key: %v", err)
+	}
+	return []testSigner{
+		{kid: "rsa-1", alg: jwtAlgRS256, key: rsaKey},
+		{kid: "ec-1", alg: jwtAlgES256, key: ecKey},
+		{kid: "ed-1", alg: jwtAlgEdDSA, key: edKey},
+	}
+}
+
+func (s testSigner) jwk() map[string]string {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch pub := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": s.kid, "use": "sig", "n": enc(pub.N.Bytes()), "e": enc(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": s.kid, "crv": "P-256", "x": enc(pub.X.FillBytes(make([]byte, 32))), "y": enc(pub.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": s.kid, "alg": jwtAlgEdDSA, "crv": "Ed25519", "x": enc(pub)}
+	}
+	return nil
+}
+
+func (s testSigner) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	enc := base64.RawURLEncoding.EncodeToString
+	header, _ := json.Marshal(map[string]string{"alg": s.alg, "kid": s.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := enc(header) + "." + enc(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	var err error
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(key, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed + "." + enc(sig)
+}
+
+func jwksDocument(signers ...testSigner) []byte {
+	keys := make([]map[string]string, 0, len(signers))
+	for _, s := range signers {
+		keys = append(keys, s.jwk())
+	}
+	raw, _ := json.Marshal(map[string]any{"keys": keys})
+	return raw
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss":           "https://idp.example",
+		"aud":           []string{"other", "clock-server"},
+		"sub":           "alice",
+		"exp":           time.Now().Add(time.Hour).Unix(),
+		"clock_devices": []string{"lobby-*"},
+	}
+}
+
+func newFileVerifier(t *testing.T, signers ...testSigner) *JWTVerifier {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwksDocument(signers...), 0o600); err != nil {
+		t.Fatalf("write jwks: %v", err)
+	}
+	v, err := NewJWTVerifier(JWTConfig{Issuer: "https://idp.example", Audience: "clock-server", JWKSFile: path})
+	if err != nil {
+		t.Fatalf("new verifier: %v", err)
+	}
+	return v
+}
+
+func TestJWTVerifierAcceptsSupportedAlgorithms(t *testing.T) {
+	signers := newTestSigners(t)
+	v := newFileVerifier(t, signers...)
+	for _, s := range signers {
+		token := s.sign(t, validClaims())
+		if !LooksLikeJWT(token) {
+			t.Fatalf("%s: expected token to look like a JWT", s.alg)
+		}
+		cred, err := v.Verify(context.Background(), token)
+		if err != nil {
+			t.Fatalf("%s: verify: %v", s.alg, err)
+		}
+		if cred.ID != "alice" || !cred.Allows("lobby-1") || cred.Allows("office-1") {
+			t.Fatalf("%s: unexpected credential %+v", s.alg, cred)
+		}
+	}
+}
+
+func TestJWTVerifierRejectsInvalidTokens(t *testing.T) {
+	signers := newTestSigners(t)
+	v := newFileVerifier(t, signers...)
+	signer := signers[1]
+	_, foreignKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	with := func(key string, value any) map[string]any {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+	cases := map[string]string{
+		"wrong issuer":    signer.sign(t, with("iss", "https://evil.example")),
+		"wrong audience":  signer.sign(t, with("aud", "someone-else")),
+		"expired":         signer.sign(t, with("exp", time.Now().Add(-time.Hour).Unix())),
+		"no expiry":       signer.sign(t, with("exp", nil)),
+		"not yet valid":   signer.sign(t, with("nbf", time.Now().Add(time.Hour).Unix())),
+		"no subject":      signer.sign(t, with("sub", nil)),
+		"bad devices":     signer.sign(t, with("clock_devices", 42)),
+		"unknown key":     testSigner{kid: "other", alg: jwtAlgEdDSA, key: foreignKey}.sign(t, validClaims()),
+		"foreign key":     testSigner{kid: "ed-1", alg: jwtAlgEdDSA, key: foreignKey}.sign(t, validClaims()),
+		"alg mismatch":    testSigner{kid: "ec-1", alg: jwtAlgRS256, key: signers[0].key}.sign(t, validClaims()),
+		"alg none":        "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`)) + ".",
+		"tampered claims": swapClaims(signer.sign(t, validClaims()), signer.sign(t, with("sub", "mallory"))),
+	}
+	for name, token := range cases {
+		if _, err := v.Verify(context.Background(), token); err == nil {
+			t.Errorf("%s: expected rejection", name)
+		}
+	}
+}
+
+// swapClaims returns token with the claims segment of other.
+func swapClaims(token, other string) string {
+	parts := strings.Split(token, ".")
+	parts[1] = strings.Split(other, ".")[1]
+	return strings.Join(parts, ".")
+}
+
+func TestJWTVerifierFetchesAndRefreshesURLKeys(t *testing.T) {
+	signers := newTestSigners(t)
+	var current atomic.Value
+	current.Store(jwksDocument(signers[0]))
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write(current.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	if _, err := NewJWTVerifier(JWTConfig{Issuer: "i", Audience: "a", JWKSURL: srv.URL}); err == nil {
+		t.Fatal("expected an http JWKS URL to be rejected by default")
+	}
+	v, err := NewJWTVerifier(JWTConfig{
+		Issuer:           "https://idp.example",
+		Audience:         "clock-server",
+		JWKSURL:          srv.URL,
+		AllowInsecureURL: true,
+		CacheTTL:         10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("new verifier: %v", err)
+	}
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), signers[0].sign(t, validClaims())); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+	}
+	if fetches.Load() != 1 {
+		t.Fatalf("expected keys to be cached, got %d fetches", fetches.Load())
+	}
+
+	// A token signed with a rotated-in key triggers a refetch, but not more
+	// often than jwksMinRefetch.
+	current.Store(jwksDocument(signers[0], signers[2]))
+	rotated := signers[2].sign(t, validClaims())
+	if _, err := v.Verify(context.Background(), rotated); err == nil || fetches.Load() != 1 {
+		t.Fatalf("expected no refetch within the minimum interval, got %v after %d fetches", err, fetches.Load())
+	}
+	now = now.Add(jwksMinRefetch)
+	if _, err := v.Verify(context.Background(), rotated); err != nil || fetches.Load() != 2 {
+		t.Fatalf("expected a refetch for the unknown key, got %v after %d fetches", err, fetches.Load())
+	}
+
+	// Keys stay usable while the JWKS endpoint fails.
+	current.Store([]byte("not json"))
+	now = now.Add(15 * time.Minute)
+	if _, err := v.Verify(context.Background(), rotated); err != nil {
+		t.Fatalf("expected cached keys to survive a failed refresh: %v", err)
+	}
+}
+
+func TestJWTVerifierFetchesOutsideTheLock(t *testing.T) {
+	signers := newTestSigners(t)
+	var current atomic.Value
+	current.Store(jwksDocument(signers[0]))
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		_, _ = w.Write(current.Load().([]byte))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	v, err := NewJWTVerifier(JWTConfig{
+		Issuer:           "https://idp.example",
+		Audience:         "clock-server",
+		JWKSURL:          srv.URL,
+		AllowInsecureURL: true,
+	})
+	if err != nil {
+		t.Fatalf("new verifier: %v", err)
+	}
+	var now atomic.Int64
+	now.Store(time.Now().UnixNano())
+	v.now = func() time.Time { return time.Unix(0, now.Load()) }
+	known := signers[0].sign(t, validClaims())
+	if _, err := v.Verify(context.Background(), known); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// A rotated key starts a refetch that hangs. The caller gives up at its
+	// deadline, but the fetch goes on for the others.
+	current.Store(jwksDocument(signers[0], signers[2]))
+	rotated := signers[2].sign(t, validClaims())
+	now.Add(int64(jwksMinRefetch))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := v.Verify(ctx, rotated); err == nil {
+		t.Fatal("expected the rotated key to be unknown until the fetch completes")
+	}
+
+	// Tokens with known keys verify while the fetch is in flight, and a
+	// second caller joins the fetch instead of starting another.
+	if _, err := v.Verify(context.Background(), known); err != nil {
+		t.Fatalf("expected a known key to verify during the fetch: %v", err)
+	}
+	joined := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(context.Background(), rotated)
+		joined <- err
+	}()
+	release <- struct{}{}
+	if err := <-joined; err != nil {
+		t.Fatalf("expected the joined fetch to provide the rotated key: %v", err)
+	}
+	if fetches.Load() != 2 {
+		t.Fatalf("expected one shared refetch, got %d fetches", fetches.Load())
+	}
+}
+
+func TestParseJWKSRejectsWeakOrInvalidKeys(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	cases := map[string][]byte{
+		"weak rsa":     jwksDocument(testSigner{kid: "weak", alg: jwtAlgRS256, key: weak}),
+		"off curve":    []byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"` + base64.RawURLEncoding.EncodeToString(make([]byte, 32)) + `","y":"` + base64.RawURLEncoding.EncodeToString(make([]byte, 32)) + `"}]}`),
+		"no sig keys":  []byte(`{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`),
+		"not json":     []byte(`keys`),
+		"short ed key": []byte(`{"keys":[{"kty":"OKP","crv":"Ed25519","x":"AAAA"}]}`),
+	}
+	for name, raw := range cases {
+		if _, err := parseJWKS(raw); err == nil {
+			t.Errorf("%s: expected parse error", name)
+		}
+	}
+}
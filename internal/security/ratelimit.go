@@ -0,0 +1,113 @@
+package security
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paul/clock-server/internal/commands"
+)
+
+// AnyCommandType keys the rate limit of command types without their own, and
+// AnyPrincipal the quota of principals without their own.
+const (
+	AnyCommandType = "*"
+	AnyPrincipal   = "*"
+)
+
+// RateLimit allows Count commands per Per, in bursts of up to Count.
+type RateLimit struct {
+	Count int
+	Per   time.Duration
+}
+
+func (l RateLimit) String() string {
+	return fmt.Sprintf("%d/%s", l.Count, l.Per)
+}
+
+var rateLimitUnits = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+
+// RateLimits are rate limits by command type. The limit under AnyCommandType
+// is shared by every type that has no limit of its own.
+type RateLimits map[string]RateLimit
+
+// ParseRateLimits parses semicolon-separated limits in the format:
+// *=60/m;display_message=10/m;set_brightness=1/s
+// The unit is s, m or h.
+func ParseRateLimits(raw string) (RateLimits, error) {
+	limits := RateLimits{}
+	for _, entry := range strings.Split(raw, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		commandType, rate, ok := strings.Cut(entry, "=")
+		commandType = strings.TrimSpace(commandType)
+		if !ok || commandType == "" {
+			return nil, fmt.Errorf("invalid rate limit %q: expected type=count/unit", entry)
+		}
+		if commandType != AnyCommandType {
+			if _, ok := commands.Lookup(commandType); !ok {
+				return nil, fmt.Errorf("rate limit %q names an unknown command type", entry)
+			}
+		}
+		if _, dup := limits[commandType]; dup {
+			return nil, fmt.Errorf("rate limit for %q is defined twice", commandType)
+		}
+		count, unit, ok := strings.Cut(strings.TrimSpace(rate), "/")
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		per, known := rateLimitUnits[strings.TrimSpace(unit)]
+		if !ok || err != nil || n <= 0 || !known {
+			return nil, fmt.Errorf("invalid rate limit %q: expected a positive count per s, m or h", entry)
+		}
+		limits[commandType] = RateLimit{Count: n, Per: per}
+	}
+	return limits, nil
+}
+
+// For returns the limit that applies to commandType and the bucket it is
+// counted in: the type itself, or AnyCommandType for the shared limit.
+func (l RateLimits) For(commandType string) (bucket string, limit RateLimit, ok bool) {
+	if limit, ok := l[commandType]; ok {
+		return commandType, limit, true
+	}
+	limit, ok = l[AnyCommandType]
+	return AnyCommandType, limit, ok
+}
+
+// Quotas are daily command quotas by principal ID. The quota under
+// AnyPrincipal applies, separately, to every principal without its own.
+type Quotas map[string]int
+
+// ParseQuotas parses semicolon-separated quotas in the format:
+// *=1000;kiosk=200
+func ParseQuotas(raw string) (Quotas, error) {
+	quotas := Quotas{}
+	for _, entry := range strings.Split(raw, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, count, ok := strings.Cut(entry, "=")
+		id = strings.TrimSpace(id)
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if !ok || id == "" || err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid quota %q: expected principal=count", entry)
+		}
+		if _, dup := quotas[id]; dup {
+			return nil, fmt.Errorf("quota for %q is defined twice", id)
+		}
+		quotas[id] = n
+	}
+	return quotas, nil
+}
+
+// For returns the daily quota of the principal id.
+func (q Quotas) For(id string) (int, bool) {
+	if n, ok := q[id]; ok {
+		return n, true
+	}
+	n, ok := q[AnyPrincipal]
+	return n, ok
+}
@@ -0,0 +1,54 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := ParseRateLimits("*=60/m; display_message=10/m;set_brightness=1/s")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if bucket, limit, ok := limits.For("display_message"); !ok || bucket != "display_message" || limit != (RateLimit{Count: 10, Per: time.Minute}) {
+		t.Fatalf("unexpected display_message limit %s %v %v", bucket, limit, ok)
+	}
+	if bucket, limit, ok := limits.For("set_alarm"); !ok || bucket != AnyCommandType || limit.Count != 60 {
+		t.Fatalf("expected set_alarm to share the default limit, got %s %v %v", bucket, limit, ok)
+	}
+	if _, _, ok := (RateLimits{}).For("set_alarm"); ok {
+		t.Fatal("expected no limit without definitions")
+	}
+
+	for _, raw := range []string{
+		"display_message",
+		"=10/m",
+		"display_message=10",
+		"display_message=0/m",
+		"display_message=10/d",
+		"reboot=1/s",
+		"*=1/s;*=2/s",
+	} {
+		if _, err := ParseRateLimits(raw); err == nil {
+			t.Errorf("%q: expected parse error", raw)
+		}
+	}
+}
+
+func TestParseQuotas(t *testing.T) {
+	quotas, err := ParseQuotas("*=1000;kiosk=200")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if n, ok := quotas.For("kiosk"); !ok || n != 200 {
+		t.Fatalf("unexpected kiosk quota %d %v", n, ok)
+	}
+	if n, ok := quotas.For("ops"); !ok || n != 1000 {
+		t.Fatalf("expected the default quota, got %d %v", n, ok)
+	}
+	for _, raw := range []string{"kiosk", "kiosk=-1", "=5", "kiosk=1;kiosk=2"} {
+		if _, err := ParseQuotas(raw); err == nil {
+			t.Errorf("%q: expected parse error", raw)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package security
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/paul/clock-server/internal/commands"
+)
+
+// Permissions granted by roles. A command permission is "command:<type>";
+// "command:*" covers every command type and "*" every permission.
+const (
+	PermissionAll         = "*"
+	PermissionAdmin       = "admin"
+	PermissionRoutingRead = "routing:read"
+	PermissionEventsRead  = "events:read"
+	PermissionAuditRead   = "audit:read"
+
+	commandPermissionPrefix = "command:"
+)
+
+// RoleAdmin is predefined with every permission.
+const RoleAdmin = "admin"
+
+// defaultPermissions apply to credentials without roles, which predate roles:
+// every command type, the routing debug endpoint and the event stream, but no
+// admin actions or audit log queries.
+var defaultPermissions = []string{commandPermissionPrefix + "*", PermissionRoutingRead, PermissionEventsRead}
+
+// CommandPermission is the permission to send commands of commandType.
+func CommandPermission(commandType string) string {
+	return commandPermissionPrefix + commandType
+}
+
+// Policy maps role names to permissions. The zero Policy knows only the
+// admin role.
+type Policy struct {
+	roles map[string][]string
+}
+
+// ParseRoles parses semicolon-separated role definitions in the format:
+// operator=command:display_message,command:set_brightness;viewer=events:read
+func ParseRoles(raw string) (Policy, error) {
+	policy := Policy{roles: map[string][]string{}}
+	for _, entry := range strings.Split(raw, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, perms, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return Policy{}, fmt.Errorf("invalid role definition %q: expected name=permission,...", entry)
+		}
+		if name == RoleAdmin {
+			return Policy{}, fmt.Errorf("role %q is predefined", RoleAdmin)
+		}
+		if _, dup := policy.roles[name]; dup {
+			return Policy{}, fmt.Errorf("role %q is defined twice", name)
+		}
+		var permissions []string
+		for _, perm := range strings.Split(perms, ",") {
+			perm = strings.TrimSpace(perm)
+			if perm == "" {
+				continue
+			}
+			if err := validatePermission(perm); err != nil {
+				return Policy{}, fmt.Errorf("role %q: %w", name, err)
+			}
+			permissions = append(permissions, perm)
+		}
+		if len(permissions) == 0 {
+			return Policy{}, fmt.Errorf("role %q has no permissions", name)
+		}
+		policy.roles[name] = permissions
+	}
+	return policy, nil
+}
+
+func validatePermission(perm string) error {
+	switch perm {
+	case PermissionAll, PermissionAdmin, PermissionRoutingRead, PermissionEventsRead, PermissionAuditRead:
+		return nil
+	}
+	commandType, ok := strings.CutPrefix(perm, commandPermissionPrefix)
+	if !ok {
+		return fmt.Errorf("unknown permission %q", perm)
+	}
+	if commandType == "*" {
+		return nil
+	}
+	if _, ok := commands.Lookup(commandType); !ok {
+		return fmt.Errorf("permission %q names an unknown command type", perm)
+	}
+	return nil
+}
+
+// HasRole reports whether name is a defined role.
+func (p Policy) HasRole(name string) bool {
+	if name == RoleAdmin {
+		return true
+	}
+	_, ok := p.roles[name]
+	return ok
+}
+
+// CheckRoles returns an error naming the first undefined role.
+func (p Policy) CheckRoles(roles []string) error {
+	for _, role := range roles {
+		if !p.HasRole(role) {
+			return fmt.Errorf("undefined role %q", role)
+		}
+	}
+	return nil
+}
+
+// Permits reports whether any of roles grants permission. Undefined roles
+// grant nothing; no roles at all grant the default permissions.
+func (p Policy) Permits(roles []string, permission string) bool {
+	if len(roles) == 0 {
+		return permitted(defaultPermissions, permission)
+	}
+	for _, role := range roles {
+		if role == RoleAdmin {
+			return true
+		}
+		if permitted(p.roles[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+func permitted(granted []string, permission string) bool {
+	for _, perm := range granted {
+		if perm == PermissionAll || perm == permission {
+			return true
+		}
+		if perm == commandPermissionPrefix+"*" && strings.HasPrefix(permission, commandPermissionPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRoleList splits a comma-separated list of role names.
+func parseRoleList(raw string) []string {
+	var roles []string
+	for _, role := range strings.Split(raw, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
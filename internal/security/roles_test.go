@@ -0,0 +1,73 @@
+package security
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPolicyPermits(t *testing.T) {
+	policy, err := ParseRoles(" messenger = command:display_message ; operator=command:*,routing:read ; observer=events:read ")
+	if err != nil {
+		t.Fatalf("parse roles: %v", err)
+	}
+	cases := []struct {
+		roles      []string
+		permission string
+		want       bool
+	}{
+		{[]string{"messenger"}, CommandPermission("display_message"), true},
+		{[]string{"messenger"}, CommandPermission("set_alarm"), false},
+		{[]string{"messenger"}, PermissionEventsRead, false},
+		{[]string{"messenger", "observer"}, PermissionEventsRead, true},
+		{[]string{"operator"}, CommandPermission("set_alarm"), true},
+		{[]string{"operator"}, PermissionAdmin, false},
+		{[]string{"admin"}, PermissionAdmin, true},
+		{[]string{"undefined"}, CommandPermission("set_alarm"), false},
+		{nil, CommandPermission("set_alarm"), true},
+		{nil, PermissionRoutingRead, true},
+		{nil, PermissionAdmin, false},
+	}
+	for _, tc := range cases {
+		if got := policy.Permits(tc.roles, tc.permission); got != tc.want {
+			t.Errorf("Permits(%v, %q) = %v, want %v", tc.roles, tc.permission, got, tc.want)
+		}
+	}
+	if err := policy.CheckRoles([]string{"messenger", "admin"}); err != nil {
+		t.Fatalf("expected defined roles to pass: %v", err)
+	}
+	if err := policy.CheckRoles([]string{"messenger", "root"}); err == nil {
+		t.Fatal("expected an undefined role to be reported")
+	}
+	if !(Policy{}).Permits([]string{RoleAdmin}, PermissionAdmin) {
+		t.Fatal("expected the zero policy to know the admin role")
+	}
+}
+
+func TestParseRolesRejectsInvalidDefinitions(t *testing.T) {
+	for _, raw := range []string{
+		"messenger",
+		"=command:display_message",
+		"messenger=",
+		"messenger=command:reboot",
+		"messenger=commands:*",
+		"admin=events:read",
+		"a=events:read;a=routing:read",
+	} {
+		if _, err := ParseRoles(raw); err == nil {
+			t.Errorf("%q: expected parse error", raw)
+		}
+	}
+}
+
+func TestParseCredentialsReadsOptionalRoles(t *testing.T) {
+	creds, err := ParseCredentials("lobby|t1|lobby-*|messenger, observer;ops|t2|*")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !reflect.DeepEqual(creds[0].Roles, []string{"messenger", "observer"}) || creds[1].Roles != nil {
+		t.Fatalf("unexpected roles: %#v %#v", creds[0].Roles, creds[1].Roles)
+	}
+	if _, err := ParseCredentials("lobby|t1|lobby-*|messenger|extra"); err == nil {
+		t.Fatal("expected a fifth field to be rejected")
+	}
+}
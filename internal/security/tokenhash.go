@@ -0,0 +1,289 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Stored token formats. A credential token starting with "$" is a verifier
+// rather than the token itself:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>   salted Argon2id (PHC string format)
+//	$hmac-sha256$<digest>                          HMAC-SHA256 of the token keyed with the pepper
+//
+// Salt, hash and digest are unpadded standard base64. The HMAC form is meant
+// for generated high-entropy tokens: the secret pepper takes the place of a
+// per-entry salt, which keeps it indexable by digest.
+const (
+	argon2idPrefix   = "$argon2id$"
+	hmacSHA256Prefix = "$hmac-sha256$"
+)
+
+// Default Argon2id cost, the OWASP minimum for Argon2id.
+const (
+	DefaultArgon2Time    = 2
+	DefaultArgon2Memory  = 19 * 1024
+	DefaultArgon2Threads = 1
+)
+
+// Upper bounds on configured Argon2id costs, so a credential entry cannot make
+// every authentication exhaust the server.
+const (
+	maxArgon2Time   = 16
+	maxArgon2Memory = 1 << 20
+)
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// argon2Slots caps concurrent Argon2id verifications at one per CPU, so a
+// burst of unknown tokens queues instead of allocating the memory cost of
+// every verification at once.
+var argon2Slots = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// generatedTokenBytes is the entropy of tokens from GenerateToken.
+const generatedTokenBytes = 32
+
+var b64 = base64.RawStdEncoding
+
+// tokenHash is a parsed token verifier.
+type tokenHash struct {
+	hmac    bool
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	digest  []byte
+}
+
+// IsTokenHash reports whether a credential token is a stored verifier.
+func IsTokenHash(token string) bool {
+	return strings.HasPrefix(token, "$")
+}
+
+// RequiresPepper reports whether any credential is an HMAC-SHA256 verifier,
+// which can only be checked with the pepper it was created with.
+func RequiresPepper(creds []Credential) bool {
+	for _, cred := range creds {
+		if strings.HasPrefix(cred.Token, hmacSHA256Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateToken returns a random URL-safe token with 256 bits of entropy.
+func GenerateToken() (string, error) {
+	raw := make([]byte, generatedTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// HashTokenArgon2id returns a salted Argon2id verifier for token using the
+// default cost.
+func HashTokenArgon2id(token string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate salt: %w", err)
+	}
+	h := tokenHash{
+		time:    DefaultArgon2Time,
+		memory:  DefaultArgon2Memory,
+		threads: DefaultArgon2Threads,
+		salt:    salt,
+	}
+	h.digest = argon2.IDKey([]byte(token), h.salt, h.time, h.memory, h.threads, argon2KeyLen)
+	return h.String(), nil
+}
+
+// HashTokenSHA256 returns the HMAC-SHA256 verifier of token under pepper.
+func HashTokenSHA256(token string, pepper []byte) (string, error) {
+	if len(pepper) == 0 {
+		return "", errors.New("a pepper is required for SHA-256 token hashes")
+	}
+	return tokenHash{hmac: true, digest: hmacToken(token, pepper)}.String(), nil
+}
+
+func (h tokenHash) String() string {
+	if h.hmac {
+		return hmacSHA256Prefix + b64.EncodeToString(h.digest)
+	}
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, h.memory, h.time, h.threads,
+		b64.EncodeToString(h.salt), b64.EncodeToString(h.digest))
+}
+
+func parseTokenHash(raw string) (tokenHash, error) {
+	switch {
+	case strings.HasPrefix(raw, hmacSHA256Prefix):
+		digest, err := b64.DecodeString(strings.TrimPrefix(raw, hmacSHA256Prefix))
+		if err != nil || len(digest) != sha256.Size {
+			return tokenHash{}, errors.New("invalid hmac-sha256 token hash")
+		}
+		return tokenHash{hmac: true, digest: digest}, nil
+	case strings.HasPrefix(raw, argon2idPrefix):
+		parts := strings.Split(strings.TrimPrefix(raw, argon2idPrefix), "$")
+		if len(parts) != 4 || parts[0] != fmt.Sprintf("v=%d", argon2.Version) {
+			return tokenHash{}, errors.New("invalid argon2id token hash: expected v=19$m=..,t=..,p=..$salt$hash")
+		}
+		var h tokenHash
+		_, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads)
+		if err != nil || fmt.Sprintf("m=%d,t=%d,p=%d", h.memory, h.time, h.threads) != parts[1] {
+			return tokenHash{}, fmt.Errorf("invalid argon2id parameters %q", parts[1])
+		}
+		if h.time < 1 || h.time > maxArgon2Time || h.threads < 1 ||
+			h.memory < 8*uint32(h.threads) || h.memory > maxArgon2Memory {
+			return tokenHash{}, fmt.Errorf("argon2id parameters %q out of range", parts[1])
+		}
+		if h.salt, err = b64.DecodeString(parts[2]); err != nil || len(h.salt) < 8 {
+			return tokenHash{}, errors.New("invalid argon2id salt")
+		}
+		if h.digest, err = b64.DecodeString(parts[3]); err != nil || len(h.digest) < 16 {
+			return tokenHash{}, errors.New("invalid argon2id hash")
+		}
+		return h, nil
+	default:
+		return tokenHash{}, errors.New("unknown token hash format: expected $argon2id$ or $hmac-sha256$")
+	}
+}
+
+func (h tokenHash) verifyArgon2id(token string) bool {
+	got := argon2.IDKey([]byte(token), h.salt, h.time, h.memory, h.threads, uint32(len(h.digest)))
+	return subtle.ConstantTimeCompare(got, h.digest) == 1
+}
+
+func hmacToken(token string, pepper []byte) []byte {
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(token))
+	return mac.Sum(nil)
+}
+
+// CredentialIndex finds the credential a bearer token belongs to. Plaintext
+// and HMAC-SHA256 entries are looked up by a digest of the presented token,
+// so no comparison runs against the stored secrets. Argon2id entries are
+// salted per credential and must be tried one by one; each token that
+// verifies is cached by digest so the cost is paid once per token, but an
+// unknown token costs one Argon2id run per entry. Large credential sets
+// should use HMAC-SHA256 entries.
+type CredentialIndex struct {
+	creds  []Credential
+	pepper []byte
+	plain  map[[sha256.Size]byte]Credential
+	keyed  map[[sha256.Size]byte]Credential
+	slow   []argon2Credential
+
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]Credential
+}
+
+type argon2Credential struct {
+	cred Credential
+	hash tokenHash
+}
+
+// NewCredentialIndex indexes creds. HMAC-SHA256 entries only match when
+// pepper is the pepper they were created with. Entries with a malformed hash
+// never match; ParseCredentials rejects them.
+func NewCredentialIndex(creds []Credential, pepper []byte) *CredentialIndex {
+	idx := &CredentialIndex{
+		creds:    creds,
+		pepper:   pepper,
+		plain:    make(map[[sha256.Size]byte]Credential),
+		keyed:    make(map[[sha256.Size]byte]Credential),
+		verified: make(map[[sha256.Size]byte]Credential),
+	}
+	for _, cred := range creds {
+		if !IsTokenHash(cred.Token) {
+			key := sha256.Sum256([]byte(cred.Token))
+			if _, dup := idx.plain[key]; !dup {
+				idx.plain[key] = cred
+			}
+			continue
+		}
+		h, err := parseTokenHash(cred.Token)
+		if err != nil {
+			continue
+		}
+		if !h.hmac {
+			idx.slow = append(idx.slow, argon2Credential{cred: cred, hash: h})
+			continue
+		}
+		var key [sha256.Size]byte
+		copy(key[:], h.digest)
+		if _, dup := idx.keyed[key]; !dup {
+			idx.keyed[key] = cred
+		}
+	}
+	return idx
+}
+
+// Credentials returns the indexed credentials in configuration order.
+func (idx *CredentialIndex) Credentials() []Credential {
+	if idx == nil {
+		return nil
+	}
+	return idx.creds
+}
+
+// Lookup returns the credential token authenticates, if any.
+func (idx *CredentialIndex) Lookup(token string) (Credential, bool) {
+	return idx.LookupGated(token, nil)
+}
+
+// LookupGated is Lookup, except that admit, when set, is called before the
+// token is verified against the Argon2id entries. If admit returns false the
+// token is not verified and matches nothing, so callers can charge a rate
+// limit for the expensive path only.
+func (idx *CredentialIndex) LookupGated(token string, admit func() bool) (Credential, bool) {
+	if idx == nil || token == "" {
+		return Credential{}, false
+	}
+	key := sha256.Sum256([]byte(token))
+	if cred, ok := idx.plain[key]; ok {
+		return cred, true
+	}
+	if len(idx.keyed) > 0 && len(idx.pepper) > 0 {
+		var mac [sha256.Size]byte
+		copy(mac[:], hmacToken(token, idx.pepper))
+		if cred, ok := idx.keyed[mac]; ok {
+			return cred, true
+		}
+	}
+	if len(idx.slow) == 0 {
+		return Credential{}, false
+	}
+	idx.mu.Lock()
+	cred, ok := idx.verified[key]
+	idx.mu.Unlock()
+	if ok {
+		return cred, true
+	}
+	if admit != nil && !admit() {
+		return Credential{}, false
+	}
+	argon2Slots <- struct{}{}
+	defer func() { <-argon2Slots }()
+	for _, entry := range idx.slow {
+		if entry.hash.verifyArgon2id(token) {
+			idx.mu.Lock()
+			idx.verified[key] = entry.cred
+			idx.mu.Unlock()
+			return entry.cred, true
+		}
+	}
+	return Credential{}, false
+}
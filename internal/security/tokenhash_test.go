@@ -0,0 +1,110 @@
+package security
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArgon2idVerifiesKnownHash(t *testing.T) {
+	// Argon2id of "password" with salt "somesaltsomesalt", m=64 KiB, t=2,
+	// p=2, cross-checked against an implementation passing the RFC 9106
+	// test vectors.
+	h, err := parseTokenHash("$argon2id$v=19$m=64,t=2,p=2$c29tZXNhbHRzb21lc2FsdA$wmMxfQAvhLU0L6ZvoWnkvOajVqE/GRkzRjIu81IuBwI")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !h.verifyArgon2id("password") || h.verifyArgon2id("passwore") {
+		t.Fatal("expected only the original password to verify")
+	}
+}
+
+func TestCredentialIndexLookup(t *testing.T) {
+	pepper := []byte("pepper")
+	argonHash, err := HashTokenArgon2id("argon-token")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	hmacHash, err := HashTokenSHA256("hmac-token", pepper)
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	creds, err := ParseCredentials("plain|plain-token|*;argon|" + argonHash + "|clock-*;keyed|" + hmacHash + "|lobby-*")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	idx := NewCredentialIndex(creds, pepper)
+
+	for token, id := range map[string]string{"plain-token": "plain", "argon-token": "argon", "hmac-token": "keyed"} {
+		for attempt := 0; attempt < 2; attempt++ {
+			cred, ok := idx.Lookup(token)
+			if !ok || cred.ID != id {
+				t.Fatalf("expected %q to authenticate as %s, got %+v %v", token, id, cred, ok)
+			}
+		}
+	}
+	for _, token := range []string{"", "wrong", argonHash, hmacHash} {
+		if _, ok := idx.Lookup(token); ok {
+			t.Fatalf("expected %q to be rejected", token)
+		}
+	}
+	if _, ok := NewCredentialIndex(creds, []byte("other")).Lookup("hmac-token"); ok {
+		t.Fatal("expected a different pepper not to match")
+	}
+}
+
+func TestCredentialIndexLookupGatedChargesOnlyArgon2(t *testing.T) {
+	argonHash, err := HashTokenArgon2id("argon-token")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	creds, err := ParseCredentials("plain|plain-token|*;argon|" + argonHash + "|clock-*")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	idx := NewCredentialIndex(creds, nil)
+	admitted := 0
+	deny := func() bool { admitted++; return false }
+
+	if cred, ok := idx.LookupGated("plain-token", deny); !ok || cred.ID != "plain" || admitted != 0 {
+		t.Fatalf("expected a plaintext match without the gate, got %v after %d admits", ok, admitted)
+	}
+	if _, ok := idx.LookupGated("argon-token", deny); ok || admitted != 1 {
+		t.Fatalf("expected a denied Argon2id lookup to fail, got %v after %d admits", ok, admitted)
+	}
+	if _, ok := idx.LookupGated("argon-token", func() bool { return true }); !ok {
+		t.Fatal("expected an admitted lookup to verify")
+	}
+	// A verified token is cached and no longer needs admission.
+	if _, ok := idx.LookupGated("argon-token", deny); !ok || admitted != 1 {
+		t.Fatalf("expected a cached match without the gate, got %v after %d admits", ok, admitted)
+	}
+}
+
+func TestParseCredentialsRejectsMalformedHashes(t *testing.T) {
+	cases := []string{
+		"ops|$argon2id$v=19$m=19456,t=2,p=1$c2FsdA|*",
+		"ops|$argon2id$v=16$m=19456,t=2,p=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA|*",
+		"ops|$argon2id$v=19$m=4194304,t=2,p=1$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA|*",
+		"ops|$argon2id$v=19$m=19456,t=2,p=1x$c2FsdHNhbHQ$aGFzaGhhc2hoYXNoaGFzaA|*",
+		"ops|$hmac-sha256$tooshort|*",
+		"ops|$bcrypt$whatever|*",
+	}
+	for _, raw := range cases {
+		if _, err := ParseCredentials(raw); err == nil {
+			t.Fatalf("expected parse error for %q", raw)
+		}
+	}
+	if _, err := HashTokenSHA256("token", nil); err == nil {
+		t.Fatal("expected a pepper to be required")
+	}
+}
+
+func TestRequiresPepper(t *testing.T) {
+	hash, _ := HashTokenSHA256("token", []byte("pepper"))
+	if RequiresPepper([]Credential{{ID: "a", Token: "plain"}}) || !RequiresPepper([]Credential{{ID: "a", Token: hash}}) {
+		t.Fatal("unexpected RequiresPepper result")
+	}
+	if !strings.HasPrefix(hash, "$hmac-sha256$") {
+		t.Fatalf("unexpected hash format %q", hash)
+	}
+}
@@ -0,0 +1,534 @@
+// gRPC API of the clock command dispatcher. The Go stubs in this directory are
+// generated from this file (go generate ./proto/...); the server implements
+// ClockServiceServer in internal/api/grpc.go.
+//
+// Calls authenticate with "authorization: Bearer <token>" metadata, using the
+// same credentials and device scopes as the HTTP API. Failed calls carry the
+// HTTP API's stable error code in "clock-error-code" metadata. Command calls
+// accept "idempotency-key" metadata with the semantics of the HTTP API's
+// Idempotency-Key header; GetCommandStatus reports such a call afterwards.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: clock.proto
+
+package clockv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SetAlarmRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	// RFC 3339 timestamp.
+	AlarmTime     string `protobuf:"bytes,2,opt,name=alarm_time,json=alarmTime,proto3" json:"alarm_time,omitempty"`
+	Label         string `protobuf:"bytes,3,opt,name=label,proto3" json:"label,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetAlarmRequest) Reset() {
+	*x = SetAlarmRequest{}
+	mi := &file_clock_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetAlarmRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetAlarmRequest) ProtoMessage() {}
+
+func (x *SetAlarmRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_clock_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetAlarmRequest.ProtoReflect.Descriptor instead.
+func (*SetAlarmRequest) Descriptor() ([]byte, []int) {
+	return file_clock_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SetAlarmRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *SetAlarmRequest) GetAlarmTime() string {
+	if x != nil {
+		return x.AlarmTime
+	}
+	return ""
+}
+
+func (x *SetAlarmRequest) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+type DisplayMessageRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId        string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	Message         string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	DurationSeconds int32                  `protobuf:"varint,3,opt,name=duration_seconds,json=durationSeconds,proto3" json:"duration_seconds,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *DisplayMessageRequest) Reset() {
+	*x = DisplayMessageRequest{}
+	mi := &file_clock_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DisplayMessageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisplayMessageRequest) ProtoMessage() {}
+
+func (x *DisplayMessageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_clock_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisplayMessageRequest.ProtoReflect.Descriptor instead.
+func (*DisplayMessageRequest) Descriptor() ([]byte, []int) {
+	return file_clock_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *DisplayMessageRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *DisplayMessageRequest) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *DisplayMessageRequest) GetDurationSeconds() int32 {
+	if x != nil {
+		return x.DurationSeconds
+	}
+	return 0
+}
+
+type SetBrightnessRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId      string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	Level         int32                  `protobuf:"varint,2,opt,name=level,proto3" json:"level,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetBrightnessRequest) Reset() {
+	*x = SetBrightnessRequest{}
+	mi := &file_clock_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetBrightnessRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetBrightnessRequest) ProtoMessage() {}
+
+func (x *SetBrightnessRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_clock_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetBrightnessRequest.ProtoReflect.Descriptor instead.
+func (*SetBrightnessRequest) Descriptor() ([]byte, []int) {
+	return file_clock_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SetBrightnessRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *SetBrightnessRequest) GetLevel() int32 {
+	if x != nil {
+		return x.Level
+	}
+	return 0
+}
+
+// CommandResponse is returned once every sender required by the delivery
+// policy has accepted the command.
+type CommandResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// "scheduled", "sent" or "updated", as in the HTTP API.
+	Result        string      `protobuf:"bytes,1,opt,name=result,proto3" json:"result,omitempty"`
+	Deliveries    []*Delivery `protobuf:"bytes,2,rep,name=deliveries,proto3" json:"deliveries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommandResponse) Reset() {
+	*x = CommandResponse{}
+	mi := &file_clock_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandResponse) ProtoMessage() {}
+
+func (x *CommandResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_clock_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandResponse.ProtoReflect.Descriptor instead.
+func (*CommandResponse) Descriptor() ([]byte, []int) {
+	return file_clock_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CommandResponse) GetResult() string {
+	if x != nil {
+		return x.Result
+	}
+	return ""
+}
+
+func (x *CommandResponse) GetDeliveries() []*Delivery {
+	if x != nil {
+		return x.Deliveries
+	}
+	return nil
+}
+
+type Delivery struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Sender string                 `protobuf:"bytes,1,opt,name=sender,proto3" json:"sender,omitempty"`
+	// "delivered", "failed", "timeout" or "skipped".
+	Status        string `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	DurationMs    int64  `protobuf:"varint,3,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Delivery) Reset() {
+	*x = Delivery{}
+	mi := &file_clock_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Delivery) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Delivery) ProtoMessage() {}
+
+func (x *Delivery) ProtoReflect() protoreflect.Message {
+	mi := &file_clock_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Delivery.ProtoReflect.Descriptor instead.
+func (*Delivery) Descriptor() ([]byte, []int) {
+	return file_clock_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Delivery) GetSender() string {
+	if x != nil {
+		return x.Sender
+	}
+	return ""
+}
+
+func (x *Delivery) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Delivery) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+type CommandStatusRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	IdempotencyKey string                 `protobuf:"bytes,1,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *CommandStatusRequest) Reset() {
+	*x = CommandStatusRequest{}
+	mi := &file_clock_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandStatusRequest) ProtoMessage() {}
+
+func (x *CommandStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_clock_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandStatusRequest.ProtoReflect.Descriptor instead.
+func (*CommandStatusRequest) Descriptor() ([]byte, []int) {
+	return file_clock_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CommandStatusRequest) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
+type CommandStatus struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// "in_progress" or "completed". Failed gRPC calls are not kept, so that
+	// they can be retried with the same key.
+	State string `protobuf:"bytes,1,opt,name=state,proto3" json:"state,omitempty"`
+	// The response of a completed call made over gRPC.
+	Response *CommandResponse `protobuf:"bytes,2,opt,name=response,proto3" json:"response,omitempty"`
+	// The HTTP status of a completed request made with the key over the HTTP
+	// API; repeat that request to replay its body.
+	HttpStatus    int32 `protobuf:"varint,3,opt,name=http_status,json=httpStatus,proto3" json:"http_status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommandStatus) Reset() {
+	*x = CommandStatus{}
+	mi := &file_clock_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandStatus) ProtoMessage() {}
+
+func (x *CommandStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_clock_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandStatus.ProtoReflect.Descriptor instead.
+func (*CommandStatus) Descriptor() ([]byte, []int) {
+	return file_clock_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CommandStatus) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *CommandStatus) GetResponse() *CommandResponse {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+func (x *CommandStatus) GetHttpStatus() int32 {
+	if x != nil {
+		return x.HttpStatus
+	}
+	return 0
+}
+
+var File_clock_proto protoreflect.FileDescriptor
+
+const file_clock_proto_rawDesc = "" +
+	"\n" +
+	"\vclock.proto\x12\bclock.v1\"c\n" +
+	"\x0fSetAlarmRequest\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12\x1d\n" +
+	"\n" +
+	"alarm_time\x18\x02 \x01(\tR\talarmTime\x12\x14\n" +
+	"\x05label\x18\x03 \x01(\tR\x05label\"y\n" +
+	"\x15DisplayMessageRequest\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12)\n" +
+	"\x10duration_seconds\x18\x03 \x01(\x05R\x0fdurationSeconds\"I\n" +
+	"\x14SetBrightnessRequest\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12\x14\n" +
+	"\x05level\x18\x02 \x01(\x05R\x05level\"]\n" +
+	"\x0fCommandResponse\x12\x16\n" +
+	"\x06result\x18\x01 \x01(\tR\x06result\x122\n" +
+	"\n" +
+	"deliveries\x18\x02 \x03(\v2\x12.clock.v1.DeliveryR\n" +
+	"deliveries\"[\n" +
+	"\bDelivery\x12\x16\n" +
+	"\x06sender\x18\x01 \x01(\tR\x06sender\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12\x1f\n" +
+	"\vduration_ms\x18\x03 \x01(\x03R\n" +
+	"durationMs\"?\n" +
+	"\x14CommandStatusRequest\x12'\n" +
+	"\x0fidempotency_key\x18\x01 \x01(\tR\x0eidempotencyKey\"}\n" +
+	"\rCommandStatus\x12\x14\n" +
+	"\x05state\x18\x01 \x01(\tR\x05state\x125\n" +
+	"\bresponse\x18\x02 \x01(\v2\x19.clock.v1.CommandResponseR\bresponse\x12\x1f\n" +
+	"\vhttp_status\x18\x03 \x01(\x05R\n" +
+	"httpStatus2\xb7\x02\n" +
+	"\fClockService\x12@\n" +
+	"\bSetAlarm\x12\x19.clock.v1.SetAlarmRequest\x1a\x19.clock.v1.CommandResponse\x12L\n" +
+	"\x0eDisplayMessage\x12\x1f.clock.v1.DisplayMessageRequest\x1a\x19.clock.v1.CommandResponse\x12J\n" +
+	"\rSetBrightness\x12\x1e.clock.v1.SetBrightnessRequest\x1a\x19.clock.v1.CommandResponse\x12K\n" +
+	"\x10GetCommandStatus\x12\x1e.clock.v1.CommandStatusRequest\x1a\x17.clock.v1.CommandStatusB5Z3github.com/paul/clock-server/proto/clock/v1;clockv1b\x06proto3"
+
+var (
+	file_clock_proto_rawDescOnce sync.Once
+	file_clock_proto_rawDescData []byte
+)
+
+func file_clock_proto_rawDescGZIP() []byte {
+	file_clock_proto_rawDescOnce.Do(func() {
+		file_clock_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_clock_proto_rawDesc), len(file_clock_proto_rawDesc)))
+	})
+	return file_clock_proto_rawDescData
+}
+
+var file_clock_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_clock_proto_goTypes = []any{
+	(*SetAlarmRequest)(nil),       // 0: clock.v1.SetAlarmRequest
+	(*DisplayMessageRequest)(nil), // 1: clock.v1.DisplayMessageRequest
+	(*SetBrightnessRequest)(nil),  // 2: clock.v1.SetBrightnessRequest
+	(*CommandResponse)(nil),       // 3: clock.v1.CommandResponse
+	(*Delivery)(nil),              // 4: clock.v1.Delivery
+	(*CommandStatusRequest)(nil),  // 5: clock.v1.CommandStatusRequest
+	(*CommandStatus)(nil),         // 6: clock.v1.CommandStatus
+}
+var file_clock_proto_depIdxs = []int32{
+	4, // 0: clock.v1.CommandResponse.deliveries:type_name -> clock.v1.Delivery
+	3, // 1: clock.v1.CommandStatus.response:type_name -> clock.v1.CommandResponse
+	0, // 2: clock.v1.ClockService.SetAlarm:input_type -> clock.v1.SetAlarmRequest
+	1, // 3: clock.v1.ClockService.DisplayMessage:input_type -> clock.v1.DisplayMessageRequest
+	2, // 4: clock.v1.ClockService.SetBrightness:input_type -> clock.v1.SetBrightnessRequest
+	5, // 5: clock.v1.ClockService.GetCommandStatus:input_type -> clock.v1.CommandStatusRequest
+	3, // 6: clock.v1.ClockService.SetAlarm:output_type -> clock.v1.CommandResponse
+	3, // 7: clock.v1.ClockService.DisplayMessage:output_type -> clock.v1.CommandResponse
+	3, // 8: clock.v1.ClockService.SetBrightness:output_type -> clock.v1.CommandResponse
+	6, // 9: clock.v1.ClockService.GetCommandStatus:output_type -> clock.v1.CommandStatus
+	6, // [6:10] is the sub-list for method output_type
+	2, // [2:6] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_clock_proto_init() }
+func file_clock_proto_init() {
+	if File_clock_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_clock_proto_rawDesc), len(file_clock_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_clock_proto_goTypes,
+		DependencyIndexes: file_clock_proto_depIdxs,
+		MessageInfos:      file_clock_proto_msgTypes,
+	}.Build()
+	File_clock_proto = out.File
+	file_clock_proto_goTypes = nil
+	file_clock_proto_depIdxs = nil
+}
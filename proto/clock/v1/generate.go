@@ -0,0 +1,4 @@
+// Package clockv1 holds the Go stubs generated from clock.proto.
+package clockv1
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative clock.proto